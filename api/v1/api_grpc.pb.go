@@ -19,10 +19,26 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	TelePilotService_StartJob_FullMethodName     = "/api.v1.TelePilotService/StartJob"
-	TelePilotService_StopJob_FullMethodName      = "/api.v1.TelePilotService/StopJob"
-	TelePilotService_GetJobStatus_FullMethodName = "/api.v1.TelePilotService/GetJobStatus"
-	TelePilotService_StreamLogs_FullMethodName   = "/api.v1.TelePilotService/StreamLogs"
+	TelePilotService_StartJob_FullMethodName              = "/api.v1.TelePilotService/StartJob"
+	TelePilotService_StopJob_FullMethodName               = "/api.v1.TelePilotService/StopJob"
+	TelePilotService_SignalJob_FullMethodName             = "/api.v1.TelePilotService/SignalJob"
+	TelePilotService_SetJobACL_FullMethodName             = "/api.v1.TelePilotService/SetJobACL"
+	TelePilotService_GetJobStatus_FullMethodName          = "/api.v1.TelePilotService/GetJobStatus"
+	TelePilotService_StreamLogs_FullMethodName            = "/api.v1.TelePilotService/StreamLogs"
+	TelePilotService_PortForward_FullMethodName           = "/api.v1.TelePilotService/PortForward"
+	TelePilotService_ExecInJob_FullMethodName             = "/api.v1.TelePilotService/ExecInJob"
+	TelePilotService_CreateSchedule_FullMethodName        = "/api.v1.TelePilotService/CreateSchedule"
+	TelePilotService_ListSchedules_FullMethodName         = "/api.v1.TelePilotService/ListSchedules"
+	TelePilotService_DeleteSchedule_FullMethodName        = "/api.v1.TelePilotService/DeleteSchedule"
+	TelePilotService_SubmitWorkflow_FullMethodName        = "/api.v1.TelePilotService/SubmitWorkflow"
+	TelePilotService_GetWorkflowStatus_FullMethodName     = "/api.v1.TelePilotService/GetWorkflowStatus"
+	TelePilotService_CanI_FullMethodName                  = "/api.v1.TelePilotService/CanI"
+	TelePilotService_GetQuota_FullMethodName              = "/api.v1.TelePilotService/GetQuota"
+	TelePilotService_IssueClientCert_FullMethodName       = "/api.v1.TelePilotService/IssueClientCert"
+	TelePilotService_CreateEnrollmentToken_FullMethodName = "/api.v1.TelePilotService/CreateEnrollmentToken"
+	TelePilotService_Enroll_FullMethodName                = "/api.v1.TelePilotService/Enroll"
+	TelePilotService_RevokeClientCert_FullMethodName      = "/api.v1.TelePilotService/RevokeClientCert"
+	TelePilotService_RenewCertificate_FullMethodName      = "/api.v1.TelePilotService/RenewCertificate"
 )
 
 // TelePilotServiceClient is the client API for TelePilotService service.
@@ -35,10 +51,43 @@ type TelePilotServiceClient interface {
 	StartJob(ctx context.Context, in *StartJobRequest, opts ...grpc.CallOption) (*StartJobResponse, error)
 	// Stop a running job.
 	StopJob(ctx context.Context, in *StopJobRequest, opts ...grpc.CallOption) (*StopJobResponse, error)
+	// Send a signal to the processes of a running job.
+	SignalJob(ctx context.Context, in *SignalJobRequest, opts ...grpc.CallOption) (*SignalJobResponse, error)
+	// Replace the access control list of a job, granting access to other users. Only for the owner by default.
+	SetJobACL(ctx context.Context, in *SetJobACLRequest, opts ...grpc.CallOption) (*SetJobACLResponse, error)
 	// Get the status of a job.
 	GetJobStatus(ctx context.Context, in *GetJobStatusRequest, opts ...grpc.CallOption) (*GetJobStatusResponse, error)
 	// Stream the logs of a running job.
 	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamLogsResponse], error)
+	// Tunnel a TCP connection to a port on the loopback of a running job.
+	PortForward(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PortForwardRequest, PortForwardResponse], error)
+	// Run an additional process within the namespaces and cgroup of a running job.
+	ExecInJob(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ExecInJobRequest, ExecInJobResponse], error)
+	// Create a schedule starting a job periodically, following a cron expression.
+	CreateSchedule(ctx context.Context, in *CreateScheduleRequest, opts ...grpc.CallOption) (*CreateScheduleResponse, error)
+	// List the schedules of the caller.
+	ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error)
+	// Delete a schedule. The jobs already started are left running.
+	DeleteSchedule(ctx context.Context, in *DeleteScheduleRequest, opts ...grpc.CallOption) (*DeleteScheduleResponse, error)
+	// Submit a workflow, a DAG of jobs each started once its dependencies are done.
+	SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*SubmitWorkflowResponse, error)
+	// Get the status of a workflow and its steps.
+	GetWorkflowStatus(ctx context.Context, in *GetWorkflowStatusRequest, opts ...grpc.CallOption) (*GetWorkflowStatusResponse, error)
+	// Check whether the caller is allowed to call a method, optionally on a given job.
+	CanI(ctx context.Context, in *CanIRequest, opts ...grpc.CallOption) (*CanIResponse, error)
+	// Get the quotas of the caller and of its groups, with their current usage.
+	GetQuota(ctx context.Context, in *GetQuotaRequest, opts ...grpc.CallOption) (*GetQuotaResponse, error)
+	// Sign a CSR into a client certificate for the given user. Requires the built-in CA.
+	IssueClientCert(ctx context.Context, in *IssueClientCertRequest, opts ...grpc.CallOption) (*IssueClientCertResponse, error)
+	// Create a one-time token for a user to enroll with. Requires the built-in CA.
+	CreateEnrollmentToken(ctx context.Context, in *CreateEnrollmentTokenRequest, opts ...grpc.CallOption) (*CreateEnrollmentTokenResponse, error)
+	// Exchange an enrollment token and a CSR for a client certificate.
+	// NOTE: The only call not requiring a client certificate, the token authenticates it.
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
+	// Revoke a client certificate. Requires the built-in CA.
+	RevokeClientCert(ctx context.Context, in *RevokeClientCertRequest, opts ...grpc.CallOption) (*RevokeClientCertResponse, error)
+	// Issue a short-lived certificate for the caller's identity, to replace its current, still valid, one. Requires the built-in CA.
+	RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error)
 }
 
 type telePilotServiceClient struct {
@@ -69,6 +118,26 @@ func (c *telePilotServiceClient) StopJob(ctx context.Context, in *StopJobRequest
 	return out, nil
 }
 
+func (c *telePilotServiceClient) SignalJob(ctx context.Context, in *SignalJobRequest, opts ...grpc.CallOption) (*SignalJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SignalJobResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_SignalJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) SetJobACL(ctx context.Context, in *SetJobACLRequest, opts ...grpc.CallOption) (*SetJobACLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetJobACLResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_SetJobACL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *telePilotServiceClient) GetJobStatus(ctx context.Context, in *GetJobStatusRequest, opts ...grpc.CallOption) (*GetJobStatusResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GetJobStatusResponse)
@@ -98,6 +167,152 @@ func (c *telePilotServiceClient) StreamLogs(ctx context.Context, in *StreamLogsR
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type TelePilotService_StreamLogsClient = grpc.ServerStreamingClient[StreamLogsResponse]
 
+func (c *telePilotServiceClient) PortForward(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PortForwardRequest, PortForwardResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TelePilotService_ServiceDesc.Streams[1], TelePilotService_PortForward_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PortForwardRequest, PortForwardResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TelePilotService_PortForwardClient = grpc.BidiStreamingClient[PortForwardRequest, PortForwardResponse]
+
+func (c *telePilotServiceClient) ExecInJob(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ExecInJobRequest, ExecInJobResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TelePilotService_ServiceDesc.Streams[2], TelePilotService_ExecInJob_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExecInJobRequest, ExecInJobResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TelePilotService_ExecInJobClient = grpc.BidiStreamingClient[ExecInJobRequest, ExecInJobResponse]
+
+func (c *telePilotServiceClient) CreateSchedule(ctx context.Context, in *CreateScheduleRequest, opts ...grpc.CallOption) (*CreateScheduleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateScheduleResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_CreateSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSchedulesResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_ListSchedules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) DeleteSchedule(ctx context.Context, in *DeleteScheduleRequest, opts ...grpc.CallOption) (*DeleteScheduleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteScheduleResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_DeleteSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*SubmitWorkflowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitWorkflowResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_SubmitWorkflow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) GetWorkflowStatus(ctx context.Context, in *GetWorkflowStatusRequest, opts ...grpc.CallOption) (*GetWorkflowStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetWorkflowStatusResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_GetWorkflowStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) CanI(ctx context.Context, in *CanIRequest, opts ...grpc.CallOption) (*CanIResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CanIResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_CanI_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) GetQuota(ctx context.Context, in *GetQuotaRequest, opts ...grpc.CallOption) (*GetQuotaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetQuotaResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_GetQuota_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) IssueClientCert(ctx context.Context, in *IssueClientCertRequest, opts ...grpc.CallOption) (*IssueClientCertResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IssueClientCertResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_IssueClientCert_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) CreateEnrollmentToken(ctx context.Context, in *CreateEnrollmentTokenRequest, opts ...grpc.CallOption) (*CreateEnrollmentTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateEnrollmentTokenResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_CreateEnrollmentToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnrollResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_Enroll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) RevokeClientCert(ctx context.Context, in *RevokeClientCertRequest, opts ...grpc.CallOption) (*RevokeClientCertResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeClientCertResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_RevokeClientCert_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telePilotServiceClient) RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenewCertificateResponse)
+	err := c.cc.Invoke(ctx, TelePilotService_RenewCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TelePilotServiceServer is the server API for TelePilotService service.
 // All implementations must embed UnimplementedTelePilotServiceServer
 // for forward compatibility.
@@ -108,10 +323,43 @@ type TelePilotServiceServer interface {
 	StartJob(context.Context, *StartJobRequest) (*StartJobResponse, error)
 	// Stop a running job.
 	StopJob(context.Context, *StopJobRequest) (*StopJobResponse, error)
+	// Send a signal to the processes of a running job.
+	SignalJob(context.Context, *SignalJobRequest) (*SignalJobResponse, error)
+	// Replace the access control list of a job, granting access to other users. Only for the owner by default.
+	SetJobACL(context.Context, *SetJobACLRequest) (*SetJobACLResponse, error)
 	// Get the status of a job.
 	GetJobStatus(context.Context, *GetJobStatusRequest) (*GetJobStatusResponse, error)
 	// Stream the logs of a running job.
 	StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[StreamLogsResponse]) error
+	// Tunnel a TCP connection to a port on the loopback of a running job.
+	PortForward(grpc.BidiStreamingServer[PortForwardRequest, PortForwardResponse]) error
+	// Run an additional process within the namespaces and cgroup of a running job.
+	ExecInJob(grpc.BidiStreamingServer[ExecInJobRequest, ExecInJobResponse]) error
+	// Create a schedule starting a job periodically, following a cron expression.
+	CreateSchedule(context.Context, *CreateScheduleRequest) (*CreateScheduleResponse, error)
+	// List the schedules of the caller.
+	ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error)
+	// Delete a schedule. The jobs already started are left running.
+	DeleteSchedule(context.Context, *DeleteScheduleRequest) (*DeleteScheduleResponse, error)
+	// Submit a workflow, a DAG of jobs each started once its dependencies are done.
+	SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*SubmitWorkflowResponse, error)
+	// Get the status of a workflow and its steps.
+	GetWorkflowStatus(context.Context, *GetWorkflowStatusRequest) (*GetWorkflowStatusResponse, error)
+	// Check whether the caller is allowed to call a method, optionally on a given job.
+	CanI(context.Context, *CanIRequest) (*CanIResponse, error)
+	// Get the quotas of the caller and of its groups, with their current usage.
+	GetQuota(context.Context, *GetQuotaRequest) (*GetQuotaResponse, error)
+	// Sign a CSR into a client certificate for the given user. Requires the built-in CA.
+	IssueClientCert(context.Context, *IssueClientCertRequest) (*IssueClientCertResponse, error)
+	// Create a one-time token for a user to enroll with. Requires the built-in CA.
+	CreateEnrollmentToken(context.Context, *CreateEnrollmentTokenRequest) (*CreateEnrollmentTokenResponse, error)
+	// Exchange an enrollment token and a CSR for a client certificate.
+	// NOTE: The only call not requiring a client certificate, the token authenticates it.
+	Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error)
+	// Revoke a client certificate. Requires the built-in CA.
+	RevokeClientCert(context.Context, *RevokeClientCertRequest) (*RevokeClientCertResponse, error)
+	// Issue a short-lived certificate for the caller's identity, to replace its current, still valid, one. Requires the built-in CA.
+	RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error)
 	mustEmbedUnimplementedTelePilotServiceServer()
 }
 
@@ -128,12 +376,60 @@ func (UnimplementedTelePilotServiceServer) StartJob(context.Context, *StartJobRe
 func (UnimplementedTelePilotServiceServer) StopJob(context.Context, *StopJobRequest) (*StopJobResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StopJob not implemented")
 }
+func (UnimplementedTelePilotServiceServer) SignalJob(context.Context, *SignalJobRequest) (*SignalJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SignalJob not implemented")
+}
+func (UnimplementedTelePilotServiceServer) SetJobACL(context.Context, *SetJobACLRequest) (*SetJobACLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetJobACL not implemented")
+}
 func (UnimplementedTelePilotServiceServer) GetJobStatus(context.Context, *GetJobStatusRequest) (*GetJobStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetJobStatus not implemented")
 }
 func (UnimplementedTelePilotServiceServer) StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[StreamLogsResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
 }
+func (UnimplementedTelePilotServiceServer) PortForward(grpc.BidiStreamingServer[PortForwardRequest, PortForwardResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PortForward not implemented")
+}
+func (UnimplementedTelePilotServiceServer) ExecInJob(grpc.BidiStreamingServer[ExecInJobRequest, ExecInJobResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ExecInJob not implemented")
+}
+func (UnimplementedTelePilotServiceServer) CreateSchedule(context.Context, *CreateScheduleRequest) (*CreateScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSchedule not implemented")
+}
+func (UnimplementedTelePilotServiceServer) ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchedules not implemented")
+}
+func (UnimplementedTelePilotServiceServer) DeleteSchedule(context.Context, *DeleteScheduleRequest) (*DeleteScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSchedule not implemented")
+}
+func (UnimplementedTelePilotServiceServer) SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*SubmitWorkflowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitWorkflow not implemented")
+}
+func (UnimplementedTelePilotServiceServer) GetWorkflowStatus(context.Context, *GetWorkflowStatusRequest) (*GetWorkflowStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWorkflowStatus not implemented")
+}
+func (UnimplementedTelePilotServiceServer) CanI(context.Context, *CanIRequest) (*CanIResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CanI not implemented")
+}
+func (UnimplementedTelePilotServiceServer) GetQuota(context.Context, *GetQuotaRequest) (*GetQuotaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuota not implemented")
+}
+func (UnimplementedTelePilotServiceServer) IssueClientCert(context.Context, *IssueClientCertRequest) (*IssueClientCertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueClientCert not implemented")
+}
+func (UnimplementedTelePilotServiceServer) CreateEnrollmentToken(context.Context, *CreateEnrollmentTokenRequest) (*CreateEnrollmentTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateEnrollmentToken not implemented")
+}
+func (UnimplementedTelePilotServiceServer) Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (UnimplementedTelePilotServiceServer) RevokeClientCert(context.Context, *RevokeClientCertRequest) (*RevokeClientCertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeClientCert not implemented")
+}
+func (UnimplementedTelePilotServiceServer) RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
 func (UnimplementedTelePilotServiceServer) mustEmbedUnimplementedTelePilotServiceServer() {}
 func (UnimplementedTelePilotServiceServer) testEmbeddedByValue()                          {}
 
@@ -191,6 +487,42 @@ func _TelePilotService_StopJob_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TelePilotService_SignalJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignalJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).SignalJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_SignalJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).SignalJob(ctx, req.(*SignalJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_SetJobACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetJobACLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).SetJobACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_SetJobACL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).SetJobACL(ctx, req.(*SetJobACLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _TelePilotService_GetJobStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetJobStatusRequest)
 	if err := dec(in); err != nil {
@@ -220,6 +552,236 @@ func _TelePilotService_StreamLogs_Handler(srv interface{}, stream grpc.ServerStr
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type TelePilotService_StreamLogsServer = grpc.ServerStreamingServer[StreamLogsResponse]
 
+func _TelePilotService_PortForward_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TelePilotServiceServer).PortForward(&grpc.GenericServerStream[PortForwardRequest, PortForwardResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TelePilotService_PortForwardServer = grpc.BidiStreamingServer[PortForwardRequest, PortForwardResponse]
+
+func _TelePilotService_ExecInJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TelePilotServiceServer).ExecInJob(&grpc.GenericServerStream[ExecInJobRequest, ExecInJobResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TelePilotService_ExecInJobServer = grpc.BidiStreamingServer[ExecInJobRequest, ExecInJobResponse]
+
+func _TelePilotService_CreateSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).CreateSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_CreateSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).CreateSchedule(ctx, req.(*CreateScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_ListSchedules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchedulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).ListSchedules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_ListSchedules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).ListSchedules(ctx, req.(*ListSchedulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_DeleteSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).DeleteSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_DeleteSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).DeleteSchedule(ctx, req.(*DeleteScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_SubmitWorkflow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitWorkflowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).SubmitWorkflow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_SubmitWorkflow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).SubmitWorkflow(ctx, req.(*SubmitWorkflowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_GetWorkflowStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWorkflowStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).GetWorkflowStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_GetWorkflowStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).GetWorkflowStatus(ctx, req.(*GetWorkflowStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_CanI_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CanIRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).CanI(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_CanI_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).CanI(ctx, req.(*CanIRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_GetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).GetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_GetQuota_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).GetQuota(ctx, req.(*GetQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_IssueClientCert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssueClientCertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).IssueClientCert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_IssueClientCert_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).IssueClientCert(ctx, req.(*IssueClientCertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_CreateEnrollmentToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateEnrollmentTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).CreateEnrollmentToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_CreateEnrollmentToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).CreateEnrollmentToken(ctx, req.(*CreateEnrollmentTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_RevokeClientCert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeClientCertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).RevokeClientCert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_RevokeClientCert_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).RevokeClientCert(ctx, req.(*RevokeClientCertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelePilotService_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelePilotServiceServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TelePilotService_RenewCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelePilotServiceServer).RenewCertificate(ctx, req.(*RenewCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TelePilotService_ServiceDesc is the grpc.ServiceDesc for TelePilotService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -235,10 +797,66 @@ var TelePilotService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "StopJob",
 			Handler:    _TelePilotService_StopJob_Handler,
 		},
+		{
+			MethodName: "SignalJob",
+			Handler:    _TelePilotService_SignalJob_Handler,
+		},
+		{
+			MethodName: "SetJobACL",
+			Handler:    _TelePilotService_SetJobACL_Handler,
+		},
 		{
 			MethodName: "GetJobStatus",
 			Handler:    _TelePilotService_GetJobStatus_Handler,
 		},
+		{
+			MethodName: "CreateSchedule",
+			Handler:    _TelePilotService_CreateSchedule_Handler,
+		},
+		{
+			MethodName: "ListSchedules",
+			Handler:    _TelePilotService_ListSchedules_Handler,
+		},
+		{
+			MethodName: "DeleteSchedule",
+			Handler:    _TelePilotService_DeleteSchedule_Handler,
+		},
+		{
+			MethodName: "SubmitWorkflow",
+			Handler:    _TelePilotService_SubmitWorkflow_Handler,
+		},
+		{
+			MethodName: "GetWorkflowStatus",
+			Handler:    _TelePilotService_GetWorkflowStatus_Handler,
+		},
+		{
+			MethodName: "CanI",
+			Handler:    _TelePilotService_CanI_Handler,
+		},
+		{
+			MethodName: "GetQuota",
+			Handler:    _TelePilotService_GetQuota_Handler,
+		},
+		{
+			MethodName: "IssueClientCert",
+			Handler:    _TelePilotService_IssueClientCert_Handler,
+		},
+		{
+			MethodName: "CreateEnrollmentToken",
+			Handler:    _TelePilotService_CreateEnrollmentToken_Handler,
+		},
+		{
+			MethodName: "Enroll",
+			Handler:    _TelePilotService_Enroll_Handler,
+		},
+		{
+			MethodName: "RevokeClientCert",
+			Handler:    _TelePilotService_RevokeClientCert_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _TelePilotService_RenewCertificate_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -246,6 +864,18 @@ var TelePilotService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _TelePilotService_StreamLogs_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "PortForward",
+			Handler:       _TelePilotService_PortForward_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExecInJob",
+			Handler:       _TelePilotService_ExecInJob_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "api/v1/api.proto",
 }
@@ -4,12 +4,25 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"os"
+	"os/signal"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v3"
+	"google.golang.org/protobuf/encoding/protojson"
 
+	pb "go.creack.net/telepilot/api/v1"
 	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/cgroups"
+	"go.creack.net/telepilot/pkg/rlimit"
+	"go.creack.net/telepilot/pkg/terminal"
 	"go.creack.net/telepilot/pkg/tlsconfig"
 )
 
@@ -17,6 +30,7 @@ import (
 func main() {
 	var client *apiclient.Client
 	var jobID string
+	var exitCode int // Exit code of the exec'd process, forwarded as our own.
 
 	jobIDArg := &cli.StringArg{
 		Name:      "<job_id>",
@@ -34,18 +48,21 @@ func main() {
 		Before: func(_ context.Context, cmd *cli.Command) error {
 			certDir := cmd.String("certs")
 			user := cmd.String("user")
+			certFile, keyFile := path.Join(certDir, "client-"+user+".pem"), path.Join(certDir, "client-"+user+"-key.pem")
+			var clientOpts []apiclient.ClientOption
+			if cmd.Args().First() == "enroll" {
+				// NOTE: Enrolling is how the user gets a certificate, connect without.
+				certFile, keyFile = "", ""
+			} else if threshold := cmd.Duration("renew-before"); threshold > 0 {
+				clientOpts = append(clientOpts, apiclient.WithCertRenewal(certFile, keyFile, threshold))
+			}
 			// TODO: Consider using one cert dir per user to simplify the flags.
-			tlsConfig, err := tlsconfig.LoadTLSConfig(
-				path.Join(certDir, "client-"+user+".pem"),
-				path.Join(certDir, "client-"+user+"-key.pem"),
-				path.Join(certDir, "ca.pem"),
-				true,
-			)
+			tlsConfig, err := tlsconfig.LoadTLSConfig(certFile, keyFile, path.Join(certDir, "ca.pem"), true)
 			if err != nil {
 				return fmt.Errorf("load tls config for %q from %q: %w", user, certDir, err)
 			}
 			// TODO: Consider making the addr a flag.
-			c, err := apiclient.NewClient(tlsConfig, "localhost:9090")
+			c, err := apiclient.NewClient(tlsConfig, "localhost:9090", clientOpts...)
 			if err != nil {
 				return fmt.Errorf("new api client: %w", err)
 			}
@@ -68,13 +85,97 @@ func main() {
 					if !cmd.Args().Present() {
 						return cli.ShowSubcommandHelp(cmd)
 					}
-					jobID, err := client.StartJob(ctx, cmd.Args().First(), cmd.Args().Tail())
+					opts, err := startJobOptions(cmd)
+					if err != nil {
+						return err
+					}
+					jobID, err := client.StartJob(ctx, cmd.Args().First(), cmd.Args().Tail(), opts...)
 					if err != nil {
 						return err //nolint:wrapcheck // No wrap needed here.
 					}
 					fmt.Fprintln(cmd.Writer, jobID)
 					return nil
 				},
+				Flags: startJobFlags(),
+			},
+			{
+				Name:  "schedule",
+				Usage: "Manage the schedules starting Jobs periodically.",
+				Commands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "Create a schedule starting a Job following a cron expression, i.e. '0 3 * * *' or '@daily'.",
+						UsageText: "telepilot [global options] schedule create [options] <cron> <command> [arguments...]\n\n" +
+							"The cron expression is in the server's time zone, with an optional leading seconds field.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() < 2 { //nolint:mnd // Cron and command.
+								return cli.ShowSubcommandHelp(cmd)
+							}
+							var overlap pb.OverlapPolicy
+							switch name := cmd.String("overlap"); name {
+							case "skip":
+								overlap = pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED
+							case "queue":
+								overlap = pb.OverlapPolicy_OVERLAP_POLICY_QUEUE
+							case "replace":
+								overlap = pb.OverlapPolicy_OVERLAP_POLICY_REPLACE
+							default:
+								return fmt.Errorf("invalid overlap policy %q, expect 'skip', 'queue' or 'replace'", name) //nolint:err113 // No need for fancy error here.
+							}
+							opts, err := startJobOptions(cmd)
+							if err != nil {
+								return err
+							}
+							args := cmd.Args().Slice()
+							sc, err := client.CreateSchedule(ctx, args[0], overlap, cmd.Bool("run-missed"), args[1], args[2:], opts...)
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							fmt.Fprintln(cmd.Writer, sc.GetScheduleId())
+							return nil
+						},
+						Flags: append(startJobFlags(),
+							&cli.StringFlag{
+								Name:  "overlap",
+								Value: "skip",
+								Usage: "Behavior when a run is due while the previous Job is still running. 'skip', 'queue' or 'replace'.",
+							},
+							&cli.BoolFlag{
+								Name:  "run-missed",
+								Usage: "Run once on server startup when runs have been missed while it was down. Skipped by default.",
+							},
+						),
+					},
+					{
+						Name:  "list",
+						Usage: "List the schedules.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							schedules, err := client.ListSchedules(ctx)
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							for _, sc := range schedules {
+								fmt.Fprintf(cmd.Writer, "%s\t%s\t%s\t%s\n", sc.GetScheduleId(), sc.GetCron(),
+									strings.Join(append([]string{sc.GetJob().GetCommand()}, sc.GetJob().GetArgs()...), " "),
+									formatScheduleRuns(sc))
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "delete",
+						Usage: "Delete a schedule. The Jobs already started are left running.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							return client.DeleteSchedule(ctx, cmd.Args().First())
+						},
+						Arguments: []cli.Argument{&cli.StringArg{
+							Name:      "<schedule_id>",
+							UsageText: "<schedule_id>",
+							Min:       1,
+							Max:       1,
+						}},
+					},
+				},
 			},
 			{
 				Name:  "stop",
@@ -85,6 +186,38 @@ func main() {
 				Arguments: []cli.Argument{jobIDArg},
 				Before:    parseJobID,
 			},
+			{
+				Name:      "signal",
+				Usage:     "Send a signal to the processes of a running Job, i.e. 'HUP', 'SIGUSR1' or '10'.",
+				UsageText: "telepilot [global options] signal <job_id> <signal>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 2 { //nolint:mnd // Job id and signal.
+						return cli.ShowSubcommandHelp(cmd)
+					}
+					return client.SignalJob(ctx, cmd.Args().Get(0), cmd.Args().Get(1))
+				},
+			},
+			{
+				Name:  "acl",
+				Usage: "Share a Job with other users or groups, replacing its access control list. Clears it when no entry is given.",
+				UsageText: "telepilot [global options] acl <job_id> [entries...]\n\n" +
+					"Entries are 'user:<name>=<permissions>' or 'group:<name>=<permissions>', with comma separated permissions\n" +
+					"among 'read_logs', 'read_status', 'signal' and 'stop', i.e. 'group:oncall=read_logs,read_status'.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if !cmd.Args().Present() {
+						return cli.ShowSubcommandHelp(cmd)
+					}
+					var acl []*pb.JobACLEntry
+					for _, elem := range cmd.Args().Tail() {
+						entry, err := parseACLEntry(elem)
+						if err != nil {
+							return err
+						}
+						acl = append(acl, entry)
+					}
+					return client.SetJobACL(ctx, cmd.Args().First(), acl)
+				},
+			},
 			{
 				Name:  "status",
 				Usage: "Lookup the status of a Job.",
@@ -94,11 +227,278 @@ func main() {
 						return err //nolint:wrapcheck // No wrap needed here.
 					}
 					fmt.Fprintln(cmd.Writer, status)
+					if !cmd.Bool("verbose") {
+						return nil
+					}
+					details, err := client.GetJobStatusDetails(ctx, jobID)
+					if err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					if startErr := details.GetStartError(); startErr != "" {
+						fmt.Fprintf(cmd.Writer, "Start error: %s\n", startErr)
+					}
+					fmt.Fprintf(cmd.Writer, "Owner: %s", details.GetOwner())
+					if groups := details.GetOwnerGroups(); len(groups) > 0 {
+						fmt.Fprintf(cmd.Writer, " (groups: %s)", strings.Join(groups, ", "))
+					}
+					fmt.Fprintln(cmd.Writer)
+					for _, entry := range details.GetAcl() {
+						fmt.Fprintf(cmd.Writer, "ACL: %s\n", formatACLEntry(entry))
+					}
+					fmt.Fprintf(cmd.Writer, "Resources: %.3g CPU, %d MB\n",
+						float64(details.GetCpuMillis())/1000, details.GetMemoryBytes()>>20) //nolint:mnd // Millis and MB.
+					fmt.Fprintf(cmd.Writer, "Exec sessions: %d\n", details.GetExecSessions())
+					if abi := details.GetLandlockAbi(); abi != 0 {
+						fmt.Fprintf(cmd.Writer, "Landlock: enforced (ABI %d)\n", abi)
+					} else {
+						fmt.Fprintln(cmd.Writer, "Landlock: not restricted")
+					}
+					for _, resource := range slices.Sorted(maps.Keys(details.GetRlimits())) {
+						limit := details.GetRlimits()[resource]
+						fmt.Fprintf(cmd.Writer, "Rlimit %s: %s\n", resource, rlimit.Limit{Soft: limit.GetSoft(), Hard: limit.GetHard()})
+					}
+					if scheduleID := details.GetScheduleId(); scheduleID != "" {
+						fmt.Fprintf(cmd.Writer, "Schedule: %s\n", scheduleID)
+					}
+					if workflowID := details.GetWorkflowId(); workflowID != "" {
+						fmt.Fprintf(cmd.Writer, "Workflow: %s\n", workflowID)
+					}
+					fmt.Fprintf(cmd.Writer, "Restarts: %d\n", details.GetRestartCount())
+					for _, attempt := range details.GetAttempts() {
+						fmt.Fprintf(cmd.Writer, "Attempt started %s: exited with code %d after %s\n",
+							time.UnixMilli(attempt.GetStartedAtUnixMs()).Format(time.RFC3339),
+							attempt.GetExitCode(), time.Duration(attempt.GetDurationMs())*time.Millisecond) //nolint:gosec // False positive, durations are small.
+					}
 					return nil
 				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "Show the job details, i.e. exec sessions or enforced restrictions.",
+					},
+				},
 				Arguments: []cli.Argument{jobIDArg},
 				Before:    parseJobID,
 			},
+			{
+				Name:  "workflow",
+				Usage: "Manage the workflows, DAGs of Jobs each started once its dependencies are done.",
+				Commands: []*cli.Command{
+					{
+						Name:  "submit",
+						Usage: "Submit a workflow from a JSON spec file.",
+						UsageText: "telepilot [global options] workflow submit <spec.json>\n\n" +
+							"The spec is the JSON form of SubmitWorkflowRequest, i.e.:\n" +
+							`{"steps": [{"name": "fetch", "job": {"command": "git", "args": ["clone", "..."]}},` + "\n" +
+							`           {"name": "build", "job": {"command": "make"}, "depends_on": ["fetch"]},` + "\n" +
+							`           {"name": "notify", "job": {"command": "./notify.sh"}, "depends_on": ["build"], "condition": "STEP_CONDITION_ON_FAILURE"}]}`,
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							buf, err := os.ReadFile(cmd.Args().First())
+							if err != nil {
+								return fmt.Errorf("read workflow spec: %w", err)
+							}
+							var req pb.SubmitWorkflowRequest
+							if err := protojson.Unmarshal(buf, &req); err != nil {
+								return fmt.Errorf("parse workflow spec: %w", err)
+							}
+							workflowID, err := client.SubmitWorkflow(ctx, &req)
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							fmt.Fprintln(cmd.Writer, workflowID)
+							return nil
+						},
+						Arguments: []cli.Argument{&cli.StringArg{
+							Name:      "<spec.json>",
+							UsageText: "<spec.json>",
+							Min:       1,
+							Max:       1,
+						}},
+					},
+					{
+						Name:  "status",
+						Usage: "Lookup the status of a workflow and its steps.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							resp, err := client.GetWorkflowStatus(ctx, cmd.Args().First())
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							fmt.Fprintln(cmd.Writer, resp.GetStatus())
+							for _, step := range resp.GetSteps() {
+								fmt.Fprintf(cmd.Writer, "%s\t%s", step.GetName(), step.GetStatus())
+								if step.GetJobId() != "" {
+									fmt.Fprintf(cmd.Writer, "\tjob %s", step.GetJobId())
+								}
+								if step.ExitCode != nil {
+									fmt.Fprintf(cmd.Writer, "\texit code %d", step.GetExitCode())
+								}
+								if step.GetError() != "" {
+									fmt.Fprintf(cmd.Writer, "\terror: %s", step.GetError())
+								}
+								fmt.Fprintln(cmd.Writer)
+							}
+							return nil
+						},
+						Arguments: []cli.Argument{&cli.StringArg{
+							Name:      "<workflow_id>",
+							UsageText: "<workflow_id>",
+							Min:       1,
+							Max:       1,
+						}},
+					},
+				},
+			},
+			{
+				Name:  "quota",
+				Usage: "Show the quotas of the user and of its groups, with their usage.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					quotas, err := client.GetQuota(ctx)
+					if err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					for _, q := range quotas {
+						fmt.Fprintln(cmd.Writer, formatQuota(q))
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "auth",
+				Usage: "Inspect the permissions of the user.",
+				Commands: []*cli.Command{
+					{
+						Name:      "can-i",
+						Usage:     "Check whether the user is allowed to call a method, optionally on a given Job.",
+						UsageText: "telepilot [global options] auth can-i <method> [job_id]\n\ni.e. 'telepilot auth can-i StopJob <job_id>'.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() < 1 || cmd.Args().Len() > 2 { //nolint:mnd // Method and optional job id.
+								return cli.ShowSubcommandHelp(cmd)
+							}
+							ok, role, err := client.CanI(ctx, cmd.Args().First(), cmd.Args().Get(1))
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							if !ok {
+								fmt.Fprintln(cmd.Writer, "no")
+								return nil
+							}
+							fmt.Fprintf(cmd.Writer, "yes (role %s)\n", role)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:      "enroll",
+				Usage:     "Get a client certificate with an enrollment token, written in the certs directory.",
+				UsageText: "telepilot [global options] enroll --token <token>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					keyPEM, csrPEM, err := apiclient.GenerateKey()
+					if err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					resp, err := client.Enroll(ctx, cmd.String("token"), csrPEM)
+					if err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					if err := apiclient.WriteClientCert(cmd.String("certs"), resp.GetUser(), resp.GetCertificate(), keyPEM); err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					fmt.Fprintf(cmd.Writer, "Enrolled as %q, certificate valid until %s.\n",
+						resp.GetUser(), time.UnixMilli(resp.GetNotAfterUnixMs()).Format(time.RFC3339))
+					return nil
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "token",
+						Usage:    "Enrollment token, given by an admin.",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:  "ca",
+				Usage: "Manage the client certificates. Requires the built-in certificate authority.",
+				Commands: []*cli.Command{
+					{
+						Name:  "issue",
+						Usage: "Issue a client certificate.",
+						UsageText: "telepilot [global options] ca issue [options] <user>\n\n" +
+							"Signs the CSR file and prints the certificate when --csr is set,\n" +
+							"otherwise generates a key and writes both in the certs directory.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(cmd)
+							}
+							user := cmd.Args().First()
+							var keyPEM, csrPEM []byte
+							var err error
+							if name := cmd.String("csr"); name != "" {
+								csrPEM, err = os.ReadFile(name)
+							} else {
+								keyPEM, csrPEM, err = apiclient.GenerateKey()
+							}
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							resp, err := client.IssueClientCert(ctx, csrPEM, user, cmd.StringSlice("group"), cmd.Duration("ttl"))
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							if keyPEM == nil {
+								_, err := cmd.Writer.Write(resp.GetCertificate())
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							if err := apiclient.WriteClientCert(cmd.String("certs"), user, resp.GetCertificate(), keyPEM); err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							fmt.Fprintf(cmd.Writer, "Issued serial %s for %q, valid until %s.\n",
+								resp.GetSerial(), user, time.UnixMilli(resp.GetNotAfterUnixMs()).Format(time.RFC3339))
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "csr", Usage: "PEM CSR file to sign."},
+							&cli.StringSliceFlag{Name: "group", Usage: "Group of the user. Can be repeated."},
+							&cli.DurationFlag{Name: "ttl", Usage: "Lifetime of the certificate. Defaults to the server max."},
+						},
+					},
+					{
+						Name:      "token",
+						Usage:     "Create a one-time enrollment token for a user.",
+						UsageText: "telepilot [global options] ca token [options] <user>",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(cmd)
+							}
+							token, expiresAt, err := client.CreateEnrollmentToken(ctx, cmd.Args().First(), cmd.StringSlice("group"),
+								cmd.Duration("ttl"), cmd.Duration("token-ttl"))
+							if err != nil {
+								return err //nolint:wrapcheck // No wrap needed here.
+							}
+							fmt.Fprintln(cmd.Writer, token)
+							fmt.Fprintf(cmd.ErrWriter, "Expires at %s.\n", expiresAt.Format(time.RFC3339))
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{Name: "group", Usage: "Group of the user. Can be repeated."},
+							&cli.DurationFlag{Name: "ttl", Usage: "Lifetime of the certificate. Defaults to the server max."},
+							&cli.DurationFlag{Name: "token-ttl", Usage: "Lifetime of the token. Defaults to 24h."},
+						},
+					},
+					{
+						Name:      "revoke",
+						Usage:     "Revoke a client certificate.",
+						UsageText: "telepilot [global options] ca revoke <serial>",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() != 1 {
+								return cli.ShowSubcommandHelp(cmd)
+							}
+							return client.RevokeClientCert(ctx, cmd.Args().First())
+						},
+					},
+				},
+			},
 			{
 				Name:  "logs",
 				Usage: "Streams logs from a Job until it exits.",
@@ -108,6 +508,95 @@ func main() {
 				Arguments: []cli.Argument{jobIDArg},
 				Before:    parseJobID,
 			},
+			{
+				Name:      "exec",
+				Usage:     "Run a command within a running Job, sharing its namespaces and cgroup.",
+				UsageText: "telepilot [global options] exec [options] <job_id> -- <command> [arguments...]",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() < 2 { //nolint:mnd // Job id and command.
+						return cli.ShowSubcommandHelp(cmd)
+					}
+					id, args := cmd.Args().First(), cmd.Args().Tail()
+					stdio := apiclient.ExecIO{Stdin: os.Stdin, Stdout: cmd.Writer, Stderr: cmd.ErrWriter}
+
+					tty := cmd.Bool("tty")
+					if tty {
+						restore, sizes, err := setupTerminal(ctx)
+						if err != nil {
+							return err
+						}
+						defer func() { _ = restore() }() // Best effort.
+						stdio.TerminalSize = sizes
+					}
+
+					code, err := client.ExecInJob(ctx, id, args[0], args[1:], tty, stdio)
+					if err != nil {
+						return err //nolint:wrapcheck // No wrap needed here.
+					}
+					exitCode = code
+					return nil
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "tty",
+						Aliases: []string{"t"},
+						Usage:   "Run the command in a pseudo-terminal. Requires stdin to be a terminal.",
+					},
+				},
+			},
+			{
+				Name:      "port-forward",
+				Usage:     "Forward a local port to a port on the loopback of a Job, over the API connection.",
+				UsageText: "telepilot [global options] port-forward [options] <job_id> <local_port>:<job_port>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 2 { //nolint:mnd // Job id and ports.
+						return cli.ShowSubcommandHelp(cmd)
+					}
+					id := cmd.Args().Get(0)
+					ports, err := parsePortMapping(cmd.Args().Get(1))
+					if err != nil {
+						return err
+					}
+					if ports.GetProtocol() != pb.Protocol_PROTOCOL_TCP_UNSPECIFIED {
+						return fmt.Errorf("only tcp can be forwarded") //nolint:err113 // No need for fancy error here.
+					}
+
+					ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+					defer cancel()
+
+					addr := net.JoinHostPort(cmd.String("address"), strconv.FormatUint(uint64(ports.GetHostPort()), 10))
+					lis, err := (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
+					if err != nil {
+						return fmt.Errorf("listen: %w", err)
+					}
+					go func() { <-ctx.Done(); _ = lis.Close() }() // Best effort.
+					fmt.Fprintf(cmd.Writer, "Forwarding from %s to port %d of %s\n", lis.Addr(), ports.GetJobPort(), id)
+
+					// Each local connection gets its own stream, multiplexed over the API connection.
+					for {
+						conn, err := lis.Accept()
+						if err != nil {
+							if ctx.Err() != nil {
+								return nil
+							}
+							return fmt.Errorf("accept: %w", err)
+						}
+						go func() {
+							defer func() { _ = conn.Close() }() // Best effort.
+							if err := client.PortForward(ctx, id, ports.GetJobPort(), conn); err != nil {
+								slog.Error("Port forward failed.", "error", err)
+							}
+						}()
+					}
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "address",
+						Value: "localhost",
+						Usage: "Local address to listen on.",
+					},
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -121,6 +610,12 @@ func main() {
 				Value: "alice",
 				Usage: "Client user name. Cert and key expected in <certdir>.",
 			},
+			&cli.DurationFlag{
+				Name:  "renew-before",
+				Value: 6 * time.Hour, //nolint:mnd // Default value.
+				Usage: "Renew the client certificate when it expires within this duration, rewriting its file. " +
+					"Requires the built-in CA on the server. 0 to disable.",
+			},
 		},
 	}
 
@@ -128,4 +623,365 @@ func main() {
 		slog.Error(err.Error())
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
+}
+
+// setupTerminal puts the local terminal in raw mode and reports its size, initially and on SIGWINCH.
+// Returns a function to restore the terminal.
+func setupTerminal(ctx context.Context) (func() error, <-chan *pb.TerminalSize, error) {
+	if !terminal.IsTerminal(os.Stdin) {
+		return nil, nil, fmt.Errorf("stdin is not a terminal") //nolint:err113 // No need for fancy error here.
+	}
+	restore, err := terminal.MakeRaw(os.Stdin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("set terminal raw mode: %w", err)
+	}
+
+	sizes := make(chan *pb.TerminalSize, 1)
+	sendSize := func() {
+		size, err := terminal.GetSize(os.Stdin)
+		if err != nil {
+			return
+		}
+		select {
+		case sizes <- &pb.TerminalSize{Rows: uint32(size.Rows), Cols: uint32(size.Cols)}:
+		default: // Previous size not consumed yet, drop.
+		}
+	}
+	sendSize()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigCh:
+				sendSize()
+			}
+		}
+	}()
+	return restore, sizes, nil
+}
+
+// formatScheduleRuns describes the last and next runs of the schedule.
+func formatScheduleRuns(sc *pb.Schedule) string {
+	next := "next: none"
+	if ms := sc.GetNextRunUnixMs(); ms != 0 {
+		next = "next: " + time.UnixMilli(ms).Format(time.RFC3339)
+	}
+	if sc.GetDisabled() {
+		next = "disabled"
+	}
+	last := "last: never"
+	switch {
+	case sc.GetLastRunUnixMs() == 0:
+	case sc.GetLastError() != "":
+		last = fmt.Sprintf("last: %s (failed: %s)", time.UnixMilli(sc.GetLastRunUnixMs()).Format(time.RFC3339), sc.GetLastError())
+	default:
+		last = fmt.Sprintf("last: %s (job %s)", time.UnixMilli(sc.GetLastRunUnixMs()).Format(time.RFC3339), sc.GetLastJobId())
+	}
+	return next + ", " + last
+}
+
+// startJobOptions builds the start job options from the flags.
+func startJobOptions(cmd *cli.Command) ([]apiclient.StartJobOption, error) {
+	var network pb.NetworkMode
+	switch mode := cmd.String("network"); mode {
+	case "none":
+		network = pb.NetworkMode_NETWORK_MODE_NONE_UNSPECIFIED
+	case "bridged":
+		network = pb.NetworkMode_NETWORK_MODE_BRIDGED
+	default:
+		return nil, fmt.Errorf("invalid network mode %q, expect 'none' or 'bridged'", mode) //nolint:err113 // No need for fancy error here.
+	}
+	var profile pb.SeccompProfile
+	switch name := cmd.String("seccomp"); name {
+	case "default":
+		profile = pb.SeccompProfile_SECCOMP_PROFILE_DEFAULT_UNSPECIFIED
+	case "strict":
+		profile = pb.SeccompProfile_SECCOMP_PROFILE_STRICT
+	case "unconfined":
+		profile = pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED
+	default:
+		return nil, fmt.Errorf("invalid seccomp profile %q, expect 'default', 'strict' or 'unconfined'", name) //nolint:err113 // No need for fancy error here.
+	}
+	var ports []*pb.PortMapping
+	for _, elem := range cmd.StringSlice("publish") {
+		port, err := parsePortMapping(elem)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	restartPolicy, err := parseRestartPolicy(cmd.String("restart"))
+	if err != nil {
+		return nil, err
+	}
+	var cpuMillis, memoryBytes uint64
+	if s := cmd.String("cpus"); s != "" {
+		if cpuMillis, err = cgroups.ParseCPU(s); err != nil {
+			return nil, err //nolint:wrapcheck // No wrap needed here.
+		}
+	}
+	if s := cmd.String("memory"); s != "" {
+		if memoryBytes, err = cgroups.ParseMemory(s); err != nil {
+			return nil, err //nolint:wrapcheck // No wrap needed here.
+		}
+	}
+	opts := []apiclient.StartJobOption{
+		apiclient.WithHostname(cmd.String("hostname")),
+		apiclient.WithNetwork(network),
+		apiclient.WithPorts(ports...),
+		apiclient.WithSeccompProfile(profile),
+		apiclient.WithCapabilities(cmd.StringSlice("cap-add"), cmd.StringSlice("cap-drop")),
+		apiclient.WithNoNewPrivileges(!cmd.Bool("allow-new-privileges")),
+		apiclient.WithLandlock(cmd.StringSlice("landlock-ro"), cmd.StringSlice("landlock-rw")),
+		apiclient.WithMaxDuration(cmd.Duration("max-duration")),
+		apiclient.WithMaxCPUTime(cmd.Duration("max-cpu-time")),
+		apiclient.WithResources(cpuMillis, memoryBytes),
+		apiclient.WithPriority(int32(cmd.Int("priority"))), //nolint:gosec // False positive, checked by the server.
+		restartPolicy,
+	}
+	for _, elem := range cmd.StringSlice("rlimit") {
+		opt, err := parseRlimit(elem)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	for _, elem := range cmd.StringSlice("acl") {
+		entry, err := parseACLEntry(elem)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, apiclient.WithACL(entry))
+	}
+	return opts, nil
+}
+
+// startJobFlags are the flags describing the job to start.
+func startJobFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "Hostname within the job. Defaults to the short job ID.",
+		},
+		&cli.StringFlag{
+			Name:  "network",
+			Value: "none",
+			Usage: "Network of the job. 'none' for loopback only, 'bridged' for outbound connectivity via NAT.",
+		},
+		&cli.StringFlag{
+			Name:  "seccomp",
+			Value: "default",
+			Usage: "Syscall filtering profile. 'default', 'strict' or 'unconfined' (if allowed by the server).",
+		},
+		&cli.StringSliceFlag{
+			Name:  "publish",
+			Usage: "Publish a port of the job on the server host, i.e. '8080:80' or '5353:53/udp'. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "Add a capability to the default set, i.e. 'NET_ADMIN' or 'ALL'. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "Drop a capability from the default set, i.e. 'NET_RAW' or 'ALL'. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "landlock-ro",
+			Usage: "Restrict the job's host filesystem access, allowing to read/execute beneath the given path. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "landlock-rw",
+			Usage: "Restrict the job's host filesystem access, allowing to read/write beneath the given path. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "rlimit",
+			Usage: "Set a resource limit, i.e. 'nofile=1024:4096', 'core=0' or 'cpu=unlimited'. Can be repeated.",
+		},
+		&cli.DurationFlag{
+			Name:  "max-duration",
+			Usage: "Terminate the job once it ran for the given duration, i.e. '1h30m'. No limit by default.",
+		},
+		&cli.DurationFlag{
+			Name:  "max-cpu-time",
+			Usage: "Terminate the job once its processes consumed the given CPU time, i.e. '10m'. No limit by default.",
+		},
+		&cli.StringFlag{
+			Name:  "restart",
+			Value: "never",
+			Usage: "Restart the job once it exits, with exponential backoff. 'never', 'on-failure[:<max_retries>]' or 'always'.",
+		},
+		&cli.StringFlag{
+			Name:  "cpus",
+			Usage: "CPU of the job, i.e. '1.5'. Defaults to the server preset (0.5).",
+		},
+		&cli.StringFlag{
+			Name:  "memory",
+			Usage: "Max memory of the job, with an optional K, M or G suffix, i.e. '256M'. Defaults to the server preset (50M).",
+		},
+		&cli.IntFlag{
+			Name:  "priority",
+			Usage: "Admission priority when the job gets queued, the highest first. Ignored unless the server orders by priority.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "acl",
+			Usage: "Share the job with another user or group, i.e. 'user:bob=read_logs,stop' or 'group:oncall=read_status'. Can be repeated.",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-new-privileges",
+			Usage: "Don't set no_new_privs, allowing the job to gain privileges via setuid binaries or file capabilities.",
+		},
+	}
+}
+
+// parseRestartPolicy parses `never`, `on-failure[:<max_retries>]` or `always`.
+func parseRestartPolicy(s string) (apiclient.StartJobOption, error) {
+	name, retries, hasRetries := strings.Cut(s, ":")
+	var mode pb.RestartMode
+	switch name {
+	case "never":
+		mode = pb.RestartMode_RESTART_MODE_NEVER_UNSPECIFIED
+	case "on-failure":
+		mode = pb.RestartMode_RESTART_MODE_ON_FAILURE
+	case "always":
+		mode = pb.RestartMode_RESTART_MODE_ALWAYS
+	default:
+		return nil, fmt.Errorf("invalid restart policy %q, expect 'never', 'on-failure[:<max_retries>]' or 'always'", s) //nolint:err113 // No need for fancy error here.
+	}
+	var maxRetries uint64
+	if hasRetries {
+		if mode != pb.RestartMode_RESTART_MODE_ON_FAILURE {
+			return nil, fmt.Errorf("invalid restart policy %q, max retries only applies to on-failure", s) //nolint:err113 // No need for fancy error here.
+		}
+		n, err := strconv.ParseUint(retries, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max retries in %q: %w", s, err)
+		}
+		maxRetries = n
+	}
+	return apiclient.WithRestartPolicy(mode, uint32(maxRetries)), nil //nolint:gosec // False positive, parsed as 32 bits.
+}
+
+// parseRlimit parses `<resource>=<soft>[:<hard>]`, the hard limit defaulting to the soft one.
+func parseRlimit(s string) (apiclient.StartJobOption, error) {
+	resource, values, ok := strings.Cut(s, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid rlimit %q, expect <resource>=<soft>[:<hard>]", s) //nolint:err113 // No need for fancy error here.
+	}
+	softValue, hardValue, hasHard := strings.Cut(values, ":")
+	soft, err := rlimit.ParseValue(softValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid soft limit in %q: %w", s, err)
+	}
+	hard := soft
+	if hasHard {
+		if hard, err = rlimit.ParseValue(hardValue); err != nil {
+			return nil, fmt.Errorf("invalid hard limit in %q: %w", s, err)
+		}
+	}
+	return apiclient.WithRlimit(resource, soft, hard), nil
+}
+
+// parsePortMapping parses `<host_port>:<job_port>[/tcp|/udp]`.
+func parsePortMapping(s string) (*pb.PortMapping, error) {
+	ports, proto, _ := strings.Cut(s, "/")
+	hostPort, jobPort, ok := strings.Cut(ports, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid port mapping %q, expect <host_port>:<job_port>[/tcp|/udp]", s) //nolint:err113 // No need for fancy error here.
+	}
+	host, err := strconv.ParseUint(hostPort, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host port in %q: %w", s, err)
+	}
+	job, err := strconv.ParseUint(jobPort, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job port in %q: %w", s, err)
+	}
+	mapping := &pb.PortMapping{HostPort: uint32(host), JobPort: uint32(job)}
+	switch proto {
+	case "", "tcp":
+		mapping.Protocol = pb.Protocol_PROTOCOL_TCP_UNSPECIFIED
+	case "udp":
+		mapping.Protocol = pb.Protocol_PROTOCOL_UDP
+	default:
+		return nil, fmt.Errorf("invalid protocol in %q, expect 'tcp' or 'udp'", s) //nolint:err113 // No need for fancy error here.
+	}
+	return mapping, nil
+}
+
+// aclPermissions are the job permissions by name.
+//
+//nolint:gochecknoglobals // Expected global.
+var aclPermissions = map[string]pb.JobPermission{
+	"read_logs":   pb.JobPermission_JOB_PERMISSION_READ_LOGS,
+	"read_status": pb.JobPermission_JOB_PERMISSION_READ_STATUS,
+	"signal":      pb.JobPermission_JOB_PERMISSION_SIGNAL,
+	"stop":        pb.JobPermission_JOB_PERMISSION_STOP,
+}
+
+// parseACLEntry parses `user:<name>=<permission>[,<permission>...]` or `group:<name>=<permission>[,<permission>...]`.
+func parseACLEntry(s string) (*pb.JobACLEntry, error) {
+	grantee, perms, ok := strings.Cut(s, "=")
+	kind, name, ok2 := strings.Cut(grantee, ":")
+	if !ok || !ok2 || name == "" || perms == "" {
+		return nil, fmt.Errorf("invalid acl entry %q, expect user:<name>=<permissions> or group:<name>=<permissions>", s) //nolint:err113 // No need for fancy error here.
+	}
+	entry := &pb.JobACLEntry{}
+	switch kind {
+	case "user":
+		entry.User = name
+	case "group":
+		entry.Group = name
+	default:
+		return nil, fmt.Errorf("invalid acl entry %q, expect 'user' or 'group'", s) //nolint:err113 // No need for fancy error here.
+	}
+	for _, perm := range strings.Split(perms, ",") {
+		p, ok := aclPermissions[perm]
+		if !ok {
+			return nil, fmt.Errorf("invalid permission %q in %q, expect 'read_logs', 'read_status', 'signal' or 'stop'", perm, s) //nolint:err113 // No need for fancy error here.
+		}
+		entry.Permissions = append(entry.Permissions, p)
+	}
+	return entry, nil
+}
+
+// formatACLEntry formats the entry the way parseACLEntry expects it.
+func formatACLEntry(entry *pb.JobACLEntry) string {
+	grantee := "user:" + entry.GetUser()
+	if entry.GetGroup() != "" {
+		grantee = "group:" + entry.GetGroup()
+	}
+	perms := make([]string, 0, len(entry.GetPermissions()))
+	for _, perm := range entry.GetPermissions() {
+		perms = append(perms, strings.ToLower(strings.TrimPrefix(perm.String(), "JOB_PERMISSION_")))
+	}
+	return grantee + "=" + strings.Join(perms, ",")
+}
+
+// formatQuota formats the usage of the quota against its limits, i.e. "user alice: jobs 1/5, cpu 0.5/unlimited, ...".
+func formatQuota(q *pb.Quota) string {
+	name := "user " + q.GetUser()
+	if q.GetGroup() != "" {
+		name = "group " + q.GetGroup()
+	}
+	limit := func(usage string, limit uint64, format func(uint64) string) string {
+		if limit == 0 {
+			return usage + "/unlimited"
+		}
+		return usage + "/" + format(limit)
+	}
+	count := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	cpu := func(n uint64) string { return strconv.FormatFloat(float64(n)/1000, 'g', 3, 64) } //nolint:mnd // Millis.
+	mb := func(n uint64) string { return strconv.FormatUint(n>>20, 10) + "MB" }              //nolint:mnd // MB.
+	usage, limits := q.GetUsage(), q.GetLimits()
+	return fmt.Sprintf("%s: jobs %s, cpu %s, memory %s, logs %s, jobs in the last hour %s", name,
+		limit(count(uint64(usage.GetJobs())), uint64(limits.GetJobs()), count),
+		limit(cpu(usage.GetCpuMillis()), limits.GetCpuMillis(), cpu),
+		limit(mb(usage.GetMemoryBytes()), limits.GetMemoryBytes(), mb),
+		limit(count(usage.GetLogBytes())+"B", limits.GetLogBytes(), func(n uint64) string { return count(n) + "B" }),
+		limit(count(uint64(usage.GetJobsPerHour())), uint64(limits.GetJobsPerHour()), count))
 }
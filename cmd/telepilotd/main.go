@@ -4,27 +4,90 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
 	pb "go.creack.net/telepilot/api/v1"
 	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/audit"
+	"go.creack.net/telepilot/pkg/ca"
 	"go.creack.net/telepilot/pkg/cgroups"
 	"go.creack.net/telepilot/pkg/initd"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/landlock"
+	"go.creack.net/telepilot/pkg/network"
+	"go.creack.net/telepilot/pkg/portproxy"
+	"go.creack.net/telepilot/pkg/rlimit"
 	"go.creack.net/telepilot/pkg/tlsconfig"
 )
 
 func main() {
 	keyDir := flag.String("certs", "./certs",
-		"Certs directory. Expecting <certdir>/ca.pem, <certdir>/server.pem and <certdir>/server-key.pem.")
+		"Certs directory. Expecting <certdir>/ca.pem, <certdir>/server.pem and <certdir>/server-key.pem. "+
+			"Reloaded when changed on disk or on SIGHUP.")
 	isInit := flag.Bool("init", false, "internal flag to toggle init mode")
+	isExec := flag.Bool("exec", false, "internal flag to toggle exec session mode")
+	userNSMode := flag.String("userns", "none",
+		"Run jobs in a user namespace. 'none' to disable, 'job' for a subordinate id range per job, "+
+			"'owner' for a range per owner.")
+	subUIDBase := uint32Flag("subuid-base", 100000, "First host uid of the subordinate ranges used for user namespaces.")
+	subGIDBase := uint32Flag("subgid-base", 100000, "First host gid of the subordinate ranges used for user namespaces.")
+	subIDSize := uint32Flag("subid-size", 65536, "Number of uids/gids per subordinate range.")
+	subIDCount := uint32Flag("subid-count", 1024, "Number of subordinate ranges available.")
+	stateDir := flag.String("state-dir", "/var/lib/telepilot", "Directory where the server state is persisted, i.e. the schedules.")
+	bridge := flag.String("network-bridge", "",
+		"Name of the host bridge for the jobs requesting a bridged network. Bridged network is disabled when empty.")
+	subnet := flag.String("network-subnet", "10.77.0.0/16",
+		"Subnet of the bridged network. The first address is assigned to the bridge.")
+	publishAddr := flag.String("publish-address", "", "Host address the published job ports listen on. All addresses when empty.")
+	publishRanges := flag.String("publish-ranges", "",
+		"Host port ranges each user can publish, i.e. 'alice=8000-8099,9000;*=30000-30999'. "+
+			"'*' applies to everyone. Publishing is denied when empty.")
+	rlimitMaxima := flag.String("rlimit-max", "",
+		"Maximum resource limits of the jobs, i.e. 'nofile=65536,nproc=4096,core=0'. "+
+			"The resources not requested by a job default to their maximum.")
+	stopGracePeriod := flag.Duration("stop-grace-period", 10*time.Second,
+		"Delay between SIGTERM and SIGKILL when terminating a job, i.e. after a timeout.")
+	unconfinedUsers := flag.String("seccomp-unconfined-users", "",
+		"Comma separated list of users allowed to run jobs without seccomp filtering.")
+	capAddAllowed := flag.String("cap-add-allowed", "",
+		"Comma separated capabilities everyone can add to the jobs' default set, i.e. 'NET_ADMIN,SYS_PTRACE'. None when empty.")
+	capAddUsers := flag.String("cap-add-users", "", "Comma separated users allowed to add any capability to their jobs.")
+	maxJobs := flag.Int("max-jobs", 0, "Max jobs running concurrently, the others wait in the admission queue. 0 for no limit.")
+	maxJobsPerUser := flag.Int("max-jobs-per-user", 0, "Max jobs running concurrently per user. 0 for no limit.")
+	cpuBudget := flag.String("cpu-budget", "",
+		"Max sum of the CPU of the running jobs, i.e. '8' or '3.5'. No limit when empty.")
+	memoryBudget := flag.String("memory-budget", "",
+		"Max sum of the memory of the running jobs, with an optional K, M or G suffix, i.e. '16G'. No limit when empty.")
+	queueOrder := flag.String("queue-order", "fifo", "Order of the admission queue. 'fifo' or 'priority'.")
+	crlFiles := flag.String("crl", "",
+		"Comma separated list of CRL files (PEM or DER) signed by the CA. Revoked client certificates are rejected. "+
+			"Reloaded when changed on disk.")
+	caKey := flag.String("ca-key", "",
+		"Key of the CA (<certdir>/ca.pem) to enable the built-in certificate authority, issuing the client certificates "+
+			"and enrolling the users. Its CRL is written in the state dir and enforced. Disabled when empty.")
+	maxCertTTL := flag.Duration("max-cert-ttl", ca.DefaultMaxTTL, "Max lifetime of the client certificates issued by the built-in CA.")
+	renewCertTTL := flag.Duration("renew-cert-ttl", ca.DefaultRenewTTL,
+		"Max lifetime of the client certificates renewed by the built-in CA, expected to be short.")
+	policyFile := flag.String("policy", "",
+		"RBAC policy file, reloaded on SIGHUP. When empty, everyone can start jobs and only access their own.")
+	quotaFile := flag.String("quota", "",
+		"Quota file, limiting the jobs, resources and retained logs per user and group. No quota when empty.")
+	auditLog := flag.String("audit-log", "",
+		"Tamper-evident log of all the API calls, verifiable with 'telepilotd audit verify'. Defaults to <state-dir>/audit.log.")
+	auditKey := flag.String("audit-key", "/etc/telepilot/audit.key",
+		"HMAC key chaining the audit log entries, hex encoded, generated if missing. Must be outside the audit log directory.")
 	flag.Parse()
 
 	if *isInit {
@@ -34,16 +97,183 @@ func main() {
 		}
 		return
 	}
+	if *isExec {
+		if err := initd.Exec(flag.Args()); err != nil {
+			slog.Error("Exec error.", "error", err, "args", flag.Args())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *auditLog == "" {
+		*auditLog = path.Join(*stateDir, "audit.log")
+	}
+	if flag.NArg() > 0 {
+		if err := subcommand(flag.Args(), *auditLog, *auditKey); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	mode, err := jobmanager.ParseUserNamespaceMode(*userNSMode)
+	if err != nil {
+		slog.Error("Invalid user namespace mode.", "error", err)
+		os.Exit(1)
+	}
+	opts := []apiserver.Option{
+		apiserver.WithStateDir(*stateDir),
+		apiserver.WithPolicyFile(*policyFile),
+		apiserver.WithAuditLog(*auditLog, *auditKey),
+		apiserver.WithJobManagerOptions(jobmanager.WithUserNamespace(jobmanager.UserNamespaceConfig{
+			Mode:    mode,
+			UIDBase: *subUIDBase,
+			GIDBase: *subGIDBase,
+			Size:    *subIDSize,
+			Count:   *subIDCount,
+		})),
+	}
+	if *unconfinedUsers != "" {
+		opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithUnconfinedUsers(strings.Split(*unconfinedUsers, ",")...)))
+	}
+	if *capAddAllowed != "" {
+		opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithAddableCapabilities(strings.Split(*capAddAllowed, ",")...)))
+	}
+	if *capAddUsers != "" {
+		opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithCapAddUsers(strings.Split(*capAddUsers, ",")...)))
+	}
+	opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithStopGracePeriod(*stopGracePeriod)))
+	maxima, err := rlimit.ParseMaxima(*rlimitMaxima)
+	if err != nil {
+		slog.Error("Invalid rlimit maxima.", "error", err)
+		os.Exit(1)
+	}
+	opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithRlimitMaxima(maxima)))
+	admission, err := parseAdmissionConfig(*maxJobs, *maxJobsPerUser, *cpuBudget, *memoryBudget, *queueOrder)
+	if err != nil {
+		slog.Error("Invalid admission config.", "error", err)
+		os.Exit(1)
+	}
+	opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithAdmission(admission)))
+	if *quotaFile != "" {
+		quotas, err := jobmanager.LoadQuotaConfig(*quotaFile)
+		if err != nil {
+			slog.Error("Invalid quota file.", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithQuotas(quotas)))
+	}
+	allowedRanges, err := portproxy.ParseAllowedRanges(*publishRanges)
+	if err != nil {
+		slog.Error("Invalid publish ranges.", "error", err)
+		os.Exit(1)
+	}
+	opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithPortPublishing(portproxy.Config{
+		Address:       *publishAddr,
+		AllowedRanges: allowedRanges,
+	})))
+	if *bridge != "" {
+		_, ipNet, err := net.ParseCIDR(*subnet)
+		if err != nil {
+			slog.Error("Invalid network subnet.", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, apiserver.WithJobManagerOptions(jobmanager.WithNetwork(network.Config{
+			Bridge:   *bridge,
+			Subnet:   ipNet,
+			StateDir: *stateDir,
+		})))
+	}
+
+	var tlsOpts []tlsconfig.Option
+	if *crlFiles != "" {
+		tlsOpts = append(tlsOpts, tlsconfig.WithCRLFiles(strings.Split(*crlFiles, ",")...))
+	}
+	if *caKey != "" {
+		authority, err := ca.New(ca.Config{
+			CertFile: path.Join(*keyDir, "ca.pem"),
+			KeyFile:  *caKey,
+			StateDir: *stateDir,
+			MaxTTL:   *maxCertTTL,
+			RenewTTL: *renewCertTTL,
+		})
+		if err != nil {
+			slog.Error("Failed to load the certificate authority.", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, apiserver.WithCertificateAuthority(authority))
+		// NOTE: The users enroll without certificate, the middlewares reject the other calls without.
+		tlsOpts = append(tlsOpts, tlsconfig.WithCRLFiles(authority.CRLFile()), tlsconfig.WithOptionalClientCerts())
+	}
 
-	server(*keyDir)
+	server(*keyDir, tlsOpts, opts...)
 }
 
-func server(keyDir string) {
-	tlsConfig, err := tlsconfig.LoadTLSConfig(
+// subcommand runs the given subcommand: 'audit verify [file]', defaulting to the audit log, checked with the audit key.
+func subcommand(args []string, auditLog, auditKey string) error {
+	if len(args) < 2 || len(args) > 3 || args[0] != "audit" || args[1] != "verify" { //nolint:mnd // Subcommand and optional file.
+		return fmt.Errorf("unknown subcommand %q, expected 'audit verify [file]'", strings.Join(args, " ")) //nolint:err113 // No need for fancy error here.
+	}
+	if len(args) == 3 { //nolint:mnd // Optional file.
+		auditLog = args[2]
+	}
+	key, err := audit.LoadKey(auditKey, auditLog, false)
+	if err != nil {
+		return fmt.Errorf("load audit key: %w", err)
+	}
+	f, err := os.Open(auditLog)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+	n, err := audit.Verify(f, key)
+	if err != nil {
+		return fmt.Errorf("verify %q after %d valid entries: %w", auditLog, n, err)
+	}
+	fmt.Printf("%s: %d entries, chain valid.\n", auditLog, n) //nolint:forbidigo // Expected output.
+	return nil
+}
+
+// parseAdmissionConfig builds the admission config from the flag values.
+func parseAdmissionConfig(maxJobs, maxJobsPerUser int, cpuBudget, memoryBudget, queueOrder string) (jobmanager.AdmissionConfig, error) {
+	cfg := jobmanager.AdmissionConfig{MaxJobs: maxJobs, MaxJobsPerOwner: maxJobsPerUser}
+	var err error
+	if cpuBudget != "" {
+		if cfg.CPUMillisBudget, err = cgroups.ParseCPU(cpuBudget); err != nil {
+			return cfg, fmt.Errorf("cpu budget: %w", err)
+		}
+	}
+	if memoryBudget != "" {
+		if cfg.MemoryBytesBudget, err = cgroups.ParseMemory(memoryBudget); err != nil {
+			return cfg, fmt.Errorf("memory budget: %w", err)
+		}
+	}
+	if cfg.Ordering, err = jobmanager.ParseQueueOrdering(queueOrder); err != nil {
+		return cfg, fmt.Errorf("queue order: %w", err)
+	}
+	return cfg, nil
+}
+
+// uint32Flag defines an uint32 flag.
+func uint32Flag(name string, value uint32, usage string) *uint32 {
+	p := &value
+	flag.Func(name, fmt.Sprintf("%s (default %d)", usage, value), func(s string) error {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return err //nolint:wrapcheck // The flag package already adds the context.
+		}
+		*p = uint32(n)
+		return nil
+	})
+	return p
+}
+
+func server(keyDir string, tlsOpts []tlsconfig.Option, opts ...apiserver.Option) {
+	certs, err := tlsconfig.NewReloader(
 		path.Join(keyDir, "server.pem"),
 		path.Join(keyDir, "server-key.pem"),
 		path.Join(keyDir, "ca.pem"),
-		false,
+		tlsOpts...,
 	)
 	if err != nil {
 		slog.Error("Failed to load tls config.", "cert_dir", keyDir, "error", err)
@@ -55,9 +285,19 @@ func server(keyDir string) {
 		os.Exit(1)
 	}
 
-	s := apiserver.NewServer()
+	if abi := landlock.ABI(); abi == 0 {
+		slog.Warn("Landlock not supported by the kernel, jobs requesting filesystem restrictions will be rejected.")
+	} else {
+		slog.Info("Landlock available.", "abi", abi)
+	}
+
+	s, err := apiserver.NewServer(append(opts, apiserver.WithRevocationCheck(certs.CheckRevoked))...)
+	if err != nil {
+		slog.Error("Failed to create server.", "error", err)
+		os.Exit(1)
+	}
 	grpcServer := grpc.NewServer(
-		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.Creds(credentials.NewTLS(certs.TLSConfig())),
 		grpc.UnaryInterceptor(s.UnaryMiddleware),
 		grpc.StreamInterceptor(s.StreamMiddleware),
 	)
@@ -66,6 +306,9 @@ func server(keyDir string) {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	go certs.Watch(ctx)
+	go reloadOnSIGHUP(ctx, s, certs)
+
 	doneCh := make(chan struct{})
 	go func() {
 		// TODO: Consider making the addr a flag.
@@ -91,4 +334,27 @@ func server(keyDir string) {
 	grpcServer.GracefulStop()
 	// TODO: Consider adding a timeout.
 	<-doneCh
+	s.Close()
+}
+
+// reloadOnSIGHUP reloads the server policy and certificates on SIGHUP until the context is done.
+func reloadOnSIGHUP(ctx context.Context, s *apiserver.Server, certs *tlsconfig.Reloader) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		if err := certs.Reload(); err != nil {
+			slog.Error("Failed to reload the TLS certificates, keeping the current ones.", "error", err)
+		}
+		if err := s.ReloadPolicy(); err != nil {
+			slog.Error("Failed to reload the policy, keeping the current one.", "error", err)
+			continue
+		}
+		slog.Info("Policy reloaded.")
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -19,9 +20,42 @@ type Client struct {
 	client pb.TelePilotServiceClient
 }
 
+// clientOptions are the optional settings of the client.
+type clientOptions struct {
+	renewCertFile, renewKeyFile string
+	renewThreshold              time.Duration
+}
+
+// ClientOption configures the Client.
+type ClientOption func(*clientOptions)
+
+// WithCertRenewal renews the client certificate from the given files when it expires within the threshold,
+// rewriting the certificate file. The certificate of the TLS config is ignored, the files are used instead.
+// Requires the built-in CA on the server.
+func WithCertRenewal(certFile, keyFile string, threshold time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.renewCertFile, o.renewKeyFile, o.renewThreshold = certFile, keyFile, threshold
+	}
+}
+
 // NewClient returns a client prepared to connect to the server.
-func NewClient(tlsConfig *tls.Config, addr string) (*Client, error) {
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+func NewClient(tlsConfig *tls.Config, addr string, opts ...ClientOption) (*Client, error) {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var dialOpts []grpc.DialOption
+	if o.renewCertFile != "" {
+		r, err := newRenewer(o.renewCertFile, o.renewKeyFile, o.renewThreshold)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = r.getClientCertificate
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(r.unaryInterceptor), grpc.WithChainStreamInterceptor(r.streamInterceptor))
+	}
+	conn, err := grpc.NewClient(addr, append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))...)
 	if err != nil {
 		return nil, fmt.Errorf("grpc new client: %w", err)
 	}
@@ -33,8 +67,107 @@ func (c *Client) Close() error {
 	return c.conn.Close() //nolint:wrapcheck // No wrap needed here.
 }
 
-func (c *Client) StartJob(ctx context.Context, cmd string, args []string) (string, error) {
-	resp, err := c.client.StartJob(ctx, &pb.StartJobRequest{Command: cmd, Args: args})
+// StartJobOption sets optional fields of the start job request.
+type StartJobOption func(*pb.StartJobRequest)
+
+// WithHostname sets the hostname within the job.
+func WithHostname(hostname string) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.Hostname = hostname }
+}
+
+// WithNetwork sets the network mode of the job.
+func WithNetwork(mode pb.NetworkMode) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.Network = mode }
+}
+
+// WithPorts publishes the given job ports on the host.
+func WithPorts(ports ...*pb.PortMapping) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.Ports = append(req.Ports, ports...) }
+}
+
+// WithSeccompProfile sets the syscall filtering profile of the job.
+func WithSeccompProfile(profile pb.SeccompProfile) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.SeccompProfile = profile }
+}
+
+// WithCapabilities adds/drops capabilities from the default set of the job.
+func WithCapabilities(add, drop []string) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.CapAdd, req.CapDrop = add, drop }
+}
+
+// WithNoNewPrivileges toggles the no_new_privs bit of the job. Enabled by default.
+func WithNoNewPrivileges(enabled bool) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.NoNewPrivileges = &enabled }
+}
+
+// WithLandlock restricts the host paths the job can access.
+func WithLandlock(readOnly, readWrite []string) StartJobOption {
+	return func(req *pb.StartJobRequest) {
+		req.Landlock = &pb.LandlockRuleset{ReadOnly: readOnly, ReadWrite: readWrite}
+	}
+}
+
+// WithRlimit sets a resource limit of the job, i.e. "nofile".
+func WithRlimit(resource string, soft, hard uint64) StartJobOption {
+	return func(req *pb.StartJobRequest) {
+		if req.Rlimits == nil {
+			req.Rlimits = map[string]*pb.Rlimit{}
+		}
+		req.Rlimits[resource] = &pb.Rlimit{Soft: soft, Hard: &hard}
+	}
+}
+
+// WithMaxDuration terminates the job once it ran for the given wall-clock duration.
+func WithMaxDuration(d time.Duration) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.MaxDurationMs = uint64(d.Milliseconds()) } //nolint:gosec // Negative is not expected.
+}
+
+// WithMaxCPUTime terminates the job once its processes consumed the given CPU time.
+func WithMaxCPUTime(d time.Duration) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.MaxCpuTimeMs = uint64(d.Milliseconds()) } //nolint:gosec // Negative is not expected.
+}
+
+// WithResources sets the CPU, in thousandths of a CPU, and the max memory, in bytes, of the job. 0 for the server presets.
+func WithResources(cpuMillis, memoryBytes uint64) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.CpuMillis, req.MemoryBytes = cpuMillis, memoryBytes }
+}
+
+// WithPriority sets the admission priority of the job, the highest first when queued.
+func WithPriority(priority int32) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.Priority = priority }
+}
+
+// WithACL grants access to the job to other users.
+func WithACL(entries ...*pb.JobACLEntry) StartJobOption {
+	return func(req *pb.StartJobRequest) { req.Acl = append(req.Acl, entries...) }
+}
+
+// WithRestartPolicy restarts the job once its process exits, with exponential backoff.
+// SignalJob sends the given signal to the processes of the job, i.e. "HUP".
+func (c *Client) SignalJob(ctx context.Context, jobID, signal string) error {
+	_, err := c.client.SignalJob(ctx, &pb.SignalJobRequest{JobId: jobID, Signal: signal})
+	return err //nolint:wrapcheck // No wrap needed here.
+}
+
+// SetJobACL replaces the access control list of the job. Empty to revoke all the access.
+func (c *Client) SetJobACL(ctx context.Context, jobID string, acl []*pb.JobACLEntry) error {
+	_, err := c.client.SetJobACL(ctx, &pb.SetJobACLRequest{JobId: jobID, Acl: acl})
+	return err //nolint:wrapcheck // No wrap needed here.
+}
+
+// maxRetries only applies to on-failure, 0 for unlimited.
+func WithRestartPolicy(mode pb.RestartMode, maxRetries uint32) StartJobOption {
+	return func(req *pb.StartJobRequest) {
+		req.RestartPolicy = &pb.RestartPolicy{Mode: mode, MaxRetries: maxRetries}
+	}
+}
+
+func (c *Client) StartJob(ctx context.Context, cmd string, args []string, opts ...StartJobOption) (string, error) {
+	req := &pb.StartJobRequest{Command: cmd, Args: args}
+	for _, opt := range opts {
+		opt(req)
+	}
+	resp, err := c.client.StartJob(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("call start job: %w", err)
 	}
@@ -52,12 +185,25 @@ func (c *Client) GetJobStatus(ctx context.Context, jobID string) (string, error)
 		return "", err //nolint:wrapcheck // Only error path, no need for wrap here.
 	}
 	status := resp.GetStatus()
-	if status == pb.JobStatus_JOB_STATUS_EXITED || status == pb.JobStatus_JOB_STATUS_STOPPED {
+	if status == pb.JobStatus_JOB_STATUS_EXITED || status == pb.JobStatus_JOB_STATUS_STOPPED ||
+		status == pb.JobStatus_JOB_STATUS_TIMED_OUT || status == pb.JobStatus_JOB_STATUS_RESTARTING {
 		return fmt.Sprintf("%s (%d)", status, resp.GetExitCode()), nil
 	}
+	if status == pb.JobStatus_JOB_STATUS_QUEUED {
+		return fmt.Sprintf("%s (position %d)", status, resp.GetQueuePosition()), nil
+	}
 	return status.String(), nil
 }
 
+// GetJobStatusDetails returns the full status of the job, i.e. exec sessions or enforced restrictions.
+func (c *Client) GetJobStatusDetails(ctx context.Context, jobID string) (*pb.GetJobStatusResponse, error) {
+	resp, err := c.client.GetJobStatus(ctx, &pb.GetJobStatusRequest{JobId: jobID})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp, nil
+}
+
 func (c *Client) StreamLogs(ctx context.Context, jobID string, w io.Writer) error {
 	stream, err := c.client.StreamLogs(ctx, &pb.StreamLogsRequest{JobId: jobID})
 	if err != nil {
@@ -74,3 +220,222 @@ func (c *Client) StreamLogs(ctx context.Context, jobID string, w io.Writer) erro
 		_, _ = fmt.Fprint(w, string(msg.GetData())) // Best effort.
 	}
 }
+
+// CreateSchedule creates a schedule starting the given job periodically, following the cron expression.
+func (c *Client) CreateSchedule(ctx context.Context, cron string, overlap pb.OverlapPolicy, runMissed bool,
+	cmd string, args []string, opts ...StartJobOption,
+) (*pb.Schedule, error) {
+	job := &pb.StartJobRequest{Command: cmd, Args: args}
+	for _, opt := range opts {
+		opt(job)
+	}
+	resp, err := c.client.CreateSchedule(ctx, &pb.CreateScheduleRequest{
+		Cron:          cron,
+		Job:           job,
+		OverlapPolicy: overlap,
+		RunMissed:     runMissed,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("call create schedule: %w", err)
+	}
+	return resp.GetSchedule(), nil
+}
+
+// ListSchedules returns the schedules of the user, oldest first.
+func (c *Client) ListSchedules(ctx context.Context) ([]*pb.Schedule, error) {
+	resp, err := c.client.ListSchedules(ctx, &pb.ListSchedulesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("call list schedules: %w", err)
+	}
+	return resp.GetSchedules(), nil
+}
+
+// DeleteSchedule deletes the given schedule. The jobs already started are left running.
+func (c *Client) DeleteSchedule(ctx context.Context, scheduleID string) error {
+	_, err := c.client.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{ScheduleId: scheduleID})
+	return err //nolint:wrapcheck // Only error path, no need for wrap here.
+}
+
+// SubmitWorkflow submits the given workflow and returns its ID.
+func (c *Client) SubmitWorkflow(ctx context.Context, req *pb.SubmitWorkflowRequest) (string, error) {
+	resp, err := c.client.SubmitWorkflow(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("call submit workflow: %w", err)
+	}
+	return resp.GetWorkflowId(), nil
+}
+
+// GetWorkflowStatus returns the status of the workflow and its steps.
+func (c *Client) GetWorkflowStatus(ctx context.Context, workflowID string) (*pb.GetWorkflowStatusResponse, error) {
+	resp, err := c.client.GetWorkflowStatus(ctx, &pb.GetWorkflowStatusRequest{WorkflowId: workflowID})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp, nil
+}
+
+// CanI checks whether the user is allowed to call the given method, on the given job if not empty.
+// Returns the role granting the call, if allowed.
+func (c *Client) CanI(ctx context.Context, method, jobID string) (bool, string, error) {
+	resp, err := c.client.CanI(ctx, &pb.CanIRequest{Method: method, Job: jobID})
+	if err != nil {
+		return false, "", err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp.GetAllowed(), resp.GetRole(), nil
+}
+
+// GetQuota returns the quotas of the user, then the ones of its groups, with their usage.
+func (c *Client) GetQuota(ctx context.Context) ([]*pb.Quota, error) {
+	resp, err := c.client.GetQuota(ctx, &pb.GetQuotaRequest{})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp.GetQuotas(), nil
+}
+
+// PortForward tunnels conn to the given port on the loopback of the job.
+// Returns once the job closes the connection. The caller is expected to close conn afterwards.
+func (c *Client) PortForward(ctx context.Context, jobID string, port uint32, conn io.ReadWriter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.PortForward(ctx)
+	if err != nil {
+		return fmt.Errorf("call port forward: %w", err)
+	}
+	if err := stream.Send(&pb.PortForwardRequest{JobId: jobID, Port: port}); err != nil {
+		return fmt.Errorf("send port forward request: %w", err)
+	}
+
+	// Local to job. Half-close the stream once the local side is done sending.
+	go func() {
+		buf := make([]byte, 32*1024) //nolint:mnd // Default value from io.Copy, reasonable.
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				// NOTE: Make a copy of the data to respect ownership.
+				if err := stream.Send(&pb.PortForwardRequest{JobId: jobID, Data: []byte(string(buf[:n]))}); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				_ = stream.CloseSend() // Best effort.
+				return
+			}
+		}
+	}()
+
+	// Job to local.
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("recv port forward data: %w", err)
+		}
+		if _, err := conn.Write(msg.GetData()); err != nil {
+			return fmt.Errorf("write port forward data: %w", err)
+		}
+	}
+}
+
+// ExecIO is the stdio of an exec session.
+type ExecIO struct {
+	Stdin          io.Reader // Optional.
+	Stdout, Stderr io.Writer
+
+	// Optional. In tty mode, the initial size of the terminal and then
+	// the new sizes, i.e. on SIGWINCH.
+	TerminalSize <-chan *pb.TerminalSize
+}
+
+// ExecInJob runs the given command within the job and streams its stdio until it exits.
+// When tty is set, the process runs in a pseudo-terminal, everything going to stdout.
+// Returns the exit code of the process.
+func (c *Client) ExecInJob(ctx context.Context, jobID, cmd string, args []string, tty bool, stdio ExecIO) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.ExecInJob(ctx)
+	if err != nil {
+		return -1, fmt.Errorf("call exec in job: %w", err)
+	}
+	req := &pb.ExecInJobRequest{JobId: jobID, Command: cmd, Args: args, Tty: tty}
+	if tty && stdio.TerminalSize != nil {
+		select {
+		case req.TerminalSize = <-stdio.TerminalSize:
+		case <-ctx.Done():
+			return -1, ctx.Err() //nolint:wrapcheck // No wrap needed here.
+		}
+	}
+	if err := stream.Send(req); err != nil {
+		return -1, fmt.Errorf("send exec request: %w", err)
+	}
+
+	// Local to process. The stream is only used from this goroutine once started.
+	go func() {
+		input := make(chan []byte)
+		if stdio.Stdin != nil {
+			go func() {
+				defer close(input)
+				buf := make([]byte, 32*1024) //nolint:mnd // Default value from io.Copy, reasonable.
+				for {
+					n, err := stdio.Stdin.Read(buf)
+					if n > 0 {
+						select {
+						// NOTE: Make a copy of the data to respect ownership.
+						case input <- []byte(string(buf[:n])):
+						case <-ctx.Done():
+							return
+						}
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		} else {
+			close(input)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case size, ok := <-stdio.TerminalSize:
+				if !ok {
+					stdio.TerminalSize = nil // Stop selecting on it.
+					continue
+				}
+				if err := stream.Send(&pb.ExecInJobRequest{JobId: jobID, TerminalSize: size}); err != nil {
+					return
+				}
+			case data, ok := <-input:
+				if !ok {
+					_ = stream.CloseSend() // Best effort. Send EOF to the process.
+					input = nil            // Stop selecting on it.
+					continue
+				}
+				if err := stream.Send(&pb.ExecInJobRequest{JobId: jobID, Stdin: data}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// Process to local, until the exit code.
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return -1, errors.New("exec stream ended without exit code") //nolint:err113 // No need for fancy error here.
+			}
+			return -1, fmt.Errorf("recv exec output: %w", err)
+		}
+		if msg.ExitCode != nil {
+			return int(msg.GetExitCode()), nil
+		}
+		_, _ = stdio.Stdout.Write(msg.GetStdout()) // Best effort.
+		_, _ = stdio.Stderr.Write(msg.GetStderr()) // Best effort.
+	}
+}
@@ -0,0 +1,101 @@
+package apiclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	pb "go.creack.net/telepilot/api/v1"
+)
+
+// GenerateKey generates a new private key and a CSR for it, both PEM encoded.
+// The CSR subject is left empty, the server sets it from the enrollment.
+func GenerateKey() (keyPEM, csrPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create csr: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+		nil
+}
+
+// WriteClientCert writes the certificate and key of the given user in the cert dir, as loaded by the CLI.
+// Each file is written then renamed, not to leave a partial file behind.
+func WriteClientCert(certDir, user string, certPEM, keyPEM []byte) error {
+	for name, data := range map[string][]byte{
+		"client-" + user + "-key.pem": keyPEM,
+		"client-" + user + ".pem":     certPEM,
+	} {
+		p := filepath.Join(certDir, name)
+		if err := os.WriteFile(p+".tmp", data, 0o600); err != nil { //nolint:mnd // Standard perm, the key is private.
+			return fmt.Errorf("write %q: %w", p, err)
+		}
+		if err := os.Rename(p+".tmp", p); err != nil {
+			return fmt.Errorf("rename %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Enroll exchanges the given enrollment token for a certificate of the given PEM CSR.
+// The client can connect without certificate to enroll.
+func (c *Client) Enroll(ctx context.Context, token string, csrPEM []byte) (*pb.EnrollResponse, error) {
+	resp, err := c.client.Enroll(ctx, &pb.EnrollRequest{Token: token, Csr: csrPEM})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp, nil
+}
+
+// IssueClientCert signs the given PEM CSR for the given user and groups. A ttl of 0 uses the server max.
+func (c *Client) IssueClientCert(ctx context.Context, csrPEM []byte, user string, groups []string, ttl time.Duration,
+) (*pb.IssueClientCertResponse, error) {
+	resp, err := c.client.IssueClientCert(ctx, &pb.IssueClientCertRequest{
+		Csr:    csrPEM,
+		User:   user,
+		Groups: groups,
+		TtlMs:  uint64(ttl.Milliseconds()), //nolint:gosec // False positive, negative durations are not expected.
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp, nil
+}
+
+// CreateEnrollmentToken creates a one-time token for the given user and groups to enroll with.
+// A ttl of 0 uses the server default.
+func (c *Client) CreateEnrollmentToken(ctx context.Context, user string, groups []string, certTTL, tokenTTL time.Duration,
+) (string, time.Time, error) {
+	resp, err := c.client.CreateEnrollmentToken(ctx, &pb.CreateEnrollmentTokenRequest{
+		User:       user,
+		Groups:     groups,
+		CertTtlMs:  uint64(certTTL.Milliseconds()),  //nolint:gosec // False positive, negative durations are not expected.
+		TokenTtlMs: uint64(tokenTTL.Milliseconds()), //nolint:gosec // False positive, negative durations are not expected.
+	})
+	if err != nil {
+		return "", time.Time{}, err //nolint:wrapcheck // Only error path, no need for wrap here.
+	}
+	return resp.GetToken(), time.UnixMilli(resp.GetExpiresAtUnixMs()), nil
+}
+
+// RevokeClientCert revokes the client certificate with the given serial number.
+func (c *Client) RevokeClientCert(ctx context.Context, serial string) error {
+	_, err := c.client.RevokeClientCert(ctx, &pb.RevokeClientCertRequest{Serial: serial})
+	return err //nolint:wrapcheck // No wrap needed here.
+}
@@ -0,0 +1,138 @@
+package apiclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "go.creack.net/telepilot/api/v1"
+)
+
+// renewRetryInterval is the min delay between renewal attempts, not to renew on each call when failing
+// or when the server renews for less than the threshold.
+const renewRetryInterval = time.Minute
+
+// renewer keeps the client certificate renewed, rewriting the certificate file.
+type renewer struct {
+	certFile, keyFile string
+	threshold         time.Duration
+
+	current atomic.Pointer[tls.Certificate] // Used for each new connection.
+
+	mu          sync.Mutex // Serializes the renewals.
+	lastAttempt time.Time
+}
+
+// newRenewer loads the given certificate and key.
+func newRenewer(certFile, keyFile string, threshold time.Duration) (*renewer, error) {
+	r := &renewer{certFile: certFile, keyFile: keyFile, threshold: threshold}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	certificate.Leaf = leaf
+	r.current.Store(&certificate)
+	return r, nil
+}
+
+// getClientCertificate implements tls.Config.GetClientCertificate.
+func (r *renewer) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current.Load(), nil
+}
+
+// renewIfNeeded renews the certificate when it expires within the threshold.
+// Failures are logged, the current certificate remains used until it expires.
+func (r *renewer) renewIfNeeded(ctx context.Context, client pb.TelePilotServiceClient) {
+	if time.Until(r.current.Load().Leaf.NotAfter) > r.threshold {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Check again, renewed while waiting for the lock.
+	if time.Until(r.current.Load().Leaf.NotAfter) > r.threshold || time.Since(r.lastAttempt) < renewRetryInterval {
+		return
+	}
+	r.lastAttempt = time.Now()
+	if err := r.renew(ctx, client); err != nil {
+		slog.Warn("Failed to renew the client certificate.", "cert", r.certFile, "not_after", r.current.Load().Leaf.NotAfter, "error", err)
+	}
+}
+
+// renew requests a new certificate for the current key, then atomically rewrites the certificate file.
+//
+// NOTE: Expected to be called with the lock held.
+func (r *renewer) renew(ctx context.Context, client pb.TelePilotServiceClient) error {
+	current := r.current.Load()
+	signer, ok := current.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported key type %T", current.PrivateKey) //nolint:err113 // No need for fancy error here.
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, signer)
+	if err != nil {
+		return fmt.Errorf("create csr: %w", err)
+	}
+	resp, err := client.RenewCertificate(ctx, &pb.RenewCertificateRequest{
+		Csr: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+	})
+	if err != nil {
+		return fmt.Errorf("renew certificate: %w", err)
+	}
+	block, _ := pem.Decode(resp.GetCertificate())
+	if block == nil {
+		return fmt.Errorf("no PEM data in the renewed certificate") //nolint:err113 // No need for fancy error here.
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse renewed certificate: %w", err)
+	}
+	if pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(signer.Public()) {
+		return fmt.Errorf("renewed certificate doesn't match the key") //nolint:err113 // No need for fancy error here.
+	}
+	certificate := tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: current.PrivateKey, Leaf: leaf}
+
+	// Write then rename, so a concurrent client never loads a partial file.
+	tmp := r.certFile + ".tmp"
+	if err := os.WriteFile(tmp, resp.GetCertificate(), 0o600); err != nil { //nolint:mnd // Standard perm.
+		return fmt.Errorf("write renewed certificate: %w", err)
+	}
+	if err := os.Rename(tmp, r.certFile); err != nil {
+		return fmt.Errorf("rename renewed certificate: %w", err)
+	}
+	r.current.Store(&certificate)
+	slog.Debug("Client certificate renewed.", "cert", r.certFile, "serial", resp.GetSerial(), "not_after", leaf.NotAfter)
+	return nil
+}
+
+// unaryInterceptor renews the certificate if needed before each call.
+func (r *renewer) unaryInterceptor(
+	ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+) error {
+	// NOTE: The renewal goes through the interceptor as well.
+	if method != pb.TelePilotService_RenewCertificate_FullMethodName {
+		r.renewIfNeeded(ctx, pb.NewTelePilotServiceClient(cc))
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// streamInterceptor renews the certificate if needed before each stream.
+func (r *renewer) streamInterceptor(
+	ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	r.renewIfNeeded(ctx, pb.NewTelePilotServiceClient(cc))
+	return streamer(ctx, desc, cc, method, opts...)
+}
@@ -1,10 +1,18 @@
 package apiserver
 
 import (
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"log/slog"
 
 	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/audit"
+	"go.creack.net/telepilot/pkg/ca"
 	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/rbac"
+	"go.creack.net/telepilot/pkg/scheduler"
+	"go.creack.net/telepilot/pkg/workflow"
 )
 
 // Common errors.
@@ -17,14 +25,139 @@ type Server struct {
 	pb.UnimplementedTelePilotServiceServer
 
 	jobmanager *jobmanager.JobManager
+	scheduler  *scheduler.Scheduler
+	workflows  *workflow.Controller
+	authorizer *rbac.Authorizer
+	ca         *ca.Authority // Nil when not enabled.
+	audit      *audit.Logger // Nil when not enabled.
+
+	checkRevoked func(*x509.Certificate) error // Nil when not enabled.
+
+	jobManagerOpts  []jobmanager.Option
+	schedulerConfig scheduler.Config
+	policyFile      string
+	auditLogFile    string
+	auditKeyFile    string
+}
+
+// Option configures the Server.
+type Option func(*Server) error
+
+// WithJobManagerOptions forwards the given options to the underlying job manager.
+func WithJobManagerOptions(opts ...jobmanager.Option) Option {
+	return func(s *Server) error {
+		s.jobManagerOpts = append(s.jobManagerOpts, opts...)
+		return nil
+	}
+}
+
+// WithStateDir persists the server state in the given directory, i.e. the schedules.
+// Kept in memory only when not set.
+func WithStateDir(dir string) Option {
+	return func(s *Server) error {
+		s.schedulerConfig.StateDir = dir
+		return nil
+	}
+}
+
+// WithPolicyFile enforces the RBAC policy from the given file, reloadable with ReloadPolicy.
+// Everyone can start jobs and only access their own when not set.
+func WithPolicyFile(path string) Option {
+	return func(s *Server) error {
+		s.policyFile = path
+		return nil
+	}
+}
+
+// WithCertificateAuthority enables the enrollment and certificate management endpoints, issuing from the given authority.
+// The server must accept connections without client certificate for the users to enroll.
+func WithCertificateAuthority(authority *ca.Authority) Option {
+	return func(s *Server) error {
+		s.ca = authority
+		return nil
+	}
+}
+
+// WithAuditLog records all the calls in the given tamper-evident log file, chained with the HMAC key
+// from keyFile, which must be outside the log directory. The key is generated if missing. Not recorded when not set.
+func WithAuditLog(path, keyFile string) Option {
+	return func(s *Server) error {
+		s.auditLogFile, s.auditKeyFile = path, keyFile
+		return nil
+	}
+}
+
+// WithRevocationCheck re-checks the owner's certificate of the schedules and workflows with the given function
+// before each deferred job start, i.e. against the CRLs, so a revoked user doesn't keep starting jobs.
+func WithRevocationCheck(check func(*x509.Certificate) error) Option {
+	return func(s *Server) error {
+		s.checkRevoked = check
+		return nil
+	}
 }
 
 // Create the server.
 // NOTE: As this creates a new job manager, it expected
 // the cgroup to be initialized via cgroups.InitalSetup()
 // before being ready to use.
-func NewServer() *Server {
-	return &Server{
-		jobmanager: jobmanager.NewJobManager(),
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	authorizer, err := rbac.NewAuthorizer(s.policyFile, serviceMethods(), defaultPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("new authorizer: %w", err)
+	}
+	s.authorizer = authorizer
+	if s.auditLogFile != "" {
+		key, err := audit.LoadKey(s.auditKeyFile, s.auditLogFile, true)
+		if err != nil {
+			return nil, fmt.Errorf("load audit key: %w", err)
+		}
+		logger, err := audit.Open(s.auditLogFile, key)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		s.audit = logger
+	}
+	jm, err := jobmanager.NewJobManager(s.jobManagerOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("new job manager: %w", err)
+	}
+	s.jobmanager = jm
+	s.schedulerConfig.Authorize = func(owner string, spec jobmanager.JobSpec) error {
+		return s.authorizeDeferred("CreateSchedule", owner, spec)
+	}
+	sched, err := scheduler.New(jm, s.schedulerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("new scheduler: %w", err)
+	}
+	s.scheduler = sched
+	s.workflows = workflow.NewController(jm, func(owner string, spec jobmanager.JobSpec) error {
+		return s.authorizeDeferred("SubmitWorkflow", owner, spec)
+	})
+	return s, nil
+}
+
+// ReloadPolicy reloads the policy file. The current policy is kept if the new one is invalid.
+func (s *Server) ReloadPolicy() error {
+	if err := s.authorizer.Reload(); err != nil {
+		return fmt.Errorf("reload policy: %w", err)
+	}
+	return nil
+}
+
+// Close stops the background tasks of the server, i.e. the scheduler and the workflows, and closes the audit log.
+// The running jobs are left as-is.
+func (s *Server) Close() {
+	s.scheduler.Close()
+	s.workflows.Close()
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			slog.Error("Failed to close the audit log.", "error", err)
+		}
 	}
 }
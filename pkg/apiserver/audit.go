@@ -0,0 +1,73 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"go.creack.net/telepilot/pkg/audit"
+	"go.creack.net/telepilot/pkg/identity"
+)
+
+// secretFields are the request fields never recorded in the audit log.
+//
+//nolint:gochecknoglobals // Expected global.
+var secretFields = map[protoreflect.Name]bool{"token": true}
+
+// summarize returns the JSON summary of the given request for the audit log: the request without its secrets,
+// nor its bytes fields, i.e. the CSRs or the streamed data.
+func summarize(req any) json.RawMessage {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	m = proto.Clone(m)
+	r := m.ProtoReflect()
+	var redacted []protoreflect.FieldDescriptor
+	r.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if fd.Kind() == protoreflect.BytesKind || secretFields[fd.Name()] {
+			redacted = append(redacted, fd)
+		}
+		return true
+	})
+	for _, fd := range redacted {
+		r.Clear(fd)
+	}
+	buf, err := protojson.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// recordCall appends the call to the audit log, if enabled.
+// Failures are logged, the call is not affected.
+func (s *Server) recordCall(start time.Time, id identity.Identity, fullMethod string, req any, decision audit.Decision, err error) {
+	if s.audit == nil {
+		return
+	}
+	e := audit.Entry{
+		Time:       start,
+		User:       id.User,
+		Groups:     id.Groups,
+		Method:     methodName(fullMethod),
+		Request:    summarize(req),
+		Decision:   decision,
+		Code:       status.Code(err).String(),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if e.Method == "" {
+		e.Method = fullMethod
+	}
+	if getter, ok := req.(interface{ GetJobId() string }); ok {
+		e.JobID = getter.GetJobId()
+	}
+	if err := s.audit.Record(e); err != nil {
+		slog.Error("Failed to record the call in the audit log.", "method", e.Method, "user", e.User, "error", err)
+	}
+}
@@ -5,6 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -12,22 +17,216 @@ import (
 	"google.golang.org/grpc/status"
 
 	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/ca"
+	"go.creack.net/telepilot/pkg/capabilities"
+	"go.creack.net/telepilot/pkg/cgroups"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/landlock"
+	"go.creack.net/telepilot/pkg/portproxy"
+	"go.creack.net/telepilot/pkg/rbac"
+	"go.creack.net/telepilot/pkg/rlimit"
+	"go.creack.net/telepilot/pkg/scheduler"
+	"go.creack.net/telepilot/pkg/seccomp"
+	"go.creack.net/telepilot/pkg/terminal"
+	"go.creack.net/telepilot/pkg/workflow"
 )
 
 func (s *Server) StartJob(ctx context.Context, req *pb.StartJobRequest) (*pb.StartJobResponse, error) {
-	user, err := getUserFromContext(ctx)
+	id, err := getIdentityFromContext(ctx)
 	if err != nil {
 		// NOTE: Not supposed to happen as already checked, but check anyway.
-		return nil, fmt.Errorf("getUserFromContext: %w", err)
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
 	}
+	spec, err := toJobSpec(req)
+	if err != nil {
+		return nil, err
+	}
+	spec.OwnerGroups = id.Groups
 	// Contextcheck // False positive. We don't want to use the request context to start the job in the background.
-	jobID, err := s.jobmanager.StartJob(user, req.GetCommand(), req.GetArgs())
+	jobID, err := s.jobmanager.StartJob(id.User, spec)
 	if err != nil {
+		if st := jobSpecError(err); st != nil {
+			return nil, st
+		}
 		return nil, fmt.Errorf("job manager start job: : %w", err)
 	}
 	return &pb.StartJobResponse{JobId: jobID.String()}, nil
 }
 
+// toJobSpec converts the start job request. Returns an InvalidArgument status error when invalid.
+func toJobSpec(req *pb.StartJobRequest) (jobmanager.JobSpec, error) {
+	spec := jobmanager.JobSpec{
+		Command:  req.GetCommand(),
+		Args:     req.GetArgs(),
+		Hostname: req.GetHostname(),
+		CapAdd:   req.GetCapAdd(),
+		CapDrop:  req.GetCapDrop(),
+
+		AllowNewPrivileges: req.NoNewPrivileges != nil && !req.GetNoNewPrivileges(),
+		Landlock: landlock.Ruleset{
+			ReadOnly:  req.GetLandlock().GetReadOnly(),
+			ReadWrite: req.GetLandlock().GetReadWrite(),
+		},
+	}
+	switch req.GetNetwork() {
+	case pb.NetworkMode_NETWORK_MODE_NONE_UNSPECIFIED:
+		spec.Network = jobmanager.NetworkNone
+	case pb.NetworkMode_NETWORK_MODE_BRIDGED:
+		spec.Network = jobmanager.NetworkBridged
+	default:
+		return spec, status.Errorf(codes.InvalidArgument, "invalid network mode: %s", req.GetNetwork())
+	}
+	switch req.GetSeccompProfile() {
+	case pb.SeccompProfile_SECCOMP_PROFILE_DEFAULT_UNSPECIFIED:
+		spec.Seccomp = seccomp.ProfileDefault
+	case pb.SeccompProfile_SECCOMP_PROFILE_STRICT:
+		spec.Seccomp = seccomp.ProfileStrict
+	case pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED:
+		spec.Seccomp = seccomp.ProfileUnconfined
+	default:
+		return spec, status.Errorf(codes.InvalidArgument, "invalid seccomp profile: %s", req.GetSeccompProfile())
+	}
+	const maxTimeoutMS = math.MaxInt64 / uint64(time.Millisecond)
+	if req.GetMaxDurationMs() > maxTimeoutMS || req.GetMaxCpuTimeMs() > maxTimeoutMS {
+		return spec, status.Errorf(codes.InvalidArgument, "invalid job spec: timeouts out of range")
+	}
+	spec.MaxDuration = time.Duration(req.GetMaxDurationMs()) * time.Millisecond //nolint:gosec // False positive, checked above.
+	spec.MaxCPUTime = time.Duration(req.GetMaxCpuTimeMs()) * time.Millisecond   //nolint:gosec // False positive, checked above.
+	switch req.GetRestartPolicy().GetMode() {
+	case pb.RestartMode_RESTART_MODE_NEVER_UNSPECIFIED:
+		spec.Restart.Mode = jobmanager.RestartNever
+	case pb.RestartMode_RESTART_MODE_ON_FAILURE:
+		spec.Restart.Mode = jobmanager.RestartOnFailure
+	case pb.RestartMode_RESTART_MODE_ALWAYS:
+		spec.Restart.Mode = jobmanager.RestartAlways
+	default:
+		return spec, status.Errorf(codes.InvalidArgument, "invalid restart mode: %s", req.GetRestartPolicy().GetMode())
+	}
+	spec.Restart.MaxRetries = int(req.GetRestartPolicy().GetMaxRetries())
+	spec.Resources = cgroups.Limits{CPUMillis: req.GetCpuMillis(), MemoryBytes: req.GetMemoryBytes()}
+	spec.Priority = int(req.GetPriority())
+	for name, limit := range req.GetRlimits() {
+		resource, err := rlimit.ParseResource(name)
+		if err != nil {
+			return spec, status.Errorf(codes.InvalidArgument, "invalid job spec: %s", err)
+		}
+		if spec.Rlimits == nil {
+			spec.Rlimits = map[rlimit.Resource]rlimit.Limit{}
+		}
+		hard := limit.GetSoft()
+		if limit.Hard != nil {
+			hard = limit.GetHard()
+		}
+		spec.Rlimits[resource] = rlimit.Limit{Soft: limit.GetSoft(), Hard: hard}
+	}
+	for _, port := range req.GetPorts() {
+		mapping, err := toPortMapping(port)
+		if err != nil {
+			return spec, status.Errorf(codes.InvalidArgument, "invalid port mapping: %s", err)
+		}
+		spec.Ports = append(spec.Ports, mapping)
+	}
+	acl, err := toACL(req.GetAcl())
+	if err != nil {
+		return spec, err
+	}
+	spec.ACL = acl
+	return spec, nil
+}
+
+// jobPermissions maps the ACL permissions from/to the API.
+//
+//nolint:gochecknoglobals // Expected global.
+var jobPermissions = map[pb.JobPermission]rbac.Permission{
+	pb.JobPermission_JOB_PERMISSION_READ_LOGS:   rbac.PermissionReadLogs,
+	pb.JobPermission_JOB_PERMISSION_READ_STATUS: rbac.PermissionReadStatus,
+	pb.JobPermission_JOB_PERMISSION_SIGNAL:      rbac.PermissionSignal,
+	pb.JobPermission_JOB_PERMISSION_STOP:        rbac.PermissionStop,
+}
+
+// toACL converts the access control list from the request.
+func toACL(entries []*pb.JobACLEntry) (rbac.ACL, error) {
+	var acl rbac.ACL
+	for _, elem := range entries {
+		e := rbac.ACLEntry{User: elem.GetUser(), Group: elem.GetGroup()}
+		for _, perm := range elem.GetPermissions() {
+			p, ok := jobPermissions[perm]
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid job permission: %s", perm)
+			}
+			e.Permissions = append(e.Permissions, p)
+		}
+		acl = append(acl, e)
+	}
+	if err := acl.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	return acl, nil
+}
+
+// toPBACL converts the access control list for the API.
+func toPBACL(acl rbac.ACL) []*pb.JobACLEntry {
+	var entries []*pb.JobACLEntry
+	for _, e := range acl {
+		entry := &pb.JobACLEntry{User: e.User, Group: e.Group}
+		for _, perm := range e.Permissions {
+			for k, v := range jobPermissions {
+				if v == perm {
+					entry.Permissions = append(entry.Permissions, k)
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// jobSpecError converts the job spec related errors to status errors. Returns nil for other errors.
+func jobSpecError(err error) error {
+	if errors.Is(err, jobmanager.ErrInvalidHostname) || errors.Is(err, jobmanager.ErrInvalidTimeout) ||
+		errors.Is(err, jobmanager.ErrInvalidRestartPolicy) || errors.Is(err, portproxy.ErrInvalidMapping) ||
+		errors.Is(err, capabilities.ErrInvalidCapability) || errors.Is(err, landlock.ErrInvalidRule) ||
+		errors.Is(err, rlimit.ErrInvalidLimit) || errors.Is(err, cgroups.ErrInvalidLimits) ||
+		errors.Is(err, rbac.ErrInvalidACL) {
+		return status.Errorf(codes.InvalidArgument, "invalid job spec: %s", err)
+	}
+	if errors.Is(err, jobmanager.ErrNetworkUnavailable) || errors.Is(err, jobmanager.ErrLandlockUnavailable) ||
+		errors.Is(err, jobmanager.ErrExceedsBudget) {
+		return status.Errorf(codes.FailedPrecondition, "invalid job spec: %s", err)
+	}
+	if errors.Is(err, portproxy.ErrPortNotAllowed) || errors.Is(err, jobmanager.ErrProfileNotAllowed) ||
+		errors.Is(err, jobmanager.ErrCapNotAllowed) {
+		return status.Errorf(codes.PermissionDenied, "invalid job spec: %s", err)
+	}
+	if errors.Is(err, portproxy.ErrPortInUse) {
+		return status.Errorf(codes.AlreadyExists, "invalid job spec: %s", err)
+	}
+	if errors.Is(err, jobmanager.ErrQuotaExceeded) {
+		return status.Errorf(codes.ResourceExhausted, "%s", err)
+	}
+	return nil
+}
+
+// toPortMapping converts the port mapping from the request.
+func toPortMapping(port *pb.PortMapping) (portproxy.Mapping, error) {
+	if port.GetHostPort() > math.MaxUint16 || port.GetJobPort() > math.MaxUint16 {
+		return portproxy.Mapping{}, fmt.Errorf("ports out of range: %d:%d", port.GetHostPort(), port.GetJobPort()) //nolint:err113 // No need for fancy error here.
+	}
+	mapping := portproxy.Mapping{
+		HostPort: uint16(port.GetHostPort()),
+		JobPort:  uint16(port.GetJobPort()),
+	}
+	switch port.GetProtocol() {
+	case pb.Protocol_PROTOCOL_TCP_UNSPECIFIED:
+		mapping.Protocol = portproxy.TCP
+	case pb.Protocol_PROTOCOL_UDP:
+		mapping.Protocol = portproxy.UDP
+	default:
+		return portproxy.Mapping{}, fmt.Errorf("unknown protocol %s", port.GetProtocol()) //nolint:err113 // No need for fancy error here.
+	}
+	return mapping, nil
+}
+
 func (s *Server) StopJob(_ context.Context, req *pb.StopJobRequest) (*pb.StopJobResponse, error) {
 	jobID, err := uuid.Parse(req.GetJobId())
 	if err != nil {
@@ -41,6 +240,41 @@ func (s *Server) StopJob(_ context.Context, req *pb.StopJobRequest) (*pb.StopJob
 	return &pb.StopJobResponse{}, nil
 }
 
+// SignalJob sends a signal to the processes of a job.
+func (s *Server) SignalJob(_ context.Context, req *pb.SignalJobRequest) (*pb.SignalJobResponse, error) {
+	jobID, err := uuid.Parse(req.GetJobId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid job id: %s", err)
+	}
+	sig, err := jobmanager.ParseSignal(req.GetSignal())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err := s.jobmanager.SignalJob(jobID, sig); err != nil {
+		if errors.Is(err, jobmanager.ErrJobNotRunning) {
+			return nil, status.Errorf(codes.FailedPrecondition, "signal job: %s", err)
+		}
+		return nil, fmt.Errorf("job manager signal job: %w", err)
+	}
+	return &pb.SignalJobResponse{}, nil
+}
+
+// SetJobACL replaces the access control list of a job.
+func (s *Server) SetJobACL(_ context.Context, req *pb.SetJobACLRequest) (*pb.SetJobACLResponse, error) {
+	jobID, err := uuid.Parse(req.GetJobId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid job id: %s", err)
+	}
+	acl, err := toACL(req.GetAcl())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.jobmanager.SetJobACL(jobID, acl); err != nil {
+		return nil, fmt.Errorf("job manager set job acl: %w", err)
+	}
+	return &pb.SetJobACLResponse{}, nil
+}
+
 func (s *Server) GetJobStatus(_ context.Context, req *pb.GetJobStatusRequest) (*pb.GetJobStatusResponse, error) {
 	jobID, err := uuid.Parse(req.GetJobId())
 	if err != nil {
@@ -51,8 +285,43 @@ func (s *Server) GetJobStatus(_ context.Context, req *pb.GetJobStatusRequest) (*
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "lookup job: %s", err)
 	}
-	resp := &pb.GetJobStatusResponse{Status: job.Status()}
-	if resp.GetStatus() != pb.JobStatus_JOB_STATUS_RUNNING {
+	resp := &pb.GetJobStatusResponse{
+		Status:       job.Status(),
+		ExecSessions: uint32(job.ExecSessions()), //nolint:gosec // False positive, can't be negative.
+		LandlockAbi:  uint32(job.LandlockABI),    //nolint:gosec // False positive, can't be negative.
+		RestartCount: uint32(job.RestartCount()), //nolint:gosec // False positive, can't be negative.
+		CpuMillis:    job.Resources.CPUMillis,
+		MemoryBytes:  job.Resources.MemoryBytes,
+		Owner:        job.Owner,
+		OwnerGroups:  job.OwnerGroups,
+		Acl:          toPBACL(job.ACL()),
+	}
+	if job.ScheduleID != uuid.Nil {
+		resp.ScheduleId = job.ScheduleID.String()
+	}
+	if job.WorkflowID != uuid.Nil {
+		resp.WorkflowId = job.WorkflowID.String()
+	}
+	for _, attempt := range job.Attempts() {
+		resp.Attempts = append(resp.Attempts, &pb.JobAttempt{
+			StartedAtUnixMs: attempt.StartedAt.UnixMilli(),
+			DurationMs:      uint64(attempt.Duration.Milliseconds()), //nolint:gosec // False positive, can't be negative.
+			ExitCode:        int32(attempt.ExitCode),                 //nolint:gosec // False positive, exit codes are uint8 or -1.
+		})
+	}
+	if len(job.Rlimits) > 0 {
+		resp.Rlimits = make(map[string]*pb.Rlimit, len(job.Rlimits))
+		for resource, limit := range job.Rlimits {
+			resp.Rlimits[string(resource)] = &pb.Rlimit{Soft: limit.Soft, Hard: &limit.Hard}
+		}
+	}
+	if resp.GetStatus() == pb.JobStatus_JOB_STATUS_QUEUED {
+		resp.QueuePosition = uint32(s.jobmanager.QueuePosition(job)) //nolint:gosec // False positive, can't be negative.
+	}
+	if err := job.StartError(); err != nil {
+		resp.StartError = err.Error()
+	}
+	if resp.GetStatus() != pb.JobStatus_JOB_STATUS_RUNNING && resp.GetStatus() != pb.JobStatus_JOB_STATUS_QUEUED {
 		//nolint:gosec // False positive about int/int32 conversion, but in POSIX, exit codes are actually uint8.
 		exitCode := int32(job.ExitCode())
 		resp.ExitCode = &exitCode
@@ -89,3 +358,648 @@ func (s *Server) StreamLogs(req *pb.StreamLogsRequest, ss grpc.ServerStreamingSe
 		}
 	}
 }
+
+func (s *Server) PortForward(ss grpc.BidiStreamingServer[pb.PortForwardRequest, pb.PortForwardResponse]) error {
+	// The first message selects the job and the port.
+	first, err := ss.Recv()
+	if err != nil {
+		return fmt.Errorf("receive port forward request: %w", err)
+	}
+	jobID, err := uuid.Parse(first.GetJobId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid job id: %s", err)
+	}
+	if first.GetPort() == 0 || first.GetPort() > math.MaxUint16 {
+		return status.Errorf(codes.InvalidArgument, "invalid port: %d", first.GetPort())
+	}
+
+	conn, err := s.jobmanager.DialJob(jobID, uint16(first.GetPort()))
+	if err != nil {
+		if errors.Is(err, jobmanager.ErrJobNotRunning) {
+			return status.Errorf(codes.FailedPrecondition, "dial job: %s", err)
+		}
+		return status.Errorf(codes.Unavailable, "dial job: %s", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	// Client to job. When the client is done sending, half-close the connection.
+	// On error, close the connection to unblock the other side and report the error.
+	errCh := make(chan error, 1)
+	go func() {
+		data := first.GetData()
+		for {
+			if _, err := conn.Write(data); err != nil {
+				errCh <- fmt.Errorf("write to job: %w", err)
+				_ = conn.Close() // Best effort.
+				return
+			}
+			req, err := ss.Recv()
+			if errors.Is(err, io.EOF) {
+				if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+					_ = cw.CloseWrite() // Best effort.
+				}
+				return
+			}
+			if err != nil {
+				errCh <- fmt.Errorf("receive port forward data: %w", err)
+				_ = conn.Close() // Best effort.
+				return
+			}
+			if req.GetJobId() != first.GetJobId() {
+				// NOTE: Each message is authorized, but switching job mid-stream is not allowed.
+				errCh <- status.Error(codes.InvalidArgument, "job id changed mid-stream")
+				_ = conn.Close() // Best effort.
+				return
+			}
+			data = req.GetData()
+		}
+	}()
+
+	// Job to client, until the job closes the connection.
+	buf := make([]byte, 32*1024) //nolint:mnd // Default value from io.Copy, reasonable.
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			// NOTE: Make a copy of the data to respect ownership.
+			if err := ss.Send(&pb.PortForwardResponse{Data: []byte(string(buf[:n]))}); err != nil {
+				return fmt.Errorf("send port forward data: %w", err)
+			}
+		}
+		if err != nil {
+			select {
+			case clientErr := <-errCh:
+				return clientErr
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read from job: %w", err)
+		}
+	}
+}
+
+// execOutput sends the output of an exec session. Safe for concurrent use as
+// stdout and stderr are consumed by different goroutines.
+type execOutput struct {
+	mu     *sync.Mutex
+	ss     grpc.BidiStreamingServer[pb.ExecInJobRequest, pb.ExecInJobResponse]
+	stderr bool
+}
+
+func (o execOutput) Write(p []byte) (int, error) {
+	// NOTE: Make a copy of the data to respect ownership.
+	resp := &pb.ExecInJobResponse{Stdout: []byte(string(p))}
+	if o.stderr {
+		resp = &pb.ExecInJobResponse{Stderr: []byte(string(p))}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.ss.Send(resp); err != nil {
+		return 0, fmt.Errorf("send exec output: %w", err)
+	}
+	return len(p), nil
+}
+
+func toTerminalSize(size *pb.TerminalSize) terminal.Size {
+	//nolint:gosec // Terminal sizes are small, truncating garbage is fine.
+	return terminal.Size{Rows: uint16(size.GetRows()), Cols: uint16(size.GetCols())}
+}
+
+func (s *Server) ExecInJob(ss grpc.BidiStreamingServer[pb.ExecInJobRequest, pb.ExecInJobResponse]) error {
+	// The first message describes the process.
+	first, err := ss.Recv()
+	if err != nil {
+		return fmt.Errorf("receive exec request: %w", err)
+	}
+	jobID, err := uuid.Parse(first.GetJobId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid job id: %s", err)
+	}
+
+	stdinR, stdinW := io.Pipe()
+	defer func() { _ = stdinW.Close() }() // Best effort.
+	mu := &sync.Mutex{}
+	spec := jobmanager.ExecSpec{
+		Command: first.GetCommand(),
+		Args:    first.GetArgs(),
+		Stdin:   stdinR,
+		Stdout:  execOutput{mu: mu, ss: ss},
+		Stderr:  execOutput{mu: mu, ss: ss, stderr: true},
+	}
+	if first.GetTty() {
+		size := toTerminalSize(first.GetTerminalSize())
+		spec.TTY = &size
+	}
+	session, err := s.jobmanager.ExecInJob(jobID, spec)
+	if err != nil {
+		if errors.Is(err, jobmanager.ErrMissingCommand) {
+			return status.Errorf(codes.InvalidArgument, "invalid exec spec: %s", err)
+		}
+		if errors.Is(err, jobmanager.ErrJobNotRunning) {
+			return status.Errorf(codes.FailedPrecondition, "exec in job: %s", err)
+		}
+		return fmt.Errorf("job manager exec in job: %w", err)
+	}
+
+	// Client to process. Kill the process if the client goes away.
+	go func() {
+		if len(first.GetStdin()) > 0 {
+			_, _ = stdinW.Write(first.GetStdin()) // Best effort.
+		}
+		for {
+			req, err := ss.Recv()
+			if errors.Is(err, io.EOF) {
+				_ = stdinW.Close() // Best effort. Send EOF to the process.
+				return
+			}
+			if err != nil || req.GetJobId() != first.GetJobId() {
+				// NOTE: Each message is authorized, but switching job mid-stream is not allowed.
+				session.Kill()
+				_ = stdinW.Close() // Best effort.
+				return
+			}
+			if req.GetTerminalSize() != nil {
+				if err := session.Resize(toTerminalSize(req.GetTerminalSize())); err != nil {
+					// Best effort.
+					slog.Warn("Failed to resize exec session terminal.", "session_id", session.ID.String(), "error", err)
+				}
+			}
+			if len(req.GetStdin()) > 0 {
+				_, _ = stdinW.Write(req.GetStdin()) // Best effort.
+			}
+		}
+	}()
+
+	//nolint:gosec // False positive about int/int32 conversion, but in POSIX, exit codes are actually uint8.
+	exitCode := int32(session.Wait())
+	mu.Lock()
+	defer mu.Unlock()
+	if err := ss.Send(&pb.ExecInJobResponse{ExitCode: &exitCode}); err != nil {
+		return fmt.Errorf("send exec exit code: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) CreateSchedule(ctx context.Context, req *pb.CreateScheduleRequest) (*pb.CreateScheduleResponse, error) {
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
+	}
+	if req.GetJob() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid schedule: missing job")
+	}
+	spec, err := toJobSpec(req.GetJob())
+	if err != nil {
+		return nil, err
+	}
+	spec.OwnerGroups = id.Groups
+	if id.Certificate != nil {
+		spec.OwnerCertificate = id.Certificate.Raw
+	}
+	sc := scheduler.Schedule{Cron: req.GetCron(), Spec: spec, RunMissed: req.GetRunMissed()}
+	switch req.GetOverlapPolicy() {
+	case pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED:
+		sc.Overlap = scheduler.OverlapSkip
+	case pb.OverlapPolicy_OVERLAP_POLICY_QUEUE:
+		sc.Overlap = scheduler.OverlapQueue
+	case pb.OverlapPolicy_OVERLAP_POLICY_REPLACE:
+		sc.Overlap = scheduler.OverlapReplace
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid overlap policy: %s", req.GetOverlapPolicy())
+	}
+	sc, err = s.scheduler.Create(id.User, sc)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrInvalidSchedule) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid schedule: %s", err)
+		}
+		if st := jobSpecError(err); st != nil {
+			return nil, st
+		}
+		return nil, fmt.Errorf("scheduler create: %w", err)
+	}
+	return &pb.CreateScheduleResponse{Schedule: toPBSchedule(sc)}, nil
+}
+
+func (s *Server) ListSchedules(ctx context.Context, _ *pb.ListSchedulesRequest) (*pb.ListSchedulesResponse, error) {
+	user, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	resp := &pb.ListSchedulesResponse{}
+	for _, sc := range s.scheduler.List(user) {
+		resp.Schedules = append(resp.Schedules, toPBSchedule(sc))
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteSchedule(ctx context.Context, req *pb.DeleteScheduleRequest) (*pb.DeleteScheduleResponse, error) {
+	user, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	scheduleID, err := uuid.Parse(req.GetScheduleId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid schedule id: %s", err)
+	}
+	if err := s.scheduler.Delete(user, scheduleID); err != nil {
+		if errors.Is(err, scheduler.ErrScheduleNotFound) {
+			// NOTE: Return PermissionDenied to avoid 'leaking' the schedules of other users, as for the jobs.
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		return nil, fmt.Errorf("scheduler delete: %w", err)
+	}
+	return &pb.DeleteScheduleResponse{}, nil
+}
+
+// toPBSchedule converts the schedule for the response.
+func toPBSchedule(sc scheduler.Schedule) *pb.Schedule {
+	out := &pb.Schedule{
+		ScheduleId:      sc.ID.String(),
+		Cron:            sc.Cron,
+		Job:             fromJobSpec(sc.Spec),
+		RunMissed:       sc.RunMissed,
+		CreatedAtUnixMs: sc.CreatedAt.UnixMilli(),
+		LastError:       sc.LastError,
+		Disabled:        sc.Disabled,
+	}
+	switch sc.Overlap {
+	case scheduler.OverlapSkip:
+		out.OverlapPolicy = pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED
+	case scheduler.OverlapQueue:
+		out.OverlapPolicy = pb.OverlapPolicy_OVERLAP_POLICY_QUEUE
+	case scheduler.OverlapReplace:
+		out.OverlapPolicy = pb.OverlapPolicy_OVERLAP_POLICY_REPLACE
+	}
+	if !sc.NextRun.IsZero() {
+		out.NextRunUnixMs = sc.NextRun.UnixMilli()
+	}
+	if !sc.LastRun.IsZero() {
+		out.LastRunUnixMs = sc.LastRun.UnixMilli()
+	}
+	if sc.LastJobID != uuid.Nil {
+		out.LastJobId = sc.LastJobID.String()
+	}
+	return out
+}
+
+// fromJobSpec converts the job spec back to a start job request, i.e. to describe a schedule.
+func fromJobSpec(spec jobmanager.JobSpec) *pb.StartJobRequest {
+	noNewPrivileges := !spec.AllowNewPrivileges
+	req := &pb.StartJobRequest{
+		Command:         spec.Command,
+		Args:            spec.Args,
+		Hostname:        spec.Hostname,
+		CapAdd:          spec.CapAdd,
+		CapDrop:         spec.CapDrop,
+		NoNewPrivileges: &noNewPrivileges,
+		MaxDurationMs:   uint64(spec.MaxDuration.Milliseconds()), //nolint:gosec // False positive, validated as positive.
+		MaxCpuTimeMs:    uint64(spec.MaxCPUTime.Milliseconds()),  //nolint:gosec // False positive, validated as positive.
+		CpuMillis:       spec.Resources.CPUMillis,
+		MemoryBytes:     spec.Resources.MemoryBytes,
+		Priority:        int32(spec.Priority), //nolint:gosec // False positive, converted from int32.
+		Acl:             toPBACL(spec.ACL),
+	}
+	if spec.Network == jobmanager.NetworkBridged {
+		req.Network = pb.NetworkMode_NETWORK_MODE_BRIDGED
+	}
+	switch spec.Seccomp {
+	case seccomp.ProfileStrict:
+		req.SeccompProfile = pb.SeccompProfile_SECCOMP_PROFILE_STRICT
+	case seccomp.ProfileUnconfined:
+		req.SeccompProfile = pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED
+	default:
+		req.SeccompProfile = pb.SeccompProfile_SECCOMP_PROFILE_DEFAULT_UNSPECIFIED
+	}
+	for _, mapping := range spec.Ports {
+		port := &pb.PortMapping{HostPort: uint32(mapping.HostPort), JobPort: uint32(mapping.JobPort)}
+		if mapping.Protocol == portproxy.UDP {
+			port.Protocol = pb.Protocol_PROTOCOL_UDP
+		}
+		req.Ports = append(req.Ports, port)
+	}
+	if !spec.Landlock.Empty() {
+		req.Landlock = &pb.LandlockRuleset{ReadOnly: spec.Landlock.ReadOnly, ReadWrite: spec.Landlock.ReadWrite}
+	}
+	if len(spec.Rlimits) > 0 {
+		req.Rlimits = make(map[string]*pb.Rlimit, len(spec.Rlimits))
+		for resource, limit := range spec.Rlimits {
+			req.Rlimits[string(resource)] = &pb.Rlimit{Soft: limit.Soft, Hard: &limit.Hard}
+		}
+	}
+	switch spec.Restart.Mode {
+	case jobmanager.RestartOnFailure:
+		req.RestartPolicy = &pb.RestartPolicy{
+			Mode:       pb.RestartMode_RESTART_MODE_ON_FAILURE,
+			MaxRetries: uint32(spec.Restart.MaxRetries), //nolint:gosec // False positive, converted from uint32.
+		}
+	case jobmanager.RestartAlways:
+		req.RestartPolicy = &pb.RestartPolicy{Mode: pb.RestartMode_RESTART_MODE_ALWAYS}
+	case jobmanager.RestartNever:
+	}
+	return req
+}
+
+func (s *Server) SubmitWorkflow(ctx context.Context, req *pb.SubmitWorkflowRequest) (*pb.SubmitWorkflowResponse, error) {
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
+	}
+	steps := make([]workflow.Step, 0, len(req.GetSteps()))
+	for _, elem := range req.GetSteps() {
+		if elem.GetJob() == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid workflow: step %q: missing job", elem.GetName())
+		}
+		spec, err := toJobSpec(elem.GetJob())
+		if err != nil {
+			return nil, err
+		}
+		spec.OwnerGroups = id.Groups
+		if id.Certificate != nil {
+			spec.OwnerCertificate = id.Certificate.Raw
+		}
+		st := workflow.Step{Name: elem.GetName(), Spec: spec, DependsOn: elem.GetDependsOn()}
+		switch elem.GetCondition() {
+		case pb.StepCondition_STEP_CONDITION_ON_SUCCESS_UNSPECIFIED:
+			st.Condition = workflow.OnSuccess
+		case pb.StepCondition_STEP_CONDITION_ON_FAILURE:
+			st.Condition = workflow.OnFailure
+		case pb.StepCondition_STEP_CONDITION_ALWAYS:
+			st.Condition = workflow.Always
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "invalid step condition: %s", elem.GetCondition())
+		}
+		steps = append(steps, st)
+	}
+	// Contextcheck // False positive. We don't want to use the request context to run the workflow in the background.
+	workflowID, err := s.workflows.Submit(id.User, steps)
+	if err != nil {
+		if errors.Is(err, workflow.ErrInvalidWorkflow) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid workflow: %s", err)
+		}
+		if st := jobSpecError(err); st != nil {
+			return nil, st
+		}
+		return nil, fmt.Errorf("workflow submit: %w", err)
+	}
+	return &pb.SubmitWorkflowResponse{WorkflowId: workflowID.String()}, nil
+}
+
+func (s *Server) GetWorkflowStatus(ctx context.Context, req *pb.GetWorkflowStatusRequest) (*pb.GetWorkflowStatusResponse, error) {
+	user, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	workflowID, err := uuid.Parse(req.GetWorkflowId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid workflow id: %s", err)
+	}
+	w, err := s.workflows.Lookup(user, workflowID)
+	if err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			// NOTE: Return PermissionDenied to avoid 'leaking' the workflows of other users, as for the jobs.
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		return nil, fmt.Errorf("workflow lookup: %w", err)
+	}
+	resp := &pb.GetWorkflowStatusResponse{
+		Status:          pb.WorkflowStatus_WORKFLOW_STATUS_RUNNING,
+		CreatedAtUnixMs: w.CreatedAt.UnixMilli(),
+	}
+	switch w.Status {
+	case workflow.StatusSucceeded:
+		resp.Status = pb.WorkflowStatus_WORKFLOW_STATUS_SUCCEEDED
+	case workflow.StatusFailed:
+		resp.Status = pb.WorkflowStatus_WORKFLOW_STATUS_FAILED
+	case workflow.StatusPending, workflow.StatusRunning, workflow.StatusCanceled:
+	}
+	if !w.EndedAt.IsZero() {
+		resp.EndedAtUnixMs = w.EndedAt.UnixMilli()
+	}
+	for _, step := range w.Steps {
+		resp.Steps = append(resp.Steps, toPBStepStatus(step))
+	}
+	return resp, nil
+}
+
+// toPBStepStatus converts the workflow step state for the response.
+func toPBStepStatus(step workflow.StepState) *pb.WorkflowStepStatus {
+	out := &pb.WorkflowStepStatus{Name: step.Name, Error: step.Error}
+	switch step.Status {
+	case workflow.StatusPending:
+		out.Status = pb.StepStatus_STEP_STATUS_PENDING
+	case workflow.StatusRunning:
+		out.Status = pb.StepStatus_STEP_STATUS_RUNNING
+	case workflow.StatusSucceeded:
+		out.Status = pb.StepStatus_STEP_STATUS_SUCCEEDED
+	case workflow.StatusFailed:
+		out.Status = pb.StepStatus_STEP_STATUS_FAILED
+	case workflow.StatusCanceled:
+		out.Status = pb.StepStatus_STEP_STATUS_CANCELED
+	}
+	if step.JobID != uuid.Nil {
+		out.JobId = step.JobID.String()
+		if step.Status == workflow.StatusSucceeded || step.Status == workflow.StatusFailed {
+			//nolint:gosec // False positive about int/int32 conversion, but in POSIX, exit codes are actually uint8.
+			exitCode := int32(step.ExitCode)
+			out.ExitCode = &exitCode
+		}
+	}
+	return out
+}
+
+// CanI checks whether the caller is allowed to call the given method, on the given job if set.
+func (s *Server) CanI(ctx context.Context, req *pb.CanIRequest) (*pb.CanIResponse, error) {
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
+	}
+	if !slices.Contains(serviceMethods(), req.GetMethod()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown method %q", req.GetMethod())
+	}
+	var j *jobmanager.Job
+	if req.GetJob() != "" {
+		jobID, err := uuid.Parse(req.GetJob())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid job id: %s", err)
+		}
+		if j, err = s.jobmanager.LookupJob(jobID); err != nil {
+			// NOTE: Don't 'leak' whether the job exists.
+			return &pb.CanIResponse{}, nil
+		}
+	}
+	role, err := s.authorize(id, req.GetMethod(), j, nil)
+	return &pb.CanIResponse{Allowed: err == nil, Role: role}, nil
+}
+
+// GetQuota returns the quotas of the caller and of its groups, with their usage.
+func (s *Server) GetQuota(ctx context.Context, _ *pb.GetQuotaRequest) (*pb.GetQuotaResponse, error) {
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
+	}
+	resp := &pb.GetQuotaResponse{}
+	for _, q := range s.jobmanager.Quotas(id.User, id.Groups) {
+		resp.Quotas = append(resp.Quotas, &pb.Quota{
+			User:  q.User,
+			Group: q.Group,
+			Limits: &pb.QuotaUsage{
+				Jobs:        uint32(q.Limits.MaxJobs), //nolint:gosec // False positive, validated as positive.
+				CpuMillis:   q.Limits.MaxCPUMillis,
+				MemoryBytes: q.Limits.MaxMemoryBytes,
+				LogBytes:    q.Limits.MaxLogBytes,
+				JobsPerHour: uint32(q.Limits.MaxJobsPerHour), //nolint:gosec // False positive, validated as positive.
+			},
+			Usage: &pb.QuotaUsage{
+				Jobs:        uint32(q.Usage.Jobs), //nolint:gosec // False positive, a count is never negative.
+				CpuMillis:   q.Usage.CPUMillis,
+				MemoryBytes: q.Usage.MemoryBytes,
+				LogBytes:    q.Usage.LogBytes,
+				JobsPerHour: uint32(q.Usage.JobsLastHour), //nolint:gosec // False positive, a count is never negative.
+			},
+		})
+	}
+	return resp, nil
+}
+
+// ttlFromMS converts the given lifetime in milliseconds. 0, meaning the server max, when out of range as clamped anyway.
+func ttlFromMS(ms uint64) time.Duration {
+	if ms > math.MaxInt64/uint64(time.Millisecond) {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond //nolint:gosec // False positive, checked above.
+}
+
+// caError maps the certificate authority errors to status codes.
+func caError(err error) error {
+	switch {
+	case errors.Is(err, ca.ErrInvalidRequest):
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	case errors.Is(err, ca.ErrInvalidToken), errors.Is(err, ca.ErrRevoked):
+		return status.Errorf(codes.Unauthenticated, "%s", err)
+	default:
+		return status.Errorf(codes.Internal, "%s", err)
+	}
+}
+
+// errNoCA is returned by the certificate authority endpoints when the built-in CA is not enabled.
+//
+//nolint:gochecknoglobals // Expected global.
+var errNoCA = status.Error(codes.FailedPrecondition, "built-in certificate authority not enabled")
+
+// IssueClientCert signs a client certificate.
+func (s *Server) IssueClientCert(ctx context.Context, req *pb.IssueClientCertRequest) (*pb.IssueClientCertResponse, error) {
+	if s.ca == nil {
+		return nil, errNoCA
+	}
+	admin, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	cert, issued, err := s.ca.Issue(req.GetCsr(), req.GetUser(), req.GetGroups(), ttlFromMS(req.GetTtlMs()), admin)
+	if err != nil {
+		return nil, caError(err)
+	}
+	slog.Info("Client certificate issued.", "audit", true,
+		"user", issued.User, "groups", issued.Groups, "serial", issued.Serial, "not_after", issued.NotAfter, "issued_by", admin)
+	return &pb.IssueClientCertResponse{
+		Certificate:    cert,
+		Serial:         issued.Serial,
+		NotAfterUnixMs: issued.NotAfter.UnixMilli(),
+	}, nil
+}
+
+// CreateEnrollmentToken creates a one-time enrollment token.
+func (s *Server) CreateEnrollmentToken(ctx context.Context, req *pb.CreateEnrollmentTokenRequest) (*pb.CreateEnrollmentTokenResponse, error) {
+	if s.ca == nil {
+		return nil, errNoCA
+	}
+	admin, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	tok, expiresAt, err := s.ca.CreateToken(req.GetUser(), req.GetGroups(), ttlFromMS(req.GetCertTtlMs()), ttlFromMS(req.GetTokenTtlMs()), admin)
+	if err != nil {
+		return nil, caError(err)
+	}
+	slog.Info("Enrollment token created.", "audit", true,
+		"user", req.GetUser(), "groups", req.GetGroups(), "expires_at", expiresAt, "created_by", admin)
+	return &pb.CreateEnrollmentTokenResponse{Token: tok, ExpiresAtUnixMs: expiresAt.UnixMilli()}, nil
+}
+
+// Enroll exchanges an enrollment token for a client certificate.
+// NOTE: Called without client certificate, there is no identity in the context.
+func (s *Server) Enroll(_ context.Context, req *pb.EnrollRequest) (*pb.EnrollResponse, error) {
+	if s.ca == nil {
+		return nil, errNoCA
+	}
+	cert, issued, err := s.ca.Enroll(req.GetToken(), req.GetCsr())
+	if err != nil {
+		if errors.Is(err, ca.ErrInvalidToken) {
+			slog.Warn("Rejected enrollment.", "audit", true, "error", err)
+		}
+		return nil, caError(err)
+	}
+	slog.Info("Client certificate enrolled.", "audit", true,
+		"user", issued.User, "groups", issued.Groups, "serial", issued.Serial, "not_after", issued.NotAfter, "issued_by", issued.IssuedBy)
+	return &pb.EnrollResponse{
+		Certificate:    cert,
+		User:           issued.User,
+		Serial:         issued.Serial,
+		NotAfterUnixMs: issued.NotAfter.UnixMilli(),
+	}, nil
+}
+
+// RevokeClientCert revokes a client certificate.
+func (s *Server) RevokeClientCert(ctx context.Context, req *pb.RevokeClientCertRequest) (*pb.RevokeClientCertResponse, error) {
+	if s.ca == nil {
+		return nil, errNoCA
+	}
+	admin, err := getUserFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getUserFromContext: %w", err)
+	}
+	descendants, err := s.ca.Revoke(req.GetSerial())
+	if err != nil {
+		return nil, caError(err)
+	}
+	slog.Info("Client certificate revoked.", "audit", true, "serial", req.GetSerial(), "renewed_serials", descendants, "revoked_by", admin)
+	return &pb.RevokeClientCertResponse{}, nil
+}
+
+// RenewCertificate issues a short-lived certificate for the caller's identity.
+func (s *Server) RenewCertificate(ctx context.Context, req *pb.RenewCertificateRequest) (*pb.RenewCertificateResponse, error) {
+	if s.ca == nil {
+		return nil, errNoCA
+	}
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		// NOTE: Not supposed to happen as already checked, but check anyway.
+		return nil, fmt.Errorf("getIdentityFromContext: %w", err)
+	}
+	if id.Certificate == nil {
+		// NOTE: Not supposed to happen as the identity is derived from the certificate, but check anyway.
+		return nil, status.Error(codes.Unauthenticated, "missing client certificate")
+	}
+	cert, issued, err := s.ca.Renew(req.GetCsr(), id.Certificate.SerialNumber.String(), id.User, id.Groups, ttlFromMS(req.GetTtlMs()))
+	if err != nil {
+		return nil, caError(err)
+	}
+	slog.Info("Client certificate renewed.", "audit", true,
+		"user", issued.User, "groups", issued.Groups, "serial", issued.Serial, "parent", issued.Parent, "not_after", issued.NotAfter)
+	return &pb.RenewCertificateResponse{
+		Certificate:    cert,
+		Serial:         issued.Serial,
+		NotAfterUnixMs: issued.NotAfter.UnixMilli(),
+	}, nil
+}
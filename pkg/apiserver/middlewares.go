@@ -2,7 +2,11 @@ package apiserver
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -11,31 +15,57 @@ import (
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/audit"
+	"go.creack.net/telepilot/pkg/identity"
 	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/rbac"
 )
 
-// Common method to extract the user's CN from context.
-func getUserFromContext(ctx context.Context) (string, error) {
+// authenticate derives the user's identity from the peer's client certificate.
+func authenticate(ctx context.Context) (identity.Identity, error) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
-		return "", fmt.Errorf("peer not found in context: %w", ErrInvalidClientCerts)
+		return identity.Identity{}, fmt.Errorf("peer not found in context: %w", ErrInvalidClientCerts)
 	}
 	mtls, ok := p.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return "", fmt.Errorf("authinfo invalid type: %w", ErrInvalidClientCerts)
+		return identity.Identity{}, fmt.Errorf("authinfo invalid type: %w", ErrInvalidClientCerts)
 	}
 	// NOTE: We control user management and their certificate, we only expect one.
 	if len(mtls.State.PeerCertificates) > 1 {
-		return "", fmt.Errorf("too many peers in cert: %w", ErrInvalidClientCerts)
+		return identity.Identity{}, fmt.Errorf("too many peers in cert: %w", ErrInvalidClientCerts)
 	}
 	for _, item := range mtls.State.PeerCertificates {
-		return item.Subject.CommonName, nil
+		id, err := identity.FromCertificate(item)
+		if err != nil {
+			return identity.Identity{}, fmt.Errorf("%w: %w", ErrInvalidClientCerts, err)
+		}
+		return id, nil
+	}
+	return identity.Identity{}, fmt.Errorf("cert not found: %w", ErrInvalidClientCerts)
+}
+
+// Common method to extract the user's identity from context, injected by the middlewares.
+func getIdentityFromContext(ctx context.Context) (identity.Identity, error) {
+	id, ok := identity.FromContext(ctx)
+	if !ok {
+		return identity.Identity{}, fmt.Errorf("identity not found in context: %w", ErrInvalidClientCerts)
+	}
+	return id, nil
+}
+
+// Common method to extract the user's name from context.
+func getUserFromContext(ctx context.Context) (string, error) {
+	id, err := getIdentityFromContext(ctx)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("CN not found: %w", ErrInvalidClientCerts)
+	return id.User, nil
 }
 
 // middleware to enforce authorization policies.
-func (s *Server) authMiddleware(user, fullMethod string, req any) error {
+func (s *Server) authMiddleware(id identity.Identity, fullMethod string, req any) error {
 	var j *jobmanager.Job
 	if getter, ok := req.(interface{ GetJobId() string }); ok {
 		jobID, err := uuid.Parse(getter.GetJobId())
@@ -52,57 +82,165 @@ func (s *Server) authMiddleware(user, fullMethod string, req any) error {
 		// TODO: Consider injecting the job in the context for the handlers to use without re-query.
 	}
 	// NOTE: Default behavior if fullMethod is not found is to deny access.
-	if !enforcePolicies(user, j, policies[fullMethod]...) {
-		return status.Error(codes.PermissionDenied, "forbidden") //nolint:wrapcheck // Expected direct return.
+	// The denial only details the commands not allowed, which the user submitted.
+	if _, err := s.authorize(id, methodName(fullMethod), j, requestCommands(req)); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error()) //nolint:wrapcheck // Expected direct return.
+	}
+	return nil
+}
+
+// authorize checks whether the user can call the given method, on the given job if not nil, running the given commands.
+// Returns the role granting the call.
+func (s *Server) authorize(id identity.Identity, method string, job *jobmanager.Job, cmds []rbac.Command) (string, error) {
+	if method == "" {
+		return "", rbac.ErrForbidden
+	}
+	req := rbac.Request{User: id.User, Groups: id.Groups, Method: method, Commands: cmds}
+	if job != nil {
+		// NOTE: job.Owner/OwnerGroups don't need lock as they are only written once at creation time.
+		req.Job, req.JobOwner, req.JobOwnerGroups = true, job.Owner, job.OwnerGroups
+		req.JobACL = job.ACL()
+	}
+	return s.authorizer.Check(req) //nolint:wrapcheck // Already wrapped.
+}
+
+// authorizeDeferred re-checks a job start deferred by the given method, i.e. a schedule run or a workflow step,
+// against the live policy and the revocation of the owner's certificate, as they may have changed since submitted.
+func (s *Server) authorizeDeferred(method, owner string, spec jobmanager.JobSpec) error {
+	if s.checkRevoked != nil && len(spec.OwnerCertificate) > 0 {
+		cert, err := x509.ParseCertificate(spec.OwnerCertificate)
+		if err != nil {
+			return fmt.Errorf("parse owner certificate: %w", err)
+		}
+		if err := s.checkRevoked(cert); err != nil {
+			return fmt.Errorf("owner certificate: %w", err)
+		}
+	}
+	id := identity.Identity{User: owner, Groups: spec.OwnerGroups}
+	if _, err := s.authorize(id, method, nil, []rbac.Command{{Path: spec.Command, Args: spec.Args}}); err != nil {
+		return err
 	}
 	return nil
 }
 
-// UnaryMiddleware handles authn/authz from mtls for unary endpoints.
+// requestCommands returns the commands the request runs: the jobs to start, directly,
+// via a schedule or a workflow, or the process to exec. Only set in the first exec message.
+func requestCommands(req any) []rbac.Command {
+	switch r := req.(type) {
+	case *pb.StartJobRequest:
+		return []rbac.Command{{Path: r.GetCommand(), Args: r.GetArgs()}}
+	case *pb.CreateScheduleRequest:
+		return []rbac.Command{{Path: r.GetJob().GetCommand(), Args: r.GetJob().GetArgs()}}
+	case *pb.SubmitWorkflowRequest:
+		cmds := make([]rbac.Command, 0, len(r.GetSteps()))
+		for _, step := range r.GetSteps() {
+			cmds = append(cmds, rbac.Command{Path: step.GetJob().GetCommand(), Args: step.GetJob().GetArgs()})
+		}
+		return cmds
+	case *pb.ExecInJobRequest:
+		if r.GetCommand() == "" {
+			return nil
+		}
+		return []rbac.Command{{Path: r.GetCommand(), Args: r.GetArgs()}}
+	default:
+		return nil
+	}
+}
+
+// UnaryMiddleware handles authn/authz from mtls for unary endpoints, and records the calls in the audit log.
 func (s *Server) UnaryMiddleware(
 	ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
-) (any, error) {
+) (resp any, err error) { //nolint:nonamedreturns // Used for defer audit.
+	start := time.Now()
+	var id identity.Identity
+	decision := audit.DecisionDeny
+	defer func() { s.recordCall(start, id, info.FullMethod, req, decision, err) }()
+
+	// NOTE: The public methods authenticate the request themselves, i.e. with an enrollment token.
+	if slices.Contains(publicMethods, methodName(info.FullMethod)) {
+		decision = audit.DecisionAllow
+		return handler(ctx, req)
+	}
 	// Authentication.
-	user, err := getUserFromContext(ctx)
+	id, err = authenticate(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getUserFromContext: %w", err)
+		return nil, fmt.Errorf("authenticate: %w", err)
 	}
 	// Authorization.
-	if err := s.authMiddleware(user, info.FullMethod, req); err != nil {
+	if err := s.authMiddleware(id, info.FullMethod, req); err != nil {
 		return nil, err
 	}
-	return handler(ctx, req)
+	decision = audit.DecisionAllow
+	return handler(identity.NewContext(ctx, id), req)
 }
 
 // serverStreamWrapper wraps the RecvMsg to enforce authorization upon each streamed request.
 type serverStreamWrapper struct {
 	grpc.ServerStream
 
+	ctx        context.Context //nolint:containedctx // Expected to override the stream context.
 	s          *Server
-	user       string
+	id         identity.Identity
 	fullMethod string
+
+	mu     sync.Mutex
+	first  any  // First request, for the audit log.
+	denied bool // Whether a request has been denied, for the audit log.
 }
 
+// Context returns the stream context, carrying the identity.
+func (w *serverStreamWrapper) Context() context.Context { return w.ctx }
+
 func (w *serverStreamWrapper) RecvMsg(m any) error {
 	if err := w.ServerStream.RecvMsg(m); err != nil {
 		return err //nolint:wrapcheck // Expected direct return.
 	}
-	if err := w.s.authMiddleware(w.user, w.fullMethod, m); err != nil {
+	err := w.s.authMiddleware(w.id, w.fullMethod, m)
+	w.mu.Lock()
+	if w.first == nil {
+		w.first = m
+	}
+	w.denied = w.denied || err != nil
+	w.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// StreamMiddleware handles the authn/authz from mtls for streaming endpoints.
+// decision returns the first request and the authorization decision of the stream, for the audit log.
+func (w *serverStreamWrapper) decision() (any, audit.Decision) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.denied {
+		return w.first, audit.DecisionDeny
+	}
+	return w.first, audit.DecisionAllow
+}
+
+// StreamMiddleware handles the authn/authz from mtls for streaming endpoints,
+// and records the streams in the audit log once done.
 func (s *Server) StreamMiddleware(
 	server any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
 ) error {
+	start := time.Now()
 	ctx := ss.Context()
 	// Authentication, only once.
-	user, err := getUserFromContext(ctx)
+	id, err := authenticate(ctx)
 	if err != nil {
-		return fmt.Errorf("getUserFromContext: %w", err)
+		s.recordCall(start, id, info.FullMethod, nil, audit.DecisionDeny, err)
+		return fmt.Errorf("authenticate: %w", err)
 	}
 	// Authorization, on each message.
-	return handler(server, &serverStreamWrapper{ServerStream: ss, s: s, user: user, fullMethod: info.FullMethod})
+	w := &serverStreamWrapper{
+		ServerStream: ss,
+		ctx:          identity.NewContext(ctx, id),
+		s:            s,
+		id:           id,
+		fullMethod:   info.FullMethod,
+	}
+	err = handler(server, w)
+	first, decision := w.decision()
+	s.recordCall(start, id, info.FullMethod, first, decision, err)
+	return err
 }
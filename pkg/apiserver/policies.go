@@ -1,39 +1,72 @@
 package apiserver
 
 import (
+	"slices"
+	"strings"
+
 	pb "go.creack.net/telepilot/api/v1"
-	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/rbac"
 )
 
+// defaultPolicy is enforced when no policy file is set: everyone can start jobs and only access their own,
+// the members of the "admins" group can manage the client certificates.
+//
 //nolint:gochecknoglobals // Expected global.
-var policies = map[string][]policyFct{
-	pb.TelePilotService_StartJob_FullMethodName:     {policyAllowed},
-	pb.TelePilotService_StopJob_FullMethodName:      {policySameOwner},
-	pb.TelePilotService_GetJobStatus_FullMethodName: {policySameOwner},
-	pb.TelePilotService_StreamLogs_FullMethodName:   {policySameOwner},
+var defaultPolicy = &rbac.Policy{
+	Roles: map[string]rbac.Role{
+		"user": {Rules: []rbac.Rule{
+			{Methods: []string{"StartJob"}},
+			{Methods: []string{"StopJob", "SignalJob", "GetJobStatus", "StreamLogs", "PortForward", "ExecInJob"}, Scope: rbac.ScopeOwn},
+
+			// NOTE: The owner shares the job with the others via its ACL.
+			{Methods: []string{"SetJobACL"}, Scope: rbac.ScopeOwn},
+
+			// NOTE: The schedules are not jobs, their ownership is enforced by the scheduler.
+			{Methods: []string{"CreateSchedule", "ListSchedules", "DeleteSchedule"}},
+
+			// NOTE: Same for the workflows, their ownership is enforced by the workflow controller.
+			{Methods: []string{"SubmitWorkflow", "GetWorkflowStatus"}},
+
+			{Methods: []string{"CanI", "GetQuota"}},
+
+			// NOTE: The renewed certificate is for the caller's own identity.
+			{Methods: []string{"RenewCertificate"}},
+		}},
+		"admin": {Rules: []rbac.Rule{
+			{Methods: []string{"IssueClientCert", "CreateEnrollmentToken", "RevokeClientCert"}},
+		}},
+	},
+	Bindings: []rbac.Binding{
+		{Role: "user", Users: []string{rbac.Wildcard}},
+		{Role: "admin", Groups: []string{"admins"}},
+	},
 }
 
-func enforcePolicies(user string, job *jobmanager.Job, policies ...policyFct) bool {
-	if len(policies) == 0 {
-		// If no policies are set for the method, deny access.
-		return false
-	}
-	for _, p := range policies {
-		if !p(user, job) {
-			return false
+// publicMethods are called without client certificate, they are not subject to the policy.
+//
+//nolint:gochecknoglobals // Expected global.
+var publicMethods = []string{"Enroll"}
+
+// serviceMethods returns the short names of all the methods/streams of the service, except the public ones.
+func serviceMethods() []string {
+	var methods []string
+	for _, ep := range pb.TelePilotService_ServiceDesc.Methods {
+		if slices.Contains(publicMethods, ep.MethodName) {
+			continue
 		}
+		methods = append(methods, ep.MethodName)
+	}
+	for _, ep := range pb.TelePilotService_ServiceDesc.Streams {
+		methods = append(methods, ep.StreamName)
 	}
-	return true
+	return methods
 }
 
-type policyFct func(user string, job *jobmanager.Job) bool
-
-// allow everything.
-func policyAllowed(string, *jobmanager.Job) bool { return true }
-
-// only allow if the user is set and matches the job's one.
-func policySameOwner(user string, job *jobmanager.Job) bool {
-	// NOTE: job.Owner doesn't need lock as it is only written once at creation time.
-	// TODO: Consider added a getter anyway, in case this changes.
-	return user != "" && job != nil && user == job.Owner
+// methodName returns the short name of the given full method name, i.e. "StopJob" for "/api.v1.TelePilotService/StopJob".
+func methodName(fullMethod string) string {
+	name, ok := strings.CutPrefix(fullMethod, "/"+pb.TelePilotService_ServiceDesc.ServiceName+"/")
+	if !ok {
+		return ""
+	}
+	return name
 }
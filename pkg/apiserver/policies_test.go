@@ -1,34 +1,175 @@
 package apiserver //nolint:testpackage // Expected to test the internal package to validate policy count.
 
 import (
+	"errors"
 	"testing"
 
 	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/rbac"
 )
 
-// Make sure we always have as many polcies as handlers.
+// Make sure the default policy covers all the handlers.
 func TestPolicyCount(t *testing.T) {
 	t.Parallel()
 
-	// Create a set of the known methods/streams.
-	tmp := map[string]struct{}{}
-	for _, ep := range pb.TelePilotService_ServiceDesc.Methods {
-		tmp["/"+pb.TelePilotService_ServiceDesc.ServiceName+"/"+ep.MethodName] = struct{}{}
+	if err := defaultPolicy.Validate(serviceMethods()); err != nil {
+		t.Fatalf("Invalid default policy: %s.", err)
 	}
-	for _, ep := range pb.TelePilotService_ServiceDesc.Streams {
-		tmp["/"+pb.TelePilotService_ServiceDesc.ServiceName+"/"+ep.StreamName] = struct{}{}
+
+	// Make sure a missing method is detected.
+	p := &rbac.Policy{
+		Roles:    map[string]rbac.Role{"user": {Rules: []rbac.Rule{{Methods: []string{"StartJob"}}}}},
+		Bindings: []rbac.Binding{{Role: "user", Users: []string{rbac.Wildcard}}},
+	}
+	if err := p.Validate(serviceMethods()); !errors.Is(err, rbac.ErrInvalidPolicy) {
+		t.Fatalf("Expected an incomplete policy to be rejected, got: %v.", err)
+	}
+
+	// Make sure all the full method names map to a known method.
+	for _, fullMethod := range []string{
+		pb.TelePilotService_StartJob_FullMethodName,
+		pb.TelePilotService_ExecInJob_FullMethodName,
+		pb.TelePilotService_CanI_FullMethodName,
+	} {
+		if methodName(fullMethod) == "" {
+			t.Errorf("Unexpected empty method name for %q.", fullMethod)
+		}
+	}
+}
+
+func TestPolicyScopes(t *testing.T) {
+	t.Parallel()
+
+	p := &rbac.Policy{
+		Groups: map[string][]string{"oncall": {"bob", "dave"}},
+		Roles: map[string]rbac.Role{
+			"user":   defaultPolicy.Roles["user"],
+			"admin":  {Rules: []rbac.Rule{{Methods: []string{rbac.Wildcard}, Scope: rbac.ScopeAll}}},
+			"oncall": {Rules: []rbac.Rule{{Methods: []string{"GetJobStatus", "StreamLogs"}, Scope: rbac.ScopeGroup}}},
+		},
+		Bindings: []rbac.Binding{
+			{Role: "admin", Users: []string{"alice"}},
+			{Role: "oncall", Groups: []string{"oncall"}},
+			{Role: "user", Users: []string{rbac.Wildcard}},
+		},
+	}
+	if err := p.Validate(serviceMethods()); err != nil {
+		t.Fatalf("Invalid policy: %s.", err)
+	}
+
+	for _, tc := range []struct {
+		user, method, owner string
+		expect              string
+	}{
+		{"alice", "StopJob", "bob", "admin"},
+		{"bob", "GetJobStatus", "dave", "oncall"},
+		{"bob", "StopJob", "dave", ""},
+		{"bob", "GetJobStatus", "alice", ""},
+		{"dave", "StopJob", "dave", "user"},
+		{"eve", "StartJob", "", "user"},
+		{"eve", "GetJobStatus", "bob", ""},
+		{"", "StartJob", "", ""},
+	} {
+		role, ok := p.Authorize(rbac.Request{User: tc.user, Method: tc.method, Job: tc.owner != "", JobOwner: tc.owner})
+		if role != tc.expect || ok != (tc.expect != "") {
+			t.Errorf("%s %s on %q's job: expected role %q, got %q (%t).", tc.user, tc.method, tc.owner, tc.expect, role, ok)
+		}
+	}
+
+	// The groups of the identities count as well: Eve is on-call via her certificate, Frank's job got the group from his.
+	role, ok := p.Authorize(rbac.Request{User: "eve", Groups: []string{"oncall"}, Method: "GetJobStatus", Job: true, JobOwner: "bob"})
+	if !ok || role != "oncall" {
+		t.Errorf("Expected eve to be on-call from her identity groups, got %q (%t).", role, ok)
+	}
+	role, ok = p.Authorize(rbac.Request{User: "bob", Method: "StreamLogs", Job: true, JobOwner: "frank", JobOwnerGroups: []string{"oncall"}})
+	if !ok || role != "oncall" {
+		t.Errorf("Expected bob to access frank's job from the job owner groups, got %q (%t).", role, ok)
 	}
 
-	// Go over our policies and remove what we have from the set.
-	for ep := range policies {
-		if _, ok := tmp[ep]; !ok {
-			t.Errorf("Left over unused policy for endpoint %q.", ep)
+	// The job ACL grants its permissions to other users, whatever their roles, but never more.
+	acl := rbac.ACL{
+		{User: "eve", Permissions: []rbac.Permission{rbac.PermissionReadLogs, rbac.PermissionStop}},
+		{Group: "qa", Permissions: []rbac.Permission{rbac.PermissionReadStatus}},
+	}
+	for _, tc := range []struct {
+		user   string
+		groups []string
+		method string
+		expect string
+	}{
+		{"eve", nil, "StreamLogs", rbac.ACLRole},
+		{"eve", nil, "StopJob", rbac.ACLRole},
+		{"eve", nil, "GetJobStatus", ""},
+		{"eve", nil, "SetJobACL", ""},
+		{"frank", []string{"qa"}, "GetJobStatus", rbac.ACLRole},
+		{"frank", []string{"qa"}, "SignalJob", ""},
+		{"frank", nil, "GetJobStatus", ""},
+		{"dave", nil, "SetJobACL", "user"},
+	} {
+		role, ok := p.Authorize(rbac.Request{User: tc.user, Groups: tc.groups, Method: tc.method, Job: true, JobOwner: "dave", JobACL: acl})
+		if role != tc.expect || ok != (tc.expect != "") {
+			t.Errorf("%s %s on dave's shared job: expected role %q, got %q (%t).", tc.user, tc.method, tc.expect, role, ok)
 		}
-		delete(tmp, ep)
 	}
+}
 
-	// Make sure we don't have any left-over.
-	if len(tmp) != 0 {
-		t.Fatalf("Missing policies for TelePilotService methods/streams: %v.", tmp)
+func TestPolicyCommands(t *testing.T) {
+	t.Parallel()
+
+	p := &rbac.Policy{
+		Roles: map[string]rbac.Role{
+			"user":  defaultPolicy.Roles["user"],
+			"admin": defaultPolicy.Roles["admin"],
+			"restricted": {Rules: []rbac.Rule{{
+				Methods: []string{"StartJob", "ExecInJob"},
+				Commands: []rbac.CommandRule{
+					{Path: "/bin/echo", Args: []string{"[a-z]+", "-n"}},
+					{Path: "/opt/tools/*"},
+				},
+			}}},
+		},
+		Bindings: []rbac.Binding{
+			{Role: "restricted", Users: []string{"bob"}},
+			{Role: "user", Users: []string{"alice"}},
+			{Role: "admin", Groups: []string{"admins"}},
+		},
+	}
+	if err := p.Validate(serviceMethods()); err != nil {
+		t.Fatalf("Invalid policy: %s.", err)
+	}
+
+	for _, tc := range []struct {
+		user    string
+		cmds    []rbac.Command
+		allowed bool
+	}{
+		{"bob", []rbac.Command{{Path: "/bin/echo", Args: []string{"-n", "hello"}}}, true},
+		{"bob", []rbac.Command{{Path: "/bin/echo"}}, true},
+		{"bob", []rbac.Command{{Path: "/opt/tools/backup", Args: []string{"--all"}}}, true},
+		{"bob", nil, true}, // CanI.
+		{"bob", []rbac.Command{{Path: "/bin/echo", Args: []string{"hello world"}}}, false},
+		{"bob", []rbac.Command{{Path: "/bin/echo", Args: []string{"-nhello"}}}, false},
+		{"bob", []rbac.Command{{Path: "/opt/tools/sub/cmd"}}, false},
+		{"bob", []rbac.Command{{Path: "/opt/tools/backup"}, {Path: "/bin/sh"}}, false},
+		{"alice", []rbac.Command{{Path: "/bin/sh"}}, true},
+	} {
+		_, err := p.Check(rbac.Request{User: tc.user, Method: "StartJob", Commands: tc.cmds})
+		if (err == nil) != tc.allowed {
+			t.Errorf("%s starting %v: expected allowed %t, got: %v.", tc.user, tc.cmds, tc.allowed, err)
+		}
+		if err != nil && !errors.Is(err, rbac.ErrForbidden) {
+			t.Errorf("%s starting %v: expected forbidden, got: %v.", tc.user, tc.cmds, err)
+		}
+	}
+
+	// Invalid globs and regexes, and relative paths are rejected.
+	for _, c := range []rbac.CommandRule{{Path: "/bin/["}, {Path: "/bin/echo", Args: []string{"("}}, {}, {Path: "*"}, {Path: "tools/*"}} {
+		invalid := &rbac.Policy{
+			Roles:    map[string]rbac.Role{"user": {Rules: []rbac.Rule{{Methods: []string{rbac.Wildcard}, Commands: []rbac.CommandRule{c}}}}},
+			Bindings: []rbac.Binding{{Role: "user", Users: []string{rbac.Wildcard}}},
+		}
+		if err := invalid.Validate(serviceMethods()); !errors.Is(err, rbac.ErrInvalidPolicy) {
+			t.Errorf("Expected command rule %+v to be rejected, got: %v.", c, err)
+		}
 	}
 }
@@ -0,0 +1,252 @@
+// Package audit records the API calls in a tamper-evident log.
+//
+// The log is a JSON lines file, each entry carrying the HMAC of the previous one,
+// so altering, removing or re-ordering entries breaks the chain. The HMAC key is kept
+// outside the log directory, so whoever can rewrite the log can't re-compute the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Common errors.
+var (
+	ErrTampered   = errors.New("audit log tampered")
+	ErrInvalidKey = errors.New("invalid audit key")
+)
+
+const (
+	maxEntrySize = 1 << 20 // Max size of an entry when reading the log.
+	keySize      = 32      // Size of the HMAC key, in bytes.
+)
+
+// Decision of the authorization.
+type Decision string
+
+// Decisions.
+const (
+	DecisionAllow Decision = "allow"
+	DecisionDeny  Decision = "deny"
+)
+
+// Entry of the log, one per call.
+type Entry struct {
+	Seq        uint64          `json:"seq"` // Starts at 1.
+	Time       time.Time       `json:"time"`
+	User       string          `json:"user,omitempty"` // Empty when not authenticated.
+	Groups     []string        `json:"groups,omitempty"`
+	Method     string          `json:"method"`
+	JobID      string          `json:"job_id,omitempty"`
+	Request    json.RawMessage `json:"request,omitempty"` // Summary of the request.
+	Decision   Decision        `json:"decision"`
+	Code       string          `json:"code"` // gRPC status code of the call.
+	DurationMS int64           `json:"duration_ms"`
+
+	PrevHash string `json:"prev_hash"` // Hash of the previous entry, empty for the first one.
+	Hash     string `json:"hash"`      // HMAC-SHA256 of the entry without this field.
+}
+
+// computeHash returns the hash of the entry, i.e. the HMAC of its JSON encoding without hash.
+func (e Entry) computeHash(key []byte) (string, error) {
+	e.Hash = ""
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("encode entry: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(buf) // Never fails.
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// LoadKey reads the hex encoded HMAC key of the given log from keyPath, which must be outside the log directory.
+// When create is set and the key doesn't exist, a random one is generated.
+func LoadKey(keyPath, logPath string, create bool) ([]byte, error) {
+	keyAbs, err := filepath.Abs(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve audit key path: %w", err)
+	}
+	logDir, err := filepath.Abs(filepath.Dir(logPath))
+	if err != nil {
+		return nil, fmt.Errorf("resolve audit log dir: %w", err)
+	}
+	if rel, err := filepath.Rel(logDir, keyAbs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%w: %q must be outside the log directory %q", ErrInvalidKey, keyPath, logDir)
+	}
+
+	buf, err := os.ReadFile(keyPath)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		return createKey(keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read audit key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil || len(key) < keySize {
+		return nil, fmt.Errorf("%w: %q: expect at least %d hex encoded bytes", ErrInvalidKey, keyPath, keySize)
+	}
+	return key, nil
+}
+
+// createKey generates a random key and writes it hex encoded to the given path, failing if it exists.
+func createKey(path string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate audit key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil { //nolint:mnd // Standard perm.
+		return nil, fmt.Errorf("create audit key dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) //nolint:mnd // Standard perm.
+	if err != nil {
+		return nil, fmt.Errorf("create audit key: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		_ = f.Close() // Best effort.
+		return nil, fmt.Errorf("write audit key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close audit key: %w", err)
+	}
+	return key, nil
+}
+
+// Logger appends the entries to the log file.
+type Logger struct {
+	mu       sync.Mutex
+	f        *os.File
+	key      []byte
+	seq      uint64
+	prevHash string
+}
+
+// Open the given log file, creating it if needed, chaining the entries with the given key. The chain resumes
+// from the last entry, the log is not extended if the last entry is invalid, i.e. altered or from another key.
+func Open(path string, key []byte) (*Logger, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: empty key", ErrInvalidKey)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil { //nolint:mnd // Standard perm.
+		return nil, fmt.Errorf("create audit log dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600) //nolint:mnd // Standard perm.
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	l := &Logger{f: f, key: key}
+	last, err := lastEntry(f, key)
+	if err != nil {
+		_ = f.Close() // Best effort.
+		return nil, fmt.Errorf("resume audit log %q: %w", path, err)
+	}
+	if last != nil {
+		l.seq, l.prevHash = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// lastEntry returns the last entry of the log, nil if empty.
+func lastEntry(r io.Reader, key []byte) (*Entry, error) {
+	var last []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxEntrySize)
+	for scanner.Scan() {
+		last = append(last[:0], scanner.Bytes()...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	if len(last) == 0 {
+		return nil, nil //nolint:nilnil // Expected when empty.
+	}
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return nil, fmt.Errorf("%w: invalid last entry: %w", ErrTampered, err)
+	}
+	if hash, err := e.computeHash(key); err != nil || !hmac.Equal([]byte(hash), []byte(e.Hash)) {
+		return nil, fmt.Errorf("%w: invalid hash of the last entry %d", ErrTampered, e.Seq)
+	}
+	return &e, nil
+}
+
+// Record chains and appends the given entry, setting its sequence number and hashes.
+func (l *Logger) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq, e.PrevHash = l.seq+1, l.prevHash
+	e.Time = e.Time.UTC()
+	hash, err := e.computeHash(l.key)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode entry: %w", err)
+	}
+	// NOTE: Single write per entry, not to interleave partial lines.
+	if _, err := l.f.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	l.seq, l.prevHash = e.Seq, e.Hash
+	return nil
+}
+
+// Close the log file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close() //nolint:wrapcheck // No wrap needed here.
+}
+
+// Verify validates the chain of the given log with the given key. Returns the number of entries.
+//
+// NOTE: Truncating the end of the log can't be detected from the log itself,
+// the last hash must be compared with a copy kept elsewhere.
+func Verify(r io.Reader, key []byte) (uint64, error) {
+	var seq uint64
+	var prevHash string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxEntrySize)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&e); err != nil {
+			return seq, fmt.Errorf("%w: line %d: invalid entry: %w", ErrTampered, line, err)
+		}
+		if e.Seq != seq+1 {
+			return seq, fmt.Errorf("%w: line %d: expected entry %d, got %d", ErrTampered, line, seq+1, e.Seq)
+		}
+		if e.PrevHash != prevHash {
+			return seq, fmt.Errorf("%w: line %d: entry %d not chained to the previous one", ErrTampered, line, e.Seq)
+		}
+		hash, err := e.computeHash(key)
+		if err != nil {
+			return seq, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !hmac.Equal([]byte(hash), []byte(e.Hash)) {
+			return seq, fmt.Errorf("%w: line %d: entry %d altered", ErrTampered, line, e.Seq)
+		}
+		seq, prevHash = e.Seq, e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return seq, fmt.Errorf("read audit log: %w", err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,133 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.creack.net/telepilot/pkg/audit"
+)
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	key, err := audit.LoadKey(filepath.Join(t.TempDir(), "audit.key"), path, true)
+	if err != nil {
+		t.Fatalf("Load key: %s.", err)
+	}
+
+	// record is a helper to open the log, record the given methods and close it.
+	record := func(methods ...string) {
+		l, err := audit.Open(path, key)
+		if err != nil {
+			t.Fatalf("Open: %s.", err)
+		}
+		for _, method := range methods {
+			if err := l.Record(audit.Entry{
+				Time:     time.Now(),
+				User:     "alice",
+				Method:   method,
+				Request:  json.RawMessage(`{"command": "/bin/ls", "args": ["-l"]}`),
+				Decision: audit.DecisionAllow,
+				Code:     "OK",
+			}); err != nil {
+				t.Fatalf("Record: %s.", err)
+			}
+		}
+		if err := l.Close(); err != nil {
+			t.Fatalf("Close: %s.", err)
+		}
+	}
+	// The chain resumes when re-opened.
+	record("StartJob", "GetJobStatus")
+	record("StopJob")
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Read: %s.", err)
+	}
+	n, err := audit.Verify(bytes.NewReader(buf), key)
+	if err != nil {
+		t.Fatalf("Verify: %s.", err)
+	}
+	if n != 3 {
+		t.Fatalf("Expected 3 entries, got %d.", n)
+	}
+
+	lines := strings.SplitAfter(string(buf), "\n")
+	for name, tampered := range map[string]string{
+		"altered":   lines[0] + strings.Replace(lines[1], "alice", "bob", 1) + lines[2],
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+		"garbage":   lines[0] + "garbage\n" + lines[1] + lines[2],
+	} {
+		if _, err := audit.Verify(strings.NewReader(tampered), key); !errors.Is(err, audit.ErrTampered) {
+			t.Errorf("%s: expected tampering to be detected, got: %v.", name, err)
+		}
+	}
+
+	// The chain can't be verified, nor re-computed, without the key.
+	otherKey := bytes.Repeat([]byte{1}, len(key))
+	if _, err := audit.Verify(bytes.NewReader(buf), otherKey); !errors.Is(err, audit.ErrTampered) {
+		t.Errorf("Expected verifying with another key to fail, got: %v.", err)
+	}
+	if _, err := audit.Open(path, otherKey); !errors.Is(err, audit.ErrTampered) {
+		t.Errorf("Expected resuming with another key to fail, got: %v.", err)
+	}
+
+	// The log is not extended when its last entry is invalid.
+	if err := os.WriteFile(path, []byte(lines[0]+lines[1]+strings.Replace(lines[2], "StopJob", "StartJob", 1)), 0o600); err != nil {
+		t.Fatalf("Write: %s.", err)
+	}
+	if _, err := audit.Open(path, key); !errors.Is(err, audit.ErrTampered) {
+		t.Fatalf("Expected tampered log to be rejected on open, got: %v.", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "logs", "audit.log")
+	keyPath := filepath.Join(dir, "keys", "audit.key")
+
+	// Not created unless asked.
+	if _, err := audit.LoadKey(keyPath, logPath, false); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected missing key error, got: %v.", err)
+	}
+	key, err := audit.LoadKey(keyPath, logPath, true)
+	if err != nil {
+		t.Fatalf("Create key: %s.", err)
+	}
+	loaded, err := audit.LoadKey(keyPath, logPath, false)
+	if err != nil {
+		t.Fatalf("Load key: %s.", err)
+	}
+	if !bytes.Equal(key, loaded) {
+		t.Fatal("Loaded key differs from the created one.")
+	}
+
+	for name, path := range map[string]string{
+		"log dir": filepath.Join(dir, "logs", "audit.key"),
+		"sub dir": filepath.Join(dir, "logs", "keys", "audit.key"),
+	} {
+		if _, err := audit.LoadKey(path, logPath, true); !errors.Is(err, audit.ErrInvalidKey) {
+			t.Errorf("%s: expected key within the log directory to be rejected, got: %v.", name, err)
+		}
+	}
+
+	short := filepath.Join(dir, "short.key")
+	if err := os.WriteFile(short, []byte("abcd\n"), 0o600); err != nil {
+		t.Fatalf("Write: %s.", err)
+	}
+	if _, err := audit.LoadKey(short, logPath, false); !errors.Is(err, audit.ErrInvalidKey) {
+		t.Errorf("Expected short key to be rejected, got: %v.", err)
+	}
+}
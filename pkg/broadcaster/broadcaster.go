@@ -71,6 +71,13 @@ func (b *BufferedBroadcaster) Buffer() string {
 	return b.buffer.String()
 }
 
+// Len returns the size of the buffer.
+func (b *BufferedBroadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Len()
+}
+
 func (b *BufferedBroadcaster) SubscribeOutput(w io.Writer) string {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -0,0 +1,416 @@
+// Package ca implements a certificate authority issuing the client certificates, and their enrollment.
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.creack.net/telepilot/pkg/statefile"
+)
+
+// Common errors.
+var (
+	ErrInvalidRequest = errors.New("invalid certificate request")
+	ErrInvalidToken   = errors.New("invalid or expired enrollment token")
+	ErrRevoked        = errors.New("certificate revoked")
+)
+
+// Files within the state dir.
+const (
+	stateFile = "ca.json"
+	crlFile   = "crl.pem"
+)
+
+// Defaults.
+const (
+	DefaultMaxTTL   = 30 * 24 * time.Hour
+	DefaultRenewTTL = 24 * time.Hour
+	DefaultTokenTTL = 24 * time.Hour
+
+	crlValidity  = 30 * 24 * time.Hour // The CRL is re-generated on each revocation and on startup.
+	clockSkew    = time.Minute         // Certificates are valid from a bit earlier, for the clocks skew.
+	serialBits   = 128
+	tokenBytes   = 32
+	maxNameBytes = 64
+)
+
+// Config of the authority.
+type Config struct {
+	// CA certificate and key, i.e. the CA the server verifies the clients with.
+	CertFile, KeyFile string
+	// Directory where the issued certificates and the CRL are persisted.
+	StateDir string
+	// Max lifetime of the issued certificates. Defaults to DefaultMaxTTL.
+	MaxTTL time.Duration
+	// Max lifetime of the renewed certificates, expected to be short. Defaults to DefaultRenewTTL, clamped by MaxTTL.
+	RenewTTL time.Duration
+}
+
+// Issued is an issued certificate.
+type Issued struct {
+	Serial    string    `json:"serial"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups,omitempty"`
+	IssuedBy  string    `json:"issued_by"` // Admin who issued the certificate or the enrollment token.
+	IssuedAt  time.Time `json:"issued_at"`
+	NotAfter  time.Time `json:"not_after"`
+	RevokedAt time.Time `json:"revoked_at"` // Zero when not revoked.
+	// Serial of the certificate presented to renew, revoking it revokes this one. Empty when issued or enrolled.
+	Parent string `json:"parent,omitempty"`
+}
+
+// Revoked is a revoked certificate, issued or not by the authority.
+type Revoked struct {
+	Serial    string    `json:"serial"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// state is the persisted state.
+type state struct {
+	CRLNumber int64     `json:"crl_number"`
+	Issued    []Issued  `json:"issued"`
+	Revoked   []Revoked `json:"revoked"`
+}
+
+// token is a pending enrollment.
+type token struct {
+	user      string
+	groups    []string
+	certTTL   time.Duration
+	createdBy string
+	expiresAt time.Time
+}
+
+// Authority issues the client certificates.
+type Authority struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	cfg      Config
+	maxTTL   time.Duration
+	renewTTL time.Duration
+
+	mu     sync.Mutex
+	state  state
+	tokens map[string]token // By sha256 of the token, not to keep them in clear.
+}
+
+// New loads the CA and the state, then writes the CRL.
+func New(cfg Config) (*Authority, error) {
+	if cfg.StateDir == "" {
+		return nil, errors.New("missing state dir") //nolint:err113 // No need for fancy error here.
+	}
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load ca: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse ca certificate: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca key type %T", pair.PrivateKey) //nolint:err113 // No need for fancy error here.
+	}
+	a := &Authority{
+		cert:   cert,
+		key:    key,
+		cfg:    cfg,
+		maxTTL: cfg.MaxTTL,
+		tokens: map[string]token{},
+	}
+	if a.maxTTL <= 0 {
+		a.maxTTL = DefaultMaxTTL
+	}
+	a.renewTTL = cfg.RenewTTL
+	if a.renewTTL <= 0 {
+		a.renewTTL = DefaultRenewTTL
+	}
+	a.renewTTL = min(a.renewTTL, a.maxTTL)
+	if err := statefile.Load(filepath.Join(cfg.StateDir, stateFile), &a.state); err != nil {
+		return nil, fmt.Errorf("load ca state: %w", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Refresh the CRL on startup, so it doesn't go past its next update.
+	if err := a.writeCRLLocked(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// CRLFile returns the path of the CRL of the revoked certificates.
+func (a *Authority) CRLFile() string {
+	return filepath.Join(a.cfg.StateDir, crlFile)
+}
+
+// Issue signs the given PEM CSR for the given identity. The subject of the CSR is ignored, the certificate
+// is issued with the user as CN and the groups as OUs. A ttl of 0 or above the max is clamped to the max.
+func (a *Authority) Issue(csrPEM []byte, user string, groups []string, ttl time.Duration, issuedBy string) ([]byte, Issued, error) {
+	return a.issue(csrPEM, user, groups, ttl, issuedBy, "")
+}
+
+// issue signs the given PEM CSR, see Issue. The parent, when set, is the serial of the certificate being renewed.
+func (a *Authority) issue(csrPEM []byte, user string, groups []string, ttl time.Duration, issuedBy, parent string) ([]byte, Issued, error) {
+	if err := validateIdentity(user, groups); err != nil {
+		return nil, Issued{}, err
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, Issued{}, fmt.Errorf("%w: expect a PEM encoded CSR", ErrInvalidRequest)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, Issued{}, fmt.Errorf("%w: parse csr: %w", ErrInvalidRequest, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, Issued{}, fmt.Errorf("%w: csr signature: %w", ErrInvalidRequest, err)
+	}
+	if ttl <= 0 || ttl > a.maxTTL {
+		ttl = a.maxTTL
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+	if err != nil {
+		return nil, Issued{}, fmt.Errorf("generate serial: %w", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: user, OrganizationalUnit: groups},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if tmpl.NotAfter.After(a.cert.NotAfter) {
+		tmpl.NotAfter = a.cert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return nil, Issued{}, fmt.Errorf("create certificate: %w", err)
+	}
+
+	issued := Issued{
+		Serial:   serial.String(),
+		User:     user,
+		Groups:   slices.Clone(groups),
+		IssuedBy: issuedBy,
+		IssuedAt: now,
+		NotAfter: tmpl.NotAfter,
+		Parent:   parent,
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The parent may have been revoked while signing, the new certificate would escape the revocation.
+	if parent != "" && a.revokedLocked(parent) {
+		return nil, Issued{}, fmt.Errorf("%w: serial %s", ErrRevoked, parent)
+	}
+	a.state.Issued = append(a.state.Issued, issued)
+	if err := a.saveLocked(); err != nil {
+		return nil, Issued{}, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), issued, nil
+}
+
+// CreateToken creates a one-time enrollment token for the given identity, valid for tokenTTL (DefaultTokenTTL if 0).
+// The tokens are kept in memory only.
+func (a *Authority) CreateToken(user string, groups []string, certTTL, tokenTTL time.Duration, createdBy string) (string, time.Time, error) {
+	if err := validateIdentity(user, groups); err != nil {
+		return "", time.Time{}, err
+	}
+	if tokenTTL <= 0 {
+		tokenTTL = DefaultTokenTTL
+	}
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("generate token: %w", err)
+	}
+	tok := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(tokenTTL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneTokensLocked()
+	a.tokens[hashToken(tok)] = token{
+		user:      user,
+		groups:    slices.Clone(groups),
+		certTTL:   certTTL,
+		createdBy: createdBy,
+		expiresAt: expiresAt,
+	}
+	return tok, expiresAt, nil
+}
+
+// Enroll consumes the given token and issues a certificate for its identity, signing the given PEM CSR.
+// The token is consumed even if the CSR is invalid.
+func (a *Authority) Enroll(tok string, csrPEM []byte) ([]byte, Issued, error) {
+	a.mu.Lock()
+	a.pruneTokensLocked()
+	h := hashToken(tok)
+	t, ok := a.tokens[h]
+	delete(a.tokens, h)
+	a.mu.Unlock()
+	if !ok {
+		return nil, Issued{}, ErrInvalidToken
+	}
+	return a.Issue(csrPEM, t.user, t.groups, t.certTTL, t.createdBy)
+}
+
+// Renew signs the given PEM CSR for the given identity, authenticated by its current certificate,
+// whose serial is recorded as the parent of the new one, so revoking it revokes the renewed ones as well.
+// The lifetime is clamped to the renewal one, so the long-lived certificates get replaced by short-lived ones.
+func (a *Authority) Renew(csrPEM []byte, parent, user string, groups []string, ttl time.Duration) ([]byte, Issued, error) {
+	if parent == "" {
+		return nil, Issued{}, fmt.Errorf("%w: missing current certificate serial", ErrInvalidRequest)
+	}
+	if ttl <= 0 || ttl > a.renewTTL {
+		ttl = a.renewTTL
+	}
+	return a.issue(csrPEM, user, groups, ttl, user, parent)
+}
+
+// Revoke the certificate with the given serial number, decimal or 0x prefixed hexadecimal, along with the
+// certificates renewed from it, directly or not, then re-writes the CRL. Returns the serials of the renewed ones.
+// The certificate doesn't have to be issued by the authority, as long as it is signed by the CA.
+func (a *Authority) Revoke(serial string) ([]string, error) {
+	n, ok := new(big.Int).SetString(serial, 0)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: invalid serial number %q", ErrInvalidRequest, serial)
+	}
+	serial = n.String()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// NOTE: The certificates are recorded in issuance order, a parent always comes before its children,
+	// so a single pass collects the whole lineage.
+	lineage := map[string]bool{serial: true}
+	var descendants []string
+	for _, elem := range a.state.Issued {
+		if elem.Parent != "" && lineage[elem.Parent] && !lineage[elem.Serial] {
+			lineage[elem.Serial] = true
+			descendants = append(descendants, elem.Serial)
+		}
+	}
+
+	now := time.Now()
+	changed := false
+	for _, s := range append([]string{serial}, descendants...) {
+		if a.revokedLocked(s) {
+			continue
+		}
+		changed = true
+		a.state.Revoked = append(a.state.Revoked, Revoked{Serial: s, RevokedAt: now})
+	}
+	if !changed {
+		return descendants, nil
+	}
+	for i, elem := range a.state.Issued {
+		if lineage[elem.Serial] && elem.RevokedAt.IsZero() {
+			a.state.Issued[i].RevokedAt = now
+		}
+	}
+	return descendants, a.writeCRLLocked()
+}
+
+// revokedLocked returns whether the given serial is revoked.
+//
+// NOTE: Expected to be called with the lock held.
+func (a *Authority) revokedLocked(serial string) bool {
+	return slices.ContainsFunc(a.state.Revoked, func(r Revoked) bool { return r.Serial == serial })
+}
+
+// writeCRLLocked generates and writes the CRL of the revoked certificates, then persists the state.
+//
+// NOTE: Expected to be called with the lock held.
+func (a *Authority) writeCRLLocked() error {
+	a.state.CRLNumber++
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(a.state.CRLNumber),
+		ThisUpdate: now.Add(-clockSkew),
+		NextUpdate: now.Add(crlValidity),
+	}
+	for _, elem := range a.state.Revoked {
+		n, _ := new(big.Int).SetString(elem.Serial, 10) //nolint:mnd // Decimal, validated when revoked.
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   n,
+			RevocationTime: elem.RevokedAt,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, a.cert, a.key)
+	if err != nil {
+		return fmt.Errorf("create crl: %w", err)
+	}
+	// NOTE: Save first, which creates the state dir if needed.
+	if err := a.saveLocked(); err != nil {
+		return err
+	}
+	// Write then rename, so the CRL reloads never see a partial file.
+	tmp := a.CRLFile() + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil { //nolint:mnd // Standard perm.
+		return fmt.Errorf("write crl: %w", err)
+	}
+	if err := os.Rename(tmp, a.CRLFile()); err != nil {
+		return fmt.Errorf("rename crl: %w", err)
+	}
+	return nil
+}
+
+// saveLocked persists the state.
+//
+// NOTE: Expected to be called with the lock held.
+func (a *Authority) saveLocked() error {
+	if err := statefile.Save(filepath.Join(a.cfg.StateDir, stateFile), a.state); err != nil {
+		return fmt.Errorf("save ca state: %w", err)
+	}
+	return nil
+}
+
+// pruneTokensLocked removes the expired tokens.
+//
+// NOTE: Expected to be called with the lock held.
+func (a *Authority) pruneTokensLocked() {
+	now := time.Now()
+	for h, t := range a.tokens {
+		if now.After(t.expiresAt) {
+			delete(a.tokens, h)
+		}
+	}
+}
+
+// hashToken returns the key of the given token.
+func hashToken(tok string) string {
+	h := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(h[:])
+}
+
+// validateIdentity makes sure the user and groups are non-empty names without control characters nor slashes,
+// which would be ambiguous in the SPIFFE IDs.
+func validateIdentity(user string, groups []string) error {
+	for _, name := range append([]string{user}, groups...) {
+		if name == "" || len(name) > maxNameBytes {
+			return fmt.Errorf("%w: user and groups must be 1 to %d bytes long", ErrInvalidRequest, maxNameBytes)
+		}
+		for _, c := range name {
+			if !unicode.IsPrint(c) || c == '/' {
+				return fmt.Errorf("%w: unexpected character %q in %q", ErrInvalidRequest, c, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package ca_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.creack.net/telepilot/pkg/ca"
+	"go.creack.net/telepilot/pkg/identity"
+)
+
+// newAuthority creates an authority with a fresh self-signed CA.
+func newAuthority(t *testing.T) *ca.Authority {
+	t.Helper()
+
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate ca key: %s.", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Create ca certificate: %s.", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Marshal ca key: %s.", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Write ca certificate: %s.", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Write ca key: %s.", err)
+	}
+	a, err := ca.New(ca.Config{CertFile: certFile, KeyFile: keyFile, StateDir: filepath.Join(dir, "state")})
+	if err != nil {
+		t.Fatalf("New authority: %s.", err)
+	}
+	return a
+}
+
+// newCSR creates a PEM encoded CSR with a fresh key.
+func newCSR(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate key: %s.", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "ignored"}}, key)
+	if err != nil {
+		t.Fatalf("Create csr: %s.", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestTokens(t *testing.T) {
+	t.Parallel()
+
+	a := newAuthority(t)
+
+	// The identity is validated when creating the token, not when enrolling.
+	for name, tc := range map[string]struct {
+		user   string
+		groups []string
+		valid  bool
+	}{
+		"user":          {"alice", nil, true},
+		"groups":        {"alice", []string{"ml", "sre"}, true},
+		"empty user":    {"", nil, false},
+		"empty group":   {"alice", []string{""}, false},
+		"slash":         {"team/alice", nil, false},
+		"control char":  {"alice\n", nil, false},
+		"too long":      {strings.Repeat("a", 65), nil, false},
+		"long group":    {"alice", []string{strings.Repeat("a", 65)}, false},
+		"max length":    {strings.Repeat("a", 64), nil, true},
+		"unicode":       {"alicé", nil, true},
+		"space in name": {"alice smith", nil, true},
+	} {
+		tok, expiresAt, err := a.CreateToken(tc.user, tc.groups, 0, 0, "admin")
+		if !tc.valid {
+			if !errors.Is(err, ca.ErrInvalidRequest) {
+				t.Errorf("%s: expected invalid request error, got: %v.", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s.", name, err)
+			continue
+		}
+		if tok == "" || time.Until(expiresAt) <= ca.DefaultTokenTTL-time.Minute {
+			t.Errorf("%s: expected a token valid for the default ttl, got %q until %s.", name, tok, expiresAt)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		tokenTTL time.Duration
+		csr      func(*testing.T) []byte
+		tamper   func(string) string
+		err      error // Expected error of the first enrollment, nil when valid.
+	}{
+		"valid":       {0, newCSR, nil, nil},
+		"unknown":     {0, newCSR, func(tok string) string { return tok + "0" }, ca.ErrInvalidToken},
+		"empty":       {0, newCSR, func(string) string { return "" }, ca.ErrInvalidToken},
+		"expired":     {time.Nanosecond, newCSR, nil, ca.ErrInvalidToken},
+		"invalid csr": {0, func(*testing.T) []byte { return []byte("garbage") }, nil, ca.ErrInvalidRequest},
+	} {
+		tok, _, err := a.CreateToken("alice", []string{"ml"}, time.Hour, tc.tokenTTL, "admin")
+		if err != nil {
+			t.Fatalf("%s: create token: %s.", name, err)
+		}
+		enrollTok := tok
+		if tc.tamper != nil {
+			enrollTok = tc.tamper(tok)
+		}
+		if tc.tokenTTL > 0 {
+			time.Sleep(tc.tokenTTL)
+		}
+
+		certPEM, issued, err := a.Enroll(enrollTok, tc.csr(t))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected error %v, got: %v.", name, tc.err, err)
+			continue
+		}
+		if err == nil {
+			block, _ := pem.Decode(certPEM)
+			if block == nil {
+				t.Fatalf("%s: expected a PEM certificate.", name)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatalf("%s: parse certificate: %s.", name, err)
+			}
+			id, err := identity.FromCertificate(cert)
+			if err != nil {
+				t.Fatalf("%s: identity: %s.", name, err)
+			}
+			if id.User != "alice" || !slices.Equal(id.Groups, []string{"ml"}) || issued.User != "alice" || issued.IssuedBy != "admin" {
+				t.Errorf("%s: expected alice [ml] issued by admin, got %q %v, issued %+v.", name, id.User, id.Groups, issued)
+			}
+			if issued.NotAfter.After(time.Now().Add(time.Hour)) {
+				t.Errorf("%s: expected the certificate ttl of the token, got not after %s.", name, issued.NotAfter)
+			}
+		}
+
+		// The tokens are one-time, consumed even when the CSR is invalid, but not by the attempts with other tokens.
+		_, _, err = a.Enroll(tok, newCSR(t))
+		if tc.tamper == nil && !errors.Is(err, ca.ErrInvalidToken) {
+			t.Errorf("%s: expected the token to be consumed, got: %v.", name, err)
+		}
+		if tc.tamper != nil && err != nil {
+			t.Errorf("%s: expected the token to be left untouched, got: %v.", name, err)
+		}
+	}
+}
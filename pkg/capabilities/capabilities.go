@@ -0,0 +1,250 @@
+// Package capabilities manages the Linux capability sets of the jobs, using only the standard library.
+package capabilities
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ErrInvalidCapability is returned when parsing an unknown capability name.
+var ErrInvalidCapability = errors.New("invalid capability")
+
+// Set is a capability bitmask, bit N being the capability N.
+type Set uint64
+
+// names of the capabilities, indexed by number. See capabilities(7).
+//
+//nolint:gochecknoglobals // Expected global.
+var names = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// All the capabilities known to this package, up to CAP_CHECKPOINT_RESTORE (40).
+const All = Set(1)<<41 - 1
+
+// maxCap is the highest capability number a Set can hold.
+const maxCap = 63
+
+// Default is the set granted to the jobs: enough for regular workloads (setuid/setgid, binding low ports, etc.)
+// Narrower than the container runtimes defaults, as the jobs share the host root filesystem without a device cgroup:
+// the file related ones (i.e. CAP_DAC_OVERRIDE, CAP_FOWNER, CAP_CHOWN), CAP_MKNOD and CAP_SYS_CHROOT would allow
+// to alter any host file or device. They can still be added when allowed by the server.
+//
+//nolint:gochecknoglobals // Expected global.
+var Default = MustParse(
+	"CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_SETFCAP",
+	"CAP_NET_BIND_SERVICE", "CAP_NET_RAW", "CAP_AUDIT_WRITE",
+)
+
+// Parse the given capability names. The "CAP_" prefix is optional and the names are
+// case insensitive. "ALL" stands for all the capabilities.
+func Parse(capNames ...string) (Set, error) {
+	var set Set
+	for _, name := range capNames {
+		name = strings.ToUpper(name)
+		if name == "ALL" {
+			set |= All
+			continue
+		}
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		i := indexOf(name)
+		if i < 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidCapability, name)
+		}
+		set |= 1 << i
+	}
+	return set, nil
+}
+
+// MustParse is like Parse but panics on error. Only meant for constants.
+func MustParse(capNames ...string) Set {
+	set, err := Parse(capNames...)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// Resolve the set of a job from the default one and the given additions/removals.
+// Removals take precedence, i.e. add "ALL" and drop "SYS_ADMIN".
+func Resolve(add, drop []string) (Set, error) {
+	added, err := Parse(add...)
+	if err != nil {
+		return 0, fmt.Errorf("cap add: %w", err)
+	}
+	dropped, err := Parse(drop...)
+	if err != nil {
+		return 0, fmt.Errorf("cap drop: %w", err)
+	}
+	return (Default | added) &^ dropped, nil
+}
+
+// Has returns whether the given capability number is in the set.
+func (s Set) Has(capability int) bool {
+	return s&(1<<capability) != 0
+}
+
+// String returns the comma separated list of the capability names.
+func (s Set) String() string {
+	out := make([]string, 0, bits.OnesCount64(uint64(s)))
+	for i, name := range names {
+		if s.Has(i) {
+			out = append(out, name)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func indexOf(name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// name returns the name of the given capability number, or its number when unknown to this package.
+func name(i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return "CAP_" + strconv.Itoa(i)
+}
+
+const (
+	linuxCapabilityVersion3 = 0x20080522 // _LINUX_CAPABILITY_VERSION_3.
+
+	prCapAmbient         = 47 // PR_CAP_AMBIENT.
+	prCapAmbientRaise    = 2  // PR_CAP_AMBIENT_RAISE.
+	prCapAmbientClearAll = 4  // PR_CAP_AMBIENT_CLEAR_ALL.
+	prSetNoNewPrivs      = 38 // PR_SET_NO_NEW_PRIVS.
+)
+
+// capHeader and capData are the kernel's struct __user_cap_header_struct and __user_cap_data_struct.
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// Apply the given set as bounding, effective, permitted, inheritable and ambient set
+// of the calling thread, so the process it executes retains exactly these capabilities,
+// whether it runs as root or not.
+//
+// NOTE: Expected to be called on a locked OS thread, right before exec.
+func Apply(set Set) error {
+	last := lastCap()
+	// Ignore the capabilities unknown to the kernel, they can't be granted.
+	set &= Set(1)<<(last+1) - 1
+
+	// Drop the bounding set first, requires CAP_SETPCAP which we may be about to lose.
+	for i := 0; i <= last; i++ {
+		if set.Has(i) {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(i), 0); errno != 0 {
+			return fmt.Errorf("drop %s from bounding set: %w", name(i), errno)
+		}
+	}
+
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	data := [2]capData{
+		{effective: uint32(set), permitted: uint32(set), inheritable: uint32(set)},
+		{effective: uint32(set >> 32), permitted: uint32(set >> 32), inheritable: uint32(set >> 32)},
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+
+	// Ambient capabilities, preserved across exec of non-root binaries.
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		if errno == syscall.EINVAL {
+			// Ambient capabilities not supported by the kernel (< 4.3).
+			return nil
+		}
+		return fmt.Errorf("clear ambient set: %w", errno)
+	}
+	for i := 0; i <= last; i++ {
+		if !set.Has(i) {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(i), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("raise %s in ambient set: %w", name(i), errno)
+		}
+	}
+	return nil
+}
+
+// SetNoNewPrivileges sets the no_new_privs bit of the calling thread, preventing
+// the executed process to gain privileges via setuid/setgid binaries or file capabilities.
+func SetNoNewPrivileges() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no new privs: %w", errno)
+	}
+	return nil
+}
+
+// lastCap returns the highest capability number supported by the kernel, i.e. its cap_last_cap,
+// possibly past the ones known to this package, so they get dropped from the bounding set as well.
+// Probes the bounding set rather than reading /proc, which may not be accessible.
+func lastCap() int {
+	for i := range maxCap + 1 {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_READ, uintptr(i), 0); errno != 0 {
+			return i - 1
+		}
+	}
+	return maxCap
+}
@@ -5,23 +5,25 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"syscall"
 )
 
+// Toggle values of the default limits.
 const (
 	CPUMax    = "50000 100000"              // 50% (quota per perdiod in usec).
 	MemoryMax = "52428800"                  // 50 MB (in bytes).
 	IOMax     = "rbps=1048576 wbps=1048576" // 1 MB/s (in bytes) read/write.
 )
 
-// Create the cgroup (v2) if needed and apply the preset limits.
+// Create the cgroup (v2) if needed and apply the given limits, the preset ones for IO.
 // Open the cgroup itself and return it, the caller is expected to close.
 // Needs to be used with clone3.
 //
 // NOTE: Naive/basic approach for the sake of the exercise.
 // Would want something more flexible for production with maybe one type per cgroup type
 // with their own settable limits and serialization logic.
-func New(name string) (f *os.File, err error) { //nolint:nonamedreturns // Using named return to cleanup in defer.
+func New(name string, limits Limits) (f *os.File, err error) { //nolint:nonamedreturns // Using named return to cleanup in defer.
 	cgroupPath := filepath.Join(CgroupBasePath, name)
 
 	// Create cgroup directory.
@@ -36,7 +38,7 @@ func New(name string) (f *os.File, err error) { //nolint:nonamedreturns // Using
 		_ = os.Remove(cgroupPath) // Best effort.
 	}()
 
-	if err := setCgroupToggles(cgroupPath); err != nil {
+	if err := setCgroupToggles(cgroupPath, limits.WithDefaults()); err != nil {
 		return nil, fmt.Errorf("setCgroupToggles: %w", err)
 	}
 
@@ -50,14 +52,14 @@ func New(name string) (f *os.File, err error) { //nolint:nonamedreturns // Using
 	return cgroupDir, nil
 }
 
-func setCgroupToggles(cgroupPath string) error {
+func setCgroupToggles(cgroupPath string, limits Limits) error {
 	// Set CPU limit.
-	if err := os.WriteFile(filepath.Join(cgroupPath, "cpu.max"), []byte(CPUMax), filePerm); err != nil {
+	if err := os.WriteFile(filepath.Join(cgroupPath, "cpu.max"), []byte(limits.cpuMax()), filePerm); err != nil {
 		return fmt.Errorf("set cpu.max toggle: %w", err)
 	}
 
 	// Set Memory limit.
-	if err := os.WriteFile(filepath.Join(cgroupPath, "memory.max"), []byte(MemoryMax), filePerm); err != nil {
+	if err := os.WriteFile(filepath.Join(cgroupPath, "memory.max"), []byte(strconv.FormatUint(limits.MemoryBytes, 10)), filePerm); err != nil {
 		return fmt.Errorf("set memory.max toggle: %w", err)
 	}
 
@@ -0,0 +1,85 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidLimits is returned when the requested CPU or memory is invalid.
+var ErrInvalidLimits = errors.New("invalid cgroup limits")
+
+// Defaults, matching the CPUMax and MemoryMax presets.
+const (
+	DefaultCPUMillis   = 500
+	DefaultMemoryBytes = 50 << 20
+)
+
+const (
+	cpuPeriod      = 100000 // Period of cpu.max, in usec.
+	minCPUMillis   = 10     // The kernel requires a quota of at least 1ms per period.
+	minMemoryBytes = 1 << 20
+)
+
+// Limits are the CPU and memory of a cgroup. Zero values use the defaults.
+type Limits struct {
+	CPUMillis   uint64 `json:"cpu_millis,omitempty"`   // Thousandths of a CPU, i.e. 1500 for 1.5 CPU.
+	MemoryBytes uint64 `json:"memory_bytes,omitempty"` // Max memory, in bytes.
+}
+
+// WithDefaults returns the limits with the zero values set to the defaults.
+func (l Limits) WithDefaults() Limits {
+	if l.CPUMillis == 0 {
+		l.CPUMillis = DefaultCPUMillis
+	}
+	if l.MemoryBytes == 0 {
+		l.MemoryBytes = DefaultMemoryBytes
+	}
+	return l
+}
+
+// Validate the limits. Zero values are valid as they use the defaults.
+func (l Limits) Validate() error {
+	if l.CPUMillis != 0 && l.CPUMillis < minCPUMillis {
+		return fmt.Errorf("%w: cpu %d millis, expect at least %d", ErrInvalidLimits, l.CPUMillis, minCPUMillis)
+	}
+	if l.CPUMillis > math.MaxUint64/cpuPeriod {
+		return fmt.Errorf("%w: cpu %d millis out of range", ErrInvalidLimits, l.CPUMillis)
+	}
+	if l.MemoryBytes != 0 && l.MemoryBytes < minMemoryBytes {
+		return fmt.Errorf("%w: memory %d bytes, expect at least %d", ErrInvalidLimits, l.MemoryBytes, minMemoryBytes)
+	}
+	return nil
+}
+
+// cpuMax returns the value for the cpu.max toggle.
+func (l Limits) cpuMax() string {
+	return fmt.Sprintf("%d %d", l.CPUMillis*cpuPeriod/1000, cpuPeriod) //nolint:mnd // Millis.
+}
+
+// ParseCPU parses a number of CPUs, i.e. "1.5", into thousandths of a CPU.
+func ParseCPU(s string) (uint64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: cpu %q, expect a positive number of CPUs, i.e. '1.5'", ErrInvalidLimits, s)
+	}
+	return uint64(math.Round(f * 1000)), nil //nolint:mnd // Millis.
+}
+
+// ParseMemory parses a size in bytes with an optional K, M or G suffix (powers of 1024), i.e. "256M".
+func ParseMemory(s string) (uint64, error) {
+	shifts := map[string]int{"K": 10, "M": 20, "G": 30} //nolint:mnd // Powers of 1024.
+	num, shift := s, 0
+	for suffix, n := range shifts {
+		if prefix, ok := strings.CutSuffix(strings.ToUpper(s), suffix); ok {
+			num, shift = prefix, n
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("%w: memory %q, expect bytes with an optional K, M or G suffix, i.e. '256M'", ErrInvalidLimits, s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,33 @@
+package cgroups
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CPUUsage returns the CPU time consumed by the processes of the given cgroup,
+// from the `usage_usec` entry of `cpu.stat`.
+func CPUUsage(cgroupPath string) (time.Duration, error) {
+	buf, err := os.ReadFile(filepath.Join(cgroupPath, "cpu.stat"))
+	if err != nil {
+		return 0, fmt.Errorf("read cpu.stat: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		key, value, _ := bytes.Cut(scanner.Bytes(), []byte(" "))
+		if string(key) != "usage_usec" {
+			continue
+		}
+		usec, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse cpu.stat usage_usec %q: %w", value, err)
+		}
+		return time.Duration(usec) * time.Microsecond, nil
+	}
+	return 0, fmt.Errorf("usage_usec not found in cpu.stat") //nolint:err113 // No need for fancy error here.
+}
@@ -0,0 +1,111 @@
+// Package identity derives the identity of the users from their client certificate.
+package identity
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Common errors.
+var (
+	ErrInvalidIdentity = errors.New("invalid identity")
+)
+
+// spiffeScheme is the URI scheme of the SPIFFE IDs.
+const spiffeScheme = "spiffe"
+
+// Identity of a user.
+type Identity struct {
+	User string
+	// Sorted and deduplicated.
+	Groups []string
+	// SPIFFE ID from the certificate's URI SAN, if any.
+	SPIFFEID string
+	// Client certificate the identity is derived from. Nil when not derived from a certificate.
+	Certificate *x509.Certificate
+}
+
+// FromCertificate derives the identity from the given client certificate:
+//   - the user is the Subject CN, or the 'user' segment of the SPIFFE ID when the CN is empty;
+//   - the groups are the Subject Organizations and Organizational Units, and the 'team' or 'group' segments of the SPIFFE ID.
+//
+// The SPIFFE ID path is read as key/value pairs, i.e. spiffe://corp/team/ml/user/alice is the user alice in the group ml.
+// The other URI SANs are ignored.
+func FromCertificate(cert *x509.Certificate) (Identity, error) {
+	id := Identity{User: cert.Subject.CommonName, Certificate: cert}
+	groups := append(slices.Clone(cert.Subject.Organization), cert.Subject.OrganizationalUnit...)
+
+	for _, u := range cert.URIs {
+		if u.Scheme != spiffeScheme {
+			continue
+		}
+		if id.SPIFFEID != "" {
+			return Identity{}, fmt.Errorf("%w: multiple SPIFFE IDs", ErrInvalidIdentity)
+		}
+		id.SPIFFEID = u.String()
+		user, spiffeGroups, err := parseSPIFFEPath(u)
+		if err != nil {
+			return Identity{}, err
+		}
+		groups = append(groups, spiffeGroups...)
+		switch {
+		case user == "":
+		case id.User == "":
+			id.User = user
+		case id.User != user:
+			return Identity{}, fmt.Errorf("%w: CN %q doesn't match the SPIFFE ID user %q", ErrInvalidIdentity, id.User, user)
+		}
+	}
+	if id.User == "" {
+		return Identity{}, fmt.Errorf("%w: no CN nor SPIFFE ID user", ErrInvalidIdentity)
+	}
+
+	groups = slices.DeleteFunc(groups, func(g string) bool { return g == "" })
+	slices.Sort(groups)
+	id.Groups = slices.Compact(groups)
+	return id, nil
+}
+
+// parseSPIFFEPath reads the path of the given SPIFFE ID as key/value pairs.
+// Returns the 'user' value and the 'team'/'group' values. The other keys are ignored.
+func parseSPIFFEPath(u *url.URL) (string, []string, error) {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments)%2 != 0 {
+		// Not key/value pairs, only the trust domain is meaningful.
+		return "", nil, nil
+	}
+	var user string
+	var groups []string
+	for i := 0; i < len(segments); i += 2 {
+		key, value := segments[i], segments[i+1]
+		switch key {
+		case "user":
+			if user != "" {
+				return "", nil, fmt.Errorf("%w: multiple users in SPIFFE ID %q", ErrInvalidIdentity, u)
+			}
+			user = value
+		case "team", "group":
+			groups = append(groups, value)
+		}
+	}
+	return user, groups, nil
+}
+
+// contextKey is the key of the identity in the context.
+type contextKey struct{}
+
+// NewContext returns a copy of the given context carrying the identity.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity carried by the context, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
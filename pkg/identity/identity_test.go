@@ -0,0 +1,75 @@
+package identity_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"go.creack.net/telepilot/pkg/identity"
+)
+
+func TestFromCertificate(t *testing.T) {
+	t.Parallel()
+
+	// cert is a helper to create a certificate with the given subject and URI SANs.
+	cert := func(subject pkix.Name, uris ...string) *x509.Certificate {
+		c := &x509.Certificate{Subject: subject}
+		for _, elem := range uris {
+			u, err := url.Parse(elem)
+			if err != nil {
+				t.Fatalf("Parse %q: %s.", elem, err)
+			}
+			c.URIs = append(c.URIs, u)
+		}
+		return c
+	}
+
+	for name, tc := range map[string]struct {
+		cert   *x509.Certificate
+		user   string
+		groups []string
+	}{
+		"cn only":       {cert(pkix.Name{CommonName: "alice"}), "alice", nil},
+		"org and ou":    {cert(pkix.Name{CommonName: "alice", Organization: []string{"corp"}, OrganizationalUnit: []string{"sre", "ml", "sre"}}), "alice", []string{"corp", "ml", "sre"}},
+		"spiffe":        {cert(pkix.Name{}, "spiffe://corp/team/ml/user/alice"), "alice", []string{"ml"}},
+		"spiffe and cn": {cert(pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"sre"}}, "spiffe://corp/team/ml/user/alice"), "alice", []string{"ml", "sre"}},
+		"spiffe no kv":  {cert(pkix.Name{CommonName: "alice"}, "spiffe://corp/workload"), "alice", nil},
+		"other uri":     {cert(pkix.Name{CommonName: "alice"}, "https://example.com/team/ml"), "alice", nil},
+	} {
+		id, err := identity.FromCertificate(tc.cert)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s.", name, err)
+		}
+		if id.User != tc.user || !slices.Equal(id.Groups, tc.groups) {
+			t.Errorf("%s: expected %q %v, got %q %v.", name, tc.user, tc.groups, id.User, id.Groups)
+		}
+	}
+
+	for name, c := range map[string]*x509.Certificate{
+		"empty":          cert(pkix.Name{}),
+		"user mismatch":  cert(pkix.Name{CommonName: "bob"}, "spiffe://corp/user/alice"),
+		"multiple ids":   cert(pkix.Name{CommonName: "alice"}, "spiffe://corp/user/alice", "spiffe://corp/team/ml"),
+		"multiple users": cert(pkix.Name{}, "spiffe://corp/user/alice/user/bob"),
+	} {
+		if _, err := identity.FromCertificate(c); !errors.Is(err, identity.ErrInvalidIdentity) {
+			t.Errorf("%s: expected invalid identity error, got: %v.", name, err)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := identity.FromContext(context.Background()); ok {
+		t.Fatal("Unexpected identity in empty context.")
+	}
+	id := identity.Identity{User: "alice", Groups: []string{"ml"}}
+	got, ok := identity.FromContext(identity.NewContext(context.Background(), id))
+	if !ok || got.User != id.User || !slices.Equal(got.Groups, id.Groups) {
+		t.Fatalf("Expected %v from context, got %v (%t).", id, got, ok)
+	}
+}
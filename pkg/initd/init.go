@@ -1,34 +1,83 @@
 package initd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"runtime"
 	"syscall"
+
+	"go.creack.net/telepilot/pkg/capabilities"
+	"go.creack.net/telepilot/pkg/landlock"
+	"go.creack.net/telepilot/pkg/netlink"
+	"go.creack.net/telepilot/pkg/nsenter"
+	"go.creack.net/telepilot/pkg/rlimit"
+	"go.creack.net/telepilot/pkg/seccomp"
+)
+
+// As we don't support setting ExtraFile, our pipes are always at the same place.
+const (
+	pipeFD    = 3 // Control pipe, child to parent. Used to report errors.
+	configFD  = 4 // Config pipe, parent to child. Used to send the Config.
+	mountNSFD = 5 // Exec sessions only. Mount namespace of the job, joined by the helper.
 )
 
-const pipeFD = 3 // As we don't support setting ExtraFile, our control pipe will always be '3'.
+// Config is the job configuration sent by the parent over the config pipe.
+// The parent is expected to close the pipe once written, Init blocks until then.
+type Config struct {
+	Hostname string         `json:"hostname"`
+	Network  *NetworkConfig `json:"network,omitempty"` // Nil when the job has no network.
+	Seccomp  string         `json:"seccomp"`           // Seccomp profile name.
+
+	Capabilities    capabilities.Set `json:"capabilities"`      // Bounding/effective/permitted/inheritable/ambient set.
+	NoNewPrivileges bool             `json:"no_new_privileges"` // Set the no_new_privs bit before exec.
+
+	Landlock *landlock.Ruleset `json:"landlock,omitempty"` // Nil when the filesystem access is not restricted.
+
+	Rlimits map[rlimit.Resource]rlimit.Limit `json:"rlimits,omitempty"` // Resource limits to set, others are inherited.
+
+	// Exec sessions only. Host ids to switch to once the job's mount namespace is joined. Nil to stay root.
+	Credential *Credential `json:"credential,omitempty"`
+}
+
+// Credential is the ids an exec session runs as, i.e. the host ids mapped to root within the job's user namespace.
+type Credential struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// NetworkConfig describes the interface moved by the parent in the job's network namespace.
+type NetworkConfig struct {
+	Interface string `json:"interface"`
+	Address   string `json:"address"` // CIDR notation.
+	Gateway   string `json:"gateway"`
+}
 
 // Init handles the operations within the namespace for the child process
 // before executing the target.
 // args is expected to be the target process os.Args.
 // args[0] being the command, it will be resolved using the PATH env variable.
 func Init(args []string) (err error) { //nolint:nonamedreturns // Used for defer error handler.
-	defer func() {
-		if err == nil {
-			return
-		}
-		if _, e1 := fmt.Fprint(os.NewFile(pipeFD, ""), err.Error()); e1 != nil {
-			// Best effort.
-			slog.Error("Failed to send error to parent.", "error", e1)
-		}
-	}()
-	if len(args) == 0 {
-		return errors.New("missing command") //nolint:err113 // No need for fancy error here.
+	defer func() { reportError(err) }()
+
+	cfg, profile, err := readConfig(args)
+	if err != nil {
+		return err
 	}
 
+	// NOTE: When running in a user namespace, ordering matters:
+	//   - the process is placed in its cgroup by clone3 (CgroupFD) using the parent's
+	//     credentials, before the user namespace gets created, so the unprivileged
+	//     job root doesn't need any access to the cgroup tree;
+	//   - the uid/gid maps are written by the parent before the child resumes,
+	//     so by the time we get here, we are root within the user namespace which
+	//     owns the new mount/pid namespaces and are allowed to (re)mount below.
+	//     Without the maps, we would be 'nobody' and the mounts would fail with EPERM.
+
 	// Make the new mount namespace private.The avoids propagation to the host.
 	if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
 		return fmt.Errorf("mount root as private: %w", err)
@@ -49,11 +98,171 @@ func Init(args []string) (err error) { //nolint:nonamedreturns // Used for defer
 		return fmt.Errorf("remount /proc: %w", err)
 	}
 
+	// Set the hostname within the new UTS namespace.
+	if cfg.Hostname != "" {
+		if err := syscall.Sethostname([]byte(cfg.Hostname)); err != nil {
+			return fmt.Errorf("set hostname: %w", err)
+		}
+	}
+
+	if cfg.Network != nil {
+		if err := setupNetwork(*cfg.Network); err != nil {
+			return fmt.Errorf("setup network: %w", err)
+		}
+	}
+
+	return confine(cfg, profile, args)
+}
+
+// Exec handles the operations for a process joining a running job, i.e. an exec session,
+// before executing the target. The parent already placed it in the job's namespaces and cgroup,
+// except the mount namespace, joined here as it can't be from the parent. Then the job's confinement
+// gets applied, as for Init. The hostname and network of the config are ignored.
+// args is expected to be the target process os.Args.
+func Exec(args []string) (err error) { //nolint:nonamedreturns // Used for defer error handler.
+	defer func() { reportError(err) }()
+
+	cfg, profile, err := readConfig(args)
+	if err != nil {
+		return err
+	}
+
+	// Join the job's mount namespace while still root on the host, from the locked thread which executes the target.
+	mountNS := os.NewFile(mountNSFD, "")
+	if err := nsenter.JoinMount(mountNS); err != nil {
+		return fmt.Errorf("join mount namespace: %w", err)
+	}
+	_ = mountNS.Close() // Best effort.
+
+	// When the job runs in a user namespace, which can't be joined, the process runs as the host ids
+	// mapped to the job's root, i.e. unprivileged: it holds no capability, so there is none to apply,
+	// and no_new_privs is required to install the filter and the ruleset.
+	if cfg.Credential != nil {
+		// NOTE: Applied to all the threads by the Go runtime, the capabilities being cleared.
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(int(cfg.Credential.GID)); err != nil {
+			return fmt.Errorf("setgid: %w", err)
+		}
+		if err := syscall.Setuid(int(cfg.Credential.UID)); err != nil {
+			return fmt.Errorf("setuid: %w", err)
+		}
+	}
+	if os.Geteuid() != 0 {
+		if err := capabilities.SetNoNewPrivileges(); err != nil {
+			return err //nolint:wrapcheck // Already wrapped.
+		}
+		cfg.NoNewPrivileges = true
+	}
+
+	return confine(cfg, profile, args)
+}
+
+// reportError sends the given error, if any, to the parent over the control pipe.
+func reportError(err error) {
+	if err == nil {
+		return
+	}
+	if _, e1 := fmt.Fprint(os.NewFile(pipeFD, ""), err.Error()); e1 != nil {
+		// Best effort.
+		slog.Error("Failed to send error to parent.", "error", e1)
+	}
+}
+
+// readConfig locks the calling thread and reads the config sent by the parent.
+func readConfig(args []string) (Config, seccomp.Profile, error) {
+	if len(args) == 0 {
+		return Config{}, "", errors.New("missing command") //nolint:err113 // No need for fancy error here.
+	}
+
+	// The seccomp filter, landlock ruleset, capabilities and no_new_privs apply to the calling thread, make sure we exec from the same one.
+	// NOTE: Never unlocked, the process gets replaced by exec.
+	runtime.LockOSThread()
+
+	var cfg Config
+	configFile := os.NewFile(configFD, "")
+	if err := json.NewDecoder(configFile).Decode(&cfg); err != nil {
+		return Config{}, "", fmt.Errorf("decode config: %w", err)
+	}
+	_ = configFile.Close() // Best effort.
+	profile, err := seccomp.ParseProfile(cfg.Seccomp)
+	if err != nil {
+		return Config{}, "", err //nolint:wrapcheck // Already wrapped.
+	}
+	return cfg, profile, nil
+}
+
+// confine applies the rlimits, seccomp filter, landlock ruleset, capabilities and no_new_privs
+// of the given config to the calling thread, then executes the target.
+// args[0] being the command, it will be resolved using the PATH env variable.
+func confine(cfg Config, profile seccomp.Profile, args []string) error {
 	cmd, err := exec.LookPath(args[0])
 	if err != nil {
 		return fmt.Errorf("lookup path for %q: %w", args[0], err)
 	}
 
+	// Set the limits while we still have CAP_SYS_RESOURCE, needed to raise the hard limits.
+	// NOTE: Once set explicitly, the Go runtime doesn't restore the original nofile soft limit on exec.
+	if err := rlimit.Apply(cfg.Rlimits); err != nil {
+		return fmt.Errorf("apply rlimits: %w", err)
+	}
+
 	syscall.CloseOnExec(pipeFD)
+
+	// Last steps before exec, as the filter, the ruleset and the capabilities may deny syscalls needed for the setup.
+	// NOTE: Ordering matters:
+	//   - the seccomp filter and landlock ruleset are applied first as they require CAP_SYS_ADMIN when no_new_privs is not set;
+	//   - the capabilities are applied before no_new_privs, it would otherwise prevent raising the ambient set.
+	if err := seccomp.Install(profile); err != nil {
+		return fmt.Errorf("install seccomp profile %q: %w", profile, err)
+	}
+	if cfg.Landlock != nil {
+		if _, err := cfg.Landlock.Restrict(); err != nil {
+			return fmt.Errorf("restrict landlock: %w", err)
+		}
+	}
+	if os.Geteuid() == 0 { // Unprivileged exec sessions have no capability to apply, see Exec.
+		if err := capabilities.Apply(cfg.Capabilities); err != nil {
+			return fmt.Errorf("apply capabilities: %w", err)
+		}
+	}
+	if cfg.NoNewPrivileges {
+		if err := capabilities.SetNoNewPrivileges(); err != nil {
+			return err //nolint:wrapcheck // Already wrapped.
+		}
+	}
 	return fmt.Errorf("exec: %w", syscall.Exec(cmd, args, os.Environ()))
 }
+
+// setupNetwork configures the interface provided by the parent as well as the loopback.
+func setupNetwork(cfg NetworkConfig) error {
+	ip, ipNet, err := net.ParseCIDR(cfg.Address)
+	if err != nil {
+		return fmt.Errorf("parse address: %w", err)
+	}
+	gateway := net.ParseIP(cfg.Gateway)
+	if gateway == nil {
+		return fmt.Errorf("invalid gateway %q", cfg.Gateway) //nolint:err113 // No need for fancy error here.
+	}
+
+	conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("dial rtnetlink: %w", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	if err := conn.SetUp("lo"); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.AddAddress(cfg.Interface, &net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.SetUp(cfg.Interface); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.AddDefaultRoute(gateway); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/rbac"
+)
+
+// Common errors.
+var (
+	ErrInvalidSignal = errors.New("invalid signal")
+)
+
+// signals are the signals which can be sent to a job, by name.
+//
+//nolint:gochecknoglobals // Expected global.
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"TSTP":  syscall.SIGTSTP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal parses the given signal, by name with or without the SIG prefix, i.e. "HUP" or "SIGHUP", or by number.
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n >= 32 { //nolint:mnd // Standard signals, the real-time ones are not expected.
+			return 0, fmt.Errorf("%w: %d out of range", ErrInvalidSignal, n)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown signal %q", ErrInvalidSignal, s)
+	}
+	return sig, nil
+}
+
+// ACL returns the access control list of the job.
+func (j *Job) ACL() rbac.ACL {
+	j.mu.RLock()
+	acl := slices.Clone(j.acl)
+	j.mu.RUnlock()
+	return acl
+}
+
+// SetJobACL replaces the access control list of the job.
+func (jm *JobManager) SetJobACL(id uuid.UUID, acl rbac.ACL) error {
+	if err := acl.Validate(); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	j, err := jm.LookupJob(id)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.acl = slices.Clone(acl)
+	j.mu.Unlock()
+	return nil
+}
+
+// SignalJob sends the given signal to all the processes of the job.
+//
+// NOTE: As the init process is the job's PID namespace init, it ignores the signals it doesn't handle,
+// the other processes get them as usual.
+func (jm *JobManager) SignalJob(id uuid.UUID, sig syscall.Signal) error {
+	j, err := jm.LookupJob(id)
+	if err != nil {
+		return err
+	}
+	// Hold the lock while signaling to make sure the job doesn't get closed under us.
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.status != pb.JobStatus_JOB_STATUS_RUNNING {
+		return ErrJobNotRunning
+	}
+	return j.signalGroup(sig)
+}
@@ -0,0 +1,214 @@
+package jobmanager
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/cgroups"
+)
+
+// QueueOrdering is the order in which the queued jobs are admitted.
+type QueueOrdering int
+
+// Available orderings.
+const (
+	// QueueFIFO admits the jobs in submission order (default).
+	QueueFIFO QueueOrdering = iota
+	// QueuePriority admits the jobs with the highest priority first, in submission order for the same priority.
+	QueuePriority
+)
+
+// ParseQueueOrdering parses 'fifo' or 'priority'.
+func ParseQueueOrdering(s string) (QueueOrdering, error) {
+	switch s {
+	case "fifo":
+		return QueueFIFO, nil
+	case "priority":
+		return QueuePriority, nil
+	default:
+		return 0, fmt.Errorf("invalid queue ordering %q, expect 'fifo' or 'priority'", s) //nolint:err113 // No need for fancy error here.
+	}
+}
+
+// AdmissionConfig limits the jobs running concurrently. The jobs exceeding the limits wait
+// in the admission queue with the QUEUED status. 0 for no limit.
+type AdmissionConfig struct {
+	MaxJobs         int // Max running jobs.
+	MaxJobsPerOwner int // Max running jobs per owner.
+
+	// Max sum of the CPU/memory requested by the running jobs.
+	CPUMillisBudget   uint64
+	MemoryBytesBudget uint64
+
+	Ordering QueueOrdering
+}
+
+// admission keeps track of the running jobs and queues the others.
+type admission struct {
+	mu  sync.Mutex
+	cfg AdmissionConfig
+
+	queue    []*Job // Admission order.
+	running  map[*Job]struct{}
+	perOwner map[string]int
+	usage    cgroups.Limits // Sum of the limits of the running jobs.
+}
+
+func newAdmission(cfg AdmissionConfig) *admission {
+	return &admission{
+		cfg:      cfg,
+		running:  map[*Job]struct{}{},
+		perOwner: map[string]int{},
+	}
+}
+
+// check whether a job with the given limits can ever be admitted.
+func (a *admission) check(limits cgroups.Limits) error {
+	if a.cfg.CPUMillisBudget != 0 && limits.CPUMillis > a.cfg.CPUMillisBudget {
+		return fmt.Errorf("%w: cpu %d millis, budget %d", ErrExceedsBudget, limits.CPUMillis, a.cfg.CPUMillisBudget)
+	}
+	if a.cfg.MemoryBytesBudget != 0 && limits.MemoryBytes > a.cfg.MemoryBytesBudget {
+		return fmt.Errorf("%w: memory %d bytes, budget %d", ErrExceedsBudget, limits.MemoryBytes, a.cfg.MemoryBytesBudget)
+	}
+	return nil
+}
+
+// submit queues the job, then admits the queued jobs within the limits.
+// Returns whether the given job got admitted, and the other admitted jobs, if any.
+func (a *admission) submit(j *Job) (bool, []*Job) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	idx := len(a.queue)
+	if a.cfg.Ordering == QueuePriority {
+		// Stable, after the jobs with the same priority.
+		idx, _ = slices.BinarySearchFunc(a.queue, j, func(elem, target *Job) int {
+			if elem.priority >= target.priority {
+				return -1
+			}
+			return 1
+		})
+	}
+	a.queue = slices.Insert(a.queue, idx, j)
+
+	admitted := a.dispatchLocked()
+	if i := slices.Index(admitted, j); i >= 0 {
+		return true, slices.Delete(admitted, i, i+1)
+	}
+	return false, admitted
+}
+
+// release the slot of the given job once done, then admits the queued jobs within the limits.
+// Returns the admitted jobs. No-op for jobs which were never admitted.
+func (a *admission) release(j *Job) []*Job {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.running[j]; !ok {
+		return nil
+	}
+	delete(a.running, j)
+	a.perOwner[j.Owner]--
+	if a.perOwner[j.Owner] == 0 {
+		delete(a.perOwner, j.Owner)
+	}
+	a.usage.CPUMillis -= j.Resources.CPUMillis
+	a.usage.MemoryBytes -= j.Resources.MemoryBytes
+	return a.dispatchLocked()
+}
+
+// cancel removes the job from the queue. Returns false if it is not queued, i.e. already admitted.
+func (a *admission) cancel(j *Job) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.Index(a.queue, j)
+	if i < 0 {
+		return false
+	}
+	a.queue = slices.Delete(a.queue, i, i+1)
+	return true
+}
+
+// position returns the 1-based position of the job in the queue, 0 if not queued.
+func (a *admission) position(j *Job) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Index(a.queue, j) + 1
+}
+
+// dispatchLocked admits the queued jobs in order, while within the limits.
+// A job exceeding the per owner limit is skipped, leaving its place in the queue.
+// A job exceeding the global limits stops the dispatch, so large jobs are not starved by smaller ones.
+//
+// NOTE: Expected to be called with the lock held.
+func (a *admission) dispatchLocked() []*Job {
+	var admitted []*Job
+	for i := 0; i < len(a.queue); {
+		j := a.queue[i]
+		if (a.cfg.MaxJobs != 0 && len(a.running) >= a.cfg.MaxJobs) ||
+			(a.cfg.CPUMillisBudget != 0 && a.usage.CPUMillis+j.Resources.CPUMillis > a.cfg.CPUMillisBudget) ||
+			(a.cfg.MemoryBytesBudget != 0 && a.usage.MemoryBytes+j.Resources.MemoryBytes > a.cfg.MemoryBytesBudget) {
+			break
+		}
+		if a.cfg.MaxJobsPerOwner != 0 && a.perOwner[j.Owner] >= a.cfg.MaxJobsPerOwner {
+			i++
+			continue
+		}
+		a.queue = slices.Delete(a.queue, i, i+1)
+		a.running[j] = struct{}{}
+		a.perOwner[j.Owner]++
+		a.usage.CPUMillis += j.Resources.CPUMillis
+		a.usage.MemoryBytes += j.Resources.MemoryBytes
+		admitted = append(admitted, j)
+	}
+	return admitted
+}
+
+// QueuePosition returns the 1-based position of the job in the admission queue, 0 if not queued.
+func (jm *JobManager) QueuePosition(j *Job) int {
+	return jm.admission.position(j)
+}
+
+// launch starts the processes of the given jobs, admitted after being queued.
+func (jm *JobManager) launch(jobs []*Job) {
+	for _, j := range jobs {
+		go jm.launchJob(j)
+	}
+}
+
+// launchJob starts the process of the given job, admitted after being queued.
+// The job ends right away, with the start error recorded, if the process can't be created.
+func (jm *JobManager) launchJob(j *Job) {
+	logger := slog.With("job_id", j.ID.String())
+
+	j.mu.Lock()
+	if j.stopRequested() {
+		// Stopped as it was being admitted.
+		j.mu.Unlock()
+		j.close()
+		return
+	}
+	r, configW, err := j.spawn()
+	if err != nil {
+		j.startErr = err
+		j.mu.Unlock()
+		logger.Error("Failed to start the admitted job.", "error", err)
+		j.close()
+		return
+	}
+	pid := j.cmd.Process.Pid
+	j.mu.Unlock()
+	logger.Info("Job admitted and started.")
+
+	if err := j.initialize(pid, r, configW); err != nil {
+		logger.Error("Failed to initialize the admitted job.", "error", err)
+		j.mu.Lock()
+		j.startErr = err
+		// The job failed, make sure it doesn't restart.
+		j.requestStop(pb.JobStatus_JOB_STATUS_UNKNOWN_UNSPECIFIED)
+		j.mu.Unlock()
+	}
+	go j.wait()
+	go j.watchDeadlines(j.maxDuration, j.maxCPUTime, jm.stopGracePeriod)
+}
@@ -0,0 +1,68 @@
+package jobmanager
+
+import (
+	"log/slog"
+	"time"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/cgroups"
+)
+
+const (
+	// defaultStopGracePeriod is the default delay between SIGTERM and SIGKILL when terminating a job.
+	defaultStopGracePeriod = 10 * time.Second
+
+	// cpuTimePollInterval is the interval between two checks of the job's CPU usage.
+	cpuTimePollInterval = time.Second
+)
+
+// watchDeadlines terminates the job once it ran for longer than maxDuration, using a timer,
+// or once its processes consumed more than maxCPUTime, polling the cgroup's cpu.stat.
+// 0 for no limit. Returns when the job ends.
+// The max duration spans the restarts while the max CPU time applies to each attempt.
+func (j *Job) watchDeadlines(maxDuration, maxCPUTime, grace time.Duration) {
+	if maxDuration <= 0 && maxCPUTime <= 0 {
+		return
+	}
+	logger := slog.With("job_id", j.ID.String())
+
+	var deadline, poll <-chan time.Time
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	if maxCPUTime > 0 {
+		ticker := time.NewTicker(cpuTimePollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-j.waitChan:
+			return
+		case <-deadline:
+			logger.Info("Job reached its max duration, terminating.", "max_duration", maxDuration)
+			j.terminate(pb.JobStatus_JOB_STATUS_TIMED_OUT, grace)
+			return
+		case <-poll:
+			if j.Status() != pb.JobStatus_JOB_STATUS_RUNNING {
+				// No cgroup while restarting.
+				continue
+			}
+			// NOTE: cgroupPath is immutable once started. The usage is per attempt as the cgroup is re-created on restart.
+			usage, err := cgroups.CPUUsage(j.cgroupPath)
+			if err != nil {
+				// Best effort, try again on next tick.
+				logger.Warn("Failed to lookup job CPU usage.", "error", err)
+				continue
+			}
+			if usage >= maxCPUTime {
+				logger.Info("Job reached its max CPU time, terminating.", "max_cpu_time", maxCPUTime, "cpu_usage", usage)
+				j.terminate(pb.JobStatus_JOB_STATUS_TIMED_OUT, grace)
+				return
+			}
+		}
+	}
+}
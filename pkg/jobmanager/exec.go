@@ -0,0 +1,265 @@
+package jobmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/google/uuid"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/initd"
+	"go.creack.net/telepilot/pkg/nsenter"
+	"go.creack.net/telepilot/pkg/terminal"
+)
+
+// Common errors.
+var (
+	ErrMissingCommand = errors.New("missing command")
+)
+
+// ExecSpec describes the process to run within a job.
+type ExecSpec struct {
+	Command string
+	Args    []string
+
+	// When set, the process runs in a pseudo-terminal of the given size,
+	// stdout getting the terminal output and stderr being unused.
+	TTY *terminal.Size
+
+	Stdin          io.Reader // Optional.
+	Stdout, Stderr io.Writer
+}
+
+// ExecSession is a process running within the namespaces and cgroup of a job.
+type ExecSession struct {
+	ID uuid.UUID
+
+	cmd *exec.Cmd
+	pty *os.File // Nil when not running in a pseudo-terminal.
+
+	outputDone chan struct{} // Closed once the pseudo-terminal output is flushed. Nil without pseudo-terminal.
+
+	exitCode int
+	waitChan chan struct{} // Closed when the process ended and the output is flushed.
+}
+
+// Resize the pseudo-terminal. No-op when not running in a pseudo-terminal.
+func (s *ExecSession) Resize(size terminal.Size) error {
+	if s.pty == nil {
+		return nil
+	}
+	return terminal.SetSize(s.pty, size) //nolint:wrapcheck // Already wrapped.
+}
+
+// Kill the process.
+func (s *ExecSession) Kill() {
+	if err := s.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		// Best effort.
+		slog.Warn("Failed to kill exec session.", "session_id", s.ID.String(), "error", err)
+	}
+}
+
+// Wait for the process to end and returns its exit code.
+func (s *ExecSession) Wait() int {
+	<-s.waitChan
+	return s.exitCode
+}
+
+// ExecInJob starts a new process joining the namespaces and the cgroup of the given running job.
+// The process is started via the exec helper (initd.Exec), joining the job's mount namespace and
+// applying the job's confinement. The session is tracked by the job until the process ends.
+//
+// NOTE: The user namespace can't be joined from a multi-threaded process, so when the job runs
+// in a user namespace, the process runs as the host ids mapped to root within the job.
+func (jm *JobManager) ExecInJob(id uuid.UUID, spec ExecSpec) (*ExecSession, error) {
+	if spec.Command == "" {
+		return nil, ErrMissingCommand
+	}
+	j, err := jm.LookupJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The command is resolved by the helper, within the job's mount namespace.
+	cmd := exec.Command("/proc/self/exe", append([]string{"-exec", spec.Command}, spec.Args...)...)
+
+	s := &ExecSession{
+		ID:       uuid.New(),
+		cmd:      cmd,
+		waitChan: make(chan struct{}),
+	}
+
+	// Hold the lock while starting to make sure the job doesn't get closed under us.
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != pb.JobStatus_JOB_STATUS_RUNNING {
+		return nil, ErrJobNotRunning
+	}
+	// NOTE: As the job is still running, its pid can't have been reused.
+	if err := s.start(j, spec); err != nil {
+		return nil, err
+	}
+	j.execSessions[s.ID] = s
+
+	go func() {
+		s.wait()
+		j.mu.Lock()
+		delete(j.execSessions, s.ID)
+		j.mu.Unlock()
+	}()
+
+	return s, nil
+}
+
+// NOTE: Expected to be called with the job lock held.
+func (s *ExecSession) start(j *Job, spec ExecSpec) error {
+	pid := j.cmd.Process.Pid
+
+	cgroupDir, err := os.Open(j.cgroupPath)
+	if err != nil {
+		return fmt.Errorf("open job cgroup: %w", err)
+	}
+	defer func() { _ = cgroupDir.Close() }() // Best effort. Needs to be kept open until after the process started.
+
+	// Joined by the helper, which needs to be single threaded, or at least its thread executing the target.
+	mountNS, err := os.Open("/proc/" + strconv.Itoa(pid) + "/ns/mnt")
+	if err != nil {
+		return fmt.Errorf("open job mount namespace: %w", err)
+	}
+	defer func() { _ = mountNS.Close() }() // Best effort. Needs to be kept open until after the process started.
+
+	cfg := j.initConfig
+	if j.idRange != nil {
+		// Run as the host ids mapped to root within the job, switched to by the helper once the mount namespace is joined.
+		cfg.Credential = &initd.Credential{UID: j.idRange.UID, GID: j.idRange.GID}
+	}
+
+	s.cmd.Dir = "/"
+	s.cmd.SysProcAttr = &syscall.SysProcAttr{
+		// Place the process in the job's cgroup upon creation.
+		UseCgroupFD: true,
+		CgroupFD:    int(cgroupDir.Fd()),
+	}
+
+	var stdin io.WriteCloser
+	var ptySlave *os.File
+	if spec.TTY != nil {
+		master, slave, err := terminal.OpenPTY()
+		if err != nil {
+			return fmt.Errorf("open pty: %w", err)
+		}
+		if err := terminal.SetSize(master, *spec.TTY); err != nil {
+			_, _ = master.Close(), slave.Close() // Best effort.
+			return err                           //nolint:wrapcheck // Already wrapped.
+		}
+		s.pty, ptySlave, stdin = master, slave, master
+		s.cmd.Stdin, s.cmd.Stdout, s.cmd.Stderr = slave, slave, slave
+		s.cmd.SysProcAttr.Setsid = true
+		s.cmd.SysProcAttr.Setctty = true
+		s.cmd.SysProcAttr.Ctty = 0 // Stdin.
+	} else {
+		s.cmd.Stdout, s.cmd.Stderr = spec.Stdout, spec.Stderr
+		if spec.Stdin != nil {
+			// NOTE: Use a pipe rather than cmd.Stdin so Wait doesn't block on the reader.
+			w, err := s.cmd.StdinPipe()
+			if err != nil {
+				return fmt.Errorf("stdin pipe: %w", err)
+			}
+			stdin = w
+		}
+	}
+
+	// Control and config pipes of the helper, as for the job's init process.
+	r, w, err := os.Pipe()
+	if err != nil {
+		s.closePTY(ptySlave)
+		return fmt.Errorf("os.Pipe: %w", err)
+	}
+	configR, configW, err := os.Pipe()
+	if err != nil {
+		s.closePTY(ptySlave)
+		_, _ = r.Close(), w.Close() // Best effort.
+		return fmt.Errorf("os.Pipe: %w", err)
+	}
+	s.cmd.ExtraFiles = []*os.File{w, configR, mountNS}
+
+	// Start from a thread which joined the job's namespaces so the process inherits them.
+	// The PID namespace only applies to the children, i.e. the new process.
+	err = nsenter.Do(pid, []nsenter.Namespace{
+		nsenter.PID, nsenter.Net, nsenter.UTS, nsenter.IPC, nsenter.Cgroup,
+	}, s.cmd.Start)
+	_, _ = w.Close(), configR.Close() // Best effort. Only the child needs them.
+	if err != nil {
+		s.closePTY(ptySlave)
+		_, _ = r.Close(), configW.Close() // Best effort.
+		return fmt.Errorf("start exec process: %w", err)
+	}
+	if s.pty != nil {
+		_ = ptySlave.Close() // Best effort. Only the child needs it, the master gets EIO once the child is done.
+	}
+
+	// Send the job's confinement to the helper and wait for it to exec the target.
+	configErr := json.NewEncoder(configW).Encode(cfg)
+	_ = configW.Close() // Best effort.
+	startErrBuf, err := io.ReadAll(r)
+	_ = r.Close() // Best effort.
+	if err == nil && len(startErrBuf) != 0 {
+		err = errors.New(string(startErrBuf)) //nolint:err113 // Expected.
+	} else if err == nil {
+		err = configErr
+	}
+	if err != nil {
+		s.Kill()
+		_ = s.cmd.Wait() // Best effort, reap the helper.
+		s.closePTY(nil)
+		return fmt.Errorf("start exec process: %w", err)
+	}
+
+	if s.pty != nil {
+		s.outputDone = make(chan struct{})
+		go func() {
+			defer close(s.outputDone)
+			_, _ = io.Copy(spec.Stdout, s.pty) // Best effort. Ends with EIO when the process is gone.
+		}()
+	}
+	if stdin != nil && spec.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(stdin, spec.Stdin) // Best effort.
+			if s.pty == nil {
+				_ = stdin.Close() // Best effort. Send EOF to the process.
+			}
+		}()
+	}
+	return nil
+}
+
+// closePTY closes the pseudo-terminal, if any, and the given slave end if not nil.
+func (s *ExecSession) closePTY(slave *os.File) {
+	if s.pty == nil {
+		return
+	}
+	_ = s.pty.Close() // Best effort.
+	if slave != nil {
+		_ = slave.Close() // Best effort.
+	}
+}
+
+// wait for the process and its output.
+func (s *ExecSession) wait() {
+	if err := s.cmd.Wait(); err != nil {
+		slog.Debug("Exec session Wait ended with error", "session_id", s.ID.String(), "error", err)
+	}
+	if s.outputDone != nil {
+		<-s.outputDone
+		_ = s.pty.Close() // Best effort.
+	}
+	s.exitCode = s.cmd.ProcessState.ExitCode()
+	close(s.waitChan)
+}
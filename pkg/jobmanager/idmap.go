@@ -0,0 +1,127 @@
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// Common errors.
+var (
+	ErrIDRangeExhausted = errors.New("no subordinate id range available")
+)
+
+// UserNamespaceMode controls how the subordinate uid/gid ranges are allocated.
+type UserNamespaceMode int
+
+// Available user namespace modes.
+const (
+	// UserNamespaceDisabled runs the jobs as the real root (default).
+	UserNamespaceDisabled UserNamespaceMode = iota
+	// UserNamespacePerJob allocates a dedicated range for each job.
+	UserNamespacePerJob
+	// UserNamespacePerOwner allocates a range per owner, shared by all their jobs.
+	UserNamespacePerOwner
+)
+
+// ParseUserNamespaceMode parses the human readable mode.
+func ParseUserNamespaceMode(s string) (UserNamespaceMode, error) {
+	switch s {
+	case "", "none":
+		return UserNamespaceDisabled, nil
+	case "job":
+		return UserNamespacePerJob, nil
+	case "owner":
+		return UserNamespacePerOwner, nil
+	default:
+		return UserNamespaceDisabled, fmt.Errorf("invalid user namespace mode %q, expect 'none', 'job' or 'owner'", s) //nolint:err113 // No need for fancy error here.
+	}
+}
+
+// UserNamespaceConfig defines the subordinate id ranges available to the jobs.
+// The host ids [UIDBase, UIDBase+Size*Count) and [GIDBase, GIDBase+Size*Count)
+// are reserved for the jobs and should not overlap with any host user/group.
+type UserNamespaceConfig struct {
+	Mode    UserNamespaceMode
+	UIDBase uint32
+	GIDBase uint32
+	Size    uint32 // Number of ids per range, i.e. ids available within the job.
+	Count   uint32 // Number of ranges.
+}
+
+// idRange is an allocated subordinate uid/gid range.
+type idRange struct {
+	UID, GID, Size uint32
+}
+
+// mappings returns the uid/gid mappings for the range. Root within the
+// namespace maps to the first id of the range.
+func (r idRange) mappings() (uids, gids []syscall.SysProcIDMap) {
+	return []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(r.UID), Size: int(r.Size)}},
+		[]syscall.SysProcIDMap{{ContainerID: 0, HostID: int(r.GID), Size: int(r.Size)}}
+}
+
+// idAllocator hands out subordinate ranges by key. The key is either the job id
+// or the owner depending on the mode. Ranges are reference counted so a range
+// allocated per owner is kept until the last job of that owner is done.
+type idAllocator struct {
+	mu sync.Mutex
+
+	cfg  UserNamespaceConfig
+	used []bool // Indexed by range number.
+	keys map[string]*idAllocation
+}
+
+type idAllocation struct {
+	idx  uint32
+	refs int
+}
+
+func newIDAllocator(cfg UserNamespaceConfig) *idAllocator {
+	return &idAllocator{
+		cfg:  cfg,
+		used: make([]bool, cfg.Count),
+		keys: map[string]*idAllocation{},
+	}
+}
+
+func (a *idAllocator) acquire(key string) (idRange, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	alloc, ok := a.keys[key]
+	if !ok {
+		alloc = &idAllocation{idx: a.cfg.Count}
+		for i, used := range a.used {
+			if !used {
+				alloc.idx = uint32(i) //nolint:gosec // False positive, bound by cfg.Count.
+				break
+			}
+		}
+		if alloc.idx == a.cfg.Count {
+			return idRange{}, ErrIDRangeExhausted
+		}
+		a.used[alloc.idx] = true
+		a.keys[key] = alloc
+	}
+	alloc.refs++
+
+	offset := alloc.idx * a.cfg.Size
+	return idRange{UID: a.cfg.UIDBase + offset, GID: a.cfg.GIDBase + offset, Size: a.cfg.Size}, nil
+}
+
+func (a *idAllocator) release(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	alloc, ok := a.keys[key]
+	if !ok {
+		return
+	}
+	if alloc.refs--; alloc.refs > 0 {
+		return
+	}
+	a.used[alloc.idx] = false
+	delete(a.keys, key)
+}
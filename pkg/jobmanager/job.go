@@ -1,6 +1,7 @@
 package jobmanager
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +9,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,6 +21,9 @@ import (
 	pb "go.creack.net/telepilot/api/v1"
 	"go.creack.net/telepilot/pkg/broadcaster"
 	"go.creack.net/telepilot/pkg/cgroups"
+	"go.creack.net/telepilot/pkg/initd"
+	"go.creack.net/telepilot/pkg/rbac"
+	"go.creack.net/telepilot/pkg/rlimit"
 )
 
 // Job represent an individual job.
@@ -26,6 +33,21 @@ type Job struct {
 	// Immutable fields. Publicly accessible.
 	ID    uuid.UUID
 	Owner string
+	// Groups of the owner's identity when the job was submitted.
+	OwnerGroups []string
+
+	// Schedule which started the job. uuid.Nil when started directly.
+	ScheduleID uuid.UUID
+	// Workflow which started the job. uuid.Nil when started directly.
+	WorkflowID uuid.UUID
+
+	// CPU and memory of the job, defaults applied.
+	Resources cgroups.Limits
+
+	// Landlock ABI version enforcing the job's ruleset. 0 when not restricted.
+	LandlockABI int
+	// Resource limits applied to the job. The others are inherited from the server.
+	Rlimits map[rlimit.Resource]rlimit.Limit
 
 	// Underlying command.
 	cmd *exec.Cmd
@@ -33,9 +55,50 @@ type Job struct {
 	// Cgroup path used. Used to cleanup when done.
 	cgroupPath string
 
+	// Configuration sent to the init process.
+	initConfig initd.Config
+
+	// Subordinate uid/gid range when running in a user namespace, nil otherwise.
+	idRange *idRange
+
+	// Hooks called once the process exists, before the config is sent to the init process.
+	// Can update initConfig. Set before the job is started, not locked.
+	startHooks []func(pid int) error
+
+	// Extra resources to release once the job is done and its cgroup removed.
+	// Set before the job is started, not locked.
+	cleanups []func()
+
+	// Admission priority.
+	priority int
+	// Deadlines, watched once started.
+	maxDuration, maxCPUTime time.Duration
+
+	// Access granted to other users, set by the owner.
+	acl rbac.ACL
+
+	// Creation time, for the jobs per hour quota.
+	createdAt time.Time
+
 	// Status.
 	status   pb.JobStatus
 	exitCode int
+	// Error when the job failed to start after being queued.
+	startErr error
+	// Terminal status requested when stopping the job, i.e. STOPPED or TIMED_OUT.
+	// Unset when the process exits on its own.
+	stopStatus pb.JobStatus
+	// Stop chan, closed when the job must not restart anymore, i.e. when stopped.
+	stopChan chan struct{}
+
+	// Restarts. The policy and the backoff bounds are set before the job is started.
+	restartPolicy         RestartPolicy
+	restartBackoffInitial time.Duration
+	restartBackoffMax     time.Duration
+	restartBackoff        time.Duration // Last backoff used, 0 when reset.
+	restartCount          int
+	attempts              []Attempt
+	attemptStartedAt      time.Time
 
 	// Log Broadcaster.
 	// In the context of the assignment, we store all the output in memory
@@ -46,17 +109,54 @@ type Job struct {
 
 	// Wait chan, closed when the process ends.
 	waitChan chan struct{}
+
+	// Running exec sessions.
+	execSessions map[uuid.UUID]*ExecSession
+
+	// Released chan, closed once the cgroup is removed and the resources released.
+	releasedChan chan struct{}
 }
 
-func newJob(owner, cmd string, args []string) *Job {
+func newJob(owner string, spec JobSpec) *Job {
+	id := uuid.New()
+	hostname := spec.Hostname
+	if hostname == "" {
+		hostname = id.String()[:8] // Short ID, i.e. the first block of the uuid.
+	}
 	j := &Job{
-		ID:    uuid.New(),
-		Owner: owner,
-		cmd:   exec.Command(cmd, args...),
+		ID:          id,
+		Owner:       owner,
+		OwnerGroups: slices.Clone(spec.OwnerGroups),
+		acl:         slices.Clone(spec.ACL),
+		createdAt:   time.Now(),
+
+		ScheduleID: spec.ScheduleID,
+		WorkflowID: spec.WorkflowID,
+		Resources:  spec.Resources.WithDefaults(),
+		cmd:        exec.Command("/proc/self/exe", append([]string{"-init", spec.Command}, spec.Args...)...),
+
+		initConfig: initd.Config{
+			Hostname: hostname,
+			Seccomp:  string(spec.Seccomp),
+
+			NoNewPrivileges: !spec.AllowNewPrivileges,
+		},
 
 		broadcaster: broadcaster.NewBufferedBroadcaster(),
 
-		waitChan: make(chan struct{}),
+		execSessions: map[uuid.UUID]*ExecSession{},
+
+		priority:    spec.Priority,
+		maxDuration: spec.MaxDuration,
+		maxCPUTime:  spec.MaxCPUTime,
+
+		restartPolicy:         spec.Restart,
+		restartBackoffInitial: defaultRestartBackoff,
+		restartBackoffMax:     defaultMaxRestartBackoff,
+
+		stopChan:     make(chan struct{}),
+		waitChan:     make(chan struct{}),
+		releasedChan: make(chan struct{}),
 	}
 
 	j.cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -68,7 +168,10 @@ func newJob(owner, cmd string, args []string) *Job {
 		// Create the job in namespaces for isolation.
 		Cloneflags: syscall.CLONE_NEWPID | // PID namespace.
 			syscall.CLONE_NEWNS | // Mount namespace.
-			syscall.CLONE_NEWNET, // Network namespace.
+			syscall.CLONE_NEWNET | // Network namespace.
+			syscall.CLONE_NEWUTS | // UTS namespace, i.e. hostname.
+			syscall.CLONE_NEWIPC | // IPC namespace, i.e. SysV IPC and POSIX message queues.
+			syscall.CLONE_NEWCGROUP, // Cgroup namespace. Rooted at the job's cgroup as it is set by clone3.
 	}
 
 	return j
@@ -81,6 +184,27 @@ func (j *Job) Status() pb.JobStatus {
 	return s
 }
 
+// ExecSessions returns the number of running exec sessions.
+func (j *Job) ExecSessions() int {
+	j.mu.RLock()
+	n := len(j.execSessions)
+	j.mu.RUnlock()
+	return n
+}
+
+// Done returns a channel closed once the job ended, restarts included.
+func (j *Job) Done() <-chan struct{} {
+	return j.waitChan
+}
+
+// StartError returns the error if the job failed to start after being queued, nil otherwise.
+func (j *Job) StartError() error {
+	j.mu.RLock()
+	err := j.startErr
+	j.mu.RUnlock()
+	return err
+}
+
 func (j *Job) ExitCode() int {
 	j.mu.RLock()
 	c := j.exitCode
@@ -111,9 +235,11 @@ func (j *Job) closeGroup() (bool, error) {
 
 func (j *Job) close() {
 	j.mu.Lock()
-	if j.status != pb.JobStatus_JOB_STATUS_STOPPED {
-		j.status = pb.JobStatus_JOB_STATUS_EXITED
+	j.status = pb.JobStatus_JOB_STATUS_EXITED
+	if j.stopStatus != pb.JobStatus_JOB_STATUS_UNKNOWN_UNSPECIFIED {
+		j.status = j.stopStatus
 	}
+	j.exitCode = -1 // Never started.
 	if j.cmd.ProcessState != nil {
 		j.exitCode = j.cmd.ProcessState.ExitCode()
 	}
@@ -124,9 +250,110 @@ func (j *Job) close() {
 	}
 	j.mu.Unlock()
 
+	j.removeCgroup()
+
+	// Release the resources only once the cgroup is gone, i.e. when nothing
+	// can be using them anymore.
+	for _, fct := range j.cleanups {
+		fct()
+	}
+	close(j.releasedChan)
+}
+
+// kill the job's init process, recording the terminal status to report once it exits.
+// No-op if the job is not running. The first recorded status wins. Prevents the job from restarting.
+func (j *Job) kill(stopStatus pb.JobStatus) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == pb.JobStatus_JOB_STATUS_RESTARTING || j.status == pb.JobStatus_JOB_STATUS_QUEUED {
+		// No process to kill, cancel the restart or the start.
+		j.requestStop(stopStatus)
+		return nil
+	}
+	if j.status != pb.JobStatus_JOB_STATUS_RUNNING || j.cmd.Process == nil {
+		return nil
+	}
+	if err := j.cmd.Process.Kill(); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			// If the process died as we were about to stop it, nothing to do.
+			// Don't set the status as stopped as it exited on it's own, but make sure it doesn't restart.
+			// This is an unavoidable "race" as we don't control the child process,
+			// it can die after the lock and before the kill. Nothing to worry about though.
+			j.requestStop(pb.JobStatus_JOB_STATUS_UNKNOWN_UNSPECIFIED)
+			return nil
+		}
+		return fmt.Errorf("process kill %d: %w", j.cmd.Process.Pid, err)
+	}
+	j.requestStop(stopStatus)
+	return nil
+}
+
+// terminate the job gracefully: sends SIGTERM to all its processes, then kills it
+// if still running after the grace period. Records the terminal status to report.
+// No-op if the job is not running. Prevents the job from restarting.
+//
+// NOTE: As the init process is the job's PID namespace init, SIGTERM is ignored unless it handles it.
+func (j *Job) terminate(stopStatus pb.JobStatus, grace time.Duration) {
+	logger := slog.With("job_id", j.ID.String())
+
+	j.mu.Lock()
+	status := j.status
+	if status == pb.JobStatus_JOB_STATUS_RUNNING || status == pb.JobStatus_JOB_STATUS_RESTARTING {
+		j.requestStop(stopStatus)
+	}
+	j.mu.Unlock()
+	if status != pb.JobStatus_JOB_STATUS_RUNNING {
+		// Nothing to terminate. When restarting, the restart is cancelled.
+		return
+	}
+
+	if err := j.signalGroup(syscall.SIGTERM); err != nil {
+		// Best effort, killed after the grace period anyway.
+		logger.Warn("Failed to send SIGTERM to the job.", "error", err)
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-j.waitChan:
+		return
+	case <-timer.C:
+	}
+	if err := j.kill(stopStatus); err != nil {
+		logger.Error("Failed to kill the job after the grace period.", "error", err)
+	}
+}
+
+// signalGroup sends the given signal to all the processes in the job's cgroup.
+func (j *Job) signalGroup(sig syscall.Signal) error {
+	buf, err := os.ReadFile(filepath.Join(j.cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return fmt.Errorf("lookup group procs: %w", err)
+	}
+	for _, elem := range strings.Fields(string(buf)) {
+		pid, err := strconv.Atoi(elem)
+		if err != nil {
+			return fmt.Errorf("invalid pid %q in group procs: %w", elem, err)
+		}
+		if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("signal %d: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+// removeCgroup kills what is left in the cgroup and removes it.
+func (j *Job) removeCgroup() {
 	// NOTE: cgroupPath is immutable and set at start before being shared, can
-	// safely be used without lock.
+	// safely be used without lock. The cgroup is re-created at the same path on restart.
+	if j.cgroupPath == "" {
+		// Failed before the cgroup got created, nothing to do.
+		return
+	}
 	logger := slog.With("job_id", j.ID.String(), "cgroup_path", j.cgroupPath)
+	if _, err := os.Stat(j.cgroupPath); errors.Is(err, os.ErrNotExist) {
+		// Already removed, i.e. when the job failed to restart.
+		return
+	}
 
 	// Wait for ~1 second (arbitrary) for the cgroup to be empty.
 	const tickerInterval = 10 * time.Millisecond
@@ -160,21 +387,60 @@ func (j *Job) close() {
 	logger.Error("Timeout trying to cleanup cgroup.")
 }
 
-// wait for the underlying process. Broadcast the end via waitChan
-// and close the given resources.
+// wait for the underlying process, restarting it according to the restart policy.
+// Broadcast the end via waitChan and close the given resources.
 func (j *Job) wait() {
-	if err := j.cmd.Wait(); err != nil {
-		slog.Debug("Process Wait ended with error", "error", err)
+	for {
+		if err := j.cmd.Wait(); err != nil {
+			slog.Debug("Process Wait ended with error", "error", err)
+		}
+		backoff, ok := j.endAttempt()
+		if !ok {
+			break
+		}
+		// Start over from a clean cgroup.
+		j.removeCgroup()
+		if !j.restart(backoff) {
+			break
+		}
 	}
 	j.close()
 }
 
+func (j *Job) runStartHooks(pid int) error {
+	for _, hook := range j.startHooks {
+		if err := hook(pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // NOTE: Expected to be called before being shared. Not locked.
 func (j *Job) start() error {
+	r, configW, err := j.spawn()
+	if err != nil {
+		j.close() // Release what may have been allocated for the job.
+		return err
+	}
+	err = j.initialize(j.cmd.Process.Pid, r, configW)
+	if err != nil {
+		// The job is discarded, make sure it doesn't restart.
+		j.requestStop(pb.JobStatus_JOB_STATUS_UNKNOWN_UNSPECIFIED)
+	}
+	go j.wait()
+	return err
+}
+
+// spawn creates the job's cgroup and starts the init process in it.
+// Returns the pipes to initialize the process with.
+//
+// NOTE: Expected to be called with the lock held or before the job is shared.
+func (j *Job) spawn() (controlR, configW *os.File, err error) { //nolint:nonamedreturns // Named for documentation.
 	// Setup the cgroup limits.
-	cgroupDir, err := cgroups.New("job-" + j.ID.String())
+	cgroupDir, err := cgroups.New("job-"+j.ID.String(), j.Resources)
 	if err != nil {
-		return fmt.Errorf("setup cgroups for job: %w", err)
+		return nil, nil, fmt.Errorf("setup cgroups for job: %w", err)
 	}
 	defer func() {
 		// NOTE: This must be kept open until *after* the process started.
@@ -186,7 +452,10 @@ func (j *Job) start() error {
 	// Make use of clone3 cgroup arg.
 	j.cmd.SysProcAttr.UseCgroupFD = true
 	j.cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
-	j.cgroupPath = cgroupDir.Name()
+	if j.cgroupPath == "" {
+		// Same path on restart, only set it once as it is used without lock.
+		j.cgroupPath = cgroupDir.Name()
+	}
 
 	// Use the broadcaster as output for the process.
 	j.cmd.Stdout = j.broadcaster
@@ -195,30 +464,56 @@ func (j *Job) start() error {
 	// Control pipe.
 	r, w, err := os.Pipe()
 	if err != nil {
-		return fmt.Errorf("os.Pipe: %w", err)
+		return nil, nil, fmt.Errorf("os.Pipe: %w", err)
+	}
+	// Config pipe.
+	configR, configW, err := os.Pipe()
+	if err != nil {
+		_, _ = r.Close(), w.Close() // Best effort.
+		return nil, nil, fmt.Errorf("os.Pipe: %w", err)
 	}
-	j.cmd.ExtraFiles = []*os.File{w} // NOTE: We don't support setting extra files. Our pipe will always be '3'.
+	// NOTE: We don't support setting extra files. Our pipes will always be '3' and '4'.
+	j.cmd.ExtraFiles = []*os.File{w, configR}
 
 	if err := j.cmd.Start(); err != nil {
 		// NOTE: We don't set a special status for 'failed to start' as this state
 		// will be discarded and garbage collected. Never surfaced to the user.
 		// When we implement listing, it may be interesting to add.
-		j.close()
-		_, _ = r.Close(), w.Close() // Best effort.
-		return fmt.Errorf("start init process: %w", err)
+		_, _, _, _ = r.Close(), w.Close(), configR.Close(), configW.Close() // Best effort.
+		return nil, nil, fmt.Errorf("start init process: %w", err)
 	}
-	_ = w.Close() // Best effort. Needs to be closed before the ReadAll and after Start.
+	_, _ = w.Close(), configR.Close() // Best effort. Needs to be closed before the ReadAll and after Start.
 	j.status = pb.JobStatus_JOB_STATUS_RUNNING
-	go j.wait()
+	j.attemptStartedAt = time.Now()
+	return r, configW, nil
+}
+
+// initialize the started process: run the hooks requiring the process, then send the config to the child.
+// If a hook fails, the config is not sent and the child will fail on its own.
+// Closes the given pipes.
+func (j *Job) initialize(pid int, r, configW *os.File) error {
+	hookErr := j.runStartHooks(pid)
+	var configErr error
+	if hookErr == nil {
+		// If it fails, the child will report the error over the control pipe, which is more relevant, check it first.
+		configErr = json.NewEncoder(configW).Encode(j.initConfig)
+	}
+	_ = configW.Close() // Best effort.
 
 	startErrBuf, err := io.ReadAll(r)
 	_ = r.Close() // Best effort.
+	if hookErr != nil {
+		return fmt.Errorf("setup job process: %w", hookErr)
+	}
 	if err != nil {
 		return fmt.Errorf("read control pipe: %w", err)
 	}
 	if len(startErrBuf) != 0 {
 		return fmt.Errorf("start job process: %w", errors.New(string(startErrBuf))) //nolint:err113 // Expected.
 	}
+	if configErr != nil {
+		return fmt.Errorf("send init config: %w", configErr)
+	}
 
 	return nil
 }
@@ -6,35 +6,416 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
+	"net"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 
 	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/capabilities"
+	"go.creack.net/telepilot/pkg/cgroups"
+	"go.creack.net/telepilot/pkg/initd"
+	"go.creack.net/telepilot/pkg/landlock"
+	"go.creack.net/telepilot/pkg/netlink"
+	"go.creack.net/telepilot/pkg/network"
+	"go.creack.net/telepilot/pkg/nsenter"
+	"go.creack.net/telepilot/pkg/portproxy"
+	"go.creack.net/telepilot/pkg/rbac"
+	"go.creack.net/telepilot/pkg/rlimit"
+	"go.creack.net/telepilot/pkg/seccomp"
 )
 
 // Common errors.
 var (
-	ErrJobNotFound = errors.New("job not found")
+	ErrJobNotFound     = errors.New("job not found")
+	ErrJobNotRunning   = errors.New("job not running")
+	ErrInvalidHostname = errors.New("invalid hostname")
+	ErrInvalidTimeout  = errors.New("invalid timeout")
+
+	ErrInvalidRestartPolicy = errors.New("invalid restart policy")
+
+	ErrNetworkUnavailable  = errors.New("bridged network not enabled on the server")
+	ErrProfileNotAllowed   = errors.New("seccomp profile not allowed")
+	ErrCapNotAllowed       = errors.New("capability not allowed")
+	ErrLandlockUnavailable = errors.New("landlock not supported by the server's kernel")
+	ErrExceedsBudget       = errors.New("job resources exceed the server budget")
+)
+
+// NetworkMode is the job's network setup.
+type NetworkMode int
+
+// Available network modes.
+const (
+	// NetworkNone isolates the job with only a loopback interface (default).
+	NetworkNone NetworkMode = iota
+	// NetworkBridged connects the job to the host bridge with NAT.
+	NetworkBridged
 )
 
+// JobSpec describes the job to start.
+type JobSpec struct {
+	Command  string
+	Args     []string
+	Hostname string              // Optional. Defaults to the short job ID.
+	Network  NetworkMode         // Optional. Defaults to NetworkNone.
+	Ports    []portproxy.Mapping // Optional. Host ports to publish.
+	Seccomp  seccomp.Profile     // Optional. Defaults to seccomp.ProfileDefault.
+
+	// Optional. Capabilities added to/dropped from capabilities.Default. Drop takes precedence.
+	CapAdd  []string
+	CapDrop []string
+	// Optional. The no_new_privs bit is set unless allowed.
+	AllowNewPrivileges bool
+	// Optional. Host paths the job can access. Unrestricted when empty.
+	Landlock landlock.Ruleset
+	// Optional. Per-process resource limits, clamped to the server maxima.
+	Rlimits map[rlimit.Resource]rlimit.Limit
+	// Optional. The job is terminated once reached, with the TIMED_OUT status. 0 for no limit.
+	MaxDuration time.Duration
+	MaxCPUTime  time.Duration
+	// Optional. Restarts the job once its process exits, with exponential backoff. Defaults to RestartNever.
+	Restart RestartPolicy
+	// Optional. CPU and memory of the job, accounted for admission. Defaults to the cgroups presets.
+	Resources cgroups.Limits
+	// Optional. Admission priority, the highest first. Only used with QueuePriority.
+	Priority int
+	// Optional. Schedule which started the job, set by the scheduler.
+	ScheduleID uuid.UUID
+	// Optional. Workflow which started the job, set by the workflow controller.
+	WorkflowID uuid.UUID
+	// Optional. Groups of the owner's identity at submission time, used for authorization.
+	OwnerGroups []string
+	// Optional. DER of the owner's client certificate at submission time, re-checked for revocation by the deferred starts.
+	OwnerCertificate []byte
+	// Optional. Access granted to other users on the job, modifiable afterwards.
+	ACL rbac.ACL
+}
+
+// maxHostnameLen is the kernel's HOST_NAME_MAX.
+const maxHostnameLen = 64
+
+// validate the spec.
+func (s JobSpec) validate() error {
+	if len(s.Hostname) > maxHostnameLen {
+		return fmt.Errorf("%w: too long, max %d characters", ErrInvalidHostname, maxHostnameLen)
+	}
+	// Follow RFC 1123: letters, digits, hyphens and dots, hyphens/dots not at the edges.
+	for i, c := range s.Hostname {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case (c == '-' || c == '.') && i != 0 && i != len(s.Hostname)-1:
+		default:
+			return fmt.Errorf("%w: unexpected character %q at position %d", ErrInvalidHostname, c, i)
+		}
+	}
+	if err := s.Landlock.Validate(); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := rlimit.Validate(s.Rlimits); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := s.Resources.Validate(); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if s.MaxDuration < 0 || s.MaxCPUTime < 0 {
+		return fmt.Errorf("%w: max duration and max cpu time can't be negative", ErrInvalidTimeout)
+	}
+	if err := s.Restart.validate(); err != nil {
+		return err
+	}
+	if err := s.ACL.Validate(); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	return nil
+}
+
+// AuthorizeFunc checks whether the owner is still allowed to start the job of the given spec.
+// Used by the deferred starts, i.e. schedules and workflows, as the policy may have changed
+// or the owner's certificate may have been revoked since submitted.
+type AuthorizeFunc func(owner string, spec JobSpec) error
+
 // JobManager is the main controller.
 type JobManager struct {
 	mu   sync.RWMutex
 	jobs map[uuid.UUID]*Job
+	// Jobs being started, not stored yet, counted in the quotas usage. See reserveQuota.
+	reserved map[uuid.UUID]*Job
+
+	// Optional user namespace support. Immutable after creation.
+	userNamespaceMode UserNamespaceMode
+	idAllocator       *idAllocator
+
+	// Optional bridged network support. Immutable after creation.
+	network *network.Manager
+
+	// Published ports. Denies everything unless configured.
+	ports *portproxy.Manager
+
+	// Users allowed to run unconfined jobs. Immutable after creation.
+	unconfinedUsers map[string]struct{}
+
+	// Capabilities anyone can add to the default set, and users allowed to add any. Immutable after creation.
+	addableCaps capabilities.Set
+	capAddUsers map[string]struct{}
+
+	// Landlock ABI version supported by the kernel, 0 when unavailable. Immutable after creation.
+	landlockABI int
+
+	// Per-process resource limits maxima. Immutable after creation.
+	rlimitMaxima map[rlimit.Resource]uint64
+
+	// Delay between SIGTERM and SIGKILL when terminating a job. Immutable after creation.
+	stopGracePeriod time.Duration
+
+	// Delay before restarting a job, doubling after each consecutive failure up to the max. Immutable after creation.
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+
+	// Admission queue, limiting the jobs running concurrently. Unlimited unless configured.
+	admission *admission
+
+	// Quotas per user and group, nil when not configured. Immutable after creation.
+	// The lock serializes the quota checks and reservations, not the starts.
+	quotas  *QuotaConfig
+	quotaMu sync.Mutex
+}
+
+// Option configures the JobManager.
+type Option func(*JobManager) error
+
+// WithUserNamespace runs the jobs in their own user namespace, mapping root
+// within the job to an unprivileged subordinate range on the host.
+func WithUserNamespace(cfg UserNamespaceConfig) Option {
+	return func(jm *JobManager) error {
+		if cfg.Mode == UserNamespaceDisabled {
+			return nil
+		}
+		if cfg.Size == 0 || cfg.Count == 0 {
+			return errors.New("invalid user namespace config: size and count must be set") //nolint:err113 // No need for fancy error here.
+		}
+		const maxID = 1<<32 - 2 // -1 is reserved as the 'invalid' id.
+		if span := uint64(cfg.Size) * uint64(cfg.Count); uint64(cfg.UIDBase)+span > maxID || uint64(cfg.GIDBase)+span > maxID {
+			return errors.New("invalid user namespace config: ranges overflow the id space") //nolint:err113 // No need for fancy error here.
+		}
+		if cfg.UIDBase == 0 || cfg.GIDBase == 0 {
+			return errors.New("invalid user namespace config: ranges must not include host root") //nolint:err113 // No need for fancy error here.
+		}
+		jm.userNamespaceMode = cfg.Mode
+		jm.idAllocator = newIDAllocator(cfg)
+		return nil
+	}
+}
+
+// WithNetwork enables the bridged network for the jobs requesting it.
+// Sets up the host bridge and NAT rules.
+func WithNetwork(cfg network.Config) Option {
+	return func(jm *JobManager) error {
+		m, err := network.NewManager(cfg)
+		if err != nil {
+			return fmt.Errorf("new network manager: %w", err)
+		}
+		jm.network = m
+		return nil
+	}
+}
+
+// WithPortPublishing allows the users to publish job ports on the host within their allowed ranges.
+func WithPortPublishing(cfg portproxy.Config) Option {
+	return func(jm *JobManager) error {
+		jm.ports = portproxy.NewManager(cfg)
+		return nil
+	}
+}
+
+// WithUnconfinedUsers allows the given users to run jobs without seccomp filtering.
+func WithUnconfinedUsers(users ...string) Option {
+	return func(jm *JobManager) error {
+		for _, user := range users {
+			jm.unconfinedUsers[user] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithAddableCapabilities allows everyone to add the given capabilities to the default set.
+// The others are denied unless the user is allowed with WithCapAddUsers.
+func WithAddableCapabilities(capNames ...string) Option {
+	return func(jm *JobManager) error {
+		set, err := capabilities.Parse(capNames...)
+		if err != nil {
+			return fmt.Errorf("addable capabilities: %w", err)
+		}
+		jm.addableCaps = set
+		return nil
+	}
+}
+
+// WithCapAddUsers allows the given users to add any capability to the default set.
+func WithCapAddUsers(users ...string) Option {
+	return func(jm *JobManager) error {
+		for _, user := range users {
+			jm.capAddUsers[user] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithRlimitMaxima clamps the resource limits of the jobs. The resources not
+// requested by a job default to their maximum.
+func WithRlimitMaxima(maxima map[rlimit.Resource]uint64) Option {
+	return func(jm *JobManager) error {
+		jm.rlimitMaxima = maxima
+		return nil
+	}
+}
+
+// WithStopGracePeriod sets the delay between SIGTERM and SIGKILL when terminating a job, i.e. on timeout.
+func WithStopGracePeriod(d time.Duration) Option {
+	return func(jm *JobManager) error {
+		jm.stopGracePeriod = d
+		return nil
+	}
+}
+
+// WithRestartBackoff sets the initial and the max delays before restarting a job.
+// The delay doubles after each consecutive failure.
+func WithRestartBackoff(initial, maxBackoff time.Duration) Option {
+	return func(jm *JobManager) error {
+		if initial <= 0 || maxBackoff < initial {
+			return errors.New("invalid restart backoff: must be positive, initial <= max") //nolint:err113 // No need for fancy error here.
+		}
+		jm.restartBackoff, jm.maxRestartBackoff = initial, maxBackoff
+		return nil
+	}
+}
+
+// WithAdmission limits the jobs running concurrently. The others are queued until admitted.
+func WithAdmission(cfg AdmissionConfig) Option {
+	return func(jm *JobManager) error {
+		if cfg.MaxJobs < 0 || cfg.MaxJobsPerOwner < 0 {
+			return errors.New("invalid admission config: max jobs can't be negative") //nolint:err113 // No need for fancy error here.
+		}
+		jm.admission = newAdmission(cfg)
+		return nil
+	}
 }
 
 // NewJobManager instantiate the job manager.
 // NOTE: This expects the cgroup tree to be setup via cgroups.InitialSetup()
 // before being ready to use.
-func NewJobManager() *JobManager {
-	return &JobManager{jobs: map[uuid.UUID]*Job{}}
+func NewJobManager(opts ...Option) (*JobManager, error) {
+	jm := &JobManager{
+		jobs:     map[uuid.UUID]*Job{},
+		reserved: map[uuid.UUID]*Job{},
+		ports:    portproxy.NewManager(portproxy.Config{}),
+
+		unconfinedUsers: map[string]struct{}{},
+		capAddUsers:     map[string]struct{}{},
+
+		landlockABI: landlock.ABI(),
+
+		stopGracePeriod: defaultStopGracePeriod,
+
+		restartBackoff:    defaultRestartBackoff,
+		maxRestartBackoff: defaultMaxRestartBackoff,
+
+		admission: newAdmission(AdmissionConfig{}),
+	}
+	for _, opt := range opts {
+		if err := opt(jm); err != nil {
+			return nil, err
+		}
+	}
+	return jm, nil
+}
+
+// ValidateSpec checks whether the given owner can start a job with the given spec, without starting it.
+// The availability of the requested resources, i.e. ports, is only checked when starting the job.
+func (jm *JobManager) ValidateSpec(owner string, spec JobSpec) error {
+	_, err := jm.checkSpec(owner, spec)
+	return err
+}
+
+// checkSpec validates the spec for the given owner and resolves its capabilities.
+func (jm *JobManager) checkSpec(owner string, spec JobSpec) (capabilities.Set, error) {
+	if err := spec.validate(); err != nil {
+		return 0, err
+	}
+	if spec.Seccomp == seccomp.ProfileUnconfined {
+		if _, ok := jm.unconfinedUsers[owner]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrProfileNotAllowed, spec.Seccomp)
+		}
+	}
+	if !spec.Landlock.Empty() && jm.landlockABI == 0 {
+		return 0, ErrLandlockUnavailable
+	}
+	if err := jm.admission.check(spec.Resources.WithDefaults()); err != nil {
+		return 0, err
+	}
+	caps, err := capabilities.Resolve(spec.CapAdd, spec.CapDrop)
+	if err != nil {
+		return 0, err //nolint:wrapcheck // Already wrapped.
+	}
+	// NOTE: Checked on the resolved set, so adding "ALL" is fine as long as the others are dropped.
+	if added := caps &^ capabilities.Default &^ jm.addableCaps; added != 0 {
+		if _, ok := jm.capAddUsers[owner]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrCapNotAllowed, added)
+		}
+	}
+	return caps, nil
 }
 
-func (jm *JobManager) StartJob(owner, cmd string, args []string) (uuid.UUID, error) {
-	j := newJob(owner, "/proc/self/exe", append([]string{"-init", cmd}, args...))
+func (jm *JobManager) StartJob(owner string, spec JobSpec) (uuid.UUID, error) {
+	caps, err := jm.checkSpec(owner, spec)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	j := newJob(owner, spec)
+	if jm.quotas != nil {
+		if err := jm.reserveQuota(j, spec); err != nil {
+			return uuid.Nil, err
+		}
+		// Once stored or failed to start, the job counts on its own or not at all.
+		defer jm.releaseQuota(j)
+	}
+	j.initConfig.Capabilities = caps
+	if !spec.Landlock.Empty() {
+		j.initConfig.Landlock = &spec.Landlock
+		j.LandlockABI = jm.landlockABI
+	}
+	j.Rlimits = rlimit.Resolve(spec.Rlimits, jm.rlimitMaxima)
+	j.initConfig.Rlimits = j.Rlimits
+	j.restartBackoffInitial, j.restartBackoffMax = jm.restartBackoff, jm.maxRestartBackoff
+
+	if err := jm.setupUserNamespace(j); err != nil {
+		return uuid.Nil, fmt.Errorf("setup user namespace: %w", err)
+	}
+	if err := jm.setupNetwork(j, spec.Network); err != nil {
+		j.close() // Release what has been allocated so far.
+		return uuid.Nil, fmt.Errorf("setup network: %w", err)
+	}
+	if err := jm.setupPorts(j, spec.Ports); err != nil {
+		j.close() // Release what has been allocated so far.
+		return uuid.Nil, fmt.Errorf("setup ports: %w", err)
+	}
+
+	// Once done, release the admission slot and start the jobs admitted in its place.
+	j.cleanups = append(j.cleanups, func() { jm.launch(jm.admission.release(j)) })
+	j.status = pb.JobStatus_JOB_STATUS_QUEUED
+	admitted, others := jm.admission.submit(j)
+	defer jm.launch(others)
+	if !admitted {
+		// Queued, started once admitted. The resources allocated so far are kept in the meantime.
+		jm.mu.Lock()
+		jm.jobs[j.ID] = j
+		jm.mu.Unlock()
+		slog.Info("Job queued.", "job_id", j.ID.String(), "position", jm.admission.position(j))
+		return j.ID, nil
+	}
 
 	if err := j.start(); err != nil {
 		return uuid.Nil, fmt.Errorf("job start: %w", err)
@@ -45,9 +426,101 @@ func (jm *JobManager) StartJob(owner, cmd string, args []string) (uuid.UUID, err
 	jm.jobs[j.ID] = j
 	jm.mu.Unlock()
 
+	go j.watchDeadlines(j.maxDuration, j.maxCPUTime, jm.stopGracePeriod)
+
 	return j.ID, nil
 }
 
+// setupUserNamespace allocates a subordinate id range for the job and
+// configures the process to be created in a new user namespace.
+// The range is released when the job is closed.
+//
+// NOTE: Expected to be called before the job is started/shared.
+func (jm *JobManager) setupUserNamespace(j *Job) error {
+	if jm.userNamespaceMode == UserNamespaceDisabled {
+		return nil
+	}
+	key := j.ID.String()
+	if jm.userNamespaceMode == UserNamespacePerOwner {
+		key = j.Owner
+	}
+	r, err := jm.idAllocator.acquire(key)
+	if err != nil {
+		return err
+	}
+	j.idRange = &r
+	j.cleanups = append(j.cleanups, func() { jm.idAllocator.release(key) })
+
+	j.cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	j.cmd.SysProcAttr.UidMappings, j.cmd.SysProcAttr.GidMappings = r.mappings()
+	// We are privileged in the parent namespace, allow setgroups within the job.
+	j.cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+	return nil
+}
+
+// setupNetwork registers the network attachment to be done once the process
+// exists, i.e. once we have its network namespace, and the detach once it is done.
+//
+// NOTE: Expected to be called before the job is started/shared.
+func (jm *JobManager) setupNetwork(j *Job, mode NetworkMode) error {
+	if mode == NetworkNone {
+		return nil
+	}
+	if jm.network == nil {
+		return ErrNetworkUnavailable
+	}
+	j.startHooks = append(j.startHooks, func(pid int) error {
+		attachment, err := jm.network.Attach(j.ID, pid)
+		if err != nil {
+			return fmt.Errorf("attach network: %w", err)
+		}
+		j.initConfig.Network = &initd.NetworkConfig{
+			Interface: attachment.Interface,
+			Address:   attachment.Address.String(),
+			Gateway:   attachment.Gateway.String(),
+		}
+		return nil
+	})
+	j.cleanups = append(j.cleanups, func() { jm.network.Detach(j.ID) })
+	return nil
+}
+
+// setupPorts binds the published host ports and starts forwarding once the process exists.
+// The loopback of the job is brought up for the proxy to reach it, regardless of the network mode.
+// The ports are released when the job is closed.
+//
+// NOTE: Expected to be called before the job is started/shared.
+func (jm *JobManager) setupPorts(j *Job, mappings []portproxy.Mapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	publication, err := jm.ports.Publish(j.Owner, j.ID.String(), mappings)
+	if err != nil {
+		return fmt.Errorf("publish ports: %w", err)
+	}
+	j.cleanups = append(j.cleanups, publication.Close)
+	j.startHooks = append(j.startHooks, func(pid int) error {
+		if err := setLoopbackUp(pid); err != nil {
+			return fmt.Errorf("setup loopback: %w", err)
+		}
+		publication.Start(pid)
+		return nil
+	})
+	return nil
+}
+
+// setLoopbackUp brings up the loopback interface in the network namespace of the given pid.
+func setLoopbackUp(pid int) error {
+	return nsenter.Do(pid, []nsenter.Namespace{nsenter.Net}, func() error { //nolint:wrapcheck // Wrapped by the caller.
+		conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+		if err != nil {
+			return fmt.Errorf("dial rtnetlink: %w", err)
+		}
+		defer func() { _ = conn.Close() }() // Best effort.
+		return conn.SetUp("lo")             //nolint:wrapcheck // Already wrapped.
+	})
+}
+
 func (jm *JobManager) LookupJob(id uuid.UUID) (*Job, error) {
 	jm.mu.RLock()
 	j := jm.jobs[id]
@@ -63,33 +536,47 @@ func (jm *JobManager) StopJob(id uuid.UUID) error {
 	if err != nil {
 		return err
 	}
-	if err := func() error {
-		j.mu.Lock()
-		defer j.mu.Unlock()
-		if j.status != pb.JobStatus_JOB_STATUS_RUNNING {
-			return nil
-		}
-		if j.cmd.Process != nil {
-			if err := j.cmd.Process.Kill(); err != nil {
-				if errors.Is(err, os.ErrProcessDone) {
-					// If the process died as we were about to stop it, nothing to do.
-					// Don't set the status as stopped as it exited on it's own.
-					// This is an unavoidable "race" as we don't control the child process,
-					// it can die after the lock and before the kill. Nothing to worry about though.
-					return nil
-				}
-				return fmt.Errorf("process kill %d: %w", j.cmd.Process.Pid, err)
-			}
-			j.status = pb.JobStatus_JOB_STATUS_STOPPED
-		}
-		return nil
-	}(); err != nil {
+	if err := j.kill(pb.JobStatus_JOB_STATUS_STOPPED); err != nil {
 		return err
 	}
-	<-j.waitChan
+	if jm.admission.cancel(j) {
+		// Never started, end it.
+		j.close()
+	}
+	// Wait for the resources to be released, i.e. for the published ports to be available again.
+	<-j.releasedChan
 	return nil
 }
 
+// DialJob connects to the given TCP port on the loopback of the job, from within its network namespace.
+// The loopback is brought up if needed, so it works regardless of the job's network mode.
+func (jm *JobManager) DialJob(id uuid.UUID, port uint16) (net.Conn, error) {
+	j, err := jm.LookupJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	j.mu.RLock()
+	running := j.status == pb.JobStatus_JOB_STATUS_RUNNING
+	var pid int
+	if running {
+		pid = j.cmd.Process.Pid
+	}
+	j.mu.RUnlock()
+	if !running {
+		return nil, ErrJobNotRunning
+	}
+
+	if err := setLoopbackUp(pid); err != nil {
+		return nil, fmt.Errorf("setup loopback: %w", err)
+	}
+	conn, err := portproxy.DialInNamespace(pid, "tcp", port)
+	if err != nil {
+		return nil, fmt.Errorf("dial job: %w", err)
+	}
+	return conn, nil
+}
+
 func (jm *JobManager) StreamLogs(ctx context.Context, id uuid.UUID) (io.Reader, error) {
 	j, err := jm.LookupJob(id)
 	if err != nil {
@@ -98,7 +585,9 @@ func (jm *JobManager) StreamLogs(ctx context.Context, id uuid.UUID) (io.Reader,
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
-	if j.status != pb.JobStatus_JOB_STATUS_RUNNING {
+	// NOTE: The output is continuous across restarts, keep following while restarting or queued.
+	if j.status != pb.JobStatus_JOB_STATUS_RUNNING && j.status != pb.JobStatus_JOB_STATUS_RESTARTING &&
+		j.status != pb.JobStatus_JOB_STATUS_QUEUED {
 		return strings.NewReader(j.broadcaster.Buffer()), nil
 	}
 
@@ -0,0 +1,241 @@
+package jobmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"go.creack.net/telepilot/pkg/cgroups"
+)
+
+// Common errors.
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrInvalidQuota  = errors.New("invalid quota config")
+)
+
+// quotaWindow is the window of the jobs per hour limit.
+const quotaWindow = time.Hour
+
+// QuotaLimits bounds the jobs of a user or a group. 0 for no limit.
+type QuotaLimits struct {
+	MaxJobs        int    `json:"max_jobs"`          // Max active jobs, queued, running or restarting.
+	MaxCPUMillis   uint64 `json:"max_cpu_millis"`    // Max sum of the CPU of the active jobs.
+	MaxMemoryBytes uint64 `json:"max_memory_bytes"`  // Max sum of the memory of the active jobs.
+	MaxLogBytes    uint64 `json:"max_log_bytes"`     // Max output retained in memory, ended jobs included, never released.
+	MaxJobsPerHour int    `json:"max_jobs_per_hour"` // Max jobs started within the last hour.
+}
+
+// QuotaConfig sets the quotas checked when starting a job. The users without their own quota
+// get the default one. The quota of a group bounds the jobs of all its members together,
+// based on the groups of the owner when the job was submitted.
+type QuotaConfig struct {
+	Default QuotaLimits            `json:"default"`
+	Users   map[string]QuotaLimits `json:"users"`
+	Groups  map[string]QuotaLimits `json:"groups"`
+}
+
+// QuotaUsage is the usage of a user or a group, compared against its limits.
+type QuotaUsage struct {
+	Jobs         int
+	CPUMillis    uint64
+	MemoryBytes  uint64
+	LogBytes     uint64
+	JobsLastHour int
+}
+
+// Quota is the limits and the usage of a user or a group.
+type Quota struct {
+	User   string // Set for the quota of a user.
+	Group  string // Set for the quota of a group.
+	Limits QuotaLimits
+	Usage  QuotaUsage
+}
+
+// LoadQuotaConfig loads and validates the quotas from the given JSON file.
+func LoadQuotaConfig(path string) (QuotaConfig, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return QuotaConfig{}, fmt.Errorf("read quota file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	var cfg QuotaConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return QuotaConfig{}, fmt.Errorf("%w: decode %q: %w", ErrInvalidQuota, path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return QuotaConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Validate the config: no negative limits.
+func (cfg QuotaConfig) Validate() error {
+	if err := cfg.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, user := range slices.Sorted(maps.Keys(cfg.Users)) {
+		if err := cfg.Users[user].validate(); err != nil {
+			return fmt.Errorf("user %q: %w", user, err)
+		}
+	}
+	for _, group := range slices.Sorted(maps.Keys(cfg.Groups)) {
+		if err := cfg.Groups[group].validate(); err != nil {
+			return fmt.Errorf("group %q: %w", group, err)
+		}
+	}
+	return nil
+}
+
+func (l QuotaLimits) validate() error {
+	if l.MaxJobs < 0 || l.MaxJobsPerHour < 0 {
+		return fmt.Errorf("%w: max jobs can't be negative", ErrInvalidQuota)
+	}
+	return nil
+}
+
+// exceeded returns why a new job with the given resources would exceed the limits, empty when within.
+// The retained logs can't be anticipated, the limit only has to be reached for the new jobs to be rejected.
+func (l QuotaLimits) exceeded(u QuotaUsage, res cgroups.Limits) string {
+	switch {
+	case l.MaxJobs != 0 && u.Jobs >= l.MaxJobs:
+		return fmt.Sprintf("%d active jobs, max %d", u.Jobs, l.MaxJobs)
+	case l.MaxJobsPerHour != 0 && u.JobsLastHour >= l.MaxJobsPerHour:
+		return fmt.Sprintf("%d jobs started within the last hour, max %d", u.JobsLastHour, l.MaxJobsPerHour)
+	case l.MaxCPUMillis != 0 && u.CPUMillis+res.CPUMillis > l.MaxCPUMillis:
+		return fmt.Sprintf("cpu %d millis requested with %d in use, max %d", res.CPUMillis, u.CPUMillis, l.MaxCPUMillis)
+	case l.MaxMemoryBytes != 0 && u.MemoryBytes+res.MemoryBytes > l.MaxMemoryBytes:
+		return fmt.Sprintf("memory %d bytes requested with %d in use, max %d", res.MemoryBytes, u.MemoryBytes, l.MaxMemoryBytes)
+	case l.MaxLogBytes != 0 && u.LogBytes >= l.MaxLogBytes:
+		return fmt.Sprintf("%d log bytes retained, max %d", u.LogBytes, l.MaxLogBytes)
+	}
+	return ""
+}
+
+// WithQuotas limits the jobs of each user and group. The jobs exceeding them are rejected.
+func WithQuotas(cfg QuotaConfig) Option {
+	return func(jm *JobManager) error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		jm.quotas = &cfg
+		return nil
+	}
+}
+
+// userLimits returns the limits of the given user.
+func (jm *JobManager) userLimits(user string) QuotaLimits {
+	if jm.quotas == nil {
+		return QuotaLimits{}
+	}
+	if l, ok := jm.quotas.Users[user]; ok {
+		return l
+	}
+	return jm.quotas.Default
+}
+
+// usage sums the usage of the jobs matching the given filter, the ones being started included.
+func (jm *JobManager) usage(match func(*Job) bool) QuotaUsage {
+	jm.mu.RLock()
+	jobs := slices.Collect(maps.Values(jm.jobs))
+	for id, j := range jm.reserved {
+		if _, ok := jm.jobs[id]; !ok { // Not stored yet.
+			jobs = append(jobs, j)
+		}
+	}
+	jm.mu.RUnlock()
+
+	var u QuotaUsage
+	since := time.Now().Add(-quotaWindow)
+	for _, j := range jobs {
+		if !match(j) {
+			continue
+		}
+		u.LogBytes += uint64(j.broadcaster.Len()) //nolint:gosec // False positive, a length is never negative.
+		if j.createdAt.After(since) {
+			u.JobsLastHour++
+		}
+		select {
+		case <-j.releasedChan: // Ended, its resources are released.
+		default:
+			u.Jobs++
+			u.CPUMillis += j.Resources.CPUMillis
+			u.MemoryBytes += j.Resources.MemoryBytes
+		}
+	}
+	return u
+}
+
+// reserveQuota checks the quotas for the given job, then counts it in the usage until released,
+// so the concurrent starts can't overshoot while the lock is only held for the check.
+func (jm *JobManager) reserveQuota(j *Job, spec JobSpec) error {
+	jm.quotaMu.Lock()
+	defer jm.quotaMu.Unlock()
+	if err := jm.checkQuotas(j.Owner, spec); err != nil {
+		return err
+	}
+	jm.mu.Lock()
+	jm.reserved[j.ID] = j
+	jm.mu.Unlock()
+	return nil
+}
+
+// releaseQuota drops the reservation of the given job, either stored by now or failed to start.
+func (jm *JobManager) releaseQuota(j *Job) {
+	jm.mu.Lock()
+	delete(jm.reserved, j.ID)
+	jm.mu.Unlock()
+}
+
+// checkQuotas checks whether the owner, and its groups, can start a job with the given spec.
+//
+// NOTE: Expected to be called with the quota lock held, until the job is reserved.
+func (jm *JobManager) checkQuotas(owner string, spec JobSpec) error {
+	res := spec.Resources.WithDefaults()
+	u := jm.usage(func(j *Job) bool { return j.Owner == owner })
+	if reason := jm.userLimits(owner).exceeded(u, res); reason != "" {
+		return fmt.Errorf("%w: user %q: %s", ErrQuotaExceeded, owner, reason)
+	}
+	for _, group := range spec.OwnerGroups {
+		limits, ok := jm.quotas.Groups[group]
+		if !ok {
+			continue
+		}
+		u := jm.usage(func(j *Job) bool { return slices.Contains(j.OwnerGroups, group) })
+		if reason := limits.exceeded(u, res); reason != "" {
+			return fmt.Errorf("%w: group %q: %s", ErrQuotaExceeded, group, reason)
+		}
+	}
+	return nil
+}
+
+// Quotas returns the quota of the given user, then the ones of the given groups which have one.
+// Without quotas, the usage is still reported with no limits.
+func (jm *JobManager) Quotas(user string, groups []string) []Quota {
+	quotas := []Quota{{
+		User:   user,
+		Limits: jm.userLimits(user),
+		Usage:  jm.usage(func(j *Job) bool { return j.Owner == user }),
+	}}
+	if jm.quotas == nil {
+		return quotas
+	}
+	for _, group := range groups {
+		limits, ok := jm.quotas.Groups[group]
+		if !ok {
+			continue
+		}
+		quotas = append(quotas, Quota{
+			Group:  group,
+			Limits: limits,
+			Usage:  jm.usage(func(j *Job) bool { return slices.Contains(j.OwnerGroups, group) }),
+		})
+	}
+	return quotas
+}
@@ -0,0 +1,202 @@
+package jobmanager
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+
+	pb "go.creack.net/telepilot/api/v1"
+)
+
+const (
+	// defaultRestartBackoff and defaultMaxRestartBackoff bound the delay before restarting a job,
+	// doubling after each consecutive failure.
+	defaultRestartBackoff    = time.Second
+	defaultMaxRestartBackoff = time.Minute
+
+	// restartBackoffReset is how long an attempt needs to run for the backoff to reset.
+	restartBackoffReset = 10 * time.Second
+
+	// maxAttemptsHistory is the number of past attempts kept in the job status.
+	maxAttemptsHistory = 10
+)
+
+// RestartMode is the behavior of the job once its process exits.
+type RestartMode int
+
+// Available restart modes.
+const (
+	// RestartNever lets the job end with its process (default).
+	RestartNever RestartMode = iota
+	// RestartOnFailure restarts the job when its process exits with a non-zero code or gets killed.
+	RestartOnFailure
+	// RestartAlways restarts the job whenever its process exits.
+	RestartAlways
+)
+
+// RestartPolicy describes when to restart a job once its process exits.
+// A stopped or timed out job is never restarted.
+type RestartPolicy struct {
+	Mode RestartMode
+	// Max number of restarts with RestartOnFailure. 0 for unlimited.
+	MaxRetries int
+}
+
+// validate the policy.
+func (p RestartPolicy) validate() error {
+	if p.Mode < RestartNever || p.Mode > RestartAlways {
+		return fmt.Errorf("%w: unknown mode %d", ErrInvalidRestartPolicy, p.Mode)
+	}
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("%w: max retries can't be negative", ErrInvalidRestartPolicy)
+	}
+	if p.MaxRetries != 0 && p.Mode != RestartOnFailure {
+		return fmt.Errorf("%w: max retries only applies to on-failure", ErrInvalidRestartPolicy)
+	}
+	return nil
+}
+
+// shouldRestart returns whether to restart after an attempt exiting with the given code,
+// restartCount restarts having already been made.
+func (p RestartPolicy) shouldRestart(exitCode, restartCount int) bool {
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0 && (p.MaxRetries == 0 || restartCount < p.MaxRetries)
+	default:
+		return false
+	}
+}
+
+// Attempt is a past run of the job's process. Each restart makes a new attempt.
+type Attempt struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	ExitCode  int // -1 when killed by a signal.
+}
+
+// RestartCount returns the number of times the job has been restarted.
+func (j *Job) RestartCount() int {
+	j.mu.RLock()
+	n := j.restartCount
+	j.mu.RUnlock()
+	return n
+}
+
+// Attempts returns the last ended attempts of the job, oldest first.
+func (j *Job) Attempts() []Attempt {
+	j.mu.RLock()
+	attempts := append([]Attempt(nil), j.attempts...)
+	j.mu.RUnlock()
+	return attempts
+}
+
+// endAttempt records the attempt which just ended and returns whether to restart the job,
+// with the delay to wait before doing so. Sets the RESTARTING status when restarting.
+func (j *Job) endAttempt() (time.Duration, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	exitCode := j.cmd.ProcessState.ExitCode()
+	duration := time.Since(j.attemptStartedAt)
+	j.attempts = append(j.attempts, Attempt{StartedAt: j.attemptStartedAt, Duration: duration, ExitCode: exitCode})
+	if len(j.attempts) > maxAttemptsHistory {
+		j.attempts = j.attempts[len(j.attempts)-maxAttemptsHistory:]
+	}
+
+	if j.stopRequested() || !j.restartPolicy.shouldRestart(exitCode, j.restartCount) {
+		return 0, false
+	}
+
+	// Exponential backoff on consecutive short lived attempts.
+	if duration >= restartBackoffReset {
+		j.restartBackoff = 0
+	}
+	if j.restartBackoff == 0 {
+		j.restartBackoff = j.restartBackoffInitial
+	} else {
+		j.restartBackoff = min(2*j.restartBackoff, j.restartBackoffMax)
+	}
+
+	j.status = pb.JobStatus_JOB_STATUS_RESTARTING
+	j.exitCode = exitCode
+
+	// Attempt marker, keeping the logs continuous across restarts.
+	_, _ = fmt.Fprintf(j.broadcaster, "--- telepilot: attempt %d exited with code %d, restarting in %s ---\n", // Best effort.
+		j.restartCount+1, exitCode, j.restartBackoff)
+	return j.restartBackoff, true
+}
+
+// stopRequested returns whether the job has been requested to stop, in which case it must not restart.
+//
+// NOTE: Expected to be called with the lock held.
+func (j *Job) stopRequested() bool {
+	select {
+	case <-j.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestStop prevents the job from restarting, recording the terminal status to report if set.
+// The first recorded status wins.
+//
+// NOTE: Expected to be called with the lock held.
+func (j *Job) requestStop(stopStatus pb.JobStatus) {
+	if j.stopStatus == pb.JobStatus_JOB_STATUS_UNKNOWN_UNSPECIFIED {
+		j.stopStatus = stopStatus
+	}
+	if !j.stopRequested() {
+		close(j.stopChan)
+	}
+}
+
+// restart waits for the backoff, then re-creates the cgroup and the process under the same job ID.
+// Returns false when the job got stopped in the meantime or the process could not be created,
+// in which case the job is done.
+//
+// NOTE: Expected to be called from the wait goroutine, once the previous attempt's cgroup is removed.
+func (j *Job) restart(backoff time.Duration) bool {
+	logger := slog.With("job_id", j.ID.String())
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-j.stopChan:
+		return false
+	case <-timer.C:
+	}
+
+	j.mu.Lock()
+	if j.stopRequested() {
+		j.mu.Unlock()
+		return false
+	}
+	j.restartCount++
+	attempt := j.restartCount + 1
+
+	// An exec.Cmd can't be reused, make a new one from the previous one.
+	prev := j.cmd
+	sysProcAttr := *prev.SysProcAttr
+	j.cmd = &exec.Cmd{Path: prev.Path, Args: prev.Args, SysProcAttr: &sysProcAttr}
+	r, configW, err := j.spawn()
+	var pid int
+	if err == nil {
+		pid = j.cmd.Process.Pid
+	}
+	j.mu.Unlock()
+	if err != nil {
+		logger.Error("Failed to restart the job.", "attempt", attempt, "error", err)
+		return false
+	}
+	logger.Info("Job restarted.", "attempt", attempt, "backoff", backoff)
+
+	if err := j.initialize(pid, r, configW); err != nil {
+		// The process fails on its own, which is handled as any other failed attempt.
+		logger.Warn("Failed to initialize the restarted job.", "attempt", attempt, "error", err)
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+// Package landlock restricts the filesystem access of the jobs using Landlock LSM rulesets,
+// using only the standard library.
+package landlock
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// ErrInvalidRule is returned when a rule of the ruleset is invalid.
+var ErrInvalidRule = errors.New("invalid landlock rule")
+
+// Ruleset lists the host paths a job can access. Everything else is denied.
+// Access to a directory applies to everything beneath it.
+type Ruleset struct {
+	ReadOnly  []string `json:"read_only,omitempty"`  // Read and execute.
+	ReadWrite []string `json:"read_write,omitempty"` // Read, execute, write, create, remove, etc.
+}
+
+// Empty returns whether the ruleset has no rule, in which case it is not enforced.
+func (r Ruleset) Empty() bool {
+	return len(r.ReadOnly) == 0 && len(r.ReadWrite) == 0
+}
+
+// Validate makes sure the paths are absolute and clean.
+func (r Ruleset) Validate() error {
+	for _, p := range append(r.ReadOnly[:len(r.ReadOnly):len(r.ReadOnly)], r.ReadWrite...) {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("%w: path %q must be absolute", ErrInvalidRule, p)
+		}
+		if filepath.Clean(p) != p {
+			return fmt.Errorf("%w: path %q must be clean", ErrInvalidRule, p)
+		}
+	}
+	return nil
+}
+
+// Landlock syscalls, shared by all the architectures.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	createRulesetVersion = 1 << 0 // LANDLOCK_CREATE_RULESET_VERSION.
+	rulePathBeneath      = 1      // LANDLOCK_RULE_PATH_BENEATH.
+
+	oPath = 0x200000 // O_PATH, missing from the syscall package.
+)
+
+// Filesystem access rights.
+const (
+	accessExecute    = 1 << 0
+	accessWriteFile  = 1 << 1
+	accessReadFile   = 1 << 2
+	accessReadDir    = 1 << 3
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+	accessRefer      = 1 << 13 // ABI 2.
+	accessTruncate   = 1 << 14 // ABI 3.
+	accessIoctlDev   = 1 << 15 // ABI 5.
+
+	accessReadOnly = accessExecute | accessReadFile | accessReadDir
+	// accessFile are the only rights applicable to regular files, the others being directory related.
+	accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate | accessIoctlDev
+)
+
+// handledAccess returns the access rights supported by the given ABI version.
+func handledAccess(abi int) uint64 {
+	access := uint64(accessExecute | accessWriteFile | accessReadFile | accessReadDir |
+		accessRemoveDir | accessRemoveFile | accessMakeChar | accessMakeDir | accessMakeReg |
+		accessMakeSock | accessMakeFifo | accessMakeBlock | accessMakeSym) // ABI 1.
+	if abi >= 2 {
+		access |= accessRefer
+	}
+	if abi >= 3 {
+		access |= accessTruncate
+	}
+	if abi >= 5 {
+		access |= accessIoctlDev
+	}
+	return access
+}
+
+// ABI returns the Landlock ABI version supported by the kernel.
+// 0 when Landlock is not supported or disabled.
+func ABI() int {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, createRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// rulesetAttr and pathBeneathAttr are the kernel's struct landlock_ruleset_attr and struct landlock_path_beneath_attr.
+// NOTE: Only the filesystem part of the ruleset attr is used, the kernel accepts smaller structs.
+type rulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// NOTE: The kernel struct is packed, the trailing padding is ignored.
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFD      int32
+}
+
+// Restrict the calling thread, and therefore the process it executes, to the ruleset.
+// Uses all the access rights supported by the kernel, returning the ABI version in use.
+//
+// The thread needs either CAP_SYS_ADMIN or the no_new_privs bit set.
+//
+// NOTE: Expected to be called on a locked OS thread, right before exec.
+func (r Ruleset) Restrict() (int, error) {
+	abi := ABI()
+	if abi == 0 {
+		return 0, errors.New("landlock not supported by the kernel") //nolint:err113 // No need for fancy error here.
+	}
+	handled := handledAccess(abi)
+
+	attr := rulesetAttr{handledAccessFS: handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("create ruleset: %w", errno)
+	}
+	defer func() { _ = syscall.Close(int(fd)) }() // Best effort.
+
+	for _, p := range r.ReadOnly {
+		if err := addPathRule(int(fd), p, accessReadOnly&handled); err != nil {
+			return 0, err
+		}
+	}
+	for _, p := range r.ReadWrite {
+		if err := addPathRule(int(fd), p, handled); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return 0, fmt.Errorf("restrict self: %w", errno)
+	}
+	return abi, nil
+}
+
+// addPathRule allows the given access beneath the path.
+func addPathRule(rulesetFD int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", path, err)
+	}
+	defer func() { _ = syscall.Close(fd) }() // Best effort.
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %q: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		// The kernel rejects directory rights on files.
+		access &= accessFile
+	}
+
+	attr := pathBeneathAttr{allowedAccess: access, parentFD: int32(fd)} //nolint:gosec // False positive, fds are small.
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFD), rulePathBeneath,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add rule for %q: %w", path, errno)
+	}
+	return nil
+}
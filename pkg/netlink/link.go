@@ -0,0 +1,222 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Link/address/route related constants not exposed by the syscall package.
+const (
+	iflaInfoKind       = 1  // IFLA_INFO_KIND.
+	iflaInfoData       = 2  // IFLA_INFO_DATA.
+	vethInfoPeer       = 1  // VETH_INFO_PEER.
+	iflaProtinfo       = 12 // IFLA_PROTINFO.
+	iflaBrportIsolated = 33 // IFLA_BRPORT_ISOLATED.
+	rtmSetLink         = 19
+	flagsCreate        = syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | syscall.NLM_F_CREATE | syscall.NLM_F_EXCL
+	flagsModify        = syscall.NLM_F_REQUEST | syscall.NLM_F_ACK
+)
+
+// ifInfoMsg encodes a struct ifinfomsg.
+func ifInfoMsg(index int32, flags, change uint32) []byte {
+	b := make([]byte, syscall.SizeofIfInfomsg)
+	b[0] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(b[4:], uint32(index)) //nolint:gosec // Expected, ifindex is a signed int in the kernel.
+	binary.NativeEndian.PutUint32(b[8:], flags)
+	binary.NativeEndian.PutUint32(b[12:], change)
+	return b
+}
+
+// linkIndex looks up the index of the given link in the current network namespace.
+func linkIndex(name string) (int32, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup link %q: %w", name, err)
+	}
+	return int32(iface.Index), nil //nolint:gosec // False positive, ifindex is an int32 in the kernel.
+}
+
+// CreateBridge creates a new bridge link.
+// Returns syscall.EEXIST (wrapped) if the link already exists.
+func (c *Conn) CreateBridge(name string) error {
+	if err := c.execute(message{
+		typ:    syscall.RTM_NEWLINK,
+		flags:  flagsCreate,
+		header: ifInfoMsg(0, 0, 0),
+		attrs: []attr{
+			strAttr(syscall.IFLA_IFNAME, name),
+			nested(syscall.IFLA_LINKINFO, strAttr(iflaInfoKind, "bridge")),
+		},
+	}); err != nil {
+		return fmt.Errorf("create bridge %q: %w", name, err)
+	}
+	return nil
+}
+
+// CreateVethPair creates a veth pair, the peer is created directly
+// within the network namespace of the given pid.
+func (c *Conn) CreateVethPair(name, peerName string, peerNSPid int) error {
+	if err := c.execute(message{
+		typ:    syscall.RTM_NEWLINK,
+		flags:  flagsCreate,
+		header: ifInfoMsg(0, 0, 0),
+		attrs: []attr{
+			strAttr(syscall.IFLA_IFNAME, name),
+			nested(syscall.IFLA_LINKINFO,
+				strAttr(iflaInfoKind, "veth"),
+				nested(iflaInfoData,
+					// The peer is an ifinfomsg followed by the link attributes.
+					bytesAttr(vethInfoPeer, encodeAttrs(ifInfoMsg(0, 0, 0), []attr{
+						strAttr(syscall.IFLA_IFNAME, peerName),
+						u32Attr(syscall.IFLA_NET_NS_PID, uint32(peerNSPid)), //nolint:gosec // False positive, pids are positive.
+					})),
+				),
+			),
+		},
+	}); err != nil {
+		return fmt.Errorf("create veth pair %q/%q: %w", name, peerName, err)
+	}
+	return nil
+}
+
+// SetMaster attaches the given link to the given master, i.e. a bridge.
+func (c *Conn) SetMaster(name, master string) error {
+	idx, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	masterIdx, err := linkIndex(master)
+	if err != nil {
+		return err
+	}
+	if err := c.execute(message{
+		typ:    rtmSetLink,
+		flags:  flagsModify,
+		header: ifInfoMsg(idx, 0, 0),
+		attrs:  []attr{u32Attr(syscall.IFLA_MASTER, uint32(masterIdx))}, //nolint:gosec // False positive, ifindex is positive.
+	}); err != nil {
+		return fmt.Errorf("set master %q for %q: %w", master, name, err)
+	}
+	return nil
+}
+
+// SetIsolated isolates the given bridge port: the traffic can't be forwarded between
+// isolated ports of the bridge, only to the bridge itself and the other ports.
+// Requires linux 4.18 or later.
+func (c *Conn) SetIsolated(name string) error {
+	idx, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	header := ifInfoMsg(idx, 0, 0)
+	header[0] = syscall.AF_BRIDGE // The bridge port attributes are handled by the bridge family.
+	if err := c.execute(message{
+		typ:    rtmSetLink,
+		flags:  flagsModify,
+		header: header,
+		attrs:  []attr{nested(iflaProtinfo, bytesAttr(iflaBrportIsolated, []byte{1}))},
+	}); err != nil {
+		return fmt.Errorf("set bridge port %q isolated: %w", name, err)
+	}
+	return nil
+}
+
+// SetUp brings the given link up.
+func (c *Conn) SetUp(name string) error {
+	idx, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	if err := c.execute(message{
+		typ:    rtmSetLink,
+		flags:  flagsModify,
+		header: ifInfoMsg(idx, syscall.IFF_UP, syscall.IFF_UP),
+	}); err != nil {
+		return fmt.Errorf("set link %q up: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteLink deletes the given link. For a veth, deletes both ends.
+func (c *Conn) DeleteLink(name string) error {
+	idx, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	if err := c.execute(message{
+		typ:    syscall.RTM_DELLINK,
+		flags:  flagsModify,
+		header: ifInfoMsg(idx, 0, 0),
+	}); err != nil {
+		return fmt.Errorf("delete link %q: %w", name, err)
+	}
+	return nil
+}
+
+// AddAddress assigns the given IPv4 address to the given link.
+// Returns syscall.EEXIST (wrapped) if the address is already assigned.
+func (c *Conn) AddAddress(name string, addr *net.IPNet) error {
+	idx, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	ip := addr.IP.To4()
+	if ip == nil {
+		return fmt.Errorf("add address %s to %q: only ipv4 is supported: %w", addr, name, syscall.EAFNOSUPPORT)
+	}
+	ones, _ := addr.Mask.Size()
+
+	// struct ifaddrmsg.
+	header := make([]byte, syscall.SizeofIfAddrmsg)
+	header[0] = syscall.AF_INET
+	header[1] = byte(ones)
+	header[3] = syscall.RT_SCOPE_UNIVERSE
+	binary.NativeEndian.PutUint32(header[4:], uint32(idx)) //nolint:gosec // False positive, ifindex is positive.
+
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^addr.Mask[len(addr.Mask)-len(ip)+i]
+	}
+
+	if err := c.execute(message{
+		typ:    syscall.RTM_NEWADDR,
+		flags:  flagsCreate,
+		header: header,
+		attrs: []attr{
+			bytesAttr(syscall.IFA_LOCAL, ip),
+			bytesAttr(syscall.IFA_ADDRESS, ip),
+			bytesAttr(syscall.IFA_BROADCAST, broadcast),
+		},
+	}); err != nil {
+		return fmt.Errorf("add address %s to %q: %w", addr, name, err)
+	}
+	return nil
+}
+
+// AddDefaultRoute adds an IPv4 default route via the given gateway.
+func (c *Conn) AddDefaultRoute(gateway net.IP) error {
+	gw := gateway.To4()
+	if gw == nil {
+		return fmt.Errorf("add default route via %s: only ipv4 is supported: %w", gateway, syscall.EAFNOSUPPORT)
+	}
+
+	// struct rtmsg.
+	header := make([]byte, syscall.SizeofRtMsg)
+	header[0] = syscall.AF_INET
+	header[4] = syscall.RT_TABLE_MAIN
+	header[5] = syscall.RTPROT_BOOT
+	header[6] = syscall.RT_SCOPE_UNIVERSE
+	header[7] = syscall.RTN_UNICAST
+
+	if err := c.execute(message{
+		typ:    syscall.RTM_NEWROUTE,
+		flags:  flagsCreate,
+		header: header,
+		attrs:  []attr{bytesAttr(syscall.RTA_GATEWAY, gw)},
+	}); err != nil {
+		return fmt.Errorf("add default route via %s: %w", gateway, err)
+	}
+	return nil
+}
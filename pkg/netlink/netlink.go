@@ -0,0 +1,171 @@
+// Package netlink provides a minimal netlink client to manage links, addresses,
+// routes and nftables rules using only the standard library syscalls, i.e.
+// without relying on external tools like `ip` or `nft`.
+//
+// NOTE: Only the small subset needed to setup the job networking is implemented.
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Conn is a netlink socket for a given protocol (NETLINK_ROUTE, NETLINK_NETFILTER, ...).
+// Not safe for concurrent use.
+type Conn struct {
+	fd  int
+	seq uint32
+}
+
+// Dial opens a netlink socket for the given protocol in the current network namespace.
+func Dial(proto int) (*Conn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		_ = syscall.Close(fd) // Best effort.
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	return &Conn{fd: fd}, nil
+}
+
+// Close the underlying socket.
+func (c *Conn) Close() error {
+	return syscall.Close(c.fd) //nolint:wrapcheck // No need to wrap here.
+}
+
+// attr is a netlink attribute. Either data or children is expected to be set.
+type attr struct {
+	typ      uint16
+	data     []byte
+	children []attr
+}
+
+func align(n int) int {
+	return (n + syscall.NLA_ALIGNTO - 1) & ^(syscall.NLA_ALIGNTO - 1)
+}
+
+// encode appends the attribute to the given buffer.
+func (a attr) encode(b []byte) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0) // Header placeholder.
+	typ := a.typ
+	if a.children != nil {
+		typ |= syscall.NLA_F_NESTED
+		for _, child := range a.children {
+			b = child.encode(b)
+		}
+	} else {
+		b = append(b, a.data...)
+	}
+	binary.NativeEndian.PutUint16(b[start:], uint16(len(b)-start)) //nolint:gosec // False positive, netlink messages are small.
+	binary.NativeEndian.PutUint16(b[start+2:], typ)
+	for len(b) != align(len(b)) {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func encodeAttrs(b []byte, attrs []attr) []byte {
+	for _, a := range attrs {
+		b = a.encode(b)
+	}
+	return b
+}
+
+// Attribute helpers.
+
+func nested(typ uint16, children ...attr) attr {
+	return attr{typ: typ, children: append([]attr{}, children...)}
+}
+
+func bytesAttr(typ uint16, data []byte) attr { return attr{typ: typ, data: data} }
+func strAttr(typ uint16, s string) attr      { return attr{typ: typ, data: append([]byte(s), 0)} }
+
+func u32Attr(typ uint16, v uint32) attr {
+	return attr{typ: typ, data: binary.NativeEndian.AppendUint32(nil, v)}
+}
+
+// be32Attr is a big endian (network byte order) uint32, used by nftables.
+func be32Attr(typ uint16, v uint32) attr {
+	return attr{typ: typ, data: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// message is a netlink message to be sent.
+type message struct {
+	typ    uint16
+	flags  uint16
+	header []byte // Protocol specific header, i.e. ifinfomsg, rtmsg, nfgenmsg, etc.
+	attrs  []attr
+}
+
+// encode appends the message to the given buffer with the given sequence number.
+func (m message) encode(b []byte, seq uint32) []byte {
+	start := len(b)
+	b = append(b, make([]byte, syscall.NLMSG_HDRLEN)...)
+	b = append(b, m.header...)
+	for len(b) != align(len(b)) {
+		b = append(b, 0)
+	}
+	b = encodeAttrs(b, m.attrs)
+	binary.NativeEndian.PutUint32(b[start:], uint32(len(b)-start)) //nolint:gosec // False positive, netlink messages are small.
+	binary.NativeEndian.PutUint16(b[start+4:], m.typ)
+	binary.NativeEndian.PutUint16(b[start+6:], m.flags)
+	binary.NativeEndian.PutUint32(b[start+8:], seq)
+	// NOTE: Port id left to 0, the kernel fills it.
+	return b
+}
+
+// execute sends the given messages at once and waits for the kernel
+// to acknowledge all the ones flagged with NLM_F_ACK.
+// Returns the errors reported by the kernel, if any.
+func (c *Conn) execute(msgs ...message) error {
+	var buf []byte
+	pending := map[uint32]message{}
+	for _, m := range msgs {
+		c.seq++
+		buf = m.encode(buf, c.seq)
+		if m.flags&syscall.NLM_F_ACK != 0 {
+			pending[c.seq] = m
+		}
+	}
+	if err := syscall.Sendto(c.fd, buf, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("netlink send: %w", err)
+	}
+
+	var errs []error
+	// NOTE: Errors echo the faulty message, make sure the buffer is large enough.
+	rbuf := make([]byte, 64*1024) //nolint:mnd // Max netlink message size.
+	for len(pending) > 0 {
+		n, _, err := syscall.Recvfrom(c.fd, rbuf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return fmt.Errorf("netlink recv: %w", err)
+		}
+		replies, err := syscall.ParseNetlinkMessage(rbuf[:n])
+		if err != nil {
+			return fmt.Errorf("parse netlink message: %w", err)
+		}
+		for _, reply := range replies {
+			m, ok := pending[reply.Header.Seq]
+			if !ok || reply.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			delete(pending, reply.Header.Seq)
+			if len(reply.Data) < 4 { //nolint:mnd // Size of the errno.
+				errs = append(errs, fmt.Errorf("netlink message %d: %w", m.typ, syscall.EINVAL))
+				continue
+			}
+			// The error is reported as negative errno, 0 being an ack.
+			if errno := -int32(binary.NativeEndian.Uint32(reply.Data)); errno != 0 { //nolint:gosec // Expected.
+				errs = append(errs, fmt.Errorf("netlink message %d: %w", m.typ, syscall.Errno(errno)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
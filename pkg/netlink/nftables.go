@@ -0,0 +1,292 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// nftables related constants from linux/netfilter/nf_tables.h and linux/netfilter/nfnetlink.h.
+const (
+	nfnlSubsysNFTables = 10
+	nfnlMsgBatchBegin  = 0x10
+	nfnlMsgBatchEnd    = 0x11
+
+	nftMsgNewTable = 0
+	nftMsgDelTable = 2
+	nftMsgNewChain = 3
+	nftMsgNewRule  = 6
+
+	nftaTableName = 1
+
+	nftaChainTable = 1
+	nftaChainName  = 3
+	nftaChainHook  = 4
+	nftaChainType  = 7
+
+	nftaHookHooknum  = 1
+	nftaHookPriority = 2
+
+	nftaRuleTable       = 1
+	nftaRuleChain       = 2
+	nftaRuleExpressions = 4
+
+	nftaListElem = 1
+	nftaExprName = 1
+	nftaExprData = 2
+
+	nftaPayloadDreg   = 1
+	nftaPayloadBase   = 2
+	nftaPayloadOffset = 3
+	nftaPayloadLen    = 4
+
+	nftaBitwiseSreg = 1
+	nftaBitwiseDreg = 2
+	nftaBitwiseLen  = 3
+	nftaBitwiseMask = 4
+	nftaBitwiseXor  = 5
+
+	nftaCmpSreg = 1
+	nftaCmpOp   = 2
+	nftaCmpData = 3
+
+	nftaMetaDreg = 1
+	nftaMetaKey  = 2
+
+	nftaCtDreg = 1
+	nftaCtKey  = 2
+
+	nftaImmediateDreg = 1
+	nftaImmediateData = 2
+
+	nftaDataValue   = 1
+	nftaDataVerdict = 2
+	nftaVerdictCode = 1
+
+	nftRegVerdict           = 0
+	nftReg1                 = 1
+	nftPayloadNetworkHeader = 1
+	nftCmpEq                = 0
+	nftCmpNeq               = 1
+	nftMetaIifname          = 6
+	nftMetaOifname          = 7
+	nftCtState              = 0
+
+	nfCtStateEstablished = 1 << 1 // NF_CT_STATE_BIT(IP_CT_ESTABLISHED).
+	nfCtStateRelated     = 1 << 2 // NF_CT_STATE_BIT(IP_CT_RELATED).
+	nfDrop               = 0
+
+	nfprotoIPv4       = 2
+	nfInetLocalIn     = 1
+	nfInetPostRouting = 4
+	nfIPPriFilter     = 0
+	nfIPPriNATSrc     = 100
+
+	ipv4SaddrOffset = 12
+	ifNameSize      = 16
+)
+
+// nfgenmsg encodes a struct nfgenmsg.
+func nfgenmsg(family uint8, resID uint16) []byte {
+	b := []byte{family, 0 /* NFNETLINK_V0 */, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], resID)
+	return b
+}
+
+// nftMsg creates a nftables message for the IPv4 family.
+func nftMsg(typ, flags uint16, attrs ...attr) message {
+	return message{
+		typ:    nfnlSubsysNFTables<<8 | typ,
+		flags:  syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | flags,
+		header: nfgenmsg(nfprotoIPv4, 0),
+		attrs:  attrs,
+	}
+}
+
+// batch wraps the given nftables messages in a transaction.
+// Either all the messages are applied or none.
+func (c *Conn) batch(msgs ...message) error {
+	begin := message{typ: nfnlMsgBatchBegin, flags: syscall.NLM_F_REQUEST, header: nfgenmsg(syscall.AF_UNSPEC, nfnlSubsysNFTables)}
+	end := message{typ: nfnlMsgBatchEnd, flags: syscall.NLM_F_REQUEST, header: nfgenmsg(syscall.AF_UNSPEC, nfnlSubsysNFTables)}
+	return c.execute(append(append([]message{begin}, msgs...), end)...)
+}
+
+// expr creates a nftables rule expression.
+func expr(name string, data ...attr) attr {
+	if len(data) == 0 {
+		return nested(nftaListElem, strAttr(nftaExprName, name))
+	}
+	return nested(nftaListElem, strAttr(nftaExprName, name), nested(nftaExprData, data...))
+}
+
+// DeleteTable deletes the given IPv4 nftables table, including its chains and rules.
+// Does nothing if the table doesn't exist.
+func (c *Conn) DeleteTable(table string) error {
+	if err := c.batch(nftMsg(nftMsgDelTable, 0, strAttr(nftaTableName, table))); err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("delete nftables table %q: %w", table, err)
+	}
+	return nil
+}
+
+// SetupMasquerade creates the given IPv4 nftables table with a NAT postrouting
+// chain masquerading the traffic from the given subnet, unless it is going
+// out through the given bridge, i.e. the equivalent of:
+//
+//	table ip <table> {
+//		chain postrouting {
+//			type nat hook postrouting priority srcnat;
+//			ip saddr <subnet> oifname != <bridge> masquerade
+//		}
+//	}
+//
+// The table is expected not to exist, see DeleteTable.
+func (c *Conn) SetupMasquerade(table string, subnet *net.IPNet, bridge string) error {
+	ip, mask := subnet.IP.To4(), net.IP(subnet.Mask).To4()
+	if ip == nil || mask == nil {
+		return fmt.Errorf("setup masquerade for %s: only ipv4 is supported: %w", subnet, syscall.EAFNOSUPPORT)
+	}
+	if len(bridge) >= ifNameSize {
+		return fmt.Errorf("setup masquerade for %q: name too long: %w", bridge, syscall.EINVAL)
+	}
+	oifname := make([]byte, ifNameSize)
+	copy(oifname, bridge)
+
+	const chain = "postrouting"
+	if err := c.batch(
+		nftMsg(nftMsgNewTable, syscall.NLM_F_CREATE, strAttr(nftaTableName, table)),
+		nftMsg(nftMsgNewChain, syscall.NLM_F_CREATE,
+			strAttr(nftaChainTable, table),
+			strAttr(nftaChainName, chain),
+			nested(nftaChainHook,
+				be32Attr(nftaHookHooknum, nfInetPostRouting),
+				be32Attr(nftaHookPriority, nfIPPriNATSrc),
+			),
+			strAttr(nftaChainType, "nat"),
+		),
+		nftMsg(nftMsgNewRule, syscall.NLM_F_CREATE|syscall.NLM_F_APPEND,
+			strAttr(nftaRuleTable, table),
+			strAttr(nftaRuleChain, chain),
+			nested(nftaRuleExpressions,
+				// Load the source address in reg1.
+				expr("payload",
+					be32Attr(nftaPayloadDreg, nftReg1),
+					be32Attr(nftaPayloadBase, nftPayloadNetworkHeader),
+					be32Attr(nftaPayloadOffset, ipv4SaddrOffset),
+					be32Attr(nftaPayloadLen, net.IPv4len),
+				),
+				// Apply the subnet mask.
+				expr("bitwise",
+					be32Attr(nftaBitwiseSreg, nftReg1),
+					be32Attr(nftaBitwiseDreg, nftReg1),
+					be32Attr(nftaBitwiseLen, net.IPv4len),
+					nested(nftaBitwiseMask, bytesAttr(nftaDataValue, mask)),
+					nested(nftaBitwiseXor, bytesAttr(nftaDataValue, make([]byte, net.IPv4len))),
+				),
+				// Compare with the subnet.
+				expr("cmp",
+					be32Attr(nftaCmpSreg, nftReg1),
+					be32Attr(nftaCmpOp, nftCmpEq),
+					nested(nftaCmpData, bytesAttr(nftaDataValue, ip.Mask(subnet.Mask))),
+				),
+				// Load the output interface name in reg1.
+				expr("meta",
+					be32Attr(nftaMetaDreg, nftReg1),
+					be32Attr(nftaMetaKey, nftMetaOifname),
+				),
+				// Skip the traffic staying on the bridge.
+				expr("cmp",
+					be32Attr(nftaCmpSreg, nftReg1),
+					be32Attr(nftaCmpOp, nftCmpNeq),
+					nested(nftaCmpData, bytesAttr(nftaDataValue, oifname)),
+				),
+				expr("masq"),
+			),
+		),
+	); err != nil {
+		return fmt.Errorf("setup masquerade in nftables table %q: %w", table, err)
+	}
+	return nil
+}
+
+// SetupHostFilter creates, if needed, the given IPv4 nftables table with a filter input
+// chain dropping the traffic coming from the given bridge, unless part of a connection
+// initiated by the host, i.e. the equivalent of:
+//
+//	table ip <table> {
+//		chain input {
+//			type filter hook input priority filter;
+//			iifname <bridge> ct state != { established, related } drop
+//		}
+//	}
+//
+// As the input hook sees the traffic to all the local addresses, the jobs can't reach
+// any service of the host, only be reached through the published ports.
+func (c *Conn) SetupHostFilter(table, bridge string) error {
+	if len(bridge) >= ifNameSize {
+		return fmt.Errorf("setup host filter for %q: name too long: %w", bridge, syscall.EINVAL)
+	}
+	iifname := make([]byte, ifNameSize)
+	copy(iifname, bridge)
+
+	const chain = "input"
+	if err := c.batch(
+		nftMsg(nftMsgNewTable, syscall.NLM_F_CREATE, strAttr(nftaTableName, table)),
+		nftMsg(nftMsgNewChain, syscall.NLM_F_CREATE,
+			strAttr(nftaChainTable, table),
+			strAttr(nftaChainName, chain),
+			nested(nftaChainHook,
+				be32Attr(nftaHookHooknum, nfInetLocalIn),
+				be32Attr(nftaHookPriority, nfIPPriFilter),
+			),
+			strAttr(nftaChainType, "filter"),
+		),
+		nftMsg(nftMsgNewRule, syscall.NLM_F_CREATE|syscall.NLM_F_APPEND,
+			strAttr(nftaRuleTable, table),
+			strAttr(nftaRuleChain, chain),
+			nested(nftaRuleExpressions,
+				// Load the input interface name in reg1.
+				expr("meta",
+					be32Attr(nftaMetaDreg, nftReg1),
+					be32Attr(nftaMetaKey, nftMetaIifname),
+				),
+				// Only the traffic coming from the bridge.
+				expr("cmp",
+					be32Attr(nftaCmpSreg, nftReg1),
+					be32Attr(nftaCmpOp, nftCmpEq),
+					nested(nftaCmpData, bytesAttr(nftaDataValue, iifname)),
+				),
+				// Load the connection tracking state in reg1.
+				expr("ct",
+					be32Attr(nftaCtDreg, nftReg1),
+					be32Attr(nftaCtKey, nftCtState),
+				),
+				// Keep the established and related bits.
+				expr("bitwise",
+					be32Attr(nftaBitwiseSreg, nftReg1),
+					be32Attr(nftaBitwiseDreg, nftReg1),
+					be32Attr(nftaBitwiseLen, 4),
+					nested(nftaBitwiseMask, u32Attr(nftaDataValue, nfCtStateEstablished|nfCtStateRelated)),
+					nested(nftaBitwiseXor, u32Attr(nftaDataValue, 0)),
+				),
+				// Neither of them, i.e. new or invalid.
+				expr("cmp",
+					be32Attr(nftaCmpSreg, nftReg1),
+					be32Attr(nftaCmpOp, nftCmpEq),
+					nested(nftaCmpData, u32Attr(nftaDataValue, 0)),
+				),
+				expr("immediate",
+					be32Attr(nftaImmediateDreg, nftRegVerdict),
+					nested(nftaImmediateData, nested(nftaDataVerdict, be32Attr(nftaVerdictCode, nfDrop))),
+				),
+			),
+		),
+	); err != nil {
+		return fmt.Errorf("setup host filter in nftables table %q: %w", table, err)
+	}
+	return nil
+}
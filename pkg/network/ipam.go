@@ -0,0 +1,59 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ipamState is the persisted IP allocation state.
+type ipamState struct {
+	Subnet      string            `json:"subnet"`
+	Allocations map[string]string `json:"allocations"` // Job ID -> IP.
+}
+
+// ipam is a simple sequential IPv4 allocator within a subnet.
+// The first address of the subnet is reserved for the gateway.
+// NOTE: Not locked, expected to be used under the manager's lock.
+type ipam struct {
+	subnet *net.IPNet
+	state  ipamState
+}
+
+func ip2int(ip net.IP) uint32 { return binary.BigEndian.Uint32(ip.To4()) }
+func int2ip(n uint32) net.IP  { return binary.BigEndian.AppendUint32(nil, n) }
+
+// gateway returns the first usable address of the subnet.
+func (i *ipam) gateway() net.IP {
+	return int2ip(ip2int(i.subnet.IP) + 1)
+}
+
+// allocate returns the IP for the given key, allocating a new one if needed.
+func (i *ipam) allocate(key string) (net.IP, error) {
+	if ip, ok := i.state.Allocations[key]; ok {
+		return net.ParseIP(ip).To4(), nil
+	}
+
+	used := make(map[string]struct{}, len(i.state.Allocations))
+	for _, ip := range i.state.Allocations {
+		used[ip] = struct{}{}
+	}
+
+	ones, bits := i.subnet.Mask.Size()
+	first := ip2int(i.subnet.IP)
+	last := first + uint32(1)<<(bits-ones) - 1 // Broadcast.
+	// Skip the network address, the gateway and the broadcast.
+	for n := first + 2; n < last; n++ {
+		ip := int2ip(n)
+		if _, ok := used[ip.String()]; ok {
+			continue
+		}
+		i.state.Allocations[key] = ip.String()
+		return ip, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSubnetExhausted, i.subnet)
+}
+
+func (i *ipam) release(key string) {
+	delete(i.state.Allocations, key)
+}
@@ -0,0 +1,286 @@
+// Package network manages the jobs networking: a host bridge masquerading the
+// traffic via nftables, and a veth pair per job with an IP allocated from a
+// configurable subnet.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+
+	"go.creack.net/telepilot/pkg/netlink"
+	"go.creack.net/telepilot/pkg/statefile"
+)
+
+// Common errors.
+var (
+	ErrSubnetExhausted = errors.New("no IP available in subnet")
+	ErrInvalidConfig   = errors.New("invalid network config")
+)
+
+const (
+	// JobInterface is the name of the interface within the job.
+	JobInterface = "eth0"
+
+	stateFile = "network.json"
+
+	ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+)
+
+// Config for the job networking.
+type Config struct {
+	// Name of the host bridge. Also used as nftables table name.
+	Bridge string
+	// Subnet for the jobs. The first address is assigned to the bridge and used as gateway.
+	Subnet *net.IPNet
+	// Directory where the IP allocations are persisted.
+	StateDir string
+}
+
+// Attachment describes the network of a job, from within the job.
+type Attachment struct {
+	Interface string
+	Address   *net.IPNet
+	Gateway   net.IP
+}
+
+// Manager is the network controller.
+type Manager struct {
+	mu sync.Mutex
+
+	cfg  Config
+	ipam *ipam
+}
+
+// NewManager loads the persisted IP allocations and sets up the host side of the network,
+// i.e. the bridge and the NAT rules. Safe to call on an already setup host.
+func NewManager(cfg Config) (*Manager, error) {
+	if cfg.Bridge == "" || len(cfg.Bridge) >= syscall.IFNAMSIZ {
+		return nil, fmt.Errorf("%w: bridge name must be between 1 and %d characters", ErrInvalidConfig, syscall.IFNAMSIZ-1)
+	}
+	if cfg.Subnet == nil || cfg.Subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("%w: subnet must be ipv4", ErrInvalidConfig)
+	}
+	if ones, bits := cfg.Subnet.Mask.Size(); bits != 8*net.IPv4len || ones > 30 { //nolint:mnd // Need at least 4 addresses.
+		return nil, fmt.Errorf("%w: subnet must be at most /30", ErrInvalidConfig)
+	}
+	subnet := &net.IPNet{IP: cfg.Subnet.IP.Mask(cfg.Subnet.Mask).To4(), Mask: cfg.Subnet.Mask}
+
+	m := &Manager{
+		cfg: cfg,
+		ipam: &ipam{
+			subnet: subnet,
+			state:  ipamState{Subnet: subnet.String(), Allocations: map[string]string{}},
+		},
+	}
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	if err := m.setupHost(); err != nil {
+		return nil, fmt.Errorf("setup host network: %w", err)
+	}
+	return m, nil
+}
+
+// loadState loads the persisted allocations and drops the ones for which
+// the job is gone, i.e. when the host side of the veth pair doesn't exist anymore.
+func (m *Manager) loadState() error {
+	var state ipamState
+	if err := statefile.Load(filepath.Join(m.cfg.StateDir, stateFile), &state); err != nil {
+		return fmt.Errorf("load ipam state: %w", err)
+	}
+	if state.Subnet != m.ipam.state.Subnet {
+		if len(state.Allocations) != 0 {
+			slog.Warn("Subnet changed, discarding the previous IP allocations.",
+				"old_subnet", state.Subnet, "new_subnet", m.ipam.state.Subnet)
+		}
+		return nil
+	}
+	for jobID, ip := range state.Allocations {
+		id, err := uuid.Parse(jobID)
+		if err != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(hostInterface(id)); err != nil {
+			continue
+		}
+		m.ipam.state.Allocations[jobID] = ip
+	}
+	return m.saveState()
+}
+
+// NOTE: Expected to be called with the lock held.
+func (m *Manager) saveState() error {
+	if err := statefile.Save(filepath.Join(m.cfg.StateDir, stateFile), m.ipam.state); err != nil {
+		return fmt.Errorf("save ipam state: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) setupHost() error {
+	conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("dial rtnetlink: %w", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	if err := conn.CreateBridge(m.cfg.Bridge); err != nil && !errors.Is(err, syscall.EEXIST) {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	ones, bits := m.ipam.subnet.Mask.Size()
+	gateway := &net.IPNet{IP: m.ipam.gateway(), Mask: net.CIDRMask(ones, bits)}
+	if err := conn.AddAddress(m.cfg.Bridge, gateway); err != nil && !errors.Is(err, syscall.EEXIST) {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.SetUp(m.cfg.Bridge); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+
+	// Enable routing.
+	if err := os.WriteFile(ipForwardPath, []byte("1"), 0); err != nil {
+		return fmt.Errorf("enable ip forward: %w", err)
+	}
+
+	// (Re)create the NAT and filter rules.
+	nfconn, err := netlink.Dial(syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("dial nfnetlink: %w", err)
+	}
+	defer func() { _ = nfconn.Close() }() // Best effort.
+	if err := nfconn.DeleteTable(m.cfg.Bridge); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := nfconn.SetupMasquerade(m.cfg.Bridge, m.ipam.subnet, m.cfg.Bridge); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := nfconn.SetupHostFilter(m.cfg.Bridge, m.cfg.Bridge); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	return nil
+}
+
+// Teardown removes the NAT rules and the bridge.
+//
+// NOTE: Not called when the server stops as the jobs can outlive it.
+// The setup being idempotent, the network is picked up again on restart.
+func (m *Manager) Teardown() error {
+	nfconn, err := netlink.Dial(syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("dial nfnetlink: %w", err)
+	}
+	defer func() { _ = nfconn.Close() }() // Best effort.
+	conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("dial rtnetlink: %w", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	return errors.Join(nfconn.DeleteTable(m.cfg.Bridge), conn.DeleteLink(m.cfg.Bridge))
+}
+
+// hostInterface returns the name of the host side of the veth pair for the given job.
+// Derived from the job id to be able to look it up after a restart.
+func hostInterface(jobID uuid.UUID) string {
+	return "tp" + strings.ReplaceAll(jobID.String(), "-", "")[:12]
+}
+
+// Attach allocates an IP for the given job and creates the veth pair, the host side being
+// attached to the bridge while the job side is moved to the network namespace of the given pid.
+// The job side is expected to be configured from within the namespace with the returned attachment.
+// Can be called again for the same job, i.e. when it restarts, keeping its IP.
+func (m *Manager) Attach(jobID uuid.UUID, pid int) (*Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip, err := m.ipam.allocate(jobID.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveState(); err != nil {
+		m.ipam.release(jobID.String())
+		return nil, err
+	}
+
+	// Remove what may be left from a previous attempt of the job, i.e. when restarting
+	// before the kernel removed the veth pair of the previous network namespace.
+	if _, err := net.InterfaceByName(hostInterface(jobID)); err == nil {
+		_ = m.deleteLink(hostInterface(jobID)) // Best effort, creating the new pair reports the error.
+	}
+	if err := m.createVeth(hostInterface(jobID), pid); err != nil {
+		m.ipam.release(jobID.String())
+		_ = m.saveState() // Best effort.
+		return nil, err
+	}
+
+	return &Attachment{
+		Interface: JobInterface,
+		Address:   &net.IPNet{IP: ip, Mask: m.ipam.subnet.Mask},
+		Gateway:   m.ipam.gateway(),
+	}, nil
+}
+
+func (m *Manager) createVeth(hostIface string, pid int) error {
+	conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("dial rtnetlink: %w", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	if err := conn.CreateVethPair(hostIface, JobInterface, pid); err != nil {
+		return err //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.SetMaster(hostIface, m.cfg.Bridge); err != nil {
+		_ = conn.DeleteLink(hostIface) // Best effort.
+		return err                     //nolint:wrapcheck // Already wrapped.
+	}
+	// The jobs can't reach each other.
+	if err := conn.SetIsolated(hostIface); err != nil {
+		_ = conn.DeleteLink(hostIface) // Best effort.
+		return err                     //nolint:wrapcheck // Already wrapped.
+	}
+	if err := conn.SetUp(hostIface); err != nil {
+		_ = conn.DeleteLink(hostIface) // Best effort.
+		return err                     //nolint:wrapcheck // Already wrapped.
+	}
+	return nil
+}
+
+// Detach removes the veth pair of the given job, if still present, and releases its IP.
+func (m *Manager) Detach(jobID uuid.UUID) {
+	logger := slog.With("job_id", jobID.String())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTE: When the network namespace is gone, so is the veth pair. Only cleanup if still there.
+	if _, err := net.InterfaceByName(hostInterface(jobID)); err == nil {
+		if err := m.deleteLink(hostInterface(jobID)); err != nil {
+			// Best effort.
+			logger.Warn("Failed to remove job veth pair.", "error", err)
+		}
+	}
+
+	m.ipam.release(jobID.String())
+	if err := m.saveState(); err != nil {
+		// Best effort.
+		logger.Error("Failed to persist IP release.", "error", err)
+	}
+}
+
+func (m *Manager) deleteLink(name string) error {
+	conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("dial rtnetlink: %w", err)
+	}
+	defer func() { _ = conn.Close() }() // Best effort.
+
+	return conn.DeleteLink(name) //nolint:wrapcheck // Already wrapped.
+}
@@ -0,0 +1,112 @@
+// Package nsenter provides helpers to run code within the namespaces of another process.
+//
+// NOTE: Only the namespaces which can be joined by a multi-threaded process are supported by Do,
+// i.e. not the mount or user namespaces which require a single threaded caller. The mount namespace
+// can still be joined by a single thread about to exec, see JoinMount.
+package nsenter
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// Namespace is a namespace which can be joined from a Go program.
+type Namespace string
+
+// Supported namespaces.
+const (
+	Net    Namespace = "net"
+	UTS    Namespace = "uts"
+	IPC    Namespace = "ipc"
+	Cgroup Namespace = "cgroup"
+	// PID only impacts the children, the calling thread stays in its own PID namespace.
+	PID Namespace = "pid"
+)
+
+func (ns Namespace) cloneFlag() (int, error) {
+	switch ns {
+	case Net:
+		return syscall.CLONE_NEWNET, nil
+	case UTS:
+		return syscall.CLONE_NEWUTS, nil
+	case IPC:
+		return syscall.CLONE_NEWIPC, nil
+	case Cgroup:
+		return syscall.CLONE_NEWCGROUP, nil
+	case PID:
+		return syscall.CLONE_NEWPID, nil
+	default:
+		return 0, fmt.Errorf("unsupported namespace %q: %w", ns, syscall.EINVAL)
+	}
+}
+
+// Do runs fn on a dedicated OS thread which joined the given namespaces of the given pid.
+// Anything created by fn within the namespace stays there, i.e. sockets or child processes.
+//
+// As the thread can't be safely reused afterwards, it is never unlocked and
+// gets destroyed by the runtime when fn returns.
+// fn must not start goroutines expecting to be in the namespace, they won't be.
+func Do(pid int, namespaces []Namespace, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// NOTE: No Unlock on purpose, the thread is tainted. Exiting the goroutine
+		// while locked terminates the thread.
+
+		if err := join(pid, namespaces); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
+
+// JoinMount joins the given mount namespace, i.e. /proc/<pid>/ns/mnt, for the calling thread only.
+// The threads of a Go program share their filesystem attributes (root, cwd), which prevents joining
+// a mount namespace, so the thread gets its own first: only it, and the process it executes, see the namespace.
+// The root and working directory become the namespace's root.
+//
+// NOTE: Expected to be called on a locked thread, never unlocked, right before exec.
+func JoinMount(f *os.File) error {
+	if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+		return fmt.Errorf("unshare filesystem attributes: %w", err)
+	}
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), syscall.CLONE_NEWNS, 0); errno != 0 {
+		return fmt.Errorf("setns mnt: %w", errno)
+	}
+	return nil
+}
+
+// join the namespaces of the given pid for the current thread.
+// NOTE: Expected to be called on a locked thread.
+func join(pid int, namespaces []Namespace) error {
+	// Open all the namespaces first, once we joined the PID namespace
+	// we may not be able to access the other ones.
+	files := make([]*os.File, 0, len(namespaces))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close() // Best effort.
+		}
+	}()
+	for _, ns := range namespaces {
+		f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/ns/" + string(ns))
+		if err != nil {
+			return fmt.Errorf("open %s namespace: %w", ns, err)
+		}
+		files = append(files, f)
+	}
+	for i, ns := range namespaces {
+		flag, err := ns.cloneFlag()
+		if err != nil {
+			return err
+		}
+		if _, _, errno := syscall.RawSyscall(sysSetns, files[i].Fd(), uintptr(flag), 0); errno != 0 {
+			return fmt.Errorf("setns %s: %w", ns, errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+package nsenter
+
+// Missing from the syscall package on amd64.
+const sysSetns = 308
@@ -0,0 +1,5 @@
+package nsenter
+
+import "syscall"
+
+const sysSetns = syscall.SYS_SETNS
@@ -0,0 +1,433 @@
+// Package portproxy publishes the jobs ports on the host using a userspace TCP/UDP proxy.
+// Connections are dialed from within the job's network namespace to its loopback,
+// so it works regardless of the job's network mode.
+package portproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.creack.net/telepilot/pkg/nsenter"
+)
+
+// Common errors.
+var (
+	ErrInvalidMapping = errors.New("invalid port mapping")
+	ErrPortNotAllowed = errors.New("host port not allowed")
+	ErrPortInUse      = errors.New("host port already published")
+)
+
+const (
+	dialTimeout    = 5 * time.Second
+	udpIdleTimeout = time.Minute
+	maxDatagram    = 64 * 1024
+)
+
+// Protocol of a published port.
+type Protocol int
+
+// Supported protocols.
+const (
+	TCP Protocol = iota
+	UDP
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	default:
+		return "unknown(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// Mapping publishes the job port on the host port.
+type Mapping struct {
+	HostPort uint16
+	JobPort  uint16
+	Protocol Protocol
+}
+
+func (m Mapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", m.HostPort, m.JobPort, m.Protocol)
+}
+
+// Config for the port publishing.
+type Config struct {
+	// Host address to listen on. Empty for all the addresses.
+	Address string
+	// Host port ranges allowed per user, AnyUser applying to everyone.
+	// Publishing is denied when none match.
+	AllowedRanges map[string][]PortRange
+}
+
+// hostPort identifies a published port on the host.
+type hostPort struct {
+	port     uint16
+	protocol Protocol
+}
+
+// Manager keeps track of the published ports across the jobs.
+type Manager struct {
+	cfg Config
+
+	mu   sync.Mutex
+	used map[hostPort]string // Value is the job id.
+}
+
+// NewManager instantiates the port manager.
+func NewManager(cfg Config) *Manager {
+	return &Manager{cfg: cfg, used: map[hostPort]string{}}
+}
+
+// allowed checks if the given user can publish the given host port.
+func (m *Manager) allowed(user string, port uint16) bool {
+	for _, key := range []string{user, AnyUser} {
+		for _, r := range m.cfg.AllowedRanges[key] {
+			if r.Contains(port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Publish validates, reserves and binds the host ports for the given job.
+// Incoming connections are queued until Start is called.
+func (m *Manager) Publish(owner, jobID string, mappings []Mapping) (*Publication, error) {
+	for _, mapping := range mappings {
+		if mapping.HostPort == 0 || mapping.JobPort == 0 || mapping.Protocol != TCP && mapping.Protocol != UDP {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMapping, mapping)
+		}
+		if !m.allowed(owner, mapping.HostPort) {
+			return nil, fmt.Errorf("%w: %s", ErrPortNotAllowed, mapping)
+		}
+	}
+
+	p := &Publication{m: m}
+	if err := m.reserve(jobID, p, mappings); err != nil {
+		return nil, err
+	}
+	for _, mapping := range mappings {
+		addr := net.JoinHostPort(m.cfg.Address, strconv.Itoa(int(mapping.HostPort)))
+		var (
+			px  proxy
+			err error
+		)
+		if mapping.Protocol == UDP {
+			px, err = listenUDP(addr, mapping.JobPort)
+		} else {
+			px, err = listenTCP(addr, mapping.JobPort)
+		}
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("publish %s: %w", mapping, err)
+		}
+		p.proxies = append(p.proxies, px)
+	}
+	return p, nil
+}
+
+// reserve the host ports, failing if any is already published, by this job or another one.
+func (m *Manager) reserve(jobID string, p *Publication, mappings []Mapping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mapping := range mappings {
+		k := hostPort{port: mapping.HostPort, protocol: mapping.Protocol}
+		if other, ok := m.used[k]; ok {
+			m.releaseLocked(p.reserved)
+			p.reserved = nil
+			return fmt.Errorf("%w: %s by job %s", ErrPortInUse, mapping, other)
+		}
+		m.used[k] = jobID
+		p.reserved = append(p.reserved, k)
+	}
+	return nil
+}
+
+// NOTE: Expected to be called with the lock held.
+func (m *Manager) releaseLocked(keys []hostPort) {
+	for _, k := range keys {
+		delete(m.used, k)
+	}
+}
+
+// Publication is the set of ports published for a job.
+type Publication struct {
+	m *Manager
+
+	reserved []hostPort
+	proxies  []proxy
+
+	// Pid of the job process whose network namespace is the target, updated when the job restarts.
+	pid atomic.Int64
+
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// Start forwarding the connections to the loopback of the network namespace of the given pid.
+// Can be called again with a new pid, i.e. when the job restarts, the new connections then go to it.
+func (p *Publication) Start(pid int) {
+	p.pid.Store(int64(pid))
+	p.startOnce.Do(func() {
+		dial := func(network string, port uint16) (net.Conn, error) {
+			return DialInNamespace(int(p.pid.Load()), network, port)
+		}
+		for _, px := range p.proxies {
+			go px.serve(dial)
+		}
+	})
+}
+
+// Close stops the listeners, closes the active connections and releases the host ports.
+func (p *Publication) Close() {
+	p.closeOnce.Do(func() {
+		for _, px := range p.proxies {
+			px.close()
+		}
+		p.m.mu.Lock()
+		p.m.releaseLocked(p.reserved)
+		p.m.mu.Unlock()
+	})
+}
+
+// DialInNamespace connects to the given port on the loopback of the network namespace of the given pid.
+//
+// NOTE: Spawns a dedicated OS thread for each call as it can't be reused once in the namespace.
+// The socket keeps the namespace it has been created in, so it can be used from anywhere afterwards.
+func DialInNamespace(pid int, network string, port uint16) (net.Conn, error) {
+	var conn net.Conn
+	if err := nsenter.Do(pid, []nsenter.Namespace{nsenter.Net}, func() error {
+		// NOTE: Using an IP literal, the dialer doesn't spawn goroutines which would not be in the namespace.
+		c, err := net.DialTimeout(network, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), dialTimeout)
+		conn = c
+		return err //nolint:wrapcheck // Wrapped below.
+	}); err != nil {
+		return nil, fmt.Errorf("dial job %s port %d: %w", network, port, err)
+	}
+	return conn, nil
+}
+
+type dialFunc func(network string, port uint16) (net.Conn, error)
+
+type proxy interface {
+	serve(dial dialFunc)
+	close()
+}
+
+// connSet tracks the active connections to close them on teardown.
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// add the connection, returns false if the set is already closed.
+func (s *connSet) add(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]struct{}{}
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *connSet) remove(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *connSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close() // Best effort.
+	}
+	s.conns = nil
+}
+
+type tcpProxy struct {
+	ln      net.Listener
+	jobPort uint16
+	conns   connSet
+}
+
+func listenTCP(addr string, jobPort uint16) (*tcpProxy, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return &tcpProxy{ln: ln, jobPort: jobPort}, nil
+}
+
+func (p *tcpProxy) serve(dial dialFunc) {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Port proxy accept failed.", "addr", p.ln.Addr().String(), "error", err)
+			}
+			return
+		}
+		go p.handle(conn, dial)
+	}
+}
+
+func (p *tcpProxy) handle(conn net.Conn, dial dialFunc) {
+	if !p.conns.add(conn) {
+		_ = conn.Close() // Best effort.
+		return
+	}
+	defer func() { p.conns.remove(conn); _ = conn.Close() }() // Best effort.
+
+	upstream, err := dial("tcp", p.jobPort)
+	if err != nil {
+		slog.Debug("Port proxy dial failed.", "error", err)
+		return
+	}
+	if !p.conns.add(upstream) {
+		_ = upstream.Close() // Best effort.
+		return
+	}
+	defer func() { p.conns.remove(upstream); _ = upstream.Close() }() // Best effort.
+
+	Pipe(conn, upstream)
+}
+
+func (p *tcpProxy) close() {
+	_ = p.ln.Close() // Best effort.
+	p.conns.close()
+}
+
+// Pipe copies the data both ways until both sides are done, propagating the half-close.
+func Pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	cp := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src) // Best effort, errors are expected when the other side goes away.
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite() // Best effort.
+		} else {
+			_ = dst.Close() // Best effort.
+		}
+	}
+	wg.Add(2) //nolint:mnd // Both ways.
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
+
+type udpProxy struct {
+	conn    *net.UDPConn
+	jobPort uint16
+
+	mu       sync.Mutex
+	sessions map[string]net.Conn // Keyed by client address.
+	conns    connSet
+}
+
+func listenUDP(addr string, jobPort uint16) (*udpProxy, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return &udpProxy{conn: conn, jobPort: jobPort, sessions: map[string]net.Conn{}}, nil
+}
+
+func (p *udpProxy) serve(dial dialFunc) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Port proxy read failed.", "addr", p.conn.LocalAddr().String(), "error", err)
+			}
+			return
+		}
+		upstream, err := p.session(client, dial)
+		if err != nil {
+			slog.Debug("Port proxy dial failed.", "error", err)
+			continue
+		}
+		_ = upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout)) // Best effort.
+		if _, err := upstream.Write(buf[:n]); err != nil {
+			slog.Debug("Port proxy write failed.", "error", err)
+		}
+	}
+}
+
+// session returns the upstream connection for the given client, creating it if needed.
+// Each session gets its own socket so the replies can be routed back to the client.
+func (p *udpProxy) session(client *net.UDPAddr, dial dialFunc) (net.Conn, error) {
+	key := client.String()
+	p.mu.Lock()
+	upstream, ok := p.sessions[key]
+	p.mu.Unlock()
+	if ok {
+		return upstream, nil
+	}
+
+	upstream, err := dial("udp", p.jobPort)
+	if err != nil {
+		return nil, err
+	}
+	if !p.conns.add(upstream) {
+		_ = upstream.Close() // Best effort.
+		return nil, net.ErrClosed
+	}
+	p.mu.Lock()
+	p.sessions[key] = upstream
+	p.mu.Unlock()
+
+	go p.reply(key, client, upstream)
+	return upstream, nil
+}
+
+// reply forwards the datagrams from the job back to the client until the session is idle.
+func (p *udpProxy) reply(key string, client *net.UDPAddr, upstream net.Conn) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.sessions, key)
+		p.mu.Unlock()
+		p.conns.remove(upstream)
+		_ = upstream.Close() // Best effort.
+	}()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		_ = upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout)) // Best effort.
+		n, err := upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := p.conn.WriteToUDP(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+func (p *udpProxy) close() {
+	_ = p.conn.Close() // Best effort.
+	p.conns.close()
+}
@@ -0,0 +1,75 @@
+package portproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AnyUser is the key of the ranges allowed to every user.
+const AnyUser = "*"
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	First, Last uint16
+}
+
+// Contains checks if the port is within the range.
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.First && port <= r.Last
+}
+
+// ParseAllowedRanges parses the per-user allowed host port ranges.
+// Format: `user=range[,range...][;user=...]` with range being either a single
+// port or `first-last`. The user `*` applies to everyone.
+// i.e. `alice=8000-8099,9000;*=30000-30999`.
+func ParseAllowedRanges(s string) (map[string][]PortRange, error) {
+	out := map[string][]PortRange{}
+	if s == "" {
+		return out, nil
+	}
+	for _, entry := range strings.Split(s, ";") {
+		user, ranges, ok := strings.Cut(entry, "=")
+		if !ok || user == "" || ranges == "" {
+			return nil, fmt.Errorf("invalid port range entry %q, expect user=ranges", entry) //nolint:err113 // No need for fancy error here.
+		}
+		for _, elem := range strings.Split(ranges, ",") {
+			r, err := parseRange(elem)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port range for %q: %w", user, err)
+			}
+			out[user] = append(out[user], r)
+		}
+	}
+	return out, nil
+}
+
+func parseRange(s string) (PortRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	start, err := parsePort(first)
+	if err != nil {
+		return PortRange{}, err
+	}
+	end, err := parsePort(last)
+	if err != nil {
+		return PortRange{}, err
+	}
+	if start > end {
+		return PortRange{}, fmt.Errorf("%q: first port greater than last", s) //nolint:err113 // No need for fancy error here.
+	}
+	return PortRange{First: start, Last: end}, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("parse port %q: %w", s, err)
+	}
+	if port == 0 {
+		return 0, fmt.Errorf("invalid port 0") //nolint:err113 // No need for fancy error here.
+	}
+	return uint16(port), nil
+}
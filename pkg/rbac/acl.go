@@ -0,0 +1,83 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Common errors.
+var (
+	ErrInvalidACL = errors.New("invalid acl")
+)
+
+// ACLRole is the role reported for the calls granted by the ACL of the job.
+const ACLRole = "acl"
+
+// maxACLEntries bounds the ACL of a job.
+const maxACLEntries = 64
+
+// Permission on a job, granted to other users by its ACL.
+type Permission string
+
+// Available permissions.
+const (
+	PermissionReadLogs   Permission = "read_logs"   // StreamLogs.
+	PermissionReadStatus Permission = "read_status" // GetJobStatus.
+	PermissionSignal     Permission = "signal"      // SignalJob.
+	PermissionStop       Permission = "stop"        // StopJob.
+)
+
+// permissionMethods are the methods granted by each permission.
+//
+//nolint:gochecknoglobals // Expected global.
+var permissionMethods = map[Permission]string{
+	PermissionReadLogs:   "StreamLogs",
+	PermissionReadStatus: "GetJobStatus",
+	PermissionSignal:     "SignalJob",
+	PermissionStop:       "StopJob",
+}
+
+// ACLEntry grants permissions on a job to a user or a group.
+type ACLEntry struct {
+	User        string       `json:"user,omitempty"`
+	Group       string       `json:"group,omitempty"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// ACL is the access control list of a job, set by its owner.
+type ACL []ACLEntry
+
+// Validate the ACL: each entry is for either a user or a group, with known permissions.
+func (acl ACL) Validate() error {
+	if len(acl) > maxACLEntries {
+		return fmt.Errorf("%w: too many entries, max %d", ErrInvalidACL, maxACLEntries)
+	}
+	for i, e := range acl {
+		if (e.User == "") == (e.Group == "") {
+			return fmt.Errorf("%w: entry %d: expect either a user or a group", ErrInvalidACL, i)
+		}
+		if len(e.Permissions) == 0 {
+			return fmt.Errorf("%w: entry %d: no permissions", ErrInvalidACL, i)
+		}
+		for _, perm := range e.Permissions {
+			if _, ok := permissionMethods[perm]; !ok {
+				return fmt.Errorf("%w: entry %d: unknown permission %q", ErrInvalidACL, i, perm)
+			}
+		}
+	}
+	return nil
+}
+
+// grants checks whether the ACL grants the given method to the user or one of the given groups.
+func (acl ACL) grants(method, user string, userGroups []string) bool {
+	for _, e := range acl {
+		if e.User != "" && e.User != user || e.Group != "" && !slices.Contains(userGroups, e.Group) {
+			continue
+		}
+		if slices.ContainsFunc(e.Permissions, func(perm Permission) bool { return permissionMethods[perm] == method }) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Command is a command run by the request, i.e. the job to start.
+type Command struct {
+	Path string
+	Args []string
+}
+
+// CommandRule allows a command in a rule.
+type CommandRule struct {
+	// Absolute path or glob, i.e. "/usr/bin/*", matched against the command as requested.
+	Path string `json:"path"`
+	// Optional regexes, each argument must fully match one of them. Any argument when empty.
+	Args []string `json:"args,omitempty"`
+
+	args []*regexp.Regexp // Compiled Args, set by compile.
+}
+
+// compile validates the command rule, an absolute path or glob and valid regexes, then compiles the regexes.
+func (c *CommandRule) compile() error {
+	if c.Path == "" {
+		return errors.New("empty command path") //nolint:err113 // Wrapped by the caller.
+	}
+	if !path.IsAbs(c.Path) {
+		// A relative command would be resolved through the PATH of the job, after authorization.
+		return fmt.Errorf("command %q: expect an absolute path", c.Path) //nolint:err113 // Wrapped by the caller.
+	}
+	if _, err := path.Match(c.Path, ""); err != nil {
+		return fmt.Errorf("command %q: invalid glob: %w", c.Path, err)
+	}
+	c.args = make([]*regexp.Regexp, 0, len(c.Args))
+	for _, arg := range c.Args {
+		re, err := regexp.Compile(anchored(arg))
+		if err != nil {
+			return fmt.Errorf("command %q: invalid args regex: %w", c.Path, err)
+		}
+		c.args = append(c.args, re)
+	}
+	return nil
+}
+
+// anchored makes the regex match the whole argument.
+func anchored(re string) string {
+	return "^(?:" + re + ")$"
+}
+
+// match checks whether the command rule allows the given command.
+//
+// NOTE: Expected to be compiled first, the rules with args not compiled don't match anything.
+func (c CommandRule) match(cmd Command) bool {
+	if ok, _ := path.Match(c.Path, cmd.Path); !ok { // Validated glob, no error.
+		return false
+	}
+	if len(c.Args) == 0 {
+		return true
+	}
+	if len(c.args) != len(c.Args) {
+		return false
+	}
+	return !slices.ContainsFunc(cmd.Args, func(arg string) bool {
+		return !slices.ContainsFunc(c.args, func(re *regexp.Regexp) bool { return re.MatchString(arg) })
+	})
+}
+
+// allowedCommands checks whether the rule allows all the given commands. Returns why not otherwise.
+// The rules without commands allow any.
+func (r Rule) allowedCommands(cmds []Command) (string, bool) {
+	if len(r.Commands) == 0 {
+		return "", true
+	}
+	for _, cmd := range cmds {
+		// The globs would match the likes of "/usr/bin/..", and a relative path is resolved in the job, after authorization.
+		if !path.IsAbs(cmd.Path) || path.Clean(cmd.Path) != cmd.Path {
+			return fmt.Sprintf("command %q is not an absolute and clean path", cmd.Path), false
+		}
+		if !slices.ContainsFunc(r.Commands, func(c CommandRule) bool { return c.match(cmd) }) {
+			allowed := make([]string, 0, len(r.Commands))
+			for _, c := range r.Commands {
+				allowed = append(allowed, c.Path)
+			}
+			return fmt.Sprintf("command %q with args %q not in the allowed commands (%s)",
+				cmd.Path, cmd.Args, strings.Join(allowed, ", ")), false
+		}
+	}
+	return "", true
+}
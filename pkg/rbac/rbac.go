@@ -0,0 +1,289 @@
+// Package rbac implements the role based access control of the API, loaded from a policy file.
+package rbac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Common errors.
+var (
+	ErrInvalidPolicy = errors.New("invalid policy")
+	ErrForbidden     = errors.New("forbidden")
+)
+
+// Wildcard matches all the methods in a rule, everyone in a binding.
+const Wildcard = "*"
+
+// Scope is the set of jobs a rule applies to. Ignored for the methods not targeting a job.
+type Scope string
+
+// Available scopes.
+const (
+	// ScopeOwn applies to the jobs owned by the user (default).
+	ScopeOwn Scope = "own"
+	// ScopeGroup applies to the jobs whose owner shares a group with the user, the user's own jobs included.
+	ScopeGroup Scope = "group"
+	// ScopeAll applies to all the jobs.
+	ScopeAll Scope = "all"
+)
+
+// Rule grants access to a set of methods.
+type Rule struct {
+	// Short RPC names, i.e. "StopJob", or Wildcard for all.
+	Methods []string `json:"methods"`
+	// Jobs the rule applies to. Defaults to ScopeOwn.
+	Scope Scope `json:"scope,omitempty"`
+	// Optional. Commands allowed for the methods running one, i.e. "StartJob". Any when empty.
+	Commands []CommandRule `json:"commands,omitempty"`
+}
+
+// Role is a named set of rules.
+type Role struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Binding grants a role to users and/or groups.
+type Binding struct {
+	Role   string   `json:"role"`
+	Users  []string `json:"users,omitempty"` // Wildcard for everyone.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Policy is the set of roles and their bindings.
+type Policy struct {
+	// Members of each group, in addition to the groups of the users' identity.
+	Groups   map[string][]string `json:"groups,omitempty"`
+	Roles    map[string]Role     `json:"roles"`
+	Bindings []Binding           `json:"bindings"`
+}
+
+// Request is an authorization request.
+type Request struct {
+	User   string
+	Groups []string // Groups of the user's identity.
+	Method string   // Short RPC name.
+	// Whether the method targets a job, in which case JobOwner/JobOwnerGroups are the owner of the job and its groups.
+	Job            bool
+	JobOwner       string
+	JobOwnerGroups []string
+	JobACL         ACL // ACL of the job, granting access to other users on top of the policy.
+	// Commands run by the request, i.e. the job to start, checked against the commands of the rules.
+	Commands []Command
+}
+
+// Load reads the policy from the given JSON file. Unknown fields are rejected.
+func Load(path string) (*Policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	p := &Policy{}
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("%w: decode %q: %w", ErrInvalidPolicy, path, err)
+	}
+	return p, nil
+}
+
+// Validate the policy against the given methods, short RPC names. The rules can't reference unknown
+// methods or roles, and every method must be granted by at least one bound role, so no method gets
+// inadvertently unreachable as the API grows. Compiles the command rules, expected before any check.
+func (p *Policy) Validate(methods []string) error {
+	var errs []error
+	covered := map[string]bool{}
+	for _, m := range methods {
+		covered[m] = false
+	}
+	for name, role := range p.Roles {
+		if len(role.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("role %q: no rules", name))
+		}
+		for i, rule := range role.Rules {
+			if len(rule.Methods) == 0 {
+				errs = append(errs, fmt.Errorf("role %q, rule %d: no methods", name, i))
+			}
+			for _, m := range rule.Methods {
+				if _, ok := covered[m]; !ok && m != Wildcard {
+					errs = append(errs, fmt.Errorf("role %q, rule %d: unknown method %q", name, i, m))
+				}
+			}
+			switch rule.Scope {
+			case "", ScopeOwn, ScopeGroup, ScopeAll:
+			default:
+				errs = append(errs, fmt.Errorf("role %q, rule %d: invalid scope %q, expect 'own', 'group' or 'all'", name, i, rule.Scope))
+			}
+			for j := range rule.Commands {
+				// Shares the rules' backing array, the compiled regexes are kept.
+				if err := rule.Commands[j].compile(); err != nil {
+					errs = append(errs, fmt.Errorf("role %q, rule %d: %w", name, i, err))
+				}
+			}
+		}
+	}
+	for i, b := range p.Bindings {
+		role, ok := p.Roles[b.Role]
+		if !ok {
+			errs = append(errs, fmt.Errorf("binding %d: unknown role %q", i, b.Role))
+			continue
+		}
+		if len(b.Users) == 0 && len(b.Groups) == 0 {
+			errs = append(errs, fmt.Errorf("binding %d: no users nor groups", i))
+			continue
+		}
+		for _, rule := range role.Rules {
+			for _, m := range rule.Methods {
+				if m == Wildcard {
+					for k := range covered {
+						covered[k] = true
+					}
+				} else {
+					covered[m] = true
+				}
+			}
+		}
+	}
+	var missing []string
+	for m, ok := range covered {
+		if !ok {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		errs = append(errs, fmt.Errorf("methods not granted by any bound role: %s", strings.Join(missing, ", ")))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+	return nil
+}
+
+// Authorize the given request. Returns the name of the first role granting it, in the bindings order,
+// or ACLRole when only granted by the ACL of the job.
+func (p *Policy) Authorize(req Request) (string, bool) {
+	role, err := p.Check(req)
+	return role, err == nil
+}
+
+// Check the given request, as Authorize. When denied, returns ErrForbidden, detailing the rules
+// which granted the method but not the commands, if any.
+func (p *Policy) Check(req Request) (string, error) {
+	if req.User == "" {
+		return "", ErrForbidden
+	}
+	userGroups := p.groupsOf(req.User, req.Groups)
+	var denials []string
+	for _, b := range p.Bindings {
+		if !slices.Contains(b.Users, req.User) && !slices.Contains(b.Users, Wildcard) &&
+			!slices.ContainsFunc(b.Groups, func(g string) bool { return slices.Contains(userGroups, g) }) {
+			continue
+		}
+		for i, rule := range p.Roles[b.Role].Rules {
+			if !slices.Contains(rule.Methods, req.Method) && !slices.Contains(rule.Methods, Wildcard) {
+				continue
+			}
+			if req.Job && !p.inScope(rule.Scope, req, userGroups) {
+				continue
+			}
+			if reason, ok := rule.allowedCommands(req.Commands); !ok {
+				denials = append(denials, fmt.Sprintf("role %q, rule %d: %s", b.Role, i, reason))
+				continue
+			}
+			return b.Role, nil
+		}
+	}
+	if req.Job && req.JobACL.grants(req.Method, req.User, userGroups) {
+		return ACLRole, nil
+	}
+	if len(denials) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrForbidden, strings.Join(denials, "; "))
+	}
+	return "", ErrForbidden
+}
+
+// inScope checks whether the job targeted by the request is within the scope for the requesting user.
+func (p *Policy) inScope(scope Scope, req Request, userGroups []string) bool {
+	switch scope {
+	case ScopeAll:
+		return true
+	case ScopeGroup:
+		if req.User == req.JobOwner {
+			return true
+		}
+		ownerGroups := p.groupsOf(req.JobOwner, req.JobOwnerGroups)
+		return slices.ContainsFunc(userGroups, func(g string) bool { return slices.Contains(ownerGroups, g) })
+	default:
+		return req.User == req.JobOwner
+	}
+}
+
+// groupsOf returns the groups the given user is a member of, the given identity groups included.
+func (p *Policy) groupsOf(user string, identityGroups []string) []string {
+	groups := slices.Clone(identityGroups)
+	for g, members := range p.Groups {
+		if slices.Contains(members, user) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// Authorizer holds the current policy, reloadable at runtime.
+type Authorizer struct {
+	path    string
+	methods []string
+	policy  atomic.Pointer[Policy]
+}
+
+// NewAuthorizer creates an authorizer enforcing the given policy file, validated against the given methods.
+// The given default policy is used when path is empty.
+func NewAuthorizer(path string, methods []string, defaultPolicy *Policy) (*Authorizer, error) {
+	a := &Authorizer{path: path, methods: methods}
+	if path == "" {
+		if err := defaultPolicy.Validate(methods); err != nil {
+			return nil, fmt.Errorf("default policy: %w", err)
+		}
+		a.policy.Store(defaultPolicy)
+		return a, nil
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload the policy file. The current policy is kept if the new one is invalid.
+// No-op when using the default policy.
+func (a *Authorizer) Reload() error {
+	if a.path == "" {
+		return nil
+	}
+	p, err := Load(a.path)
+	if err != nil {
+		return err
+	}
+	if err := p.Validate(a.methods); err != nil {
+		return fmt.Errorf("policy file %q: %w", a.path, err)
+	}
+	a.policy.Store(p)
+	return nil
+}
+
+// Authorize the given request against the current policy.
+func (a *Authorizer) Authorize(req Request) (string, bool) {
+	return a.policy.Load().Authorize(req)
+}
+
+// Check the given request against the current policy, explaining the denial.
+func (a *Authorizer) Check(req Request) (string, error) {
+	return a.policy.Load().Check(req)
+}
@@ -0,0 +1,91 @@
+package rbac_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.creack.net/telepilot/pkg/rbac"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	p := &rbac.Policy{
+		Groups: map[string][]string{"oncall": {"bob"}},
+		Roles: map[string]rbac.Role{
+			"admin":  {Rules: []rbac.Rule{{Methods: []string{rbac.Wildcard}, Scope: rbac.ScopeAll}}},
+			"oncall": {Rules: []rbac.Rule{{Methods: []string{"GetJobStatus", "StopJob"}, Scope: rbac.ScopeGroup}}},
+			"user":   {Rules: []rbac.Rule{{Methods: []string{"StartJob", "GetJobStatus", "StopJob"}}}},
+			"runner": {Rules: []rbac.Rule{{
+				Methods: []string{"StartJob"},
+				Commands: []rbac.CommandRule{
+					{Path: "/bin/echo", Args: []string{"[a-z]+", "-n"}},
+					{Path: "/opt/tools/*"},
+				},
+			}}},
+		},
+		Bindings: []rbac.Binding{
+			{Role: "admin", Users: []string{"alice"}},
+			{Role: "runner", Users: []string{"carol"}},
+			{Role: "oncall", Groups: []string{"oncall"}},
+			{Role: "user", Users: []string{rbac.Wildcard}},
+		},
+	}
+	if err := p.Validate([]string{"StartJob", "GetJobStatus", "StopJob", "SetJobACL"}); err != nil {
+		t.Fatalf("Invalid policy: %s.", err)
+	}
+
+	for name, tc := range map[string]struct {
+		req    rbac.Request
+		expect string // Expected role, empty when denied.
+	}{
+		"anonymous":             {rbac.Request{Method: "StartJob"}, ""},
+		"wildcard user":         {rbac.Request{User: "eve", Method: "StartJob"}, "user"},
+		"wildcard method":       {rbac.Request{User: "alice", Method: "SetJobACL"}, "admin"},
+		"unknown method":        {rbac.Request{User: "eve", Method: "SetJobACL"}, ""},
+		"binding order":         {rbac.Request{User: "alice", Method: "StartJob"}, "admin"},
+		"own job":               {rbac.Request{User: "eve", Method: "StopJob", Job: true, JobOwner: "eve"}, "user"},
+		"other's job":           {rbac.Request{User: "eve", Method: "StopJob", Job: true, JobOwner: "dave"}, ""},
+		"scope all":             {rbac.Request{User: "alice", Method: "StopJob", Job: true, JobOwner: "dave"}, "admin"},
+		"policy group":          {rbac.Request{User: "bob", Method: "StopJob", Job: true, JobOwner: "dave", JobOwnerGroups: []string{"oncall"}}, "oncall"},
+		"identity group":        {rbac.Request{User: "eve", Groups: []string{"oncall"}, Method: "StopJob", Job: true, JobOwner: "bob"}, "oncall"},
+		"no shared group":       {rbac.Request{User: "bob", Method: "StopJob", Job: true, JobOwner: "dave"}, ""},
+		"group own job":         {rbac.Request{User: "bob", Method: "StopJob", Job: true, JobOwner: "bob"}, "oncall"},
+		"command allowed":       {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/bin/echo", Args: []string{"-n", "hello"}}}}, "runner"},
+		"command glob":          {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/opt/tools/backup", Args: []string{"--all"}}}}, "runner"},
+		"command no args":       {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/bin/echo"}}}, "runner"},
+		"command fallback":      {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/bin/sh"}}}, "user"},
+		"command anchored args": {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/bin/echo", Args: []string{"-nhello"}}}}, "user"},
+		"command glob depth":    {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/opt/tools/sub/cmd"}}}, "user"},
+		"command not clean":     {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/opt/tools/.."}}}, "user"},
+		"command double slash":  {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/opt//tools/backup"}}}, "user"},
+		"command relative":      {rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "tools/backup"}}}, "user"},
+		"acl user":              {rbac.Request{User: "eve", Method: "StopJob", Job: true, JobOwner: "dave", JobACL: rbac.ACL{{User: "eve", Permissions: []rbac.Permission{rbac.PermissionStop}}}}, rbac.ACLRole},
+		"acl group":             {rbac.Request{User: "eve", Groups: []string{"qa"}, Method: "GetJobStatus", Job: true, JobOwner: "dave", JobACL: rbac.ACL{{Group: "qa", Permissions: []rbac.Permission{rbac.PermissionReadStatus}}}}, rbac.ACLRole},
+		"acl other permission":  {rbac.Request{User: "eve", Method: "StopJob", Job: true, JobOwner: "dave", JobACL: rbac.ACL{{User: "eve", Permissions: []rbac.Permission{rbac.PermissionReadLogs}}}}, ""},
+		"acl other user":        {rbac.Request{User: "eve", Method: "StopJob", Job: true, JobOwner: "dave", JobACL: rbac.ACL{{User: "frank", Permissions: []rbac.Permission{rbac.PermissionStop}}}}, ""},
+	} {
+		role, err := p.Check(tc.req)
+		if tc.expect == "" {
+			if !errors.Is(err, rbac.ErrForbidden) {
+				t.Errorf("%s: expected forbidden, got %q (%v).", name, role, err)
+			}
+			continue
+		}
+		if err != nil || role != tc.expect {
+			t.Errorf("%s: expected role %q, got %q (%v).", name, tc.expect, role, err)
+		}
+	}
+
+	// The denials on commands are detailed, when no other rule grants the request.
+	p.Bindings = p.Bindings[:3]
+	_, err := p.Check(rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/bin/sh"}}})
+	if !errors.Is(err, rbac.ErrForbidden) || !strings.Contains(err.Error(), `command "/bin/sh"`) {
+		t.Errorf("Expected a detailed command denial, got: %v.", err)
+	}
+	_, err = p.Check(rbac.Request{User: "carol", Method: "StartJob", Commands: []rbac.Command{{Path: "/opt/tools/../../bin/sh"}}})
+	if !errors.Is(err, rbac.ErrForbidden) || !strings.Contains(err.Error(), "not an absolute and clean path") {
+		t.Errorf("Expected an unclean command to be denied, got: %v.", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package rlimit resolves and applies the per-process resource limits of the jobs.
+package rlimit
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrInvalidLimit is returned when a resource or a limit is invalid.
+var ErrInvalidLimit = errors.New("invalid rlimit")
+
+// Unlimited is RLIM_INFINITY.
+const Unlimited = ^uint64(0)
+
+// Resource is a limited resource.
+type Resource string
+
+// Supported resources.
+const (
+	CPU    Resource = "cpu"    // CPU time in seconds. SIGXCPU on soft limit, SIGKILL on hard limit.
+	Core   Resource = "core"   // Max core dump size in bytes.
+	Stack  Resource = "stack"  // Max stack size in bytes.
+	NProc  Resource = "nproc"  // Max number of processes for the real uid.
+	NoFile Resource = "nofile" // Max number of open file descriptors.
+)
+
+// numbers maps the resources to the kernel's RLIMIT_* values.
+//
+//nolint:gochecknoglobals // Expected global.
+var numbers = map[Resource]int{
+	CPU:    syscall.RLIMIT_CPU,
+	Core:   syscall.RLIMIT_CORE,
+	Stack:  syscall.RLIMIT_STACK,
+	NProc:  6, // RLIMIT_NPROC, missing from the syscall package.
+	NoFile: syscall.RLIMIT_NOFILE,
+}
+
+// ParseResource parses the resource name.
+func ParseResource(s string) (Resource, error) {
+	r := Resource(strings.ToLower(s))
+	if _, ok := numbers[r]; !ok {
+		return "", fmt.Errorf("%w: unknown resource %q, expect 'cpu', 'core', 'stack', 'nproc' or 'nofile'", ErrInvalidLimit, s)
+	}
+	return r, nil
+}
+
+// Limit is a soft/hard limit pair.
+type Limit struct {
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// String returns the limit as "soft:hard".
+func (l Limit) String() string {
+	return formatValue(l.Soft) + ":" + formatValue(l.Hard)
+}
+
+func formatValue(v uint64) string {
+	if v == Unlimited {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// ParseValue parses a limit value, "unlimited" being Unlimited.
+func ParseValue(s string) (uint64, error) {
+	if s == "unlimited" {
+		return Unlimited, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: value %q: %w", ErrInvalidLimit, s, err)
+	}
+	return v, nil
+}
+
+// Validate the limits.
+func Validate(limits map[Resource]Limit) error {
+	for r, l := range limits {
+		if _, ok := numbers[r]; !ok {
+			return fmt.Errorf("%w: unknown resource %q", ErrInvalidLimit, r)
+		}
+		if l.Soft > l.Hard {
+			return fmt.Errorf("%w: %s soft limit %s exceeds the hard limit %s", ErrInvalidLimit, r, formatValue(l.Soft), formatValue(l.Hard))
+		}
+	}
+	return nil
+}
+
+// ParseMaxima parses the server maxima, i.e. "nofile=65536,nproc=4096,core=0".
+func ParseMaxima(s string) (map[Resource]uint64, error) {
+	maxima := map[Resource]uint64{}
+	if s == "" {
+		return maxima, nil
+	}
+	for _, elem := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(elem, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, expect '<resource>=<max>'", ErrInvalidLimit, elem)
+		}
+		r, err := ParseResource(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		v, err := ParseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		maxima[r] = v
+	}
+	return maxima, nil
+}
+
+// Resolve the limits to apply from the requested ones and the server maxima.
+// Requested limits are clamped to the maxima, the resources not requested
+// default to their maximum when set, otherwise they are inherited from the server.
+func Resolve(requested map[Resource]Limit, maxima map[Resource]uint64) map[Resource]Limit {
+	out := make(map[Resource]Limit, len(requested)+len(maxima))
+	maps.Copy(out, requested)
+	for r, m := range maxima {
+		l, ok := out[r]
+		if !ok {
+			out[r] = Limit{Soft: m, Hard: m}
+			continue
+		}
+		out[r] = Limit{Soft: min(l.Soft, m), Hard: min(l.Hard, m)}
+	}
+	return out
+}
+
+// Apply the limits to the current process. Inherited by the process it executes.
+//
+// NOTE: Raising a hard limit requires CAP_SYS_RESOURCE.
+func Apply(limits map[Resource]Limit) error {
+	for _, r := range slices.Sorted(maps.Keys(limits)) {
+		n, ok := numbers[r]
+		if !ok {
+			return fmt.Errorf("%w: unknown resource %q", ErrInvalidLimit, r)
+		}
+		l := limits[r]
+		if err := syscall.Setrlimit(n, &syscall.Rlimit{Cur: l.Soft, Max: l.Hard}); err != nil {
+			return fmt.Errorf("set %s limit %s: %w", r, l, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed cron expression.
+// Each field is a bitset of the allowed values.
+type Expression struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// Whether the day of month/week fields are '*'. When both are restricted,
+	// a day matches if either matches, as in the classic cron.
+	domStar, dowStar bool
+}
+
+// field describes the bounds of a cron field.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int // Optional. Value aliases, i.e. "jan" or "mon".
+}
+
+//nolint:gochecknoglobals // Expected globals.
+var (
+	secondField = field{name: "second", min: 0, max: 59}
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// NOTE: 7 is Sunday as well, folded to 0 once parsed.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// maxSearchYears bounds the search of the next run, for expressions which never match, i.e. "0 0 30 2 *".
+const maxSearchYears = 5
+
+// ParseCron parses a cron expression: "<minute> <hour> <day of month> <month> <day of week>",
+// optionally prefixed with a seconds field, or a descriptor, i.e. "@daily".
+// Fields support '*', values, ranges ("1-5"), steps ("*/15", "0-30/10"), lists ("1,15")
+// and month/day names ("jan", "mon").
+func ParseCron(s string) (*Expression, error) {
+	expr := strings.TrimSpace(s)
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q: expect 5 or 6 fields, got %d", ErrInvalidSchedule, s, len(fields))
+	}
+
+	e := &Expression{
+		domStar: fields[3] == "*" || fields[3] == "?",
+		dowStar: fields[5] == "*" || fields[5] == "?",
+	}
+	for i, elem := range []struct {
+		dst *uint64
+		f   field
+	}{
+		{&e.second, secondField},
+		{&e.minute, minuteField},
+		{&e.hour, hourField},
+		{&e.dom, domField},
+		{&e.month, monthField},
+		{&e.dow, dowField},
+	} {
+		bits, err := elem.f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidSchedule, s, err)
+		}
+		*elem.dst = bits
+	}
+	// Fold Sunday.
+	if e.dow&(1<<7) != 0 {
+		e.dow = e.dow&^(1<<7) | 1
+	}
+	return e, nil
+}
+
+// parse the field as a bitset of the allowed values.
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, elem := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(elem, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, stepStr) //nolint:err113 // Wrapped by the caller.
+			}
+			step = n
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = f.min, f.max
+		default:
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "n/step" is "n-max/step".
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rng) //nolint:err113 // Wrapped by the caller.
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v) //nolint:gosec // False positive, bounded by the field.
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single value of the field, number or name.
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, expect %d-%d", f.name, s, f.min, f.max) //nolint:err113 // Wrapped by the caller.
+	}
+	return v, nil
+}
+
+// Next returns the first time matching the expression strictly after the given one, in its location.
+// Returns the zero time if none within the next years, i.e. for "0 0 30 2 *".
+func (e *Expression) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+	loc := t.Location()
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case e.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !e.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case e.hour&(1<<uint(t.Hour())) == 0: //nolint:gosec // False positive, hours are positive.
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case e.minute&(1<<uint(t.Minute())) == 0: //nolint:gosec // False positive, minutes are positive.
+			t = time.Date(y, m, d, t.Hour(), t.Minute()+1, 0, 0, loc)
+		case e.second&(1<<uint(t.Second())) == 0: //nolint:gosec // False positive, seconds are positive.
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and day of week fields.
+func (e *Expression) dayMatches(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0     //nolint:gosec // False positive, days are positive.
+	dow := e.dow&(1<<uint(t.Weekday())) != 0 //nolint:gosec // False positive, weekdays are positive.
+	if e.domStar || e.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,50 @@
+package scheduler_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.creack.net/telepilot/pkg/scheduler"
+)
+
+func TestCronNext(t *testing.T) {
+	t.Parallel()
+
+	// Wednesday.
+	base := time.Date(2024, time.May, 15, 10, 30, 15, 0, time.UTC)
+	for _, tc := range []struct {
+		expr   string
+		expect time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.May, 15, 10, 31, 0, 0, time.UTC)},
+		{"* * * * * *", time.Date(2024, time.May, 15, 10, 30, 16, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.May, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.May, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon-fri", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, time.May, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Both days restricted, either matches.
+		{"0 0 1 * fri", time.Date(2024, time.May, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}}, // Never.
+	} {
+		expr, err := scheduler.ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("Parse cron %q: %s.", tc.expr, err)
+		}
+		if next := expr.Next(base); !next.Equal(tc.expect) {
+			t.Errorf("Invalid next run for %q: expected %s, got %s.", tc.expr, tc.expect, next)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := scheduler.ParseCron(expr); !errors.Is(err, scheduler.ErrInvalidSchedule) {
+			t.Errorf("Expected invalid schedule error for %q, got: %v.", expr, err)
+		}
+	}
+}
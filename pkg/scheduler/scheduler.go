@@ -0,0 +1,435 @@
+// Package scheduler starts jobs periodically, following cron expressions.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/statefile"
+)
+
+// Common errors.
+var (
+	ErrInvalidSchedule  = errors.New("invalid schedule")
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+// stateFile is the name of the file persisting the schedules within the state dir.
+const stateFile = "schedules.json"
+
+// OverlapPolicy is the behavior when a run is due while the job of the previous run is still running.
+type OverlapPolicy int
+
+// Available overlap policies.
+const (
+	// OverlapSkip skips the run (default).
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue starts the run once the previous job is done. At most one run is queued.
+	OverlapQueue
+	// OverlapReplace stops the previous job, then starts the run.
+	OverlapReplace
+)
+
+// Schedule is a job started periodically.
+type Schedule struct {
+	ID    uuid.UUID `json:"id"`
+	Owner string    `json:"owner"`
+
+	Cron    string             `json:"cron"`
+	Spec    jobmanager.JobSpec `json:"spec"`
+	Overlap OverlapPolicy      `json:"overlap"`
+	// Run once on startup when runs have been missed while the server was down. Skipped otherwise.
+	RunMissed bool `json:"run_missed"`
+
+	CreatedAt time.Time `json:"created_at"`
+	// Last run. LastJobID is unset when the job failed to start, LastError being set instead.
+	LastRun   time.Time `json:"last_run"`
+	LastJobID uuid.UUID `json:"last_job_id"`
+	LastError string    `json:"last_error,omitempty"`
+	// Set once a run is denied, i.e. the policy changed or the owner's certificate got revoked, LastError
+	// explaining why. Never runs again.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Next run, computed from the cron expression. Not persisted.
+	NextRun time.Time `json:"-"`
+}
+
+// entry is a registered schedule.
+type entry struct {
+	Schedule
+
+	expr   *Expression
+	queued bool // Whether a run is waiting for the previous job to be done.
+}
+
+// state is the persisted state.
+type state struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
+// Config of the scheduler.
+type Config struct {
+	// Optional. Directory where the schedules are persisted. Kept in memory only when empty.
+	StateDir string
+	// Optional. Checked before each run, the schedule is disabled when denied. Not checked when nil.
+	Authorize jobmanager.AuthorizeFunc
+}
+
+// Scheduler starts the jobs of the schedules when they are due.
+type Scheduler struct {
+	mu        sync.Mutex
+	schedules map[uuid.UUID]*entry
+
+	jm  *jobmanager.JobManager
+	cfg Config
+
+	wakeChan  chan struct{} // Notifies the loop the schedules changed.
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New creates the scheduler and starts it. Loads the persisted schedules, handling the runs missed
+// while the server was down.
+func New(jm *jobmanager.JobManager, cfg Config) (*Scheduler, error) {
+	s := &Scheduler{
+		schedules: map[uuid.UUID]*entry{},
+		jm:        jm,
+		cfg:       cfg,
+		wakeChan:  make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go func() { defer s.wg.Done(); s.run() }()
+	return s, nil
+}
+
+// load the persisted schedules.
+func (s *Scheduler) load() error {
+	if s.cfg.StateDir == "" {
+		return nil
+	}
+	var st state
+	if err := statefile.Load(filepath.Join(s.cfg.StateDir, stateFile), &st); err != nil {
+		return fmt.Errorf("load schedules: %w", err)
+	}
+	now := time.Now()
+	for _, sc := range st.Schedules {
+		logger := slog.With("schedule_id", sc.ID.String())
+		expr, err := ParseCron(sc.Cron)
+		if err != nil {
+			// Best effort, keep going with the other schedules.
+			logger.Error("Invalid persisted schedule, ignoring it.", "error", err)
+			continue
+		}
+		e := &entry{Schedule: sc, expr: expr}
+		if sc.Disabled {
+			s.schedules[sc.ID] = e
+			continue
+		}
+		last := sc.LastRun
+		if last.IsZero() {
+			last = sc.CreatedAt
+		}
+		if missed := expr.Next(last); !missed.IsZero() && !missed.After(now) {
+			if sc.RunMissed {
+				logger.Info("Schedule missed runs while the server was down, running it.", "missed_run", missed)
+				e.NextRun = now
+			} else {
+				logger.Info("Schedule missed runs while the server was down, skipping them.", "missed_run", missed)
+			}
+		}
+		if e.NextRun.IsZero() {
+			e.NextRun = expr.Next(now)
+		}
+		s.schedules[sc.ID] = e
+	}
+	return nil
+}
+
+// saveLocked persists the schedules.
+//
+// NOTE: Expected to be called with the lock held.
+func (s *Scheduler) saveLocked() error {
+	if s.cfg.StateDir == "" {
+		return nil
+	}
+	st := state{Schedules: make([]Schedule, 0, len(s.schedules))}
+	for _, e := range s.schedules {
+		st.Schedules = append(st.Schedules, e.Schedule)
+	}
+	slices.SortFunc(st.Schedules, func(a, b Schedule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	if err := statefile.Save(filepath.Join(s.cfg.StateDir, stateFile), st); err != nil {
+		return fmt.Errorf("save schedules: %w", err)
+	}
+	return nil
+}
+
+// Close stops the scheduler. The jobs already started are left running.
+func (s *Scheduler) Close() {
+	close(s.closeChan)
+	s.wg.Wait()
+}
+
+// wake notifies the loop the schedules changed.
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// Create registers a new schedule for the given owner from the cron expression, the job spec,
+// the overlap policy and the missed run setting of sc. The spec is validated upfront.
+func (s *Scheduler) Create(owner string, sc Schedule) (Schedule, error) {
+	if sc.Overlap < OverlapSkip || sc.Overlap > OverlapReplace {
+		return Schedule{}, fmt.Errorf("%w: unknown overlap policy %d", ErrInvalidSchedule, sc.Overlap)
+	}
+	expr, err := ParseCron(sc.Cron)
+	if err != nil {
+		return Schedule{}, err
+	}
+	now := time.Now()
+	next := expr.Next(now)
+	if next.IsZero() {
+		return Schedule{}, fmt.Errorf("%w: %q never matches", ErrInvalidSchedule, sc.Cron)
+	}
+	if err := s.jm.ValidateSpec(owner, sc.Spec); err != nil {
+		return Schedule{}, fmt.Errorf("validate job spec: %w", err)
+	}
+
+	e := &entry{
+		Schedule: Schedule{
+			ID:        uuid.New(),
+			Owner:     owner,
+			Cron:      sc.Cron,
+			Spec:      sc.Spec,
+			Overlap:   sc.Overlap,
+			RunMissed: sc.RunMissed,
+			CreatedAt: now,
+			NextRun:   next,
+		},
+		expr: expr,
+	}
+
+	s.mu.Lock()
+	s.schedules[e.ID] = e
+	if err := s.saveLocked(); err != nil {
+		delete(s.schedules, e.ID)
+		s.mu.Unlock()
+		return Schedule{}, err
+	}
+	s.mu.Unlock()
+
+	s.wake()
+	return e.Schedule, nil
+}
+
+// List returns the schedules of the given owner, oldest first.
+func (s *Scheduler) List(owner string) []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Schedule
+	for _, e := range s.schedules {
+		if e.Owner == owner {
+			out = append(out, e.Schedule)
+		}
+	}
+	slices.SortFunc(out, func(a, b Schedule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return out
+}
+
+// Delete the given schedule of the given owner. The jobs already started are left running.
+func (s *Scheduler) Delete(owner string, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.schedules[id]
+	if !ok || e.Owner != owner {
+		// NOTE: Same error for the schedules of other owners to avoid leaking them.
+		return ErrScheduleNotFound
+	}
+	delete(s.schedules, id)
+	if err := s.saveLocked(); err != nil {
+		s.schedules[id] = e
+		return err
+	}
+	// NOTE: No need to wake the loop, it ignores the deleted schedules.
+	return nil
+}
+
+// run the loop starting the jobs when due, until closed.
+func (s *Scheduler) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.closeChan:
+			return
+		case <-s.wakeChan:
+		case <-timer.C:
+		}
+
+		// Collect the due schedules and the next wake up.
+		now := time.Now()
+		var due []uuid.UUID
+		var next time.Time
+		s.mu.Lock()
+		for id, e := range s.schedules {
+			if e.Disabled {
+				continue
+			}
+			if !e.NextRun.After(now) {
+				due = append(due, id)
+				e.NextRun = e.expr.Next(now)
+			}
+			if !e.NextRun.IsZero() && (next.IsZero() || e.NextRun.Before(next)) {
+				next = e.NextRun
+			}
+		}
+		s.mu.Unlock()
+
+		for _, id := range due {
+			s.fire(id)
+		}
+
+		timer.Stop()
+		select {
+		case <-timer.C:
+		default:
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// fire runs the given schedule, applying its overlap policy.
+func (s *Scheduler) fire(id uuid.UUID) {
+	s.mu.Lock()
+	e, ok := s.schedules[id]
+	if !ok {
+		// Deleted in the meantime.
+		s.mu.Unlock()
+		return
+	}
+	overlap, lastJobID := e.Overlap, e.LastJobID
+	s.mu.Unlock()
+	logger := slog.With("schedule_id", id.String())
+
+	prev := s.activeJob(lastJobID)
+	if prev == nil {
+		s.start(id)
+		return
+	}
+	switch overlap {
+	case OverlapSkip:
+		logger.Info("Previous job still running, skipping the schedule run.", "job_id", prev.ID.String())
+	case OverlapQueue:
+		s.mu.Lock()
+		if e.queued {
+			s.mu.Unlock()
+			logger.Info("Schedule run already queued, skipping.", "job_id", prev.ID.String())
+			return
+		}
+		e.queued = true
+		s.mu.Unlock()
+		logger.Info("Previous job still running, queuing the schedule run.", "job_id", prev.ID.String())
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			select {
+			case <-s.closeChan:
+				return
+			case <-prev.Done():
+			}
+			s.mu.Lock()
+			e.queued = false
+			s.mu.Unlock()
+			s.start(id)
+		}()
+	case OverlapReplace:
+		logger.Info("Previous job still running, replacing it.", "job_id", prev.ID.String())
+		if err := s.jm.StopJob(prev.ID); err != nil {
+			// Best effort, start the new one anyway.
+			logger.Warn("Failed to stop the previous job.", "job_id", prev.ID.String(), "error", err)
+		}
+		s.start(id)
+	}
+}
+
+// activeJob returns the given job if still running, nil otherwise.
+func (s *Scheduler) activeJob(id uuid.UUID) *jobmanager.Job {
+	if id == uuid.Nil {
+		return nil
+	}
+	j, err := s.jm.LookupJob(id)
+	if err != nil {
+		// Not found, i.e. started before the server restarted.
+		return nil
+	}
+	select {
+	case <-j.Done():
+		return nil
+	default:
+		return j
+	}
+}
+
+// start the job of the given schedule and record the run.
+func (s *Scheduler) start(id uuid.UUID) {
+	s.mu.Lock()
+	e, ok := s.schedules[id]
+	if !ok || e.Disabled {
+		// Deleted or disabled in the meantime.
+		s.mu.Unlock()
+		return
+	}
+	owner, spec := e.Owner, e.Spec
+	s.mu.Unlock()
+	logger := slog.With("schedule_id", id.String())
+
+	// Re-check the schedule on each run, as the policy may have changed since created.
+	var authErr, err error
+	var jobID uuid.UUID
+	if s.cfg.Authorize != nil {
+		authErr = s.cfg.Authorize(owner, spec)
+	}
+	if authErr == nil {
+		spec.ScheduleID = id
+		jobID, err = s.jm.StartJob(owner, spec)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.LastRun = time.Now()
+	e.LastJobID, e.LastError = jobID, ""
+	switch {
+	case authErr != nil:
+		logger.Warn("Schedule run denied, disabling the schedule.", "audit", true, "owner", owner, "error", authErr)
+		e.LastError = "disabled: " + authErr.Error()
+		e.Disabled, e.NextRun = true, time.Time{}
+	case err != nil:
+		logger.Error("Failed to start the scheduled job.", "error", err)
+		e.LastError = err.Error()
+	default:
+		logger.Info("Scheduled job started.", "job_id", jobID.String())
+	}
+	if _, ok := s.schedules[id]; !ok {
+		// Deleted while starting, nothing to persist.
+		return
+	}
+	if err := s.saveLocked(); err != nil {
+		// Best effort, the run is only lost on restart.
+		logger.Warn("Failed to persist the schedule run.", "error", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package seccomp compiles the syscall filtering profiles of the jobs to
+// seccomp-bpf programs and installs them, using only the standard library.
+package seccomp
+
+import (
+	"fmt"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// Profile is a named syscall filtering profile.
+type Profile string
+
+// Available profiles.
+const (
+	// ProfileDefault denies the syscalls which can be used to escape the job or impact the host.
+	ProfileDefault Profile = "default"
+	// ProfileStrict also denies the syscalls seldom needed by regular workloads, i.e. ptrace or io_uring.
+	ProfileStrict Profile = "strict"
+	// ProfileUnconfined doesn't filter anything.
+	ProfileUnconfined Profile = "unconfined"
+)
+
+// ParseProfile parses the profile name. Empty defaults to ProfileDefault.
+func ParseProfile(s string) (Profile, error) {
+	switch p := Profile(s); p {
+	case "":
+		return ProfileDefault, nil
+	case ProfileDefault, ProfileStrict, ProfileUnconfined:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid seccomp profile %q, expect 'default', 'strict' or 'unconfined'", s) //nolint:err113 // No need for fancy error here.
+	}
+}
+
+// defaultDenied are the syscalls denied by the default profile.
+//
+//nolint:gochecknoglobals // Expected global.
+var defaultDenied = []string{
+	// Filesystem/mount manipulation.
+	"mount", "umount2", "pivot_root", "chroot",
+	"open_tree", "move_mount", "fsopen", "fsconfig", "fsmount", "fspick", "mount_setattr",
+	"name_to_handle_at", "open_by_handle_at",
+	// Kernel modules and kexec.
+	"init_module", "finit_module", "delete_module", "kexec_load", "kexec_file_load",
+	// Host wide settings.
+	"reboot", "swapon", "swapoff", "acct", "quotactl", "quotactl_fd", "nfsservctl", "syslog", "vhangup",
+	"settimeofday", "clock_settime", "clock_adjtime", "adjtimex",
+	"iopl", "ioperm",
+	// Device nodes, the jobs share the host /dev and have no device cgroup.
+	"mknod", "mknodat",
+	// Kernel keyring, not namespaced.
+	"add_key", "request_key", "keyctl",
+	// Namespace changes. Also clone with the CLONE_NEW* flags, see Compile.
+	"setns", "unshare",
+	// Tracing and introspection.
+	"bpf", "perf_event_open", "lookup_dcookie", "userfaultfd",
+	// Obsolete.
+	"uselib", "ustat", "sysfs",
+}
+
+// strictDenied are the syscalls denied by the strict profile on top of the default ones.
+//
+//nolint:gochecknoglobals // Expected global.
+var strictDenied = []string{
+	// Other processes memory access.
+	"ptrace", "process_vm_readv", "process_vm_writev", "kcmp",
+	// Execution domain.
+	"personality",
+	// UTS changes.
+	"sethostname", "setdomainname",
+	// io_uring bypasses the syscall filtering.
+	"io_uring_setup", "io_uring_enter", "io_uring_register",
+	// NUMA memory policy.
+	"mbind", "set_mempolicy", "migrate_pages", "move_pages",
+}
+
+// deniedSyscalls returns the names of the syscalls denied by the given profile.
+func deniedSyscalls(profile Profile) []string {
+	switch profile {
+	case ProfileDefault:
+		return defaultDenied
+	case ProfileStrict:
+		return slices.Concat(defaultDenied, strictDenied)
+	default:
+		return nil
+	}
+}
+
+// Classic BPF opcodes and seccomp constants.
+const (
+	bpfLoadAbs = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJumpEq  = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfJumpGe  = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+	bpfRet     = syscall.BPF_RET | syscall.BPF_K
+
+	bpfJumpSet = syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K
+
+	// Offsets in struct seccomp_data.
+	offsetNR   = 0
+	offsetArch = 4
+	offsetArg0 = 16 // Lower 32 bits, little endian.
+
+	retKillProcess = 0x80000000
+	retErrno       = 0x00050000
+	retAllow       = 0x7fff0000
+
+	prSetSeccomp      = 22 // PR_SET_SECCOMP.
+	seccompModeFilter = 2  // SECCOMP_MODE_FILTER.
+
+	// cloneNewFlags are the CLONE_NEW* flags of clone, all in the lower 32 bits of its flags.
+	// NOTE: CLONE_NEWTIME (0x80) is only for clone3/unshare, it is part of the exit signal for clone.
+	cloneNewFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC |
+		syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+)
+
+// Compile the given profile to a BPF program.
+// Returns nil for the unconfined profile.
+//
+// The program kills the process if the architecture doesn't match (i.e. 32 bits syscalls),
+// and returns EPERM for the denied syscalls, allowing everything else.
+//
+// When unshare is denied, so are the namespace changes via clone: EPERM when its flags include CLONE_NEW*,
+// and ENOSYS for clone3, whose flags are behind a pointer seccomp can't check, so the libc falls back to clone.
+func Compile(profile Profile) []syscall.SockFilter {
+	names := deniedSyscalls(profile)
+	if len(names) == 0 {
+		return nil
+	}
+
+	prog := []syscall.SockFilter{
+		{Code: bpfLoadAbs, K: offsetArch},
+		{Code: bpfJumpEq, K: auditArch, Jt: 1},
+		{Code: bpfRet, K: retKillProcess},
+		{Code: bpfLoadAbs, K: offsetNR},
+	}
+	if x32SyscallBit != 0 {
+		// Deny the x32 ABI, sharing the same arch but with a flag in the syscall number.
+		prog = append(prog,
+			syscall.SockFilter{Code: bpfJumpGe, K: x32SyscallBit, Jf: 1},
+			syscall.SockFilter{Code: bpfRet, K: retErrno | uint32(syscall.EPERM)},
+		)
+	}
+	for _, name := range names {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			// Not available on this architecture.
+			continue
+		}
+		prog = append(prog,
+			syscall.SockFilter{Code: bpfJumpEq, K: nr, Jf: 1},
+			syscall.SockFilter{Code: bpfRet, K: retErrno | uint32(syscall.EPERM)},
+		)
+	}
+	if slices.Contains(names, "unshare") {
+		prog = append(prog,
+			syscall.SockFilter{Code: bpfJumpEq, K: syscallNumbers["clone3"], Jf: 1},
+			syscall.SockFilter{Code: bpfRet, K: retErrno | uint32(syscall.ENOSYS)},
+			// Last check, the flags replace the syscall number, anything else is allowed.
+			syscall.SockFilter{Code: bpfJumpEq, K: syscallNumbers["clone"], Jf: 3}, //nolint:mnd // Skip to allow.
+			syscall.SockFilter{Code: bpfLoadAbs, K: offsetArg0},
+			syscall.SockFilter{Code: bpfJumpSet, K: cloneNewFlags, Jf: 1},
+			syscall.SockFilter{Code: bpfRet, K: retErrno | uint32(syscall.EPERM)},
+		)
+	}
+	return append(prog, syscall.SockFilter{Code: bpfRet, K: retAllow})
+}
+
+// Install the given profile for the current thread, and therefore for the
+// process it executes. No-op for the unconfined profile.
+//
+// The thread needs either CAP_SYS_ADMIN or the no_new_privs bit set.
+//
+// NOTE: Expected to be called on a locked OS thread, right before exec.
+func Install(profile Profile) error {
+	prog := Compile(profile)
+	if prog == nil {
+		return nil
+	}
+
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]} //nolint:gosec // False positive, profiles are small.
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("set seccomp filter: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package seccomp //nolint:testpackage // Expected to test the internal package to evaluate the programs per architecture.
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// run evaluates the given program against the given syscall, as the kernel would. Fails on invalid programs.
+func run(t *testing.T, prog []syscall.SockFilter, arch, nr uint32, arg0 uint64) uint32 {
+	t.Helper()
+
+	// struct seccomp_data: nr, arch, instruction pointer, then the args, in native (little) endian.
+	data := make([]byte, offsetArg0+6*8) //nolint:mnd // 6 args.
+	binary.LittleEndian.PutUint32(data[offsetNR:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	binary.LittleEndian.PutUint64(data[offsetArg0:], arg0)
+
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case bpfLoadAbs:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+			continue
+		case bpfRet:
+			return ins.K
+		}
+		var cond bool
+		switch ins.Code {
+		case bpfJumpEq:
+			cond = acc == ins.K
+		case bpfJumpGe:
+			cond = acc >= ins.K
+		case bpfJumpSet:
+			cond = acc&ins.K != 0
+		default:
+			t.Fatalf("Unexpected instruction %d: %+v.", pc, ins)
+		}
+		if cond {
+			pc += int(ins.Jt)
+		} else {
+			pc += int(ins.Jf)
+		}
+	}
+	t.Fatal("Program ended without returning.")
+	return 0
+}
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	if prog := Compile(ProfileUnconfined); prog != nil {
+		t.Fatalf("Expected no program for the unconfined profile, got %d instructions.", len(prog))
+	}
+
+	const (
+		eperm  = retErrno | uint32(syscall.EPERM)
+		enosys = retErrno | uint32(syscall.ENOSYS)
+	)
+	for _, tc := range []struct {
+		name        string
+		nr          uint32
+		arg0        uint64
+		def, strict uint32 // Expected results for the default and the strict profiles.
+	}{
+		{"getpid", uint32(syscall.SYS_GETPID), 0, retAllow, retAllow},
+		{"mount", syscallNumbers["mount"], 0, eperm, eperm},
+		{"mount_setattr", syscallNumbers["mount_setattr"], 0, eperm, eperm},
+		{"mknodat", syscallNumbers["mknodat"], 0, eperm, eperm},
+		{"unshare", syscallNumbers["unshare"], syscall.CLONE_NEWUSER, eperm, eperm},
+		{"ptrace", syscallNumbers["ptrace"], 0, retAllow, eperm},
+		{"io_uring_setup", syscallNumbers["io_uring_setup"], 0, retAllow, eperm},
+		{"clone thread", syscallNumbers["clone"], syscall.CLONE_VM | syscall.CLONE_THREAD, retAllow, retAllow},
+		{"clone newuser", syscallNumbers["clone"], syscall.CLONE_NEWUSER, eperm, eperm},
+		{"clone newns", syscallNumbers["clone"], syscall.CLONE_NEWNS | uint64(syscall.SIGCHLD), eperm, eperm},
+		{"clone upper bits", syscallNumbers["clone"], 1 << 32, retAllow, retAllow},
+		{"clone3", syscallNumbers["clone3"], 0, enosys, enosys},
+	} {
+		for _, p := range []struct {
+			profile Profile
+			expect  uint32
+		}{{ProfileDefault, tc.def}, {ProfileStrict, tc.strict}} {
+			if got := run(t, Compile(p.profile), auditArch, tc.nr, tc.arg0); got != p.expect {
+				t.Errorf("%s with the %s profile: expected %#x, got %#x.", tc.name, p.profile, p.expect, got)
+			}
+		}
+	}
+
+	// The x32 ABI shares the architecture, denied by its syscall number.
+	if x32SyscallBit != 0 {
+		if got := run(t, Compile(ProfileDefault), auditArch, x32SyscallBit|uint32(syscall.SYS_GETPID), 0); got != eperm {
+			t.Errorf("Expected the x32 syscalls to be denied, got %#x.", got)
+		}
+	}
+
+	// Any other architecture is killed, whatever the syscall.
+	if got := run(t, Compile(ProfileDefault), auditArch^1, uint32(syscall.SYS_GETPID), 0); got != retKillProcess {
+		t.Errorf("Expected a foreign architecture to be killed, got %#x.", got)
+	}
+}
@@ -0,0 +1,74 @@
+package seccomp
+
+import "syscall"
+
+const (
+	auditArch = 0xc00000b7 // AUDIT_ARCH_AARCH64.
+
+	x32SyscallBit = 0 // No x32 equivalent.
+)
+
+// syscallNumbers maps the filtered syscall names to their number.
+// The recent ones are missing from the syscall package.
+// Legacy syscalls like mknod or iopl don't exist on arm64.
+//
+//nolint:gochecknoglobals // Expected global.
+var syscallNumbers = map[string]uint32{
+	"mount":             syscall.SYS_MOUNT,
+	"umount2":           syscall.SYS_UMOUNT2,
+	"pivot_root":        syscall.SYS_PIVOT_ROOT,
+	"chroot":            syscall.SYS_CHROOT,
+	"open_tree":         428,
+	"move_mount":        429,
+	"fsopen":            430,
+	"fsconfig":          431,
+	"fsmount":           432,
+	"fspick":            433,
+	"mount_setattr":     442,
+	"name_to_handle_at": syscall.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at": syscall.SYS_OPEN_BY_HANDLE_AT,
+	"init_module":       syscall.SYS_INIT_MODULE,
+	"finit_module":      syscall.SYS_FINIT_MODULE,
+	"delete_module":     syscall.SYS_DELETE_MODULE,
+	"kexec_load":        syscall.SYS_KEXEC_LOAD,
+	"kexec_file_load":   294,
+	"reboot":            syscall.SYS_REBOOT,
+	"swapon":            syscall.SYS_SWAPON,
+	"swapoff":           syscall.SYS_SWAPOFF,
+	"acct":              syscall.SYS_ACCT,
+	"quotactl":          syscall.SYS_QUOTACTL,
+	"quotactl_fd":       443,
+	"nfsservctl":        syscall.SYS_NFSSERVCTL,
+	"syslog":            syscall.SYS_SYSLOG,
+	"vhangup":           syscall.SYS_VHANGUP,
+	"settimeofday":      syscall.SYS_SETTIMEOFDAY,
+	"clock_settime":     syscall.SYS_CLOCK_SETTIME,
+	"clock_adjtime":     syscall.SYS_CLOCK_ADJTIME,
+	"adjtimex":          syscall.SYS_ADJTIMEX,
+	"add_key":           syscall.SYS_ADD_KEY,
+	"request_key":       syscall.SYS_REQUEST_KEY,
+	"keyctl":            syscall.SYS_KEYCTL,
+	"setns":             syscall.SYS_SETNS,
+	"unshare":           syscall.SYS_UNSHARE,
+	"clone":             syscall.SYS_CLONE,
+	"clone3":            435,
+	"bpf":               syscall.SYS_BPF,
+	"perf_event_open":   syscall.SYS_PERF_EVENT_OPEN,
+	"lookup_dcookie":    syscall.SYS_LOOKUP_DCOOKIE,
+	"userfaultfd":       282,
+	"ptrace":            syscall.SYS_PTRACE,
+	"process_vm_readv":  syscall.SYS_PROCESS_VM_READV,
+	"process_vm_writev": syscall.SYS_PROCESS_VM_WRITEV,
+	"kcmp":              syscall.SYS_KCMP,
+	"personality":       syscall.SYS_PERSONALITY,
+	"mknodat":           syscall.SYS_MKNODAT,
+	"sethostname":       syscall.SYS_SETHOSTNAME,
+	"setdomainname":     syscall.SYS_SETDOMAINNAME,
+	"io_uring_setup":    425,
+	"io_uring_enter":    426,
+	"io_uring_register": 427,
+	"mbind":             syscall.SYS_MBIND,
+	"set_mempolicy":     syscall.SYS_SET_MEMPOLICY,
+	"migrate_pages":     syscall.SYS_MIGRATE_PAGES,
+	"move_pages":        syscall.SYS_MOVE_PAGES,
+}
@@ -0,0 +1,65 @@
+// Package statefile provides helpers to persist the server state as JSON files.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const dirPerm = 0o700
+
+// Load decodes the given file in v.
+// If the file doesn't exist, v is left untouched and no error is returned.
+func Load(path string, v any) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read state file: %w", err)
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("decode state file %q: %w", path, err)
+	}
+	return nil
+}
+
+// Save atomically writes v as JSON to the given file, creating the parent directory if needed.
+// The data is written in a temporary file (0600) in the same directory, then renamed.
+func Save(path string, v any) (err error) { //nolint:nonamedreturns // Used for defer cleanup.
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp state file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name()) // Best effort.
+		}
+	}()
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close() // Best effort.
+		return fmt.Errorf("write temp state file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close() // Best effort.
+		return fmt.Errorf("sync temp state file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp state file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename temp state file: %w", err)
+	}
+	return nil
+}
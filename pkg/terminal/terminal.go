@@ -0,0 +1,110 @@
+// Package terminal provides the minimal pseudo-terminal and termios helpers
+// used by the exec sessions, using only the standard library syscalls.
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Size of a terminal.
+type Size struct {
+	Rows, Cols uint16
+}
+
+// winsize is the kernel's struct winsize.
+type winsize struct {
+	Rows, Cols, X, Y uint16
+}
+
+// ioctl on the given file without switching it to blocking mode, unlike f.Fd().
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("syscall conn: %w", err)
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	}); err != nil {
+		return fmt.Errorf("control: %w", err)
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenPTY allocates a new pseudo-terminal and returns its master and slave sides.
+func OpenPTY() (master, slave *os.File, err error) { //nolint:nonamedreturns // Named for readability.
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open ptmx: %w", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		_ = master.Close() // Best effort.
+		return nil, nil, fmt.Errorf("unlock pty: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		_ = master.Close() // Best effort.
+		return nil, nil, fmt.Errorf("lookup pty number: %w", err)
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		_ = master.Close() // Best effort.
+		return nil, nil, fmt.Errorf("open pty slave: %w", err)
+	}
+	return master, slave, nil
+}
+
+// SetSize sets the size of the given terminal.
+func SetSize(f *os.File, size Size) error {
+	ws := winsize{Rows: size.Rows, Cols: size.Cols}
+	if err := ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return fmt.Errorf("set winsize: %w", err)
+	}
+	return nil
+}
+
+// GetSize returns the size of the given terminal.
+func GetSize(f *os.File) (Size, error) {
+	var ws winsize
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return Size{}, fmt.Errorf("get winsize: %w", err)
+	}
+	return Size{Rows: ws.Rows, Cols: ws.Cols}, nil
+}
+
+// IsTerminal checks if the given file is a terminal.
+func IsTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
+
+// MakeRaw puts the given terminal in raw mode, like cfmakeraw(3).
+// Returns a function to restore the previous state.
+func MakeRaw(f *os.File) (func() error, error) {
+	var termios syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		return nil, fmt.Errorf("get termios: %w", err)
+	}
+	prev := termios
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&termios)); err != nil {
+		return nil, fmt.Errorf("set termios: %w", err)
+	}
+	return func() error { return ioctl(f, syscall.TCSETS, unsafe.Pointer(&prev)) }, nil
+}
@@ -0,0 +1,176 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Common errors.
+var (
+	ErrCertificateRevoked = errors.New("certificate revoked")
+	ErrInvalidCRL         = errors.New("invalid crl")
+)
+
+// crlFile is a loaded CRL file.
+type crlFile struct {
+	path string
+
+	// Stat of the file when loaded, to detect changes.
+	modTime time.Time
+	size    int64
+
+	issuer  []byte               // Raw issuer of the CRL.
+	revoked map[string]time.Time // Revocation time by serial number.
+}
+
+// crlChecker checks the peer certificates against CRL files, reloaded when they change on disk.
+type crlChecker struct {
+	mu    sync.Mutex
+	cas   []*x509.Certificate // CAs the CRLs are expected to be signed by.
+	files []*crlFile
+}
+
+// newCRLChecker loads the given CRL files, which must be signed by one of the given CAs.
+func newCRLChecker(cas []*x509.Certificate, paths []string) (*crlChecker, error) {
+	c := &crlChecker{cas: cas}
+	for _, path := range paths {
+		f := &crlFile{path: path}
+		if err := c.load(f); err != nil {
+			return nil, err
+		}
+		c.files = append(c.files, f)
+	}
+	return c, nil
+}
+
+// load (or reload) the given CRL file. f is left untouched on error.
+//
+// NOTE: Expected to be called with the lock held, or before the checker is shared.
+func (c *crlChecker) load(f *crlFile) error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("stat crl file: %w", err)
+	}
+	buf, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("read crl file: %w", err)
+	}
+	// Accept both PEM and DER.
+	if block, _ := pem.Decode(buf); block != nil {
+		buf = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(buf)
+	if err != nil {
+		return fmt.Errorf("%w: parse %q: %w", ErrInvalidCRL, f.path, err)
+	}
+	signed := false
+	for _, ca := range c.cas {
+		if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return fmt.Errorf("%w: %q not signed by a known CA", ErrInvalidCRL, f.path)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		// Still enforced, better than nothing.
+		slog.Warn("CRL past its next update, it should be renewed.", "crl", f.path, "next_update", crl.NextUpdate)
+	}
+
+	revoked := make(map[string]time.Time, len(crl.RevokedCertificateEntries))
+	for _, elem := range crl.RevokedCertificateEntries {
+		revoked[elem.SerialNumber.String()] = elem.RevocationTime
+	}
+	f.modTime, f.size = st.ModTime(), st.Size()
+	f.issuer, f.revoked = crl.RawIssuer, revoked
+	return nil
+}
+
+// setCAs updates the CAs the CRLs are expected to be signed by, for the next reloads.
+func (c *crlChecker) setCAs(cas []*x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cas = cas
+}
+
+// reloadChanged reloads the CRL files which changed on disk since loaded.
+// On failure, the previous version is kept and the error is logged.
+//
+// NOTE: Expected to be called with the lock held.
+func (c *crlChecker) reloadChanged() {
+	for _, f := range c.files {
+		st, err := os.Stat(f.path)
+		if err == nil && st.ModTime().Equal(f.modTime) && st.Size() == f.size {
+			continue
+		}
+		if err := c.load(f); err != nil {
+			slog.Error("Failed to reload the CRL, keeping the previous one.", "crl", f.path, "error", err)
+			continue
+		}
+		slog.Info("CRL reloaded.", "crl", f.path, "revoked", len(f.revoked))
+	}
+}
+
+// lookup returns the CRL file revoking the given certificate, if any.
+//
+// NOTE: Expected to be called with the lock held.
+func (c *crlChecker) lookup(cert *x509.Certificate) (*crlFile, time.Time, bool) {
+	serial := cert.SerialNumber.String()
+	for _, f := range c.files {
+		if !bytes.Equal(f.issuer, cert.RawIssuer) {
+			continue
+		}
+		if revokedAt, ok := f.revoked[serial]; ok {
+			return f, revokedAt, true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+// checkRevoked returns ErrCertificateRevoked if the given certificate is revoked.
+func (c *crlChecker) checkRevoked(cert *x509.Certificate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloadChanged()
+
+	if _, _, ok := c.lookup(cert); ok {
+		return fmt.Errorf("%w: serial %s", ErrCertificateRevoked, cert.SerialNumber)
+	}
+	return nil
+}
+
+// VerifyPeerCertificate implements tls.Config.VerifyPeerCertificate. Rejects the chains including a revoked certificate.
+// Called once the chains are verified by the standard library.
+func (c *crlChecker) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloadChanged()
+
+	for _, chain := range verifiedChains {
+		// NOTE: The last certificate is the trusted root, it can't be revoked by a CRL.
+		for i := 0; i < len(chain)-1; i++ {
+			cert := chain[i]
+			f, revokedAt, ok := c.lookup(cert)
+			if !ok {
+				continue
+			}
+			slog.Warn("Rejected revoked certificate.",
+				"audit", true,
+				"subject", cert.Subject.String(),
+				"serial", cert.SerialNumber.String(),
+				"revoked_at", revokedAt,
+				"crl", f.path,
+			)
+			return fmt.Errorf("%w: serial %s", ErrCertificateRevoked, cert.SerialNumber)
+		}
+	}
+	return nil
+}
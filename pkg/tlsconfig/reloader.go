@@ -0,0 +1,197 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// watchInterval is the delay between checks for changes of the files.
+const watchInterval = 5 * time.Second
+
+// Common errors.
+var (
+	ErrInvalidCertificate = errors.New("invalid certificate")
+)
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// material is a loaded version of the server certificate and CA.
+type material struct {
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool
+	cas         []*x509.Certificate
+
+	stamps []fileStamp // Of the cert, key and CA files when loaded.
+}
+
+// Reloader serves the server TLS config, reloading the certificate, key and CA files
+// when they change on disk or on demand. The new files are validated before being used.
+// Only the new connections use the new files, the established ones are left intact.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	crl        *crlChecker // Nil when not enabled.
+	clientAuth tls.ClientAuthType
+
+	mu      sync.Mutex // Serializes the reloads.
+	current atomic.Pointer[material]
+}
+
+// NewReloader loads the server certificate, key and CA files.
+func NewReloader(certFile, keyFile, caFile string, opts ...Option) (*Reloader, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, clientAuth: o.clientAuth()}
+	m, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(o.crlFiles) > 0 {
+		checker, err := newCRLChecker(m.cas, o.crlFiles)
+		if err != nil {
+			return nil, fmt.Errorf("load crl: %w", err)
+		}
+		r.crl = checker
+	}
+	r.current.Store(m)
+	return r, nil
+}
+
+// stat returns the stamps of the cert, key and CA files.
+func (r *Reloader) stat() ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, 3) //nolint:mnd // Cert, key and CA.
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		st, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("stat %q: %w", name, err)
+		}
+		stamps = append(stamps, fileStamp{modTime: st.ModTime(), size: st.Size()})
+	}
+	return stamps, nil
+}
+
+// load and validate the files: the key must match the certificate, which must be valid now, and the CA must parse.
+func (r *Reloader) load() (*material, error) {
+	// Stat first, so a change while loading is picked up by the next check.
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("%w: %q only valid from %s to %s", ErrInvalidCertificate, r.certFile, leaf.NotBefore, leaf.NotAfter)
+	}
+	certificate.Leaf = leaf
+
+	ca, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("faild to read CA certificate: %w", err)
+	}
+	cas, err := parseCertificates(ca)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA certificate: %w", err)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("%w: no CA certificate in %q", ErrInvalidCertificate, r.caFile)
+	}
+	capool := x509.NewCertPool()
+	for _, elem := range cas {
+		capool.AddCert(elem)
+	}
+	return &material{certificate: certificate, clientCAs: capool, cas: cas, stamps: stamps}, nil
+}
+
+// Reload the files. The current ones are kept if the new ones are invalid.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.load()
+	if err != nil {
+		return err
+	}
+	if r.crl != nil {
+		r.crl.setCAs(m.cas)
+	}
+	r.current.Store(m)
+	slog.Info("TLS certificates reloaded.", "cert", r.certFile, "not_after", m.certificate.Leaf.NotAfter)
+	return nil
+}
+
+// sameStamps checks whether the given stamps identify the same versions of the files.
+func sameStamps(a, b []fileStamp) bool {
+	return slices.EqualFunc(a, b, func(x, y fileStamp) bool { return x.modTime.Equal(y.modTime) && x.size == y.size })
+}
+
+// Watch reloads the files when they change, until the context is done.
+// Invalid files are logged and ignored until they change again.
+func (r *Reloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	var failed []fileStamp // Stamps of the last invalid version, not to retry it.
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stamps, err := r.stat()
+		if err != nil || sameStamps(stamps, r.current.Load().stamps) || sameStamps(stamps, failed) {
+			// NOTE: Failing to stat likely means a file is being replaced, try again later.
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Error("Failed to reload the TLS certificates, keeping the current ones.", "error", err)
+			failed = stamps
+		}
+	}
+}
+
+// CheckRevoked returns ErrCertificateRevoked if the given client certificate is revoked by the CRL files,
+// i.e. to re-check the certificate of a user after the connection. Always nil when no CRL is enabled.
+func (r *Reloader) CheckRevoked(cert *x509.Certificate) error {
+	if r.crl == nil {
+		return nil
+	}
+	return r.crl.checkRevoked(cert)
+}
+
+// TLSConfig returns the server TLS config, using the latest loaded files for each new connection.
+func (r *Reloader) TLSConfig() *tls.Config {
+	tlsConfig := baseConfig()
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m := r.current.Load()
+		cfg := baseConfig()
+		cfg.Certificates = []tls.Certificate{m.certificate}
+		cfg.ClientAuth = r.clientAuth
+		cfg.ClientCAs = m.clientCAs
+		// NOTE: The config returned here is used as-is, the gRPC credentials can't set the ALPN protocol on it.
+		cfg.NextProtos = []string{"h2"}
+		if r.crl != nil {
+			cfg.VerifyPeerCertificate = r.crl.VerifyPeerCertificate
+		}
+		return cfg, nil
+	}
+	return tlsConfig
+}
@@ -4,18 +4,57 @@ package tlsconfig
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
 )
 
-// LoadTLSConfig loads the certs from file.
+// options of the TLS config.
+type options struct {
+	crlFiles            []string
+	optionalClientCerts bool
+}
+
+// Option configures the TLS config.
+type Option func(*options)
+
+// WithCRLFiles rejects the peer certificates revoked by the given CRL files, PEM or DER encoded,
+// signed by the CA. The files are reloaded when they change on disk.
+func WithCRLFiles(paths ...string) Option {
+	return func(o *options) { o.crlFiles = append(o.crlFiles, paths...) }
+}
+
+// WithOptionalClientCerts accepts the clients without certificate, i.e. to enroll.
+// The certificates given are still verified, the server is expected to reject the calls without identity.
+func WithOptionalClientCerts() Option {
+	return func(o *options) { o.optionalClientCerts = true }
+}
+
+// clientAuth returns the client auth mode of the server.
+func (o options) clientAuth() tls.ClientAuthType {
+	if o.optionalClientCerts {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
+// LoadTLSConfig loads the certs from file. Clients can omit the cert and key files to connect without certificate.
 //
 // NOTE: We currently use the same CA for clients/server, for production, should use distinct ones.
-func LoadTLSConfig(certFile, keyFile, caFile string, isClient bool) (*tls.Config, error) {
-	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load certificate: %w", err)
+func LoadTLSConfig(certFile, keyFile, caFile string, isClient bool, opts ...Option) (*tls.Config, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var certificates []tls.Certificate
+	if certFile != "" || !isClient {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
 	}
 
 	ca, err := os.ReadFile(caFile)
@@ -28,7 +67,34 @@ func LoadTLSConfig(certFile, keyFile, caFile string, isClient bool) (*tls.Config
 		return nil, errors.New("unable to append the CA certificate to CA pool") //nolint: err113 // Acceptable.
 	}
 
-	tlsConfig := &tls.Config{
+	tlsConfig := baseConfig()
+	tlsConfig.Certificates = certificates
+
+	if len(o.crlFiles) > 0 {
+		cas, err := parseCertificates(ca)
+		if err != nil {
+			return nil, fmt.Errorf("parse CA certificate: %w", err)
+		}
+		checker, err := newCRLChecker(cas, o.crlFiles)
+		if err != nil {
+			return nil, fmt.Errorf("load crl: %w", err)
+		}
+		tlsConfig.VerifyPeerCertificate = checker.VerifyPeerCertificate
+	}
+
+	if isClient {
+		tlsConfig.RootCAs = capool
+	} else {
+		tlsConfig.ClientAuth = o.clientAuth()
+		tlsConfig.ClientCAs = capool
+	}
+
+	return tlsConfig, nil
+}
+
+// baseConfig returns the common settings of the TLS configs.
+func baseConfig() *tls.Config {
+	return &tls.Config{
 		MinVersion: tls.VersionTLS13,
 		CurvePreferences: []tls.CurveID{
 			tls.X25519,
@@ -37,15 +103,26 @@ func LoadTLSConfig(certFile, keyFile, caFile string, isClient bool) (*tls.Config
 			tls.CurveP256,
 		},
 		// NOTE: CipherSuites are ignored in TLS1.3.
-		Certificates: []tls.Certificate{certificate},
 	}
+}
 
-	if isClient {
-		tlsConfig.RootCAs = capool
-	} else {
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		tlsConfig.ClientCAs = capool
+// parseCertificates parses all the certificates of the given PEM data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
 	}
-
-	return tlsConfig, nil
+	return certs, nil
 }
@@ -0,0 +1,406 @@
+// Package workflow runs DAGs of jobs, starting each step once its dependencies are done.
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/jobmanager"
+)
+
+// Common errors.
+var (
+	ErrInvalidWorkflow  = errors.New("invalid workflow")
+	ErrWorkflowNotFound = errors.New("workflow not found")
+)
+
+// Condition is the requirement on the dependencies of a step for it to start.
+type Condition int
+
+// Available conditions.
+const (
+	// OnSuccess starts the step when all its dependencies succeeded (default).
+	OnSuccess Condition = iota
+	// OnFailure starts the step when any of its dependencies did not succeed, i.e. to cleanup or notify.
+	OnFailure
+	// Always starts the step once all its dependencies are done, regardless of their outcome.
+	Always
+)
+
+// Status of a workflow or of one of its steps.
+type Status int
+
+// Available statuses.
+const (
+	// StatusPending is the status of a step waiting for its dependencies.
+	StatusPending Status = iota
+	// StatusRunning is the status of a running step or workflow.
+	StatusRunning
+	// StatusSucceeded is the status of a step whose job exited with code 0, or a workflow without failed step.
+	StatusSucceeded
+	// StatusFailed is the status of a step whose job failed to start, exited with a non-zero code,
+	// was stopped or timed out, or a workflow with a failed step.
+	StatusFailed
+	// StatusCanceled is the status of a step never started as its condition can't be met anymore.
+	StatusCanceled
+)
+
+// Step of a workflow.
+type Step struct {
+	Name      string
+	Spec      jobmanager.JobSpec
+	DependsOn []string  // Optional. Names of the steps to wait for.
+	Condition Condition // Optional. Defaults to OnSuccess.
+}
+
+// StepState is the state of a step.
+type StepState struct {
+	Name     string
+	Status   Status
+	JobID    uuid.UUID // uuid.Nil until started.
+	ExitCode int       // Exit code of the job, once done.
+	Error    string    // Set when the job failed to start.
+}
+
+// Workflow is the state of a submitted workflow.
+type Workflow struct {
+	ID        uuid.UUID
+	Owner     string
+	Status    Status
+	CreatedAt time.Time
+	EndedAt   time.Time // Zero until all the steps are done.
+	Steps     []StepState
+}
+
+// step is a step being run.
+type step struct {
+	Step
+	state StepState
+
+	deps       []*step
+	dependents []*step
+}
+
+// workflow is a workflow being run.
+type workflow struct {
+	id        uuid.UUID
+	owner     string
+	status    Status
+	createdAt time.Time
+	endedAt   time.Time
+
+	steps []*step // Submission order.
+}
+
+// Controller runs the workflows.
+type Controller struct {
+	mu        sync.Mutex
+	workflows map[uuid.UUID]*workflow
+
+	jm        *jobmanager.JobManager
+	authorize jobmanager.AuthorizeFunc // Nil when not checked.
+
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewController creates the workflow controller.
+// When not nil, authorize is checked before starting each step, as the policy may have changed since submitted.
+func NewController(jm *jobmanager.JobManager, authorize jobmanager.AuthorizeFunc) *Controller {
+	return &Controller{
+		workflows: map[uuid.UUID]*workflow{},
+		jm:        jm,
+		authorize: authorize,
+		closeChan: make(chan struct{}),
+	}
+}
+
+// Close stops the controller. The running jobs are left running, the pending steps are never started.
+func (c *Controller) Close() {
+	close(c.closeChan)
+	c.wg.Wait()
+}
+
+// Submit validates the given steps and starts the workflow for the given owner.
+// The steps without dependencies are started right away.
+func (c *Controller) Submit(owner string, steps []Step) (uuid.UUID, error) {
+	w, err := newWorkflow(owner, steps)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	for _, s := range w.steps {
+		if err := c.jm.ValidateSpec(owner, s.Spec); err != nil {
+			return uuid.Nil, fmt.Errorf("validate job spec of step %q: %w", s.Name, err)
+		}
+	}
+
+	c.mu.Lock()
+	c.workflows[w.id] = w
+	c.mu.Unlock()
+	slog.Info("Workflow submitted.", "workflow_id", w.id.String(), "steps", len(w.steps))
+	c.advance(w)
+	return w.id, nil
+}
+
+// Lookup returns the state of the given workflow of the given owner.
+func (c *Controller) Lookup(owner string, id uuid.UUID) (Workflow, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.workflows[id]
+	if !ok || w.owner != owner {
+		// NOTE: Same error for the workflows of other owners to avoid leaking them.
+		return Workflow{}, ErrWorkflowNotFound
+	}
+	out := Workflow{
+		ID:        w.id,
+		Owner:     w.owner,
+		Status:    w.status,
+		CreatedAt: w.createdAt,
+		EndedAt:   w.endedAt,
+		Steps:     make([]StepState, 0, len(w.steps)),
+	}
+	for _, s := range w.steps {
+		out.Steps = append(out.Steps, s.state)
+	}
+	return out, nil
+}
+
+// newWorkflow validates the steps and builds the graph.
+func newWorkflow(owner string, steps []Step) (*workflow, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%w: no steps", ErrInvalidWorkflow)
+	}
+	w := &workflow{
+		id:        uuid.New(),
+		owner:     owner,
+		status:    StatusRunning,
+		createdAt: time.Now(),
+	}
+	byName := make(map[string]*step, len(steps))
+	for _, elem := range steps {
+		if elem.Name == "" {
+			return nil, fmt.Errorf("%w: missing step name", ErrInvalidWorkflow)
+		}
+		if _, ok := byName[elem.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate step %q", ErrInvalidWorkflow, elem.Name)
+		}
+		if elem.Condition < OnSuccess || elem.Condition > Always {
+			return nil, fmt.Errorf("%w: step %q: unknown condition %d", ErrInvalidWorkflow, elem.Name, elem.Condition)
+		}
+		if elem.Condition == OnFailure && len(elem.DependsOn) == 0 {
+			return nil, fmt.Errorf("%w: step %q: on failure condition without dependencies", ErrInvalidWorkflow, elem.Name)
+		}
+		s := &step{Step: elem, state: StepState{Name: elem.Name, Status: StatusPending}}
+		byName[elem.Name] = s
+		w.steps = append(w.steps, s)
+	}
+	for _, s := range w.steps {
+		for _, name := range s.DependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: step %q depends on unknown step %q", ErrInvalidWorkflow, s.Name, name)
+			}
+			if dep == s {
+				return nil, fmt.Errorf("%w: step %q depends on itself", ErrInvalidWorkflow, s.Name)
+			}
+			s.deps = append(s.deps, dep)
+			dep.dependents = append(dep.dependents, s)
+		}
+	}
+	if name, ok := findCycle(w.steps); ok {
+		return nil, fmt.Errorf("%w: dependency cycle through step %q", ErrInvalidWorkflow, name)
+	}
+	return w, nil
+}
+
+// findCycle looks for a dependency cycle, using Kahn's algorithm.
+// Returns the name of a step part of, or depending on, a cycle.
+func findCycle(steps []*step) (string, bool) {
+	pending := make(map[*step]int, len(steps))
+	var ready []*step
+	for _, s := range steps {
+		pending[s] = len(s.deps)
+		if len(s.deps) == 0 {
+			ready = append(ready, s)
+		}
+	}
+	for len(ready) > 0 {
+		s := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		delete(pending, s)
+		for _, dependent := range s.dependents {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	for _, s := range steps {
+		if _, ok := pending[s]; ok {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+// done returns whether the status is terminal.
+func (s Status) done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// ready returns whether all the dependencies of the step are done and whether its condition is met.
+func (s *step) ready() (done, met bool) { //nolint:nonamedreturns // Named for documentation.
+	allSucceeded := true
+	for _, dep := range s.deps {
+		if !dep.state.Status.done() {
+			return false, false
+		}
+		if dep.state.Status != StatusSucceeded {
+			allSucceeded = false
+		}
+	}
+	switch s.Condition {
+	case OnSuccess:
+		return true, allSucceeded
+	case OnFailure:
+		return true, !allSucceeded
+	case Always:
+	}
+	return true, true
+}
+
+// advance starts the pending steps whose dependencies are done and cancels the ones whose condition
+// is not met, until nothing changes. Ends the workflow once all the steps are done.
+// The steps to start are collected under the lock, then started without it.
+func (c *Controller) advance(w *workflow) {
+	for {
+		c.mu.Lock()
+		runnable := c.collectLocked(w)
+		c.mu.Unlock()
+		if len(runnable) == 0 {
+			return
+		}
+		// NOTE: The steps failing to start may make others ready, loop until nothing changes.
+		for _, s := range runnable {
+			c.start(w, s)
+		}
+	}
+}
+
+// collectLocked cancels the pending steps whose dependencies are done but whose condition is not met,
+// and returns the ones to start, marked running so they are started only once.
+// Ends the workflow when all the steps are done.
+//
+// NOTE: Expected to be called with the lock held.
+func (c *Controller) collectLocked(w *workflow) []*step {
+	if w.status.done() {
+		return nil
+	}
+	var runnable []*step
+	for changed := true; changed; {
+		changed = false
+		for _, s := range w.steps {
+			if s.state.Status != StatusPending {
+				continue
+			}
+			done, met := s.ready()
+			if !done {
+				continue
+			}
+			if !met {
+				changed = true
+				s.state.Status = StatusCanceled
+				slog.Info("Workflow step canceled.", "workflow_id", w.id.String(), "step", s.Name)
+				continue
+			}
+			s.state.Status = StatusRunning // JobID unset until started.
+			runnable = append(runnable, s)
+		}
+	}
+	if len(runnable) != 0 {
+		return runnable
+	}
+
+	failed := false
+	for _, s := range w.steps {
+		if !s.state.Status.done() {
+			return nil
+		}
+		if s.state.Status == StatusFailed {
+			failed = true
+		}
+	}
+	w.status, w.endedAt = StatusSucceeded, time.Now()
+	if failed {
+		w.status = StatusFailed
+	}
+	slog.Info("Workflow ended.", "workflow_id", w.id.String(), "failed", failed)
+	return nil
+}
+
+// start authorizes and starts the job of the step, then waits for it in the background.
+// The step fails right away if the job can't be started.
+//
+// NOTE: Expected to be called without the lock held, starting a job being slow.
+func (c *Controller) start(w *workflow, s *step) {
+	logger := slog.With("workflow_id", w.id.String(), "step", s.Name)
+
+	fail := func(reason string) {
+		c.mu.Lock()
+		s.state.Status, s.state.Error = StatusFailed, reason
+		c.mu.Unlock()
+	}
+
+	if c.authorize != nil {
+		if err := c.authorize(w.owner, s.Spec); err != nil {
+			logger.Warn("Workflow step denied.", "audit", true, "owner", w.owner, "error", err)
+			fail("denied: " + err.Error())
+			return
+		}
+	}
+
+	spec := s.Spec
+	spec.WorkflowID = w.id
+	jobID, err := c.jm.StartJob(w.owner, spec)
+	if err != nil {
+		logger.Error("Failed to start the workflow step.", "error", err)
+		fail(err.Error())
+		return
+	}
+	j, err := c.jm.LookupJob(jobID)
+	if err != nil {
+		// Not supposed to happen as just started.
+		logger.Error("Failed to lookup the workflow step job.", "job_id", jobID.String(), "error", err)
+		fail(err.Error())
+		return
+	}
+	logger.Info("Workflow step started.", "job_id", jobID.String())
+	c.mu.Lock()
+	s.state.JobID = jobID
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		select {
+		case <-c.closeChan:
+			return
+		case <-j.Done():
+		}
+		exitCode, status := j.ExitCode(), StatusFailed
+		if j.Status() == pb.JobStatus_JOB_STATUS_EXITED && exitCode == 0 {
+			status = StatusSucceeded
+		}
+		c.mu.Lock()
+		s.state.ExitCode, s.state.Status = exitCode, status
+		c.mu.Unlock()
+		logger.Info("Workflow step ended.", "job_id", jobID.String(), "job_status", j.Status().String(), "exit_code", exitCode)
+		c.advance(w)
+	}()
+}
@@ -0,0 +1,74 @@
+package workflow //nolint:testpackage // Expected to test the internal package to validate the graph without a job manager.
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewWorkflowCycles(t *testing.T) {
+	t.Parallel()
+
+	// steps is a helper to create the steps from "name:dep,dep" descriptions.
+	steps := func(descs ...string) []Step {
+		out := make([]Step, 0, len(descs))
+		for _, desc := range descs {
+			name, deps, _ := strings.Cut(desc, ":")
+			s := Step{Name: name}
+			if deps != "" {
+				s.DependsOn = strings.Split(deps, ",")
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+
+	for name, tc := range map[string]struct {
+		steps []Step
+		cycle string // Step reported in the cycle, empty when valid.
+	}{
+		"single":            {steps("a"), ""},
+		"chain":             {steps("a", "b:a", "c:b"), ""},
+		"diamond":           {steps("a", "b:a", "c:a", "d:b,c"), ""},
+		"unordered":         {steps("d:b,c", "c:a", "b:a", "a"), ""},
+		"disjoint":          {steps("a", "b:a", "c", "d:c"), ""},
+		"two steps":         {steps("a:b", "b:a"), "a"},
+		"three steps":       {steps("a", "b:a,d", "c:b", "d:c"), "b"},
+		"dependent":         {steps("x:c", "a", "b:a,c", "c:b"), "x"},
+		"all in cycle":      {steps("a:c", "b:a", "c:b"), "a"},
+		"cycle and a chain": {steps("a", "b:a", "c:d", "d:c"), "c"},
+	} {
+		w, err := newWorkflow("alice", tc.steps)
+		if tc.cycle == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s.", name, err)
+			} else if len(w.steps) != len(tc.steps) {
+				t.Errorf("%s: expected %d steps, got %d.", name, len(tc.steps), len(w.steps))
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidWorkflow) {
+			t.Errorf("%s: expected invalid workflow error, got: %v.", name, err)
+			continue
+		}
+		if expect := "dependency cycle through step " + strconv.Quote(tc.cycle); !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected %q, got: %s.", name, expect, err)
+		}
+	}
+
+	// The other invalid graphs are rejected before looking for cycles.
+	for name, s := range map[string][]Step{
+		"no steps":     nil,
+		"missing name": {{}},
+		"duplicate":    steps("a", "a"),
+		"self":         steps("a:a"),
+		"unknown":      steps("a:b"),
+		"condition":    {{Name: "a", Condition: Always + 1}},
+		"on failure":   {{Name: "a", Condition: OnFailure}},
+	} {
+		if _, err := newWorkflow("alice", s); !errors.Is(err, ErrInvalidWorkflow) {
+			t.Errorf("%s: expected invalid workflow error, got: %v.", name, err)
+		}
+	}
+}
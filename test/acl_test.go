@@ -0,0 +1,67 @@
+package telepilot_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+)
+
+func TestJobACL(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	// Alice shares her job with Bob, read only.
+	jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"60"}, apiclient.WithACL(&pb.JobACLEntry{
+		User:        "bob",
+		Permissions: []pb.JobPermission{pb.JobPermission_JOB_PERMISSION_READ_STATUS, pb.JobPermission_JOB_PERMISSION_READ_LOGS},
+	}))
+	noError(t, err, "Alice start job.")
+	t.Cleanup(func() { _ = ts.alice.StopJob(ctx, jobID) }) // Best effort.
+
+	_, err = ts.bob.GetJobStatus(ctx, jobID)
+	noError(t, err, "Bob get Alice's shared job status.")
+	ok, role, err := ts.bob.CanI(ctx, "StreamLogs", jobID)
+	noError(t, err, "Bob can-i.")
+	assert(t, true, ok, "bob not allowed")
+	assert(t, "acl", role, "invalid role for bob")
+
+	// Not granted.
+	assert(t, codes.PermissionDenied, status.Code(ts.bob.SignalJob(ctx, jobID, "HUP")), "Unexpected error code for bob signal.")
+	assert(t, codes.PermissionDenied, status.Code(ts.bob.StopJob(ctx, jobID)), "Unexpected error code for bob stop.")
+	assert(t, codes.PermissionDenied, status.Code(ts.bob.SetJobACL(ctx, jobID, nil)), "Unexpected error code for bob set acl.")
+
+	// The details report the ACL.
+	details, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+	noError(t, err, "Alice get job status details.")
+	assert(t, 1, len(details.GetAcl()), "Unexpected acl entry count.")
+	assert(t, "bob", details.GetAcl()[0].GetUser(), "Unexpected acl user.")
+
+	// Invalid entries are rejected.
+	err = ts.alice.SetJobACL(ctx, jobID, []*pb.JobACLEntry{{User: "bob", Group: "oncall", Permissions: []pb.JobPermission{pb.JobPermission_JOB_PERMISSION_STOP}}})
+	assert(t, codes.InvalidArgument, status.Code(err), "Unexpected error code for invalid acl.")
+
+	// Alice lets Bob signal and stop the job.
+	noError(t, ts.alice.SetJobACL(ctx, jobID, []*pb.JobACLEntry{{
+		User:        "bob",
+		Permissions: []pb.JobPermission{pb.JobPermission_JOB_PERMISSION_SIGNAL, pb.JobPermission_JOB_PERMISSION_STOP},
+	}}), "Alice set acl.")
+	_, err = ts.bob.GetJobStatus(ctx, jobID)
+	assert(t, codes.PermissionDenied, status.Code(err), "Unexpected error code for revoked read status.")
+	assert(t, codes.InvalidArgument, status.Code(ts.bob.SignalJob(ctx, jobID, "NOPE")), "Unexpected error code for invalid signal.")
+	noError(t, ts.bob.SignalJob(ctx, jobID, "CONT"), "Bob signal Alice's shared job.")
+	noError(t, ts.bob.StopJob(ctx, jobID), "Bob stop Alice's shared job.")
+	assert(t, codes.FailedPrecondition, status.Code(ts.alice.SignalJob(ctx, jobID, "HUP")), "Unexpected error code for signal on stopped job.")
+
+	// Alice clears the ACL.
+	noError(t, ts.alice.SetJobACL(ctx, jobID, nil), "Alice clear acl.")
+	assert(t, codes.PermissionDenied, status.Code(ts.bob.StopJob(ctx, jobID)), "Unexpected error code for cleared acl.")
+
+	// Unknown jobs are not leaked.
+	assert(t, codes.PermissionDenied, status.Code(ts.bob.SetJobACL(ctx, uuid.NewString(), nil)), "Unexpected error code for unknown job.")
+}
@@ -0,0 +1,76 @@
+package telepilot_test
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+)
+
+func TestAdmission(t *testing.T) {
+	t.Parallel()
+
+	t.Run("queue", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithAdmission(jobmanager.AdmissionConfig{MaxJobs: 1})))
+
+		// The first job takes the only slot.
+		jobID1, err := ts.alice.StartJob(ctx, "sleep", []string{"10"})
+		noError(t, err, "Start first job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID1), "Cleanup stop first job.") })
+
+		// The following ones are queued, in order.
+		jobID2, err := ts.alice.StartJob(ctx, "echo", []string{"hello"})
+		noError(t, err, "Start second job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID2), "Cleanup stop second job.") })
+		jobID3, err := ts.alice.StartJob(ctx, "echo", []string{"world"})
+		noError(t, err, "Start third job.")
+
+		for i, jobID := range []string{jobID2, jobID3} {
+			st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+			noError(t, err, "Get queued job status.")
+			assert(t, pb.JobStatus_JOB_STATUS_QUEUED, st.GetStatus(), "job not queued")
+			assert(t, uint32(i+1), st.GetQueuePosition(), "invalid queue position")
+		}
+
+		// Stopping a queued job removes it from the queue without starting it.
+		noError(t, ts.alice.StopJob(ctx, jobID3), "Stop queued job.")
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID3)
+		noError(t, err, "Get stopped job status.")
+		assert(t, pb.JobStatus_JOB_STATUS_STOPPED, st.GetStatus(), "queued job not stopped")
+		assert(t, uint32(0), st.GetQueuePosition(), "stopped job still queued")
+
+		// Stopping the running job admits the next one.
+		noError(t, ts.alice.StopJob(ctx, jobID1), "Stop first job.")
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID2, w), "Stream logs of the admitted job.")
+		assert(t, "hello\n", w.String(), "invalid logs of the admitted job")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithAdmission(jobmanager.AdmissionConfig{
+			CPUMillisBudget:   2000,
+			MemoryBytesBudget: 1 << 30,
+		})))
+
+		for name, tc := range map[string]struct {
+			opt  apiclient.StartJobOption
+			code codes.Code
+		}{
+			"cpu too small":    {apiclient.WithResources(1, 0), codes.InvalidArgument},
+			"memory too small": {apiclient.WithResources(0, 1024), codes.InvalidArgument},
+			"cpu budget":       {apiclient.WithResources(4000, 0), codes.FailedPrecondition},
+			"memory budget":    {apiclient.WithResources(0, 2<<30), codes.FailedPrecondition},
+		} {
+			_, err := ts.alice.StartJob(ctx, "true", nil, tc.opt)
+			assert(t, tc.code, status.Code(err), "invalid grpc status code for "+name)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package telepilot_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/audit"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	auditKey := filepath.Join(t.TempDir(), "audit.key")
+	ts, ctx := newTestServer(t, apiserver.WithAuditLog(auditLog, auditKey))
+
+	_, _, err := ts.alice.CanI(ctx, "StopJob", "")
+	noError(t, err, "Alice can-i.")
+	jobID := uuid.NewString()
+	err = ts.bob.StopJob(ctx, jobID)
+	assert(t, codes.PermissionDenied, status.Code(err), "Unexpected error code for unknown job.")
+
+	f, err := os.Open(auditLog)
+	noError(t, err, "Open audit log.")
+	defer func() { _ = f.Close() }()
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e audit.Entry
+		noError(t, json.Unmarshal(scanner.Bytes(), &e), "Decode audit entry.")
+		entries = append(entries, e)
+	}
+	noError(t, scanner.Err(), "Read audit log.")
+	assert(t, 2, len(entries), "Unexpected audit entry count.")
+
+	assert(t, "alice", entries[0].User, "Unexpected user.")
+	assert(t, "CanI", entries[0].Method, "Unexpected method.")
+	assert(t, audit.DecisionAllow, entries[0].Decision, "Unexpected decision.")
+	assert(t, codes.OK.String(), entries[0].Code, "Unexpected code.")
+	assert(t, `{"method":"StopJob"}`, string(entries[0].Request), "Unexpected request summary.")
+
+	assert(t, "bob", entries[1].User, "Unexpected user.")
+	assert(t, "StopJob", entries[1].Method, "Unexpected method.")
+	assert(t, jobID, entries[1].JobID, "Unexpected job id.")
+	assert(t, audit.DecisionDeny, entries[1].Decision, "Unexpected decision.")
+	assert(t, codes.PermissionDenied.String(), entries[1].Code, "Unexpected code.")
+	assert(t, entries[0].Hash, entries[1].PrevHash, "Entries not chained.")
+
+	_, err = f.Seek(0, 0)
+	noError(t, err, "Rewind audit log.")
+	key, err := audit.LoadKey(auditKey, auditLog, false)
+	noError(t, err, "Load audit key.")
+	n, err := audit.Verify(f, key)
+	noError(t, err, "Verify audit log.")
+	assert(t, uint64(2), n, "Unexpected verified entry count.")
+}
@@ -32,7 +32,8 @@ func TestUnauthenticatedUser(t *testing.T) {
 	}
 
 	// Create a server.
-	s := apiserver.NewServer()
+	s, err := apiserver.NewServer()
+	noError(t, err, "NewServer")
 	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLSConfig)),
 		grpc.UnaryInterceptor(s.UnaryMiddleware), grpc.StreamInterceptor(s.StreamMiddleware))
 	pb.RegisterTelePilotServiceServer(grpcServer, s)
@@ -0,0 +1,203 @@
+package telepilot_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/ca"
+	"go.creack.net/telepilot/pkg/tlsconfig"
+)
+
+// newCertClient creates a client to the given address with the given PEM certificate and key, or without when empty.
+func newCertClient(t *testing.T, addr string, certPEM, keyPEM []byte) *apiclient.Client {
+	t.Helper()
+	cfg, err := tlsconfig.LoadTLSConfig("", "", "../certs/ca.pem", true)
+	noError(t, err, "Load client TLS config.")
+	if certPEM != nil {
+		certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+		noError(t, err, "Load client certificate.")
+		cfg.Certificates = []tls.Certificate{certificate}
+	}
+	client, err := apiclient.NewClient(cfg, addr)
+	noError(t, err, "New client.")
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// newTestCA creates an authority from the test CA, with its state in a temp dir.
+func newTestCA(t *testing.T) *ca.Authority {
+	t.Helper()
+	loadTLSConfig(t, "server") // Skip if the certs are missing.
+	authority, err := ca.New(ca.Config{CertFile: "../certs/ca.pem", KeyFile: "../certs/ca-key.pem", StateDir: t.TempDir()})
+	noError(t, err, "New CA.")
+	return authority
+}
+
+func TestEnrollment(t *testing.T) {
+	t.Parallel()
+
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	noError(t, os.WriteFile(policyFile, []byte(testPolicy), 0o600), "Write policy file.")
+
+	authority := newTestCA(t)
+	serverTLSConfig := loadTLSConfig(t, "server", tlsconfig.WithCRLFiles(authority.CRLFile()), tlsconfig.WithOptionalClientCerts())
+	ts, ctx := newTestServerTLS(t, serverTLSConfig, apiserver.WithPolicyFile(policyFile), apiserver.WithCertificateAuthority(authority))
+
+	// Only the admins can create tokens.
+	_, _, err := ts.bob.CreateEnrollmentToken(ctx, "erin", nil, 0, 0)
+	assert(t, codes.PermissionDenied, status.Code(err), "Unexpected error code for Bob's token.")
+	token, expiresAt, err := ts.alice.CreateEnrollmentToken(ctx, "erin", []string{"ops"}, time.Hour, 0)
+	noError(t, err, "Create enrollment token.")
+	if time.Until(expiresAt) < 23*time.Hour {
+		t.Fatalf("Unexpected token expiry %s, expected the 24h default.", expiresAt)
+	}
+
+	// Without certificate, only enrolling is allowed.
+	anonymous := newCertClient(t, ts.addr, nil, nil)
+	if _, _, err := anonymous.CanI(ctx, "StartJob", ""); err == nil {
+		t.Fatal("Expected call without certificate to be rejected.")
+	}
+	keyPEM, csrPEM, err := apiclient.GenerateKey()
+	noError(t, err, "Generate key.")
+	resp, err := anonymous.Enroll(ctx, token, csrPEM)
+	noError(t, err, "Enroll.")
+	assert(t, "erin", resp.GetUser(), "Unexpected enrolled user.")
+	if ttl := time.Until(time.UnixMilli(resp.GetNotAfterUnixMs())); ttl > time.Hour || ttl < 59*time.Minute {
+		t.Fatalf("Unexpected certificate lifetime %s.", ttl)
+	}
+
+	// The token is one-time.
+	_, err = anonymous.Enroll(ctx, token, csrPEM)
+	assert(t, codes.Unauthenticated, status.Code(err), "Unexpected error code for reused token.")
+
+	// The certificate identifies Erin and her groups.
+	block, _ := pem.Decode(resp.GetCertificate())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	noError(t, err, "Parse enrolled certificate.")
+	assert(t, "erin", cert.Subject.CommonName, "Unexpected CN.")
+	assert(t, 1, len(cert.Subject.OrganizationalUnit), "Unexpected OU count.")
+	assert(t, "ops", cert.Subject.OrganizationalUnit[0], "Unexpected OU.")
+
+	erin := newCertClient(t, ts.addr, resp.GetCertificate(), keyPEM)
+	ok, role, err := erin.CanI(ctx, "StartJob", "")
+	noError(t, err, "Erin can-i.")
+	assert(t, true, ok, "erin not allowed")
+	assert(t, "user", role, "invalid role for erin")
+
+	// Once revoked, the new connections are rejected.
+	noError(t, ts.alice.RevokeClientCert(ctx, resp.GetSerial()), "Revoke Erin's certificate.")
+	if _, _, err := newCertClient(t, ts.addr, resp.GetCertificate(), keyPEM).CanI(ctx, "StartJob", ""); err == nil {
+		t.Fatal("Expected revoked certificate to be rejected.")
+	}
+
+	// Admins can issue certificates directly, the CSR must be valid.
+	issued, err := ts.alice.IssueClientCert(ctx, csrPEM, "frank", nil, 0)
+	noError(t, err, "Issue certificate.")
+	if ttl := time.Until(time.UnixMilli(issued.GetNotAfterUnixMs())); ttl < ca.DefaultMaxTTL-time.Minute {
+		t.Fatalf("Unexpected certificate lifetime %s, expected the max.", ttl)
+	}
+	_, err = ts.alice.IssueClientCert(ctx, []byte("invalid"), "frank", nil, 0)
+	assert(t, codes.InvalidArgument, status.Code(err), "Unexpected error code for invalid CSR.")
+	_, err = ts.alice.IssueClientCert(ctx, csrPEM, "frank/admin", nil, 0)
+	assert(t, codes.InvalidArgument, status.Code(err), "Unexpected error code for invalid user.")
+}
+
+func TestEnrollmentDisabled(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+	_, err := ts.alice.Enroll(ctx, "token", nil)
+	assert(t, codes.FailedPrecondition, status.Code(err), "Unexpected error code without CA.")
+	_, _, err = ts.alice.CreateEnrollmentToken(ctx, "erin", nil, 0, 0)
+	assert(t, codes.PermissionDenied, status.Code(err), "Unexpected error code for non-admin.")
+}
+
+func TestCertRenewal(t *testing.T) {
+	t.Parallel()
+
+	authority := newTestCA(t)
+	serverTLSConfig := loadTLSConfig(t, "server", tlsconfig.WithCRLFiles(authority.CRLFile()), tlsconfig.WithOptionalClientCerts())
+	ts, ctx := newTestServerTLS(t, serverTLSConfig, apiserver.WithCertificateAuthority(authority))
+
+	// Issue a certificate expiring in 30 minutes for Gina.
+	dir := t.TempDir()
+	keyPEM, csrPEM, err := apiclient.GenerateKey()
+	noError(t, err, "Generate key.")
+	certPEM, issued, err := authority.Issue(csrPEM, "gina", []string{"ops"}, 30*time.Minute, "test")
+	noError(t, err, "Issue certificate.")
+	noError(t, apiclient.WriteClientCert(dir, "gina", certPEM, keyPEM), "Write certificate.")
+	certFile, keyFile := filepath.Join(dir, "client-gina.pem"), filepath.Join(dir, "client-gina-key.pem")
+	tlsConfig, err := tlsconfig.LoadTLSConfig(certFile, keyFile, "../certs/ca.pem", true)
+	noError(t, err, "Load client TLS config.")
+
+	// loadSerial is a helper to read the serial number and expiry of the certificate file.
+	loadSerial := func(t *testing.T) (string, time.Time) {
+		t.Helper()
+		buf, err := os.ReadFile(certFile)
+		noError(t, err, "Read certificate.")
+		block, _ := pem.Decode(buf)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		noError(t, err, "Parse certificate.")
+		return cert.SerialNumber.String(), cert.NotAfter
+	}
+
+	// Not within the threshold, the certificate is kept.
+	client, err := apiclient.NewClient(tlsConfig, ts.addr, apiclient.WithCertRenewal(certFile, keyFile, time.Minute))
+	noError(t, err, "New client.")
+	t.Cleanup(func() { _ = client.Close() })
+	_, _, err = client.CanI(ctx, "StartJob", "")
+	noError(t, err, "Gina can-i.")
+	serial, _ := loadSerial(t)
+	assert(t, issued.Serial, serial, "Unexpected renewal.")
+
+	// Within the threshold, the certificate is renewed with the short renewal lifetime and the file rewritten.
+	client, err = apiclient.NewClient(tlsConfig, ts.addr, apiclient.WithCertRenewal(certFile, keyFile, time.Hour))
+	noError(t, err, "New client.")
+	t.Cleanup(func() { _ = client.Close() })
+	_, _, err = client.CanI(ctx, "StartJob", "")
+	noError(t, err, "Gina can-i.")
+	serial, notAfter := loadSerial(t)
+	if serial == issued.Serial {
+		t.Fatal("Expected the certificate to be renewed.")
+	}
+	if ttl := time.Until(notAfter); ttl > ca.DefaultRenewTTL || ttl < ca.DefaultRenewTTL-time.Minute {
+		t.Fatalf("Unexpected renewed certificate lifetime %s.", ttl)
+	}
+
+	// The renewed certificate works with the unchanged key, for the same identity.
+	renewed, err := tlsconfig.LoadTLSConfig(certFile, keyFile, "../certs/ca.pem", true)
+	noError(t, err, "Load renewed client TLS config.")
+	client, err = apiclient.NewClient(renewed, ts.addr)
+	noError(t, err, "New client.")
+	t.Cleanup(func() { _ = client.Close() })
+	ok, role, err := client.CanI(ctx, "RenewCertificate", "")
+	noError(t, err, "Gina can-i with the renewed certificate.")
+	assert(t, true, ok, "gina not allowed")
+	assert(t, "user", role, "invalid role for gina")
+
+	// Revoking the original certificate revokes the renewed one as well, and it can't be renewed anymore.
+	descendants, err := authority.Revoke(issued.Serial)
+	noError(t, err, "Revoke Gina's original certificate.")
+	assert(t, 1, len(descendants), "invalid revoked descendants count")
+	assert(t, serial, descendants[0], "invalid revoked descendant")
+	client, err = apiclient.NewClient(renewed, ts.addr)
+	noError(t, err, "New client.")
+	t.Cleanup(func() { _ = client.Close() })
+	if _, _, err := client.CanI(ctx, "StartJob", ""); err == nil {
+		t.Fatal("Expected the certificate renewed from a revoked one to be rejected.")
+	}
+	if _, _, err := authority.Renew(csrPEM, issued.Serial, "gina", []string{"ops"}, 0); !errors.Is(err, ca.ErrRevoked) {
+		t.Fatalf("Expected renewing a revoked certificate to fail, got: %v.", err)
+	}
+}
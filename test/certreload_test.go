@@ -0,0 +1,105 @@
+package telepilot_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.creack.net/telepilot/pkg/tlsconfig"
+)
+
+// writeServerCert writes a new server certificate and key signed by the test CA, valid from notBefore for a day.
+// Returns the serial number of the certificate.
+func writeServerCert(t *testing.T, certFile, keyFile string, notBefore time.Time) *big.Int {
+	t.Helper()
+
+	ca, caKey := loadTestCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	noError(t, err, "Generate server key.")
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127)) //nolint:mnd // 128 bits serial.
+	noError(t, err, "Generate serial.")
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	noError(t, err, "Create server certificate.")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	noError(t, err, "Marshal server key.")
+
+	noError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600), "Write server cert.")
+	noError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600), "Write server key.")
+	return serial
+}
+
+func TestCertReload(t *testing.T) {
+	t.Parallel()
+
+	aliceTLSConfig := loadTLSConfig(t, "client-alice")
+
+	// Copy the CA to a temp dir along with a fresh server certificate.
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, "ca.pem")
+	ca, err := os.ReadFile(path.Join("../certs", "ca.pem"))
+	noError(t, err, "Read CA.")
+	noError(t, os.WriteFile(caFile, ca, 0o600), "Write CA.")
+	serial1 := writeServerCert(t, certFile, keyFile, time.Now().Add(-time.Minute))
+
+	certs, err := tlsconfig.NewReloader(certFile, keyFile, caFile)
+	noError(t, err, "New reloader.")
+	ts, ctx := newTestServerTLS(t, certs.TLSConfig())
+
+	// serverSerial is a helper to lookup the serial number of the certificate presented by the server on a new connection.
+	serverSerial := func(t *testing.T) *big.Int {
+		t.Helper()
+		cfg := aliceTLSConfig.Clone()
+		cfg.NextProtos = []string{"h2"}
+		conn, err := tls.Dial("tcp", ts.addr, cfg)
+		noError(t, err, "Dial server.")
+		defer func() { _ = conn.Close() }()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	// Establish a connection with the initial certificate.
+	_, _, err = ts.alice.CanI(ctx, "StartJob", "")
+	noError(t, err, "Alice call.")
+	assert(t, 0, serial1.Cmp(serverSerial(t)), "invalid initial server certificate")
+
+	// Rotate the certificate.
+	serial2 := writeServerCert(t, certFile, keyFile, time.Now().Add(-time.Minute))
+	noError(t, certs.Reload(), "Reload certificates.")
+	assert(t, 0, serial2.Cmp(serverSerial(t)), "server certificate not rotated")
+
+	// The established connection is left intact.
+	_, _, err = ts.alice.CanI(ctx, "StartJob", "")
+	noError(t, err, "Alice call on the established connection.")
+
+	// Invalid material is rejected, the current certificate is kept.
+	otherDir := t.TempDir()
+	writeServerCert(t, filepath.Join(otherDir, "server.pem"), keyFile, time.Now().Add(-time.Minute)) // Key mismatch.
+	if err := certs.Reload(); err == nil {
+		t.Fatal("Expected mismatched key to be rejected.")
+	}
+	writeServerCert(t, certFile, keyFile, time.Now().Add(time.Hour)) // Not yet valid.
+	if err := certs.Reload(); err == nil {
+		t.Fatal("Expected not yet valid certificate to be rejected.")
+	}
+	assert(t, 0, serial2.Cmp(serverSerial(t)), "server certificate changed after invalid reload")
+}
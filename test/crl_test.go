@@ -0,0 +1,108 @@
+package telepilot_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/tlsconfig"
+)
+
+// readPEM reads the first PEM block of the given file from the certs dir.
+func readPEM(t *testing.T, name string) []byte {
+	t.Helper()
+	buf, err := os.ReadFile(path.Join("../certs", name))
+	noError(t, err, "Read "+name)
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		t.Fatalf("No PEM data in %q.", name)
+	}
+	return block.Bytes
+}
+
+// loadTestCA loads the test CA certificate and key, to sign test material.
+func loadTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	ca, err := x509.ParseCertificate(readPEM(t, "ca.pem"))
+	noError(t, err, "Parse CA certificate.")
+	caKey, err := x509.ParseECPrivateKey(readPEM(t, "ca-key.pem"))
+	noError(t, err, "Parse CA key.")
+	return ca, caKey
+}
+
+// writeCRL writes a CRL signed by the test CA revoking the certificates of the given clients.
+func writeCRL(t *testing.T, crlFile string, number int64, clients ...string) {
+	t.Helper()
+
+	ca, caKey := loadTestCA(t)
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, name := range clients {
+		cert, err := x509.ParseCertificate(readPEM(t, "client-"+name+".pem"))
+		noError(t, err, "Parse client certificate.")
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca, caKey)
+	noError(t, err, "Create CRL.")
+	noError(t, os.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600), "Write CRL.")
+}
+
+func TestCRL(t *testing.T) {
+	t.Parallel()
+
+	// Make sure the certs are present before writing the CRL.
+	aliceTLSConfig := loadTLSConfig(t, "client-alice")
+
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, crlFile, 1, "bob")
+	ts, ctx := newTestServerTLS(t, loadTLSConfig(t, "server", tlsconfig.WithCRLFiles(crlFile)))
+
+	// canI is a helper to make a call with a new connection, forcing a new handshake.
+	canI := func(t *testing.T) error {
+		t.Helper()
+		client, err := apiclient.NewClient(aliceTLSConfig, ts.addr)
+		noError(t, err, "NewClient for Alice")
+		defer func() { _ = client.Close() }()
+		_, _, err = client.CanI(ctx, "StartJob", "")
+		return err
+	}
+
+	// Bob is revoked, Alice is not.
+	if _, _, err := ts.bob.CanI(ctx, "StartJob", ""); err == nil {
+		t.Fatal("Expected Bob's revoked certificate to be rejected.")
+	}
+	noError(t, canI(t), "Alice call.")
+
+	// Revoke Alice as well, the CRL is reloaded on change.
+	writeCRL(t, crlFile, 2, "bob", "alice")
+	if err := canI(t); err == nil {
+		t.Fatal("Expected Alice's certificate to be rejected once revoked.")
+	}
+
+	// An invalid CRL is ignored, the previous one remains enforced.
+	noError(t, os.WriteFile(crlFile, []byte("invalid"), 0o600), "Write invalid CRL.")
+	if err := canI(t); err == nil {
+		t.Fatal("Expected Alice's certificate to still be rejected after an invalid CRL update.")
+	}
+
+	// Invalid CRL on load.
+	if _, err := tlsconfig.LoadTLSConfig("../certs/server.pem", "../certs/server-key.pem", "../certs/ca.pem", false,
+		tlsconfig.WithCRLFiles(crlFile)); err == nil {
+		t.Fatal("Expected invalid CRL to be rejected on load.")
+	}
+}
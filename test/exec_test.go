@@ -0,0 +1,85 @@
+package telepilot_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.creack.net/telepilot/pkg/apiclient"
+)
+
+func TestExecInJob(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"5"}, apiclient.WithHostname("exectest"))
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+	t.Run("namespaces", func(t *testing.T) {
+		stdout, stderr := &strings.Builder{}, &strings.Builder{}
+		code, err := ts.alice.ExecInJob(ctx, jobID, "sh", []string{"-c", "hostname; cat /proc/1/cmdline; echo; cat /proc/self/cgroup; exit 3"},
+			false, apiclient.ExecIO{Stdout: stdout, Stderr: stderr})
+		noError(t, err, "Exec in job.")
+		assert(t, 3, code, "exec exit code")
+		// Same hostname, pid 1 being the job's process and rooted in the job's cgroup.
+		assert(t, "exectest\nsleep\x005\x00\n0::/\n", stdout.String(), "exec output")
+	})
+
+	t.Run("mount namespace", func(t *testing.T) {
+		// The exec session joined the job's mount namespace, not only its root.
+		stdout, stderr := &strings.Builder{}, &strings.Builder{}
+		code, err := ts.alice.ExecInJob(ctx, jobID, "readlink", []string{"/proc/self/ns/mnt", "/proc/1/ns/mnt"},
+			false, apiclient.ExecIO{Stdout: stdout, Stderr: stderr})
+		noError(t, err, "Exec in job.")
+		assert(t, 0, code, "exec exit code")
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		assert(t, 2, len(lines), "mount namespace lines")
+		assert(t, lines[1], lines[0], "exec session mount namespace")
+	})
+
+	t.Run("stdio", func(t *testing.T) {
+		stdout, stderr := &strings.Builder{}, &strings.Builder{}
+		code, err := ts.alice.ExecInJob(ctx, jobID, "sh", []string{"-c", "cat; echo oops >&2"},
+			false, apiclient.ExecIO{Stdin: strings.NewReader("hello"), Stdout: stdout, Stderr: stderr})
+		noError(t, err, "Exec in job.")
+		assert(t, 0, code, "exec exit code")
+		assert(t, "hello", stdout.String(), "exec stdout")
+		assert(t, "oops\n", stderr.String(), "exec stderr")
+	})
+
+	t.Run("tty", func(t *testing.T) {
+		stdout := &strings.Builder{}
+		code, err := ts.alice.ExecInJob(ctx, jobID, "tty", nil, true, apiclient.ExecIO{Stdout: stdout, Stderr: stdout})
+		noError(t, err, "Exec in job.")
+		assert(t, 0, code, "exec exit code")
+		if !strings.HasPrefix(stdout.String(), "/dev/pts/") {
+			t.Fatalf("Unexpected tty output: %q.", stdout.String())
+		}
+	})
+
+	t.Run("confined", func(t *testing.T) {
+		// The exec session gets the same capabilities, no_new_privs and seccomp mode as the job's process.
+		stdout, stderr := &strings.Builder{}, &strings.Builder{}
+		code, err := ts.alice.ExecInJob(ctx, jobID, "sh", []string{
+			"-c", "grep -E '^(CapEff|CapBnd|NoNewPrivs|Seccomp):' /proc/self/status; grep -E '^(CapEff|CapBnd|NoNewPrivs|Seccomp):' /proc/1/status",
+		}, false, apiclient.ExecIO{Stdout: stdout, Stderr: stderr})
+		noError(t, err, "Exec in job.")
+		assert(t, 0, code, "exec exit code")
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		assert(t, 8, len(lines), "status lines")
+		assert(t, strings.Join(lines[4:], "\n"), strings.Join(lines[:4], "\n"), "exec session confinement")
+		assert(t, "Seccomp:\t2", lines[3], "exec session seccomp mode")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		_, err := ts.bob.ExecInJob(ctx, jobID, "true", nil, false, apiclient.ExecIO{Stdout: io.Discard, Stderr: io.Discard})
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from exec error")
+		assert(t, codes.PermissionDenied, st.Code(), "invalid grpc status code for bob's exec")
+	})
+}
@@ -4,11 +4,25 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/cgroups"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/netlink"
+	"go.creack.net/telepilot/pkg/network"
 )
 
 func TestPIDNamespace(t *testing.T) {
@@ -93,7 +107,10 @@ func TestNetworkNamespace(t *testing.T) {
 func TestMountNamespace(t *testing.T) {
 	t.Parallel()
 
-	ts, ctx := newTestServer(t)
+	// Mounting is denied by the default seccomp profile and capabilities, run unconfined with CAP_SYS_ADMIN.
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithUnconfinedUsers("alice")))
+	unconfined := apiclient.WithSeccompProfile(pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED)
+	sysAdmin := apiclient.WithCapabilities([]string{"SYS_ADMIN"}, nil)
 
 	// NOTE: Once we implement a pivot-root, this won't work and we will need
 	// to have a mount-point relative to the new root.
@@ -103,7 +120,7 @@ func TestMountNamespace(t *testing.T) {
 	jobID1, err := ts.alice.StartJob(ctx, "sh", []string{
 		"-c",
 		"mount -t tmpfs tmpfs " + mountPoint + " && mount && sleep 5",
-	})
+	}, unconfined, sysAdmin)
 	noError(t, err, "Start job.")
 
 	r, w := io.Pipe()
@@ -135,7 +152,7 @@ func TestMountNamespace(t *testing.T) {
 
 	// While still hanging on the pipe, start a new job and check the mount table.
 	{
-		jobID2, err := ts.alice.StartJob(ctx, "mount", nil)
+		jobID2, err := ts.alice.StartJob(ctx, "mount", nil, unconfined)
 		noError(t, err, "Start job.")
 		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID2), "Cleanup stop job.") })
 
@@ -151,3 +168,306 @@ func TestMountNamespace(t *testing.T) {
 	noError(t, err, "Get job status.")
 	assert(t, pb.JobStatus_JOB_STATUS_RUNNING.String(), st, "invalid status")
 }
+
+func TestUserNamespace(t *testing.T) {
+	t.Parallel()
+
+	const uidBase, gidBase, size = 200000, 300000, 65536
+
+	newUserNSServer := func(t *testing.T, mode jobmanager.UserNamespaceMode) (*testServer, context.Context) {
+		t.Helper()
+		return newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithUserNamespace(jobmanager.UserNamespaceConfig{
+			Mode:    mode,
+			UIDBase: uidBase,
+			GIDBase: gidBase,
+			Size:    size,
+			Count:   4,
+		})))
+	}
+
+	// runJob runs the given shell script as alice or bob and returns the trimmed output.
+	runJob := func(ctx context.Context, t *testing.T, client *apiclient.Client, script string) string {
+		t.Helper()
+		jobID, err := client.StartJob(ctx, "sh", []string{"-c", script})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, client.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, client.StreamLogs(ctx, jobID, w), "Stream logs.")
+		return strings.TrimSpace(w.String())
+	}
+
+	t.Run("root inside", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newUserNSServer(t, jobmanager.UserNamespacePerJob)
+
+		assert(t, "0 0", runJob(ctx, t, ts.alice, "echo $(id -u) $(id -g)"), "invalid uid/gid within the job")
+	})
+
+	t.Run("unprivileged outside", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newUserNSServer(t, jobmanager.UserNamespacePerJob)
+
+		// uid_map/gid_map format: <id inside> <id outside> <size>.
+		uidMap := strings.Fields(runJob(ctx, t, ts.alice, "cat /proc/self/uid_map"))
+		assert(t, 3, len(uidMap), "invalid uid_map")
+		assert(t, "0", uidMap[0], "invalid uid_map inside id")
+		assert(t, strconv.Itoa(uidBase), uidMap[1], "invalid uid_map outside id")
+		assert(t, strconv.Itoa(size), uidMap[2], "invalid uid_map size")
+
+		gidMap := strings.Fields(runJob(ctx, t, ts.alice, "cat /proc/self/gid_map"))
+		assert(t, 3, len(gidMap), "invalid gid_map")
+		assert(t, "0", gidMap[0], "invalid gid_map inside id")
+		assert(t, strconv.Itoa(gidBase), gidMap[1], "invalid gid_map outside id")
+
+		// Make sure the host sees the job's process as the unprivileged uid.
+		// NOTE: The job's pid is not exposed, lookup the process from the cgroup.
+		jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"5"})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+		buf, err := os.ReadFile(path.Join(cgroups.CgroupBasePath, "job-"+jobID, "cgroup.procs"))
+		noError(t, err, "read job cgroup.procs")
+		fi, err := os.Stat(path.Join("/proc", strings.TrimSpace(string(buf))))
+		noError(t, err, "stat job process")
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		assert(t, true, ok, "stat_t")
+		assert(t, uint32(uidBase), st.Uid, "invalid job process uid on the host")
+	})
+
+	t.Run("range per job", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newUserNSServer(t, jobmanager.UserNamespacePerJob)
+
+		// Hold a range with a first job, the second one is expected to get a different one.
+		jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"5"})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		uidMap := strings.Fields(runJob(ctx, t, ts.alice, "cat /proc/self/uid_map"))
+		assert(t, 3, len(uidMap), "invalid uid_map")
+		assert(t, strconv.Itoa(uidBase+size), uidMap[1], "invalid uid_map outside id")
+	})
+
+	t.Run("range per owner", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newUserNSServer(t, jobmanager.UserNamespacePerOwner)
+
+		jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"5"})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		// Alice's jobs share the same range, bob gets a different one.
+		aliceMap := strings.Fields(runJob(ctx, t, ts.alice, "cat /proc/self/uid_map"))
+		bobMap := strings.Fields(runJob(ctx, t, ts.bob, "cat /proc/self/uid_map"))
+		assert(t, 3, len(aliceMap), "invalid alice uid_map")
+		assert(t, 3, len(bobMap), "invalid bob uid_map")
+		assert(t, strconv.Itoa(uidBase), aliceMap[1], "invalid alice uid_map outside id")
+		assert(t, strconv.Itoa(uidBase+size), bobMap[1], "invalid bob uid_map outside id")
+	})
+}
+
+func TestUTSNamespace(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	t.Run("default hostname", func(t *testing.T) {
+		t.Parallel()
+
+		jobID, err := ts.alice.StartJob(ctx, "hostname", nil)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		// We expect the short job id.
+		assert(t, strings.Split(jobID, "-")[0], strings.TrimSpace(w.String()), "invalid hostname")
+	})
+	t.Run("custom hostname", func(t *testing.T) {
+		t.Parallel()
+
+		jobID, err := ts.alice.StartJob(ctx, "hostname", nil, apiclient.WithHostname("my-job"))
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		assert(t, "my-job", strings.TrimSpace(w.String()), "invalid hostname")
+
+		// Make sure the host is not impacted.
+		hostname, err := os.Hostname()
+		noError(t, err, "Get host hostname.")
+		if hostname == "my-job" {
+			t.Fatal("Job hostname leaked to the host.")
+		}
+	})
+	t.Run("invalid hostname", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithHostname("-invalid_"))
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from start job error")
+		assert(t, codes.InvalidArgument, st.Code(), "invalid grpc status code for start job")
+	})
+}
+
+// assertNamespaceIsolated makes sure the given namespace differs between the host and a job.
+func assertNamespaceIsolated(ctx context.Context, t *testing.T, client *apiclient.Client, ns string) {
+	t.Helper()
+
+	hostNS, err := os.Readlink("/proc/self/ns/" + ns)
+	noError(t, err, "Lookup host namespace.")
+
+	jobID, err := client.StartJob(ctx, "readlink", []string{"/proc/self/ns/" + ns})
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, client.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+	w := &strings.Builder{}
+	noError(t, client.StreamLogs(ctx, jobID, w), "Stream logs.")
+	jobNS := strings.TrimSpace(w.String())
+	if !strings.HasPrefix(jobNS, ns+":[") {
+		t.Fatalf("Unexpected %s namespace from job: %q.", ns, jobNS)
+	}
+	if jobNS == hostNS {
+		t.Fatalf("Job shares the %s namespace with the host: %s.", ns, jobNS)
+	}
+}
+
+func TestIPCNamespace(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	t.Run("ns", func(t *testing.T) {
+		t.Parallel()
+		assertNamespaceIsolated(ctx, t, ts.alice, "ipc")
+	})
+	t.Run("shm", func(t *testing.T) {
+		t.Parallel()
+
+		// Create a SysV shared memory segment on the host, make sure the job doesn't see it.
+		const ipcPrivate, ipcCreat, ipcRmid = 0, 0o1000, 0
+		shmID, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate, 4096, ipcCreat|0o600)
+		if errno != 0 {
+			t.Fatalf("Create host shm segment: %s.", errno)
+		}
+		t.Cleanup(func() { _, _, _ = syscall.Syscall(syscall.SYS_SHMCTL, shmID, ipcRmid, 0) })
+
+		jobID, err := ts.alice.StartJob(ctx, "cat", []string{"/proc/sysvipc/shm"})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		// We expect only the header line.
+		if l := len(strings.Split(strings.TrimSpace(w.String()), "\n")); l != 1 {
+			t.Fatalf("Unexpected shm segments within the job:\n%s\n", w)
+		}
+	})
+}
+
+func TestCgroupNamespace(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	t.Run("ns", func(t *testing.T) {
+		t.Parallel()
+		assertNamespaceIsolated(ctx, t, ts.alice, "cgroup")
+	})
+	t.Run("root", func(t *testing.T) {
+		t.Parallel()
+
+		jobID, err := ts.alice.StartJob(ctx, "cat", []string{"/proc/self/cgroup"})
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		// We expect the job's cgroup to be the root of the namespace.
+		assert(t, "0::/", strings.TrimSpace(w.String()), "invalid cgroup within the job")
+	})
+}
+
+func TestBridgedNetwork(t *testing.T) {
+	t.Parallel()
+
+	const bridge = "tptest0"
+	_, subnet, err := net.ParseCIDR("10.78.0.0/24")
+	noError(t, err, "Parse subnet.")
+	stateDir := t.TempDir()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithNetwork(network.Config{
+		Bridge:   bridge,
+		Subnet:   subnet,
+		StateDir: stateDir,
+	})))
+	t.Cleanup(func() {
+		// Remove the bridge and NAT table.
+		conn, err := netlink.Dial(syscall.NETLINK_ROUTE)
+		noError(t, err, "Dial rtnetlink.")
+		defer func() { _ = conn.Close() }()
+		noError(t, conn.DeleteLink(bridge), "Delete bridge.")
+		nfconn, err := netlink.Dial(syscall.NETLINK_NETFILTER)
+		noError(t, err, "Dial nfnetlink.")
+		defer func() { _ = nfconn.Close() }()
+		noError(t, nfconn.DeleteTable(bridge), "Delete nftables table.")
+	})
+
+	// Start a job holding the network, and a second one dumping its config.
+	jobID1, err := ts.alice.StartJob(ctx, "sleep", []string{"5"}, apiclient.WithNetwork(pb.NetworkMode_NETWORK_MODE_BRIDGED))
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID1), "Cleanup stop job.") })
+
+	jobID2, err := ts.alice.StartJob(ctx, "sh", []string{"-c", "ip -4 -o address show eth0 && ip route show default"},
+		apiclient.WithNetwork(pb.NetworkMode_NETWORK_MODE_BRIDGED))
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID2), "Cleanup stop job.") })
+
+	// Make sure the allocations are persisted while the jobs are running.
+	buf, err := os.ReadFile(path.Join(stateDir, "network.json"))
+	noError(t, err, "Read network state.")
+	if !strings.Contains(string(buf), jobID1) {
+		t.Fatalf("Missing job allocation from the network state:\n%s\n", buf)
+	}
+
+	w := &strings.Builder{}
+	noError(t, ts.alice.StreamLogs(ctx, jobID2, w), "Stream logs.")
+	st, err := ts.alice.GetJobStatus(ctx, jobID2)
+	noError(t, err, "Get job status.")
+	if st != pb.JobStatus_JOB_STATUS_EXITED.String()+" (0)" {
+		t.Skipf("ip exited with error, likely missing: %s", w)
+	}
+	// The first job got the first IP after the gateway, we expect the second one.
+	if !strings.Contains(w.String(), "inet 10.78.0.3/24") {
+		t.Fatalf("Unexpected address within the job:\n%s\n", w)
+	}
+	if !strings.Contains(w.String(), "default via 10.78.0.1 dev eth0") {
+		t.Fatalf("Unexpected default route within the job:\n%s\n", w)
+	}
+
+	// The host services are not reachable from the jobs, expect the connection to the gateway to time out.
+	l, err := net.Listen("tcp", "10.78.0.1:0")
+	noError(t, err, "Listen on the gateway.")
+	t.Cleanup(func() { _ = l.Close() })
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert // Expected.
+	jobID3, err := ts.alice.StartJob(ctx, "timeout", []string{"2", "bash", "-c", "echo > /dev/tcp/10.78.0.1/" + port},
+		apiclient.WithNetwork(pb.NetworkMode_NETWORK_MODE_BRIDGED))
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID3), "Cleanup stop job.") })
+	noError(t, ts.alice.StreamLogs(ctx, jobID3, io.Discard), "Stream logs.")
+	st, err = ts.alice.GetJobStatus(ctx, jobID3)
+	noError(t, err, "Get job status.")
+	assert(t, pb.JobStatus_JOB_STATUS_EXITED.String()+" (124)", st, "connection to the host from the job not filtered")
+}
+
+func TestBridgedNetworkUnavailable(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithNetwork(pb.NetworkMode_NETWORK_MODE_BRIDGED))
+	st, ok := status.FromError(err)
+	assert(t, true, ok, "extract grpc status from start job error")
+	assert(t, codes.FailedPrecondition, st.Code(), "invalid grpc status code for start job")
+}
@@ -0,0 +1,141 @@
+package telepilot_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/portproxy"
+)
+
+// dialEcho connects to the given address, retrying until the job is ready,
+// and asserts the echo server replies.
+func dialEcho(ctx context.Context, t *testing.T, addr string) {
+	t.Helper()
+
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			noError(t, ctx.Err(), "Dial echo server.")
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		defer func() { _ = conn.Close() }() // Best effort.
+		_, err = fmt.Fprintln(conn, "hello")
+		noError(t, err, "Write to echo server.")
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			// The proxy accepted but the job is not listening yet.
+			noError(t, ctx.Err(), "Read from echo server.")
+			_ = conn.Close() // Best effort.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		assert(t, "hello\n", line, "echo reply")
+		return
+	}
+}
+
+func TestPublishedPorts(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithPortPublishing(portproxy.Config{
+		Address:       "127.0.0.1",
+		AllowedRanges: map[string][]portproxy.PortRange{"alice": {{First: 18080, Last: 18089}}},
+	})))
+
+	exe, err := os.Executable()
+	noError(t, err, "Lookup test executable.")
+	port := &pb.PortMapping{HostPort: 18080, JobPort: 80}
+
+	// Bob is not allowed to publish anything.
+	_, err = ts.bob.StartJob(ctx, exe, []string{"-echo", "127.0.0.1:80"}, apiclient.WithPorts(port))
+	st, ok := status.FromError(err)
+	assert(t, true, ok, "extract grpc status from start job error")
+	assert(t, codes.PermissionDenied, st.Code(), "invalid grpc status code for bob's start job")
+
+	jobID, err := ts.alice.StartJob(ctx, exe, []string{"-echo", "127.0.0.1:80"}, apiclient.WithPorts(port))
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+	// The job listens on its own loopback, reachable via the published port.
+	dialEcho(ctx, t, "127.0.0.1:18080")
+
+	// The port is already used by the first job.
+	_, err = ts.alice.StartJob(ctx, exe, []string{"-echo", "127.0.0.1:80"}, apiclient.WithPorts(port))
+	st, ok = status.FromError(err)
+	assert(t, true, ok, "extract grpc status from start job error")
+	assert(t, codes.AlreadyExists, st.Code(), "invalid grpc status code for conflicting start job")
+
+	// Once stopped, the port is released.
+	noError(t, ts.alice.StopJob(ctx, jobID), "Stop job.")
+	jobID2, err := ts.alice.StartJob(ctx, exe, []string{"-echo", "127.0.0.1:80"}, apiclient.WithPorts(port))
+	noError(t, err, "Start job after release.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID2), "Cleanup stop job.") })
+	dialEcho(ctx, t, "127.0.0.1:18080")
+}
+
+func TestPortForward(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	exe, err := os.Executable()
+	noError(t, err, "Lookup test executable.")
+
+	// No network nor published port, only reachable from within the job's namespace.
+	jobID, err := ts.alice.StartJob(ctx, exe, []string{"-echo", "127.0.0.1:80"})
+	noError(t, err, "Start job.")
+	t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+	// Bob can't forward to Alice's job.
+	local, remote := net.Pipe()
+	t.Cleanup(func() { _, _ = local.Close(), remote.Close() })
+	err = ts.bob.PortForward(ctx, jobID, 80, remote)
+	st, ok := status.FromError(err)
+	assert(t, true, ok, "extract grpc status from port forward error")
+	assert(t, codes.PermissionDenied, st.Code(), "invalid grpc status code for bob's port forward")
+
+	// Retry until the job is listening.
+	for {
+		line, err := forwardEcho(ctx, ts.alice, jobID)
+		if err != nil {
+			noError(t, ctx.Err(), "Port forward: "+err.Error())
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		assert(t, "hello\n", line, "echo reply")
+		break
+	}
+}
+
+// forwardEcho sends a line to the echo server of the job via port forward and returns the reply.
+func forwardEcho(ctx context.Context, client *apiclient.Client, jobID string) (string, error) {
+	local, remote := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() { defer func() { _ = remote.Close() }(); errCh <- client.PortForward(ctx, jobID, 80, remote) }()
+
+	line, err := func() (string, error) {
+		defer func() { _ = local.Close() }() // Best effort.
+		if _, err := fmt.Fprintln(local, "hello"); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(local).ReadString('\n')
+	}()
+	if forwardErr := <-errCh; forwardErr != nil && err != nil {
+		return "", forwardErr
+	}
+	return line, err
+}
@@ -0,0 +1,74 @@
+package telepilot_test
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+)
+
+func TestQuotas(t *testing.T) {
+	t.Parallel()
+
+	quotas := jobmanager.QuotaConfig{
+		Default: jobmanager.QuotaLimits{MaxJobs: 1, MaxMemoryBytes: 100 << 20},
+		Users:   map[string]jobmanager.QuotaLimits{"bob": {MaxJobsPerHour: 1}},
+	}
+
+	t.Run("resources", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithQuotas(quotas)))
+
+		_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithResources(0, 200<<20))
+		st, _ := status.FromError(err)
+		assert(t, codes.ResourceExhausted, st.Code(), "invalid grpc status code for memory over quota")
+		if !strings.Contains(st.Message(), `user "alice": memory`) {
+			t.Fatalf("Expected the message to explain the exceeded quota, got %q.", st.Message())
+		}
+
+		q, err := ts.alice.GetQuota(ctx)
+		noError(t, err, "Get quota.")
+		assert(t, 1, len(q), "invalid quota count")
+		assert(t, "alice", q[0].GetUser(), "invalid quota user")
+		assert(t, uint32(1), q[0].GetLimits().GetJobs(), "invalid max jobs")
+		assert(t, uint64(100<<20), q[0].GetLimits().GetMemoryBytes(), "invalid max memory")
+		assert(t, uint32(0), q[0].GetUsage().GetJobs(), "rejected job accounted")
+
+		// Bob has his own quota, not the default one.
+		q, err = ts.bob.GetQuota(ctx)
+		noError(t, err, "Get quota.")
+		assert(t, uint32(0), q[0].GetLimits().GetJobs(), "invalid max jobs for bob")
+		assert(t, uint32(1), q[0].GetLimits().GetJobsPerHour(), "invalid max jobs per hour for bob")
+	})
+
+	t.Run("jobs", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithQuotas(quotas)))
+
+		jobID, err := ts.alice.StartJob(ctx, "sleep", []string{"10"})
+		noError(t, err, "Start first job.")
+
+		_, err = ts.alice.StartJob(ctx, "true", nil)
+		assert(t, codes.ResourceExhausted, status.Code(err), "invalid grpc status code for concurrent jobs over quota")
+		q, err := ts.alice.GetQuota(ctx)
+		noError(t, err, "Get quota.")
+		assert(t, uint32(1), q[0].GetUsage().GetJobs(), "invalid active jobs")
+		assert(t, uint32(1), q[0].GetUsage().GetJobsPerHour(), "invalid jobs in the last hour")
+
+		// Once stopped, the slot is available again.
+		noError(t, ts.alice.StopJob(ctx, jobID), "Stop first job.")
+		_, err = ts.alice.StartJob(ctx, "true", nil)
+		noError(t, err, "Start second job.")
+
+		// Bob can run concurrent jobs, but only one per hour.
+		_, err = ts.bob.StartJob(ctx, "true", nil)
+		noError(t, err, "Bob start first job.")
+		_, err = ts.bob.StartJob(ctx, "true", nil)
+		assert(t, codes.ResourceExhausted, status.Code(err), "invalid grpc status code for jobs per hour over quota")
+	})
+}
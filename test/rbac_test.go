@@ -0,0 +1,213 @@
+package telepilot_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiserver"
+)
+
+// Alice is admin, Bob and Dave are on-call and can read each other's jobs.
+const testPolicy = `{
+  "groups": {"oncall": ["bob", "dave"]},
+  "roles": {
+    "user": {"rules": [
+      {"methods": ["StartJob", "CreateSchedule", "ListSchedules", "DeleteSchedule", "SubmitWorkflow", "GetWorkflowStatus", "CanI", "GetQuota"]},
+      {"methods": ["StopJob", "SignalJob", "SetJobACL", "GetJobStatus", "StreamLogs", "PortForward", "ExecInJob"], "scope": "own"}
+    ]},
+    "admin": {"rules": [{"methods": ["*"], "scope": "all"}]},
+    "oncall": {"rules": [{"methods": ["GetJobStatus", "StreamLogs"], "scope": "group"}]}
+  },
+  "bindings": [
+    {"role": "admin", "users": ["alice"]},
+    {"role": "oncall", "groups": ["oncall"]},
+    {"role": "user", "users": ["*"]}
+  ]
+}`
+
+func TestRBAC(t *testing.T) {
+	t.Parallel()
+
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	noError(t, os.WriteFile(policyFile, []byte(testPolicy), 0o600), "Write policy file.")
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		invalidFile := filepath.Join(t.TempDir(), "policy.json")
+		// Only StartJob is granted, the other methods are not covered.
+		noError(t, os.WriteFile(invalidFile, []byte(`{"roles": {"user": {"rules": [{"methods": ["StartJob"]}]}}, "bindings": [{"role": "user", "users": ["*"]}]}`), 0o600), "Write invalid policy file.")
+		if _, err := apiserver.NewServer(apiserver.WithPolicyFile(invalidFile)); err == nil {
+			t.Fatal("Expected incomplete policy to be rejected.")
+		}
+	})
+
+	t.Run("can-i", func(t *testing.T) {
+		t.Parallel()
+		ts, ctx := newTestServer(t, apiserver.WithPolicyFile(policyFile))
+
+		ok, role, err := ts.alice.CanI(ctx, "ExecInJob", "")
+		noError(t, err, "Alice can-i.")
+		assert(t, true, ok, "alice not allowed")
+		assert(t, "admin", role, "invalid role for alice")
+
+		ok, role, err = ts.bob.CanI(ctx, "StartJob", "")
+		noError(t, err, "Bob can-i.")
+		assert(t, true, ok, "bob not allowed")
+		assert(t, "user", role, "invalid role for bob")
+
+		_, _, err = ts.bob.CanI(ctx, "Unknown", "")
+		assert(t, codes.InvalidArgument, status.Code(err), "invalid grpc status code for unknown method")
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		t.Parallel()
+		policyFile := filepath.Join(t.TempDir(), "policy.json")
+		noError(t, os.WriteFile(policyFile, []byte(testPolicy), 0o600), "Write policy file.")
+		ts, ctx := newTestServer(t, apiserver.WithPolicyFile(policyFile))
+
+		aliceJobID, err := ts.alice.StartJob(ctx, "true", nil)
+		noError(t, err, "Alice start job.")
+		bobJobID, err := ts.bob.StartJob(ctx, "true", nil)
+		noError(t, err, "Bob start job.")
+
+		// Alice is admin, she can access Bob's job.
+		_, err = ts.alice.GetJobStatus(ctx, bobJobID)
+		noError(t, err, "Alice get Bob's job status.")
+		noError(t, ts.alice.StreamLogs(ctx, bobJobID, io.Discard), "Alice stream Bob's logs.")
+
+		// Bob is not, he can't access Alice's job, as if it didn't exist.
+		_, err = ts.bob.GetJobStatus(ctx, aliceJobID)
+		assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for Bob accessing Alice's job")
+		ok, _, err := ts.bob.CanI(ctx, "GetJobStatus", aliceJobID)
+		noError(t, err, "Bob can-i.")
+		assert(t, false, ok, "bob allowed to access alice's job")
+
+		// Once the policy is reloaded without the admin binding, Alice can't access Bob's job anymore.
+		// Unknown field.
+		noError(t, os.WriteFile(policyFile, []byte(testPolicy[:len(testPolicy)-1]+`,"_":1}`), 0o600), "Write invalid policy file.")
+		if err := ts.server.ReloadPolicy(); err == nil {
+			t.Fatal("Expected invalid policy reload to fail.")
+		}
+		_, err = ts.alice.GetJobStatus(ctx, bobJobID)
+		noError(t, err, "Alice get Bob's job status after failed reload.")
+
+		noError(t, os.WriteFile(policyFile, []byte(`{
+  "roles": {"user": {"rules": [{"methods": ["*"]}]}},
+  "bindings": [{"role": "user", "users": ["*"]}]
+}`), 0o600), "Write policy file.")
+		noError(t, ts.server.ReloadPolicy(), "Reload policy.")
+		_, err = ts.alice.GetJobStatus(ctx, bobJobID)
+		assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for Alice accessing Bob's job after reload")
+		_, err = ts.bob.GetJobStatus(ctx, bobJobID)
+		noError(t, err, "Bob get own job status after reload.")
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		t.Parallel()
+		policyFile := filepath.Join(t.TempDir(), "policy.json")
+		// Bob can only echo words, or run the tools in /opt/tools.
+		noError(t, os.WriteFile(policyFile, []byte(`{
+  "roles": {
+    "user": {"rules": [{"methods": ["*"]}]},
+    "restricted": {"rules": [
+      {"methods": ["StartJob", "CreateSchedule", "SubmitWorkflow", "ExecInJob"], "commands": [
+        {"path": "/bin/echo", "args": ["[a-z]+", "-n"]},
+        {"path": "/opt/tools/*"}
+      ]},
+      {"methods": ["StopJob", "GetJobStatus", "StreamLogs", "ListSchedules", "DeleteSchedule", "GetWorkflowStatus", "CanI"]}
+    ]}
+  },
+  "bindings": [{"role": "restricted", "users": ["bob"]}, {"role": "user", "users": ["alice"]}]
+}`), 0o600), "Write policy file.")
+		ts, ctx := newTestServer(t, apiserver.WithPolicyFile(policyFile))
+
+		for _, tc := range []struct {
+			cmd  string
+			args []string
+		}{
+			{"/bin/sh", []string{"-c", "id"}},
+			{"/bin/echo", []string{"Hello"}},
+			{"echo", []string{"hello"}},
+			{"/opt/tools/sub/cmd", nil},
+		} {
+			_, err := ts.bob.StartJob(ctx, tc.cmd, tc.args)
+			st, _ := status.FromError(err)
+			assert(t, codes.PermissionDenied, st.Code(), "invalid grpc status code for a command not allowed")
+			if !strings.Contains(st.Message(), `role "restricted", rule 0: command "`+tc.cmd+`"`) {
+				t.Errorf("Expected the denial to name the failing rule, got %q.", st.Message())
+			}
+		}
+
+		// Same via the schedules.
+		_, err := ts.bob.CreateSchedule(ctx, "@daily", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "/bin/sh", nil)
+		assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for a scheduled command not allowed")
+		_, err = ts.bob.CreateSchedule(ctx, "@daily", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "/opt/tools/backup", nil)
+		noError(t, err, "Bob create allowed schedule.")
+
+		// The constraints only apply to the roles setting them.
+		_, err = ts.alice.CreateSchedule(ctx, "@daily", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "/bin/sh", nil)
+		noError(t, err, "Alice create schedule.")
+
+		// Invalid regexes are rejected.
+		noError(t, os.WriteFile(policyFile, []byte(`{
+  "roles": {"user": {"rules": [{"methods": ["*"], "commands": [{"path": "/bin/echo", "args": ["("]}]}]}},
+  "bindings": [{"role": "user", "users": ["*"]}]
+}`), 0o600), "Write invalid policy file.")
+		if err := ts.server.ReloadPolicy(); err == nil {
+			t.Fatal("Expected invalid command regex to be rejected.")
+		}
+	})
+	t.Run("deferred", func(t *testing.T) {
+		t.Parallel()
+		policyFile := filepath.Join(t.TempDir(), "policy.json")
+		policy := `{
+  "roles": {
+    "user": {"rules": [
+      {"methods": ["CreateSchedule"], "commands": [{"path": "%s"}]},
+      {"methods": ["ListSchedules", "DeleteSchedule"]}
+    ]},
+    "admin": {"rules": [{"methods": ["*"], "scope": "all"}]}
+  },
+  "bindings": [{"role": "user", "users": ["*"]}, {"role": "admin", "groups": ["admins"]}]
+}`
+		noError(t, os.WriteFile(policyFile, []byte(fmt.Sprintf(policy, "/opt/tools/*")), 0o600), "Write policy file.")
+		ts, ctx := newTestServer(t, apiserver.WithPolicyFile(policyFile))
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		t.Cleanup(cancel)
+
+		sc, err := ts.bob.CreateSchedule(ctx, "* * * * * *", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "/opt/tools/backup", nil)
+		noError(t, err, "Create schedule.")
+
+		// Once the command is not allowed anymore, the next run disables the schedule.
+		noError(t, os.WriteFile(policyFile, []byte(fmt.Sprintf(policy, "/bin/true")), 0o600), "Write policy file.")
+		noError(t, ts.server.ReloadPolicy(), "Reload policy.")
+		for {
+			schedules, err := ts.bob.ListSchedules(ctx)
+			noError(t, err, "List schedules.")
+			assert(t, 1, len(schedules), "invalid schedule count")
+			if schedules[0].GetDisabled() {
+				assert(t, sc.GetScheduleId(), schedules[0].GetScheduleId(), "invalid disabled schedule")
+				assert(t, int64(0), schedules[0].GetNextRunUnixMs(), "disabled schedule has a next run")
+				if !strings.Contains(schedules[0].GetLastError(), `command "/opt/tools/backup"`) {
+					t.Fatalf("Expected the last error to explain the denial, got %q.", schedules[0].GetLastError())
+				}
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for the schedule to be disabled.")
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package telepilot_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithRestartBackoff(50*time.Millisecond, 100*time.Millisecond)))
+
+	// runJob runs the given command until it is done, restarts included, and returns its logs and status.
+	runJob := func(t *testing.T, cmd string, args []string, opts ...apiclient.StartJobOption) (string, *pb.GetJobStatusResponse) {
+		t.Helper()
+		jobID, err := ts.alice.StartJob(ctx, cmd, args, opts...)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		return w.String(), st
+	}
+
+	t.Run("never", func(t *testing.T) {
+		t.Parallel()
+		logs, st := runJob(t, "sh", []string{"-c", "echo run; exit 1"})
+		assert(t, "run\n", logs, "invalid logs")
+		assert(t, pb.JobStatus_JOB_STATUS_EXITED, st.GetStatus(), "invalid status")
+		assert(t, 0, int(st.GetRestartCount()), "invalid restart count")
+		assert(t, 1, len(st.GetAttempts()), "invalid number of attempts")
+	})
+
+	t.Run("on failure max retries", func(t *testing.T) {
+		t.Parallel()
+		logs, st := runJob(t, "sh", []string{"-c", "echo run; exit 2"},
+			apiclient.WithRestartPolicy(pb.RestartMode_RESTART_MODE_ON_FAILURE, 2))
+		assert(t, "run\n"+
+			"--- telepilot: attempt 1 exited with code 2, restarting in 50ms ---\nrun\n"+
+			"--- telepilot: attempt 2 exited with code 2, restarting in 100ms ---\nrun\n", logs, "invalid logs")
+		assert(t, pb.JobStatus_JOB_STATUS_EXITED, st.GetStatus(), "invalid status")
+		assert(t, 2, int(st.GetExitCode()), "invalid exit code")
+		assert(t, 2, int(st.GetRestartCount()), "invalid restart count")
+		assert(t, 3, len(st.GetAttempts()), "invalid number of attempts")
+		for _, attempt := range st.GetAttempts() {
+			assert(t, 2, int(attempt.GetExitCode()), "invalid attempt exit code")
+		}
+	})
+
+	t.Run("on failure until success", func(t *testing.T) {
+		t.Parallel()
+		// Fails on the first attempt only, the marker file being kept across restarts on the host.
+		marker := filepath.Join(t.TempDir(), "marker")
+		_, st := runJob(t, "sh", []string{"-c", "test -f " + marker + " && exit 0; touch " + marker + "; exit 1"},
+			apiclient.WithRestartPolicy(pb.RestartMode_RESTART_MODE_ON_FAILURE, 0))
+		assert(t, pb.JobStatus_JOB_STATUS_EXITED, st.GetStatus(), "invalid status")
+		assert(t, 0, int(st.GetExitCode()), "invalid exit code")
+		assert(t, 1, int(st.GetRestartCount()), "invalid restart count")
+		assert(t, 2, len(st.GetAttempts()), "invalid number of attempts")
+		assert(t, 1, int(st.GetAttempts()[0].GetExitCode()), "invalid first attempt exit code")
+	})
+
+	t.Run("always until stopped", func(t *testing.T) {
+		t.Parallel()
+		jobID, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithRestartPolicy(pb.RestartMode_RESTART_MODE_ALWAYS, 0))
+		noError(t, err, "Start job.")
+
+		// Wait for a few restarts.
+		for {
+			st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+			noError(t, err, "Get job status.")
+			if st.GetRestartCount() >= 2 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for the job to restart.")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		noError(t, ts.alice.StopJob(ctx, jobID), "Stop job.")
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		assert(t, pb.JobStatus_JOB_STATUS_STOPPED, st.GetStatus(), "invalid status")
+
+		// Make sure it doesn't restart anymore.
+		time.Sleep(200 * time.Millisecond)
+		st2, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		assert(t, st.GetRestartCount(), st2.GetRestartCount(), "job restarted after being stopped")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithRestartPolicy(pb.RestartMode_RESTART_MODE_ALWAYS, 3))
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from start job error")
+		assert(t, codes.InvalidArgument, st.Code(), "invalid grpc status code for max retries with always")
+	})
+}
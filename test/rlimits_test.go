@@ -0,0 +1,64 @@
+package telepilot_test
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/rlimit"
+)
+
+func TestRlimits(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithRlimitMaxima(map[rlimit.Resource]uint64{
+		rlimit.NoFile: 4096,
+		rlimit.Core:   0,
+	})))
+
+	t.Run("applied", func(t *testing.T) {
+		t.Parallel()
+
+		// Nofile is clamped, core defaults to its maximum and stack is set as requested.
+		jobID, err := ts.alice.StartJob(ctx, "sh", []string{"-c", "ulimit -Sn; ulimit -Hn; ulimit -c; ulimit -s"},
+			apiclient.WithRlimit("nofile", 1024, 8192),
+			apiclient.WithRlimit("stack", 8<<20, 8<<20),
+		)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		assert(t, "1024\n4096\n0\n8192\n", w.String(), "invalid limits within the job") // Stack in KiB.
+
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		limits := map[string]string{}
+		for resource, limit := range st.GetRlimits() {
+			limits[resource] = rlimit.Limit{Soft: limit.GetSoft(), Hard: limit.GetHard()}.String()
+		}
+		assert(t, 3, len(limits), "invalid number of limits in status")
+		assert(t, "1024:4096", limits["nofile"], "invalid nofile limit in status")
+		assert(t, "0:0", limits["core"], "invalid core limit in status")
+		assert(t, "8388608:8388608", limits["stack"], "invalid stack limit in status")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, opt := range []apiclient.StartJobOption{
+			apiclient.WithRlimit("unknown", 1, 1),
+			apiclient.WithRlimit("nproc", 2, 1),
+		} {
+			_, err := ts.alice.StartJob(ctx, "true", nil, opt)
+			st, ok := status.FromError(err)
+			assert(t, true, ok, "extract grpc status from start job error")
+			assert(t, codes.InvalidArgument, st.Code(), "invalid grpc status code for invalid rlimit")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package telepilot_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/scheduler"
+	"go.creack.net/telepilot/pkg/statefile"
+)
+
+func TestSchedules(t *testing.T) {
+	t.Parallel()
+
+	ts, _ := newTestServer(t)
+	// Scheduled runs are at least a second apart, use a larger timeout than the default one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	// waitRun waits for the schedule to have run a different job than the given one and returns the new job id.
+	waitRun := func(t *testing.T, client *apiclient.Client, scheduleID, prevJobID string) string {
+		t.Helper()
+		for {
+			schedules, err := client.ListSchedules(ctx)
+			noError(t, err, "List schedules.")
+			for _, sc := range schedules {
+				if sc.GetScheduleId() == scheduleID && sc.GetLastJobId() != "" && sc.GetLastJobId() != prevJobID {
+					return sc.GetLastJobId()
+				}
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for the schedule to run.")
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("run and delete", func(t *testing.T) {
+		t.Parallel()
+		sc, err := ts.alice.CreateSchedule(ctx, "* * * * * *", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "true", nil)
+		noError(t, err, "Create schedule.")
+		assert(t, "true", sc.GetJob().GetCommand(), "invalid schedule job")
+
+		jobID := waitRun(t, ts.alice, sc.GetScheduleId(), "")
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get scheduled job status.")
+		assert(t, sc.GetScheduleId(), st.GetScheduleId(), "invalid schedule link of the job")
+
+		// Bob can't see nor delete Alice's schedule.
+		schedules, err := ts.bob.ListSchedules(ctx)
+		noError(t, err, "List Bob's schedules.")
+		assert(t, 0, len(schedules), "Bob listed Alice's schedules")
+		err = ts.bob.DeleteSchedule(ctx, sc.GetScheduleId())
+		assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for Bob deleting Alice's schedule")
+
+		noError(t, ts.alice.DeleteSchedule(ctx, sc.GetScheduleId()), "Delete schedule.")
+		schedules, err = ts.alice.ListSchedules(ctx)
+		noError(t, err, "List schedules.")
+		for _, elem := range schedules {
+			if elem.GetScheduleId() == sc.GetScheduleId() {
+				t.Fatal("Schedule still listed after deletion.")
+			}
+		}
+	})
+
+	t.Run("overlap skip", func(t *testing.T) {
+		t.Parallel()
+		sc, err := ts.alice.CreateSchedule(ctx, "* * * * * *", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "sleep", []string{"10"})
+		noError(t, err, "Create schedule.")
+		t.Cleanup(func() { noError(t, ts.alice.DeleteSchedule(ctx, sc.GetScheduleId()), "Cleanup delete schedule.") })
+
+		jobID := waitRun(t, ts.alice, sc.GetScheduleId(), "")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+		time.Sleep(2500 * time.Millisecond) // Let a couple of runs be due.
+
+		schedules, err := ts.alice.ListSchedules(ctx)
+		noError(t, err, "List schedules.")
+		for _, elem := range schedules {
+			if elem.GetScheduleId() == sc.GetScheduleId() {
+				assert(t, jobID, elem.GetLastJobId(), "runs not skipped while the previous job is running")
+			}
+		}
+	})
+
+	t.Run("overlap replace", func(t *testing.T) {
+		t.Parallel()
+		sc, err := ts.alice.CreateSchedule(ctx, "* * * * * *", pb.OverlapPolicy_OVERLAP_POLICY_REPLACE, false, "sleep", []string{"10"})
+		noError(t, err, "Create schedule.")
+		t.Cleanup(func() { noError(t, ts.alice.DeleteSchedule(ctx, sc.GetScheduleId()), "Cleanup delete schedule.") })
+
+		jobID1 := waitRun(t, ts.alice, sc.GetScheduleId(), "")
+		jobID2 := waitRun(t, ts.alice, sc.GetScheduleId(), jobID1)
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID2), "Cleanup stop job.") })
+
+		st, err := ts.alice.GetJobStatus(ctx, jobID1)
+		noError(t, err, "Get replaced job status.")
+		assert(t, pb.JobStatus_JOB_STATUS_STOPPED.String()+" (-1)", st, "invalid replaced job status")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := ts.alice.CreateSchedule(ctx, "* * *", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "true", nil)
+		assert(t, codes.InvalidArgument, status.Code(err), "invalid grpc status code for invalid cron")
+
+		_, err = ts.alice.CreateSchedule(ctx, "@daily", pb.OverlapPolicy_OVERLAP_POLICY_SKIP_UNSPECIFIED, false, "true", nil,
+			apiclient.WithHostname("-invalid"))
+		assert(t, codes.InvalidArgument, status.Code(err), "invalid grpc status code for invalid job spec")
+	})
+}
+
+func TestSchedulesMissedRuns(t *testing.T) {
+	t.Parallel()
+
+	// Persist a couple of yearly schedules which missed their last run while the server was down.
+	stateDir := t.TempDir()
+	createdAt := time.Now().AddDate(-2, 0, 0)
+	runMissed := scheduler.Schedule{
+		ID: uuid.New(), Owner: "alice", Cron: "@yearly", Spec: jobmanager.JobSpec{Command: "true"},
+		RunMissed: true, CreatedAt: createdAt,
+	}
+	skipMissed := scheduler.Schedule{
+		ID: uuid.New(), Owner: "alice", Cron: "@yearly", Spec: jobmanager.JobSpec{Command: "true"},
+		CreatedAt: createdAt.Add(time.Second),
+	}
+	noError(t, statefile.Save(filepath.Join(stateDir, "schedules.json"), map[string]any{
+		"schedules": []scheduler.Schedule{runMissed, skipMissed},
+	}), "Persist schedules.")
+
+	ts, ctx := newTestServer(t, apiserver.WithStateDir(stateDir))
+	for {
+		schedules, err := ts.alice.ListSchedules(ctx)
+		noError(t, err, "List schedules.")
+		assert(t, 2, len(schedules), "invalid number of loaded schedules")
+		assert(t, int64(0), schedules[1].GetLastRunUnixMs(), "missed run not skipped")
+		if schedules[0].GetLastJobId() != "" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("Timeout waiting for the missed run.")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
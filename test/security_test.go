@@ -0,0 +1,198 @@
+package telepilot_test
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/capabilities"
+	"go.creack.net/telepilot/pkg/jobmanager"
+	"go.creack.net/telepilot/pkg/landlock"
+)
+
+func TestSeccomp(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithUnconfinedUsers("alice"), jobmanager.WithCapAddUsers("alice")))
+
+	// runJob runs the given shell script with the given profile and returns the output.
+	runJob := func(t *testing.T, profile pb.SeccompProfile, script string) string {
+		t.Helper()
+		// Grant CAP_SYS_ADMIN so only the seccomp filter denies the syscalls.
+		jobID, err := ts.alice.StartJob(ctx, "sh", []string{"-c", script},
+			apiclient.WithSeccompProfile(profile), apiclient.WithCapabilities([]string{"SYS_ADMIN"}, nil))
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		return w.String()
+	}
+	// Filter mode from the kernel, 2 being filtered, and whether unshare/sethostname are allowed.
+	const script = "grep Seccomp: /proc/self/status; " +
+		"unshare -U true 2>/dev/null && echo unshare allowed || echo unshare denied; " +
+		"hostname test 2>/dev/null && echo hostname allowed || echo hostname denied"
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		out := runJob(t, pb.SeccompProfile_SECCOMP_PROFILE_DEFAULT_UNSPECIFIED, script)
+		assert(t, "Seccomp:\t2\nunshare denied\nhostname allowed\n", out, "default profile")
+	})
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		out := runJob(t, pb.SeccompProfile_SECCOMP_PROFILE_STRICT, script)
+		assert(t, "Seccomp:\t2\nunshare denied\nhostname denied\n", out, "strict profile")
+	})
+	t.Run("unconfined", func(t *testing.T) {
+		t.Parallel()
+		out := runJob(t, pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED, script)
+		assert(t, "Seccomp:\t0\nunshare allowed\nhostname allowed\n", out, "unconfined profile")
+	})
+	t.Run("unconfined not allowed", func(t *testing.T) {
+		t.Parallel()
+		_, err := ts.bob.StartJob(ctx, "true", nil, apiclient.WithSeccompProfile(pb.SeccompProfile_SECCOMP_PROFILE_UNCONFINED))
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from start job error")
+		assert(t, codes.PermissionDenied, st.Code(), "invalid grpc status code for bob's unconfined job")
+	})
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithCapAddUsers("alice"), jobmanager.WithAddableCapabilities("NET_ADMIN")))
+
+	// runJob dumps the effective capabilities and the no_new_privs bit of the job started with the given options.
+	runJob := func(t *testing.T, opts ...apiclient.StartJobOption) (capEff uint64, noNewPrivs string) {
+		t.Helper()
+		jobID, err := ts.alice.StartJob(ctx, "sh", []string{"-c", "grep -E '^(CapEff|NoNewPrivs):' /proc/self/status"}, opts...)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		for _, line := range strings.Split(strings.TrimSpace(w.String()), "\n") {
+			key, value, _ := strings.Cut(line, ":")
+			switch value = strings.TrimSpace(value); key {
+			case "CapEff":
+				capEff, err = strconv.ParseUint(value, 16, 64)
+				noError(t, err, "parse CapEff")
+			case "NoNewPrivs":
+				noNewPrivs = value
+			}
+		}
+		return capEff, noNewPrivs
+	}
+
+	const capSysAdmin, capNetRaw, capMknod, capDacOverride = 21, 13, 27, 1
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		capEff, noNewPrivs := runJob(t)
+		assert(t, uint64(capabilities.Default), capEff, "invalid default capabilities")
+		assert(t, uint64(0), capEff&(1<<capSysAdmin), "CAP_SYS_ADMIN not expected by default")
+		assert(t, uint64(0), capEff&(1<<capMknod|1<<capDacOverride), "CAP_MKNOD and CAP_DAC_OVERRIDE not expected by default")
+		assert(t, "1", noNewPrivs, "no_new_privs expected by default")
+	})
+	t.Run("cap add", func(t *testing.T) {
+		t.Parallel()
+		capEff, _ := runJob(t, apiclient.WithCapabilities([]string{"cap_sys_admin"}, nil))
+		assert(t, uint64(1<<capSysAdmin), capEff&(1<<capSysAdmin), "CAP_SYS_ADMIN expected when added")
+	})
+	t.Run("cap drop", func(t *testing.T) {
+		t.Parallel()
+		capEff, _ := runJob(t, apiclient.WithCapabilities(nil, []string{"NET_RAW"}))
+		assert(t, uint64(capabilities.Default)&^(1<<capNetRaw), capEff, "invalid capabilities after drop")
+
+		capEff, _ = runJob(t, apiclient.WithCapabilities([]string{"ALL"}, []string{"ALL"}))
+		assert(t, uint64(0), capEff, "drop expected to take precedence")
+	})
+	t.Run("allow new privileges", func(t *testing.T) {
+		t.Parallel()
+		_, noNewPrivs := runJob(t, apiclient.WithNoNewPrivileges(false))
+		assert(t, "0", noNewPrivs, "no_new_privs not expected")
+	})
+	t.Run("cap add not allowed", func(t *testing.T) {
+		t.Parallel()
+		// Bob can only add NET_ADMIN.
+		for _, add := range [][]string{{"SYS_ADMIN"}, {"ALL"}, {"NET_ADMIN", "SYS_MODULE"}} {
+			_, err := ts.bob.StartJob(ctx, "true", nil, apiclient.WithCapabilities(add, nil))
+			assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for bob's privileged capabilities")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithCapabilities([]string{"NOT_A_CAP"}, nil))
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from start job error")
+		assert(t, codes.InvalidArgument, st.Code(), "invalid grpc status code for unknown capability")
+	})
+}
+
+func TestLandlock(t *testing.T) {
+	t.Parallel()
+
+	abi := landlock.ABI()
+	if abi == 0 {
+		t.Skip("Landlock not supported by the kernel.")
+	}
+
+	ts, ctx := newTestServer(t)
+
+	t.Run("restricted", func(t *testing.T) {
+		t.Parallel()
+
+		// Allow the system dirs needed to run the shell, the rest of the host is denied.
+		var readOnly []string
+		for _, dir := range []string{"/bin", "/sbin", "/usr", "/lib", "/lib64", "/etc"} {
+			if _, err := os.Stat(dir); err == nil {
+				readOnly = append(readOnly, dir)
+			}
+		}
+		readWrite, outside := t.TempDir(), t.TempDir()
+
+		jobID, err := ts.alice.StartJob(ctx, "sh", []string{"-c", "echo hello > " + readWrite + "/file && cat " + readWrite + "/file; " +
+			"cat /etc/hostname > /dev/null && echo read allowed; " +
+			"touch /etc/telepilot-landlock 2> /dev/null || echo write denied; " +
+			"ls " + outside + " 2> /dev/null || echo outside denied",
+		}, apiclient.WithLandlock(readOnly, []string{readWrite, "/dev/null"}))
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		assert(t, "hello\nread allowed\nwrite denied\noutside denied\n", w.String(), "invalid landlock restrictions")
+
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		assert(t, uint32(abi), st.GetLandlockAbi(), "invalid landlock abi in status") //nolint:gosec // False positive, can't be negative.
+	})
+
+	t.Run("unrestricted", func(t *testing.T) {
+		t.Parallel()
+
+		jobID, err := ts.alice.StartJob(ctx, "true", nil)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		st, err := ts.alice.GetJobStatusDetails(ctx, jobID)
+		noError(t, err, "Get job status.")
+		assert(t, uint32(0), st.GetLandlockAbi(), "landlock abi not expected in status")
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := ts.alice.StartJob(ctx, "true", nil, apiclient.WithLandlock([]string{"relative/path"}, nil))
+		st, ok := status.FromError(err)
+		assert(t, true, ok, "extract grpc status from start job error")
+		assert(t, codes.InvalidArgument, st.Code(), "invalid grpc status code for relative landlock path")
+	})
+}
@@ -26,6 +26,8 @@ import (
 
 func TestMain(m *testing.M) {
 	isInit := flag.Bool("init", false, "internal flag to toggle init mode")
+	isExec := flag.Bool("exec", false, "internal flag to toggle exec session mode")
+	echoAddr := flag.String("echo", "", "internal flag to run a tcp echo server, used as job by the tests")
 	flag.Parse()
 	if *isInit {
 		if err := initd.Init(flag.Args()); err != nil {
@@ -34,6 +36,20 @@ func TestMain(m *testing.M) {
 		}
 		return
 	}
+	if *isExec {
+		if err := initd.Exec(flag.Args()); err != nil {
+			slog.Error("Exec error.", "error", err, "args", flag.Args())
+			os.Exit(1)
+		}
+		return
+	}
+	if *echoAddr != "" {
+		if err := echoServer(*echoAddr); err != nil {
+			slog.Error("Echo server error.", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if err := cgroups.InitialSetup(); err != nil {
 		slog.Error("Failed to init cgroups.", "error", err)
@@ -44,6 +60,21 @@ func TestMain(m *testing.M) {
 	os.Exit(ret)
 }
 
+// echoServer listens on the given address and echoes back everything it receives.
+func echoServer(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go func() { defer func() { _ = conn.Close() }(); _, _ = io.Copy(conn, conn) }()
+	}
+}
+
 // Helper to assert success.
 func noError(t *testing.T, err error, msg string) {
 	t.Helper()
@@ -74,7 +105,7 @@ func assertChanOnce(ctx context.Context, t *testing.T, expect string, ch <-chan
 // Helper to load TLS Config from the certts dir.
 // Requires the certs to be present, otherwise, skip the test.
 // Run `make mtls` (or `make test`) to generate them.
-func loadTLSConfig(t *testing.T, name string) *tls.Config {
+func loadTLSConfig(t *testing.T, name string, opts ...tlsconfig.Option) *tls.Config {
 	t.Helper()
 	const certDir = "../certs"
 
@@ -87,6 +118,7 @@ func loadTLSConfig(t *testing.T, name string) *tls.Config {
 		path.Join(certDir, name+"-key.pem"),
 		path.Join(certDir, "ca.pem"),
 		name != "server",
+		opts...,
 	)
 	noError(t, err, "Load certs for "+name)
 	return cfg
@@ -95,25 +127,35 @@ func loadTLSConfig(t *testing.T, name string) *tls.Config {
 // testServer wraps a running server listening on local host
 // and a coupe of clients pointing to it.
 type testServer struct {
+	server     *apiserver.Server
 	grpcServer *grpc.Server
+	addr       string
 	alice, bob *apiclient.Client
 }
 
 // newTestServer handles the common setup to create a test server and clients.
-func newTestServer(t *testing.T) (*testServer, context.Context) {
+// The given options are passed as-is to the server.
+func newTestServer(t *testing.T, opts ...apiserver.Option) (*testServer, context.Context) {
+	t.Helper()
+	return newTestServerTLS(t, loadTLSConfig(t, "server"), opts...)
+}
+
+// newTestServerTLS is newTestServer with the given server TLS config.
+func newTestServerTLS(t *testing.T, serverTLSConfig *tls.Config, opts ...apiserver.Option) (*testServer, context.Context) {
 	t.Helper()
 
 	// Create a context with a large enough timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	t.Cleanup(cancel)
 
-	// Load certs for the server and a couple of clients.
-	serverTLSConfig := loadTLSConfig(t, "server")
+	// Load certs for a couple of clients.
 	aliceTLSConfig := loadTLSConfig(t, "client-alice")
 	bobTLSConfig := loadTLSConfig(t, "client-bob")
 
 	// Create a server.
-	s := apiserver.NewServer()
+	s, err := apiserver.NewServer(opts...)
+	noError(t, err, "NewServer")
+	t.Cleanup(s.Close)
 	grpcServer := grpc.NewServer(
 		grpc.Creds(credentials.NewTLS(serverTLSConfig)),
 		grpc.UnaryInterceptor(s.UnaryMiddleware),
@@ -141,7 +183,9 @@ func newTestServer(t *testing.T) (*testServer, context.Context) {
 	t.Cleanup(func() { noError(t, bobClient.Close(), "Closing Bob's client.") })
 
 	return &testServer{
+		server:     s,
 		grpcServer: grpcServer,
+		addr:       lis.Addr().String(),
 		alice:      aliceClient,
 		bob:        bobClient,
 	}, ctx
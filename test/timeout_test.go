@@ -0,0 +1,64 @@
+package telepilot_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "go.creack.net/telepilot/api/v1"
+	"go.creack.net/telepilot/pkg/apiclient"
+	"go.creack.net/telepilot/pkg/apiserver"
+	"go.creack.net/telepilot/pkg/jobmanager"
+)
+
+func TestTimeouts(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t, apiserver.WithJobManagerOptions(jobmanager.WithStopGracePeriod(500*time.Millisecond)))
+
+	// runJob runs the given command until it ends and returns its logs and status.
+	runJob := func(t *testing.T, cmd string, args []string, opts ...apiclient.StartJobOption) (string, string) {
+		t.Helper()
+		jobID, err := ts.alice.StartJob(ctx, cmd, args, opts...)
+		noError(t, err, "Start job.")
+		t.Cleanup(func() { noError(t, ts.alice.StopJob(ctx, jobID), "Cleanup stop job.") })
+
+		w := &strings.Builder{}
+		noError(t, ts.alice.StreamLogs(ctx, jobID, w), "Stream logs.")
+		st, err := ts.alice.GetJobStatus(ctx, jobID)
+		noError(t, err, "Get job status.")
+		return w.String(), st
+	}
+
+	t.Run("max duration graceful", func(t *testing.T) {
+		t.Parallel()
+		// The job handles SIGTERM, it is expected to exit on its own within the grace period.
+		logs, st := runJob(t, "sh", []string{"-c", "trap 'echo terminated; exit 3' TERM; echo started; while true; do sleep 0.1; done"},
+			apiclient.WithMaxDuration(200*time.Millisecond))
+		assert(t, "started\nterminated\n", logs, "invalid logs")
+		assert(t, pb.JobStatus_JOB_STATUS_TIMED_OUT.String()+" (3)", st, "invalid status")
+	})
+
+	t.Run("max duration killed", func(t *testing.T) {
+		t.Parallel()
+		// As the job's init, sleep ignores SIGTERM, it is expected to be killed after the grace period.
+		start := time.Now()
+		_, st := runJob(t, "sleep", []string{"60"}, apiclient.WithMaxDuration(200*time.Millisecond))
+		assert(t, pb.JobStatus_JOB_STATUS_TIMED_OUT.String()+" (-1)", st, "invalid status")
+		if elapsed := time.Since(start); elapsed < 700*time.Millisecond || elapsed > 10*time.Second {
+			t.Fatalf("Unexpected job duration %s, expected max duration + grace period.", elapsed)
+		}
+	})
+
+	t.Run("max cpu time", func(t *testing.T) {
+		t.Parallel()
+		_, st := runJob(t, "sh", []string{"-c", "while true; do :; done"}, apiclient.WithMaxCPUTime(200*time.Millisecond))
+		assert(t, pb.JobStatus_JOB_STATUS_TIMED_OUT.String()+" (-1)", st, "invalid status")
+	})
+
+	t.Run("not reached", func(t *testing.T) {
+		t.Parallel()
+		_, st := runJob(t, "true", nil, apiclient.WithMaxDuration(time.Minute), apiclient.WithMaxCPUTime(time.Minute))
+		assert(t, pb.JobStatus_JOB_STATUS_EXITED.String()+" (0)", st, "invalid status")
+	})
+}
@@ -0,0 +1,115 @@
+package telepilot_test
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "go.creack.net/telepilot/api/v1"
+)
+
+func TestWorkflows(t *testing.T) {
+	t.Parallel()
+
+	ts, ctx := newTestServer(t)
+
+	// step is a helper to create a workflow step running `sh -c <script>`.
+	step := func(name, script string, condition pb.StepCondition, dependsOn ...string) *pb.WorkflowStep {
+		return &pb.WorkflowStep{
+			Name:      name,
+			Job:       &pb.StartJobRequest{Command: "sh", Args: []string{"-c", script}},
+			DependsOn: dependsOn,
+			Condition: condition,
+		}
+	}
+	const onSuccess = pb.StepCondition_STEP_CONDITION_ON_SUCCESS_UNSPECIFIED
+
+	// waitWorkflow waits for the workflow to end and returns the status of its steps by name.
+	waitWorkflow := func(t *testing.T, workflowID string) (pb.WorkflowStatus, map[string]*pb.WorkflowStepStatus) {
+		t.Helper()
+		for {
+			resp, err := ts.alice.GetWorkflowStatus(ctx, workflowID)
+			noError(t, err, "Get workflow status.")
+			if resp.GetStatus() != pb.WorkflowStatus_WORKFLOW_STATUS_RUNNING {
+				steps := map[string]*pb.WorkflowStepStatus{}
+				for _, elem := range resp.GetSteps() {
+					steps[elem.GetName()] = elem
+				}
+				return resp.GetStatus(), steps
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for the workflow to end.")
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		workflowID, err := ts.alice.SubmitWorkflow(ctx, &pb.SubmitWorkflowRequest{Steps: []*pb.WorkflowStep{
+			step("test", "true", onSuccess, "build"),
+			step("build", "true", onSuccess, "fetch"),
+			step("fetch", "true", onSuccess),
+			step("notify", "true", pb.StepCondition_STEP_CONDITION_ON_FAILURE, "test"),
+			step("cleanup", "true", pb.StepCondition_STEP_CONDITION_ALWAYS, "test"),
+		}})
+		noError(t, err, "Submit workflow.")
+
+		st, steps := waitWorkflow(t, workflowID)
+		assert(t, pb.WorkflowStatus_WORKFLOW_STATUS_SUCCEEDED, st, "invalid workflow status")
+		for _, name := range []string{"fetch", "build", "test", "cleanup"} {
+			assert(t, pb.StepStatus_STEP_STATUS_SUCCEEDED, steps[name].GetStatus(), "invalid status for step "+name)
+		}
+		assert(t, pb.StepStatus_STEP_STATUS_CANCELED, steps["notify"].GetStatus(), "invalid status for the on failure step")
+
+		// The jobs link back to the workflow.
+		jobStatus, err := ts.alice.GetJobStatusDetails(ctx, steps["build"].GetJobId())
+		noError(t, err, "Get step job status.")
+		assert(t, workflowID, jobStatus.GetWorkflowId(), "invalid workflow link of the job")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		t.Parallel()
+		workflowID, err := ts.alice.SubmitWorkflow(ctx, &pb.SubmitWorkflowRequest{Steps: []*pb.WorkflowStep{
+			step("fetch", "true", onSuccess),
+			step("build", "exit 3", onSuccess, "fetch"),
+			step("test", "true", onSuccess, "build"),
+			step("notify", "true", pb.StepCondition_STEP_CONDITION_ON_FAILURE, "test"),
+		}})
+		noError(t, err, "Submit workflow.")
+
+		st, steps := waitWorkflow(t, workflowID)
+		assert(t, pb.WorkflowStatus_WORKFLOW_STATUS_FAILED, st, "invalid workflow status")
+		assert(t, pb.StepStatus_STEP_STATUS_SUCCEEDED, steps["fetch"].GetStatus(), "invalid status for fetch")
+		assert(t, pb.StepStatus_STEP_STATUS_FAILED, steps["build"].GetStatus(), "invalid status for build")
+		assert(t, int32(3), steps["build"].GetExitCode(), "invalid exit code for build")
+		assert(t, pb.StepStatus_STEP_STATUS_CANCELED, steps["test"].GetStatus(), "downstream step not canceled")
+		assert(t, "", steps["test"].GetJobId(), "canceled step started")
+		assert(t, pb.StepStatus_STEP_STATUS_SUCCEEDED, steps["notify"].GetStatus(), "on failure step not run")
+
+		// Bob can't see Alice's workflow.
+		_, err = ts.bob.GetWorkflowStatus(ctx, workflowID)
+		assert(t, codes.PermissionDenied, status.Code(err), "invalid grpc status code for Bob looking up Alice's workflow")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for name, steps := range map[string][]*pb.WorkflowStep{
+			"empty":          nil,
+			"missing name":   {step("", "true", onSuccess)},
+			"duplicate":      {step("a", "true", onSuccess), step("a", "true", onSuccess)},
+			"unknown dep":    {step("a", "true", onSuccess, "b")},
+			"cycle":          {step("a", "true", onSuccess), step("b", "true", onSuccess, "a", "c"), step("c", "true", onSuccess, "b")},
+			"self":           {step("a", "true", onSuccess, "a")},
+			"root onfailure": {step("a", "true", pb.StepCondition_STEP_CONDITION_ON_FAILURE)},
+			"missing job":    {{Name: "a"}},
+			"invalid spec":   {{Name: "a", Job: &pb.StartJobRequest{Command: "true", Hostname: "-invalid"}}},
+		} {
+			_, err := ts.alice.SubmitWorkflow(ctx, &pb.SubmitWorkflowRequest{Steps: steps})
+			assert(t, codes.InvalidArgument, status.Code(err), "invalid grpc status code for "+name)
+		}
+	})
+}